@@ -16,6 +16,7 @@ type CPU struct {
 	PS   PS         // processor status word
 	Inst uint16     // instruction being executed (actual instruction bits)
 	Mem  Memory     // attached memory
+	IMem Memory     // instruction space, for split I&D; nil means Mem holds both
 	F    [6]float64 // floating-point registers
 	FPS  FPS        // floating point status word
 	FEC  uint8      // fp error code
@@ -157,6 +158,16 @@ func (cpu *CPU) ReadW(addr uint16) (uint16, error) {
 	return cpu.Mem.ReadW(addr)
 }
 
+// ReadIW reads and returns the word at addr in instruction space:
+// IMem for a program with split instruction and data spaces,
+// and otherwise the same memory ReadW reads.
+func (cpu *CPU) ReadIW(addr uint16) (uint16, error) {
+	if cpu.IMem != nil {
+		return cpu.IMem.ReadW(addr)
+	}
+	return cpu.ReadW(addr)
+}
+
 // WriteB writes the byte val to addr.
 func (cpu *CPU) WriteB(addr uint16, val uint8) error {
 	// PS is at special address 0o177776.
@@ -10,7 +10,7 @@ import (
 )
 
 func (cpu *CPU) Disasm(pc uint16) (asm string, next uint16, err error) {
-	code, err := cpu.ReadW(pc)
+	code, err := cpu.ReadIW(pc)
 	if err != nil {
 		return "", pc, err
 	}
@@ -89,7 +89,7 @@ func fmtArg(out []byte, cpu *CPU, w, next uint16) (out1 []byte, next1 uint16, er
 
 	// Conveniences for PC-relative data and immediates.
 	if r == PC {
-		if imm, err := cpu.ReadW(next); err == nil {
+		if imm, err := cpu.ReadIW(next); err == nil {
 			switch mode {
 			case 2:
 				return fmt.Appendf(out, "#%o", int16(imm)), next + 2, nil
@@ -124,7 +124,7 @@ func fmtArg(out []byte, cpu *CPU, w, next uint16) (out1 []byte, next1 uint16, er
 	case 4: // pre-increment
 		return fmt.Appendf(out, "%s-%s", indir, reg), next, nil
 	case 6: // indexed
-		imm, err := cpu.ReadW(next)
+		imm, err := cpu.ReadIW(next)
 		if err != nil {
 			return nil, next, err
 		}
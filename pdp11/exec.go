@@ -9,11 +9,21 @@ import (
 	"runtime"
 )
 
-func (cpu *CPU) Step(n int) (err error) {
+// Step executes up to n instructions,
+// stopping early at one that traps or faults.
+func (cpu *CPU) Step(n int) error {
+	_, err := cpu.StepN(n)
+	return err
+}
+
+// StepN is like Step but also returns the number of
+// instructions executed, counting one that traps or faults.
+func (cpu *CPU) StepN(n int) (done int, err error) {
 	var old CPU
 	defer func() {
 		if e := recover(); e != nil {
 			*cpu = old
+			done++
 			if _, ok := e.(runtime.Error); ok {
 				panic(e)
 			}
@@ -25,13 +35,13 @@ func (cpu *CPU) Step(n int) (err error) {
 		}
 	}()
 
-	for ; n > 0; n-- {
+	for ; done < n; done++ {
 		old = *cpu
 		pc := cpu.R[PC]
 		if pc&1 != 0 {
 			panic(ErrInst)
 		}
-		w, err := cpu.ReadW(pc)
+		w, err := cpu.ReadIW(pc)
 		if err != nil {
 			panic(err)
 		}
@@ -40,12 +50,15 @@ func (cpu *CPU) Step(n int) (err error) {
 		cpu.R[PC] = pc + 2
 		lookup(w).do(cpu)
 	}
-	return nil
+	return done, nil
 }
 
 type addr uint32
 
-const addrReg addr = 1 << 16
+const (
+	addrReg addr = 1 << 16
+	addrI   addr = 1 << 17 // in instruction space, for split I&D
+)
 
 func (a addr) String() string {
 	if a&addrReg != 0 {
@@ -76,22 +89,37 @@ func (cpu *CPU) addr(enc, size uint16) addr {
 	case 6:
 		// index offset from PC
 		pc := cpu.R[PC]
-		imm := cpu.readW(addr(pc))
+		imm := cpu.readW(addr(pc) | addrI)
 		cpu.R[PC] = pc + 2
 		a = cpu.R[reg] + imm // reload reg in case reg is PC
 	}
+	ia := addr(a)
+	if reg == PC && mode&^1 == 2 {
+		// immediate or absolute: the word follows the instruction
+		ia |= addrI
+	}
 	if mode != 1 && mode&1 == 1 {
 		// extra dereference
-		a = cpu.readW(addr(a))
+		return addr(cpu.readW(ia))
+	}
+	return ia
+}
+
+// mem returns the memory holding a:
+// IMem for an address in instruction space
+// of a split I&D program, and otherwise Mem.
+func (cpu *CPU) mem(a addr) Memory {
+	if a&addrI != 0 && cpu.IMem != nil {
+		return cpu.IMem
 	}
-	return addr(a)
+	return cpu.Mem
 }
 
 func (cpu *CPU) readW(a addr) uint16 {
 	if a&addrReg != 0 {
 		return cpu.R[a&07]
 	}
-	val, err := cpu.Mem.ReadW(uint16(a))
+	val, err := cpu.mem(a).ReadW(uint16(a))
 	if err != nil {
 		panic(err)
 	}
@@ -103,7 +131,7 @@ func (cpu *CPU) readB(a addr) uint8 {
 	if a&addrReg != 0 {
 		return uint8(cpu.R[a&07])
 	}
-	val, err := cpu.Mem.ReadB(uint16(a))
+	val, err := cpu.mem(a).ReadB(uint16(a))
 	if err != nil {
 		panic(err)
 	}
@@ -116,7 +144,7 @@ func (cpu *CPU) writeW(a addr, val uint16) {
 		cpu.R[a&07] = val
 		return
 	}
-	if err := cpu.Mem.WriteW(uint16(a), val); err != nil {
+	if err := cpu.mem(a).WriteW(uint16(a), val); err != nil {
 		panic(err)
 	}
 	// fmt.Fprintf(os.Stderr, "write *%06o = %06o\n", uint16(a), val)
@@ -127,7 +155,7 @@ func (cpu *CPU) writeB(a addr, val uint8) {
 		cpu.R[a&07] = cpu.R[a&07]&0o177400 | uint16(val)
 		return
 	}
-	if err := cpu.Mem.WriteB(uint16(a), val); err != nil {
+	if err := cpu.mem(a).WriteB(uint16(a), val); err != nil {
 		panic(err)
 	}
 	// fmt.Fprintf(os.Stderr, "write *%06o = %03o\n", uint16(a), val)
@@ -309,3 +309,48 @@ func testApoutExec(t *testing.T, file string) {
 		t.Fatalf("did not see pc %06o", nows[0].pc)
 	}
 }
+
+func TestSplitID(t *testing.T) {
+	var cpu CPU
+	imem, dmem := new(ArrayMem), new(ArrayMem)
+	cpu.Mem, cpu.IMem = dmem, imem
+	for i, w := range []uint16{
+		0o012700, 0o1234, // mov $1234, r0
+		0o013701, 0o100, // mov @#100, r1
+		0o016702, 0o100 - 12, // mov 100, r2 (pc-relative)
+		0o010003, // mov r0, r3
+	} {
+		imem.WriteW(uint16(2*i), w)
+	}
+	dmem.WriteW(0o100, 0o4321)
+	imem.WriteW(0o100, 0o7777)
+	if err := cpu.Step(4); err != nil {
+		t.Fatal(err)
+	}
+	if r := cpu.R; r[0] != 0o1234 || r[1] != 0o4321 || r[2] != 0o4321 || r[3] != 0o1234 {
+		t.Errorf("r0-r3 = %#o %#o %#o %#o, want immediates from I space, data from D space", r[0], r[1], r[2], r[3])
+	}
+	if w, _ := dmem.ReadW(0); w != 0 {
+		t.Errorf("D space at 0 = %#o, want 0 (untouched)", w)
+	}
+}
+
+func TestStepN(t *testing.T) {
+	var cpu CPU
+	mem := new(ArrayMem)
+	cpu.Mem = mem
+	for i, w := range []uint16{
+		0o005200, // inc r0
+		0o005200, // inc r0
+		0o104401, // sys exit
+		0o005200, // inc r0
+	} {
+		mem.WriteW(uint16(2*i), w)
+	}
+	if n, err := cpu.StepN(1); n != 1 || err != nil || cpu.R[0] != 1 {
+		t.Errorf("StepN(1) = %d, %v, r0 %d, want 1, nil, 1", n, err, cpu.R[0])
+	}
+	if n, err := cpu.StepN(10); n != 2 || err != ErrTrap || cpu.R[0] != 2 {
+		t.Errorf("StepN(10) = %d, %v, r0 %d, want 2, ErrTrap, 2", n, err, cpu.R[0])
+	}
+}
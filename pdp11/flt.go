@@ -210,25 +210,25 @@ func (cpu *CPU) readF(a addr) float64 {
 		}
 		return cpu.conv(cpu.F[a&07], false)
 	}
-	w0, err := cpu.Mem.ReadW(uint16(a))
+	w0, err := cpu.mem(a).ReadW(uint16(a))
 	if err != nil {
 		panic(err)
 	}
 	if regOrImm(cpu) {
 		return fromF32(w0, 0)
 	}
-	w1, err := cpu.Mem.ReadW(uint16(a + 2))
+	w1, err := cpu.mem(a).ReadW(uint16(a + 2))
 	if err != nil {
 		panic(err)
 	}
 	if cpu.FPS&FD == 0 {
 		return fromF32(w0, w1)
 	}
-	w2, err := cpu.Mem.ReadW(uint16(a + 4))
+	w2, err := cpu.mem(a).ReadW(uint16(a + 4))
 	if err != nil {
 		panic(err)
 	}
-	w3, err := cpu.Mem.ReadW(uint16(a + 6))
+	w3, err := cpu.mem(a).ReadW(uint16(a + 6))
 	if err != nil {
 		panic(err)
 	}
@@ -246,26 +246,26 @@ func (cpu *CPU) writeF(a addr, f float64) {
 	}
 	if cpu.FPS&FD == 0 {
 		w0, w1 := toF32(f)
-		if err := cpu.Mem.WriteW(uint16(a), w0); err != nil {
+		if err := cpu.mem(a).WriteW(uint16(a), w0); err != nil {
 			panic(err)
 		}
-		if err := cpu.Mem.WriteW(uint16(a+2), w1); err != nil {
+		if err := cpu.mem(a).WriteW(uint16(a+2), w1); err != nil {
 			panic(err)
 		}
 		return
 	}
 
 	w0, w1, w2, w3 := toF64(f)
-	if err := cpu.Mem.WriteW(uint16(a), w0); err != nil {
+	if err := cpu.mem(a).WriteW(uint16(a), w0); err != nil {
 		panic(err)
 	}
-	if err := cpu.Mem.WriteW(uint16(a+2), w1); err != nil {
+	if err := cpu.mem(a).WriteW(uint16(a+2), w1); err != nil {
 		panic(err)
 	}
-	if err := cpu.Mem.WriteW(uint16(a+4), w2); err != nil {
+	if err := cpu.mem(a).WriteW(uint16(a+4), w2); err != nil {
 		panic(err)
 	}
-	if err := cpu.Mem.WriteW(uint16(a+6), w3); err != nil {
+	if err := cpu.mem(a).WriteW(uint16(a+6), w3); err != nil {
 		panic(err)
 	}
 }
@@ -0,0 +1,314 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Analogous to _fs/usr/sys/dmr/bio.c but the code is new.
+// There is no strategy routine or interrupt-driven I/O:
+// a block device is a host file or a RAM disk, and bread
+// and bwrite call ReadAt and WriteAt directly.
+
+package v6unix
+
+import (
+	"errors"
+	"io"
+	"slices"
+)
+
+const BSIZE = 512 /* size of a disk block */
+
+// A buf is one block in a block device's buffer cache.
+type buf struct {
+	blkno int64
+	dirty bool /* delayed write: data must be written before reuse */
+	data  [BSIZE]byte
+}
+
+// A blkdev is a block device backed by a host file.
+// It keeps the last NBUF blocks it used in a cache,
+// so that reading or writing a block a byte at a time
+// does not go to the file each time.
+type blkdev struct {
+	r     io.ReaderAt
+	w     io.WriterAt // nil if read-only
+	cache []*buf      // least recently used first
+	size  int64       // bytes on the device, or 0 if unknown; I/O past them fails with EIO
+}
+
+// AttachBlockDevice installs a block device with the given major number
+// that reads and writes 512-byte blocks of disk.
+// If disk also implements io.WriterAt, the device is writable;
+// otherwise writes fail with EROFS.
+func (sys *System) AttachBlockDevice(major uint8, disk io.ReaderAt) error {
+	d := &blkdev{r: disk}
+	d.w, _ = disk.(io.WriterAt)
+	return sys.install(major, d)
+}
+
+// A RamDisk is the memory holding a block device made by NewRamDisk.
+type RamDisk struct {
+	data []byte
+	dev  *blkdev
+}
+
+func (r *RamDisk) ReadAt(b []byte, off int64) (int, error) {
+	if off >= int64(len(r.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, r.data[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *RamDisk) WriteAt(b []byte, off int64) (int, error) {
+	if off+int64(len(b)) > int64(len(r.data)) {
+		return 0, EIO
+	}
+	return copy(r.data[off:], b), nil
+}
+
+// Bytes writes the delayed-write blocks of the RAM disk back
+// and returns its memory, which later writes to the device change.
+func (r *RamDisk) Bytes() []byte {
+	r.dev.flush()
+	return r.data
+}
+
+// NewRamDisk installs a block device of the given number of 512-byte blocks,
+// kept in memory and initially zero, at the first unused major number,
+// and returns the major number. Reading or writing past the end fails with EIO.
+// RamDisk returns the device's memory.
+// NewRamDisk fails if blocks is negative or all 256 major numbers are in use.
+func (sys *System) NewRamDisk(blocks int) (major uint8, err error) {
+	if blocks < 0 {
+		return 0, EINVAL
+	}
+	devtab := sys.devices()
+	i := slices.Index(devtab, nil)
+	if i < 0 {
+		i = len(devtab)
+	}
+	if i > 0xff {
+		return 0, errors.New("NewRamDisk: device table full")
+	}
+	r := &RamDisk{data: make([]byte, blocks*BSIZE)}
+	r.dev = &blkdev{r: r, w: r, size: int64(len(r.data))}
+	if err := sys.install(uint8(i), r.dev); err != nil {
+		return 0, err
+	}
+	return uint8(i), nil
+}
+
+// RamDisk returns the memory of the RAM disk made by NewRamDisk
+// with the given major number, or nil if there is none.
+func (sys *System) RamDisk(major uint8) *RamDisk {
+	devtab := sys.devices()
+	if int(major) >= len(devtab) {
+		return nil
+	}
+	if d, ok := devtab[major].(*blkdev); ok {
+		r, _ := d.r.(*RamDisk)
+		return r
+	}
+	return nil
+}
+
+/*
+ * Find the block in the cache,
+ * or take over the least recently used buffer for it.
+ * The returned buffer is marked most recently used.
+ * Its data is only meaningful if it was found in the cache.
+ */
+func (d *blkdev) getblk(blkno int64) (bp *buf, found bool, err error) {
+	for i, bp := range d.cache {
+		if bp.blkno == blkno {
+			d.cache = append(slices.Delete(d.cache, i, i+1), bp)
+			return bp, true, nil
+		}
+	}
+	if len(d.cache) < NBUF {
+		bp = new(buf)
+	} else {
+		bp = d.cache[0]
+		if bp.dirty {
+			if err := d.bwrite(bp); err != nil {
+				return nil, false, err
+			}
+		}
+		d.cache = d.cache[1:]
+	}
+	bp.blkno = blkno
+	bp.dirty = false
+	d.cache = append(d.cache, bp)
+	return bp, false, nil
+}
+
+/*
+ * Read in (if necessary) the block and return a buffer pointer.
+ * A block entirely past the end of the file returns io.EOF;
+ * a block partly past the end is zero-filled.
+ */
+func (d *blkdev) bread(blkno int64) (*buf, error) {
+	bp, found, err := d.getblk(blkno)
+	if err != nil || found {
+		return bp, err
+	}
+	n, err := d.r.ReadAt(bp.data[:], blkno*BSIZE)
+	clear(bp.data[n:])
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	if err != nil {
+		d.cache = d.cache[:len(d.cache)-1]
+		return nil, err
+	}
+	return bp, nil
+}
+
+/*
+ * Read the block without disturbing the cache:
+ * the cached copy if there is one, or else
+ * the block as it is in the file.
+ */
+func (d *blkdev) peek(blkno int64) (*[BSIZE]byte, error) {
+	for _, bp := range d.cache {
+		if bp.blkno == blkno {
+			b := bp.data
+			return &b, nil
+		}
+	}
+	b := new([BSIZE]byte)
+	if _, err := d.r.ReadAt(b[:], blkno*BSIZE); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return b, nil
+}
+
+/*
+ * Write the buffer back to the file.
+ */
+func (d *blkdev) bwrite(bp *buf) error {
+	if d.w == nil {
+		return EROFS
+	}
+	if _, err := d.w.WriteAt(bp.data[:], bp.blkno*BSIZE); err != nil {
+		return err
+	}
+	bp.dirty = false
+	return nil
+}
+
+/*
+ * Write out all the delayed-write buffers.
+ */
+func (d *blkdev) flush() error {
+	for _, bp := range d.cache {
+		if bp.dirty {
+			if err := d.bwrite(bp); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *blkdev) open(p *Proc, minor uint8, rw int) {
+	if rw != 0 && d.w == nil {
+		p.Error = EROFS
+	}
+}
+
+func (d *blkdev) read(p *Proc, minor uint8, b []byte, off int) int {
+	total := 0
+	for len(b) > 0 {
+		if d.size > 0 && int64(off) >= d.size {
+			p.Error = EIO
+			break
+		}
+		bp, err := d.bread(int64(off / BSIZE))
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			p.Error = EIO
+			break
+		}
+		n := copy(b, bp.data[off%BSIZE:])
+		b = b[n:]
+		off += n
+		total += n
+	}
+	return total
+}
+
+func (d *blkdev) write(p *Proc, minor uint8, b []byte, off int) int {
+	if d.w == nil {
+		p.Error = EROFS
+		return 0
+	}
+	total := 0
+	for len(b) > 0 {
+		if d.size > 0 && int64(off) >= d.size {
+			p.Error = EIO
+			break
+		}
+		var bp *buf
+		var err error
+		if off%BSIZE == 0 && len(b) >= BSIZE {
+			// Overwriting the whole block; no need to read it.
+			bp, _, err = d.getblk(int64(off / BSIZE))
+		} else {
+			bp, err = d.bread(int64(off / BSIZE))
+			if err == io.EOF {
+				// Extending the file.
+				bp, _, err = d.getblk(int64(off / BSIZE))
+				if bp != nil {
+					clear(bp.data[:])
+				}
+			}
+		}
+		if err != nil {
+			p.Error = EIO
+			break
+		}
+		n := copy(bp.data[off%BSIZE:], b)
+		bp.dirty = true
+		b = b[n:]
+		off += n
+		total += n
+	}
+	return total
+}
+
+func (d *blkdev) close(p *Proc, minor uint8) {
+	if err := d.flush(); err != nil {
+		p.Error = EIO
+	}
+}
+
+func (d *blkdev) sgtty(p *Proc, minor uint8, in, out *[3]uint16) {
+	p.Error = ENOTTY
+}
+
+// Sync writes all delayed writes back to the devices holding them.
+// As in update, the inodes in use and the changed super blocks
+// of the mounted file systems are written first, into the buffer caches;
+// then devices are flushed in order of major number,
+// and then the disk image from MountImage, if any.
+func (sys *System) Sync() error {
+	var errs []error
+	for _, mp := range sys.mounts {
+		errs = append(errs, mp.disk.iflush(), mp.disk.img.flush(sys.now()))
+	}
+	for _, d := range sys.devices() {
+		if d, ok := d.(flusher); ok {
+			errs = append(errs, d.flush())
+		}
+	}
+	if sys.Disk != nil && sys.Disk.img != nil {
+		errs = append(errs, sys.Disk.iflush(), sys.Disk.img.flush(sys.now()))
+	}
+	return errors.Join(errs...)
+}
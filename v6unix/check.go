@@ -0,0 +1,279 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Analogous to _fs/usr/source/s1/icheck.c and dcheck.c,
+// but the code is new. The checks look at the file system
+// as the kernel sees it: in-core inodes and super block,
+// and blocks in the buffer cache, but change nothing.
+
+package v6unix
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// A Problem is an inconsistency in a file system, found by Check.
+type Problem struct {
+	Inum  uint16 // inode with the problem, or 0
+	Block uint16 // block with the problem, or 0
+	Desc  string // what is wrong
+}
+
+func (p Problem) String() string {
+	s := p.Desc
+	if p.Block != 0 {
+		s = fmt.Sprintf("block %d: %s", p.Block, s)
+	}
+	if p.Inum != 0 {
+		s = fmt.Sprintf("inode %d: %s", p.Inum, s)
+	}
+	return s
+}
+
+// Check checks the v6 file system on the device with the given major number,
+// which must be mounted, or be the disk image from MountImage if major is 0.
+// As icheck does, it checks that every block after the I list is either
+// in exactly one file or on the free list; as dcheck does, it checks that
+// the link count of every allocated inode is the number of directory entries
+// for it. It returns the problems found, or an error if the file system
+// cannot be read. It does not change the file system or its buffer cache.
+func (sys *System) Check(major uint8) ([]Problem, error) {
+	var d *Disk
+	if major == 0 && sys.Disk != nil && sys.Disk.img != nil {
+		d = sys.Disk
+	}
+	for _, mp := range sys.mounts {
+		if d == nil && uint8(mp.dev>>8) == major {
+			d = mp.disk
+		}
+	}
+	if d == nil {
+		return nil, EINVAL
+	}
+	c := &checker{d: d, fs: &d.img.fs, owner: make(map[uint16]uint16)}
+	c.icheck()
+	if c.err == nil {
+		c.dcheck()
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.probs, nil
+}
+
+// A checker holds the state of Check.
+type checker struct {
+	d     *Disk
+	fs    *filsys
+	owner map[uint16]uint16 // block number -> inode using it, or 0 for the free list
+	probs []Problem
+	err   error // first read error
+}
+
+func (c *checker) problem(inum, bno uint16, format string, args ...any) {
+	c.probs = append(c.probs, Problem{inum, bno, fmt.Sprintf(format, args...)})
+}
+
+// block returns the contents of block bno, or nil after a read error.
+func (c *checker) block(bno uint16) *[BSIZE]byte {
+	b, err := c.d.img.dev.peek(int64(bno))
+	if err != nil && c.err == nil {
+		c.err = fmt.Errorf("check: reading block %d: %v", bno, err)
+	}
+	return b
+}
+
+// addrs returns the block addresses in the indirect block bno.
+func (c *checker) addrs(bno uint16) *[256]uint16 {
+	b := c.block(bno)
+	if b == nil {
+		return new([256]uint16)
+	}
+	return (*[256]uint16)(unsafe.Pointer(b))
+}
+
+// ninodes returns the number of inodes in the I list.
+func (c *checker) ninodes() int {
+	return int(c.fs.isize) * INOPB
+}
+
+// inode returns the current contents of inode inum:
+// the in-core copy if there is one, or else the one on disk.
+func (c *checker) inode(inum uint16) *stat {
+	if ip := c.d.inodes[inum]; ip != nil {
+		return &ip.stat
+	}
+	st := new(stat)
+	if b := c.block(uint16((int(inum) + 31) / INOPB)); b != nil {
+		copy(st.dinode(), b[dinodeSize*((int(inum)+31)%INOPB):])
+	}
+	return st
+}
+
+// holdsBlocks reports whether the inode's addresses are block numbers.
+func holdsBlocks(st *stat) bool {
+	t := fileType(st.mode)
+	return st.mode&_IALLOC != 0 && t != _IFCHR && t != _IFBLK
+}
+
+/*
+ * Claim block bno for inode inum,
+ * or for the free list if inum is 0.
+ * Report whether it is a good block
+ * claimed for the first time.
+ */
+func (c *checker) claim(inum, bno uint16) bool {
+	what := "block"
+	if inum == 0 {
+		what = "free block"
+	}
+	if bno < c.fs.isize+2 || bno >= c.fs.fsize {
+		c.problem(inum, bno, "bad %s", what)
+		return false
+	}
+	if o, ok := c.owner[bno]; ok {
+		if o == 0 {
+			c.problem(inum, bno, "dup %s, also free", what)
+		} else {
+			c.problem(inum, bno, "dup %s, also in inode %d", what, o)
+		}
+		return false
+	}
+	c.owner[bno] = inum
+	return true
+}
+
+/*
+ * Check the blocks of the files,
+ * then the free list, and then
+ * that no block is missing from both.
+ */
+func (c *checker) icheck() {
+	for inum := 1; inum <= c.ninodes() && c.err == nil; inum++ {
+		st := c.inode(uint16(inum))
+		if !holdsBlocks(st) {
+			continue
+		}
+		for i := 0; i < 8; i++ {
+			a := *st.iaddr(i)
+			if a == 0 || !c.claim(uint16(inum), a) || !st.large() {
+				continue
+			}
+			for _, b := range c.addrs(a) {
+				if b == 0 || !c.claim(uint16(inum), b) || i != 7 {
+					continue
+				}
+				for _, bb := range c.addrs(b) {
+					if bb != 0 {
+						c.claim(uint16(inum), bb)
+					}
+				}
+			}
+		}
+	}
+
+	nfree, free := int(c.fs.nfree), c.fs.free[:]
+	for c.err == nil {
+		if nfree < 0 || nfree > NICFREE {
+			c.problem(0, 0, "bad free count %d", nfree)
+			break
+		}
+		if nfree == 0 {
+			break
+		}
+		for _, b := range free[1:nfree] {
+			c.claim(0, b)
+		}
+		if free[0] == 0 || !c.claim(0, free[0]) {
+			break
+		}
+		b := c.addrs(free[0])
+		nfree, free = int(b[0]), b[1:1+NICFREE]
+	}
+
+	for bno := c.fs.isize + 2; bno < c.fs.fsize && c.err == nil; bno++ {
+		if _, ok := c.owner[bno]; !ok {
+			c.problem(0, bno, "missing")
+		}
+	}
+}
+
+/*
+ * Read-only bmap: return the block number of
+ * logical block bn of inode inum, or 0 if there
+ * is none. Indirect blocks that are not the
+ * file's own are not followed.
+ */
+func (c *checker) bmap(inum uint16, st *stat, bn int) uint16 {
+	if !st.large() {
+		if bn < 8 {
+			return *st.iaddr(bn)
+		}
+		return 0
+	}
+	i := bn >> 8
+	if i >= 7 {
+		i = 7
+	}
+	a := *st.iaddr(i)
+	if a == 0 || c.owner[a] != inum {
+		return 0
+	}
+	if i == 7 {
+		if a = c.addrs(a)[(bn>>8)-7]; a == 0 || c.owner[a] != inum {
+			return 0
+		}
+	}
+	return c.addrs(a)[bn&0o377]
+}
+
+/*
+ * Count the directory entries for each inode
+ * and compare the counts with the link counts.
+ */
+func (c *checker) dcheck() {
+	n := c.ninodes()
+	entries := make([]int, n+1)
+	for inum := 1; inum <= n && c.err == nil; inum++ {
+		st := c.inode(uint16(inum))
+		if st.mode&_IALLOC == 0 || fileType(st.mode) != _IFDIR {
+			continue
+		}
+		size := st.size()
+		for off := 0; off < size && c.err == nil; off += BSIZE {
+			bno := c.bmap(uint16(inum), st, off/BSIZE)
+			if bno == 0 || c.owner[bno] != uint16(inum) {
+				continue
+			}
+			b := c.block(bno)
+			if b == nil {
+				break
+			}
+			for i := 0; i < BSIZE && off+i < size; i += 16 {
+				ent := *(*uint16)(unsafe.Pointer(&b[i]))
+				switch {
+				case ent == 0:
+				case int(ent) > n:
+					c.problem(uint16(inum), 0, "entry for inode %d past the I list", ent)
+				case c.inode(ent).mode&_IALLOC == 0:
+					c.problem(ent, 0, "unallocated, with an entry in directory %d", inum)
+				default:
+					entries[ent]++
+				}
+			}
+		}
+	}
+	for inum := 1; inum <= n && c.err == nil; inum++ {
+		st := c.inode(uint16(inum))
+		if st.mode&_IALLOC == 0 || int(st.nlink) == entries[inum] {
+			continue
+		}
+		if entries[inum] == 0 {
+			c.problem(uint16(inum), 0, "unreferenced, link count %d", st.nlink)
+		} else {
+			c.problem(uint16(inum), 0, "%d entries, link count %d", entries[inum], st.nlink)
+		}
+	}
+}
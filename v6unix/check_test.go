@@ -0,0 +1,138 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package v6unix
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	p := mountRoot(t, readRoot(t))
+	if probs, err := p.Sys.Check(0); err != nil || len(probs) != 0 {
+		t.Fatalf("Check of root image = %v, %v, want no problems", probs, err)
+	}
+	if _, err := p.Sys.Check(99); err != EINVAL {
+		t.Errorf("Check of unmounted device: %v, want EINVAL", err)
+	}
+
+	data, err := os.ReadFile("../v6/v6src")
+	if err != nil {
+		t.Skip(err)
+	}
+	major, err := p.Sys.NewRamDisk(len(data) / BSIZE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rd := p.Sys.RamDisk(major)
+	copy(rd.Bytes(), data)
+	call := func(fn func(*Proc), args ...string) Errno {
+		p.Error = 0
+		for i, s := range args {
+			p.Args[i] = strArg(p, 0o1000+0o200*uint16(i), s)
+		}
+		fn(p)
+		return p.Error
+	}
+	p.Args[1], p.Args[2] = _IFBLK|0o600, uint16(major)<<8
+	if err := call(sysmknod, "/dev/rd0"); err != 0 {
+		t.Fatal(err)
+	}
+	p.Args[2] = 0 // read-write
+	if err := call(sysmount, "/dev/rd0", "/mnt"); err != 0 {
+		t.Fatal(err)
+	}
+
+	// A batch of changes, checked before they are written back.
+	p.Args[1] = 0o755
+	if err := call(sysmkdir, "/mnt/d"); err != 0 {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("/mnt/d/f%d", i)
+		p.Args[1] = 0o644
+		if err := call(syscreate, name); err != 0 {
+			t.Fatal(err)
+		}
+		fd := p.CPU.R[0]
+		p.writei(p.Files[fd].inode, make([]byte, i*600), 0)
+		closefd(p, fd)
+		if i%3 == 0 {
+			call(syslink, name, name+"L")
+		}
+		if i%2 == 0 {
+			call(sysunlink, name)
+		}
+	}
+	if err := call(sysrename, "/mnt/d/f1", "/mnt/f1"); err != 0 {
+		t.Fatal(err)
+	}
+	if err := call(syssymlink, "/mnt/d/f3", "/mnt/f3link"); err != 0 {
+		t.Fatal(err)
+	}
+	dev := p.dev(major, 0).(*blkdev)
+	cache := slices.Clone(dev.cache)
+	dirty := 0
+	for _, bp := range cache {
+		if bp.dirty {
+			dirty++
+		}
+	}
+	probs, err := p.Sys.Check(major)
+	if err != nil || len(probs) != 0 {
+		t.Errorf("Check after changes = %v, %v, want no problems", probs, err)
+	}
+	after := 0
+	for _, bp := range dev.cache {
+		if bp.dirty {
+			after++
+		}
+	}
+	if !slices.Equal(dev.cache, cache) || after != dirty {
+		t.Errorf("Check changed the buffer cache")
+	}
+
+	// Damage that Check finds.
+	want := func(what string, probs []Problem, substr ...string) {
+		t.Helper()
+		var got []string
+		for _, pr := range probs {
+			got = append(got, pr.String())
+		}
+		for _, s := range substr {
+			found := false
+			for _, g := range got {
+				found = found || strings.Contains(g, s)
+			}
+			if !found {
+				t.Errorf("%s: problems %q, want one with %q", what, got, s)
+			}
+		}
+	}
+	ip, _, _ := p.namei("/mnt/f1", nameFind)
+	if ip == nil {
+		t.Fatal(p.Error)
+	}
+	ip.nlink++
+	bno := *ip.iaddr(0)
+	probs, _ = p.Sys.Check(major)
+	want("extra link", probs, fmt.Sprintf("inode %d: 1 entries, link count 2", ip.inum))
+	ip.nlink--
+
+	p.free(ip.img, bno)
+	probs, _ = p.Sys.Check(major)
+	want("freed block in use", probs, fmt.Sprintf("block %d: dup free block, also in inode %d", bno, ip.inum))
+	p.alloc(ip.img)
+
+	*ip.iaddr(1) = 1
+	probs, _ = p.Sys.Check(major)
+	want("bad address", probs, fmt.Sprintf("inode %d: block 1: bad block", ip.inum))
+	*ip.iaddr(1) = 0
+	probs, _ = p.Sys.Check(major)
+	want("lost block", probs, "missing")
+}
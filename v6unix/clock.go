@@ -0,0 +1,188 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// The clock device is not a port; the code is new,
+// after the KW11-L line clock and clock in ken/clock.c.
+// The line clock interrupts ClockHz times a second.
+// Each interrupt charges the running process for the cpu,
+// and once a second the clock recomputes user priorities.
+// The clock also keeps the system's time, which time, sleep
+// and alarm follow. Normally that is the host's time, with
+// the line clock interrupting as the host's time goes by,
+// plus whatever Tick has added. In deterministic mode the
+// line clock interrupts every InstPerTick instructions
+// instead, and the system's time is only the ticks.
+
+package v6unix
+
+import (
+	"time"
+
+	"rsc.io/unix/pdp11"
+)
+
+// DefaultInstPerTick is the number of instructions
+// between clock ticks in deterministic mode,
+// when System.InstPerTick is zero:
+// about the speed of a PDP-11/40.
+const DefaultInstPerTick = 5000
+
+// syscallInsts is the number of instructions
+// a system call counts as, for the clock:
+// about what the v6 kernel ran for a simple one.
+const syscallInsts = 100
+
+// hz returns the line frequency of the clock.
+func (sys *System) hz() int {
+	if sys.ClockHz > 0 {
+		return sys.ClockHz
+	}
+	return HZ
+}
+
+// instPerTick returns the number of instructions
+// between ticks in deterministic mode.
+func (sys *System) instPerTick() int {
+	if sys.InstPerTick > 0 {
+		return sys.InstPerTick
+	}
+	return DefaultInstPerTick
+}
+
+// clockTime returns the system's idea of the current time.
+func (sys *System) clockTime() time.Time {
+	if sys.Deterministic {
+		return start.Add(sys.elapsed)
+	}
+	return time.Now().Add(sys.elapsed)
+}
+
+// Tick makes the clock interrupt once, as if no process were running,
+// advancing the system's time by one tick (1/ClockHz seconds)
+// and sending any alarms and waking any sleepers that are then due.
+func (sys *System) Tick() {
+	sys.elapsed += time.Second / time.Duration(sys.hz())
+	sys.tick(nil)
+	sys.clock()
+}
+
+/*
+ * Advance the clock after the running process p
+ * has made a system call, charging any tick to
+ * p's system time.
+ */
+func (sys *System) clockSys(p *Proc) {
+	p.insys = true
+	sys.clockStep(p, syscallInsts)
+	p.insys = false
+}
+
+/*
+ * Advance the clock after the running
+ * process p has executed n instructions,
+ * interrupting if a tick has gone by.
+ */
+func (sys *System) clockStep(p *Proc, n int) {
+	if sys.Deterministic {
+		if sys.insts += n; sys.insts < sys.instPerTick() {
+			return
+		}
+		sys.insts = 0
+		sys.elapsed += time.Second / time.Duration(sys.hz())
+		sys.tick(p)
+		return
+	}
+	now := time.Now()
+	if now.Sub(sys.lbolt) < time.Second/time.Duration(sys.hz()) {
+		return
+	}
+	sys.lbolt = now
+	sys.tick(p)
+}
+
+/*
+ * The scheduling part of the clock interrupt,
+ * with p the running process, or nil.
+ * Every tick, charge p for the cpu, as system
+ * time if p is in a system call and otherwise
+ * as user time, counted in p's profile;
+ * every second, decay everyone's cpu usage,
+ * recompute user priorities, and ask for
+ * a reschedule so equal priorities take turns.
+ */
+func (sys *System) tick(p *Proc) {
+	const SCHMAG = 10
+	if p != nil {
+		if p.insys {
+			p.STime++
+		} else {
+			p.UTime++
+			if p.Prof[3] != 0 {
+				p.incupc(p.CPU.R[pdp11.PC])
+			}
+		}
+		if uint8(p.cpu) != 0o377 {
+			p.cpu++
+		}
+	}
+	if sys.ticks++; sys.ticks < sys.hz() {
+		return
+	}
+	sys.ticks = 0
+	for _, pp := range sys.Procs {
+		if pp.time != 127 {
+			pp.time++
+		}
+		pp.cpu = int8(uint8(max(0, int(uint8(pp.cpu))-SCHMAG)))
+		if pp.pri >= _PUSER {
+			pp.setpri(pp)
+		}
+	}
+	sys.runrun++
+}
+
+/*
+ * Count a clock tick at the user pc in the
+ * profile buffer set by the prof system call,
+ * as incupc in m40.s does: the counter is the
+ * word at ((pc-offset)/2 * Prof[3]) >> 14,
+ * rounded up to even, if within the buffer.
+ * Prof[3] is already half the scale given to
+ * prof, which halves it as v6 sysprof does.
+ * A buffer outside p's memory turns profiling off.
+ */
+func (p *Proc) incupc(pc uint16) {
+	off := uint16(uint32((pc-p.Prof[2])>>1) * uint32(p.Prof[3]) >> 14)
+	off = (off + 1) &^ 1
+	if off >= p.Prof[1] {
+		return
+	}
+	addr := p.Prof[0] + off
+	b, err := p.ReadMem(addr, 2)
+	if err != nil {
+		p.Prof[3] = 0
+		return
+	}
+	w := uint16(b[0]) | uint16(b[1])<<8
+	w++
+	p.WriteMem(addr, []byte{byte(w), byte(w >> 8)})
+}
+
+/*
+ * In deterministic mode, nothing happens while every
+ * process is asleep, so skip ahead to the next timer.
+ */
+func (sys *System) skipIdle() {
+	if !sys.Deterministic || sys.Timer.IsZero() {
+		return
+	}
+	for _, p := range sys.Procs {
+		if p.status == _SRUN {
+			return
+		}
+	}
+	if d := sys.Timer.Sub(sys.clockTime()); d > 0 {
+		sys.elapsed += d
+	}
+}
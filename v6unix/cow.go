@@ -0,0 +1,158 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Copy-on-write fork is not in v6, whose newproc copied
+// the whole image of the parent; the code is new.
+// After fork, the child's memory is the parent's, except
+// for the pages either of them has written since, which the
+// child has copies of in its own Mem. A process is never
+// both sharing another's memory and sharing its own.
+
+package v6unix
+
+import "math/bits"
+
+// cowPage is the size of the pages that are copied on write.
+const cowPage = 1 << 10
+
+// A cowmem is the memory of a process whose pages are shared
+// copy-on-write, the child of a fork or its parent.
+// The process reads each page from where it is
+// and takes a private copy before writing it.
+type cowmem struct {
+	p *Proc
+}
+
+func (m cowmem) ReadB(addr uint16) (uint8, error) {
+	return m.p.page(addr)[0], nil
+}
+
+func (m cowmem) ReadW(addr uint16) (uint16, error) {
+	lo, _ := m.ReadB(addr)
+	hi, _ := m.ReadB(addr + 1)
+	return uint16(lo) | uint16(hi)<<8, nil
+}
+
+func (m cowmem) WriteB(addr uint16, val uint8) error {
+	m.p.unshare(int(addr), 1)
+	m.p.Mem[addr] = val
+	return nil
+}
+
+func (m cowmem) WriteW(addr uint16, val uint16) error {
+	m.p.unshare(int(addr), 2)
+	m.p.Mem[addr] = uint8(val)
+	m.p.Mem[addr+1] = uint8(val >> 8)
+	return nil
+}
+
+// page returns the rest of the page of p's memory at addr
+// for reading, from the parent's memory if p still shares it.
+func (p *Proc) page(addr uint16) []byte {
+	mem := &p.Mem
+	switch {
+	case p.vmem != nil:
+		mem = p.vmem
+	case p.cowpages&(1<<(addr/cowPage)) != 0:
+		mem = &p.cowsrc.Mem
+	}
+	return mem[addr : (int(addr)/cowPage+1)*cowPage]
+}
+
+// umemRange returns the n bytes of p's memory at addr
+// for reading or writing, first making their pages p's own.
+// Unlike umem, it leaves the other pages shared.
+func (p *Proc) umemRange(addr, n int) []byte {
+	p.unshare(addr, n)
+	if p.vmem != nil {
+		return p.vmem[addr : addr+n]
+	}
+	return p.Mem[addr : addr+n]
+}
+
+// cowfork makes the memory of the new process c
+// that of p, sharing its pages copy-on-write.
+// A process borrowing memory by vfork has its memory copied,
+// and one sharing its parent's is given its own first.
+func (p *Proc) cowfork(c *Proc) {
+	if p.vmem != nil {
+		c.Mem = *p.vmem
+		return
+	}
+	if p.cowsrc != nil {
+		p.unshare(0, 1<<16)
+	}
+	c.cowsrc = p
+	c.cowpages = ^uint64(0)
+	c.CPU.Mem = cowmem{c}
+	p.cowkids = append(p.cowkids, c)
+	p.CPU.Mem = cowmem{p}
+}
+
+/*
+ * Make the pages holding the n bytes
+ * at addr p's own, copying them from
+ * the parent if p shares them, or to
+ * the children that share them from p.
+ */
+func (p *Proc) unshare(addr, n int) {
+	if n <= 0 || p.cowsrc == nil && p.cowkids == nil {
+		return
+	}
+	first := addr / cowPage
+	last := min(addr+n-1, 1<<16-1) / cowPage
+	mask := (^uint64(0) >> (63 - last)) &^ (1<<first - 1)
+	if src := p.cowsrc; src != nil {
+		for m := p.cowpages & mask; m != 0; m &= m - 1 {
+			a := bits.TrailingZeros64(m) * cowPage
+			copy(p.Mem[a:a+cowPage], src.Mem[a:])
+		}
+		if p.cowpages &^= mask; p.cowpages == 0 {
+			src.cowdrop(p)
+		}
+	}
+	for i := 0; i < len(p.cowkids); i++ {
+		c := p.cowkids[i]
+		for m := c.cowpages & mask; m != 0; m &= m - 1 {
+			a := bits.TrailingZeros64(m) * cowPage
+			copy(c.Mem[a:a+cowPage], p.Mem[a:])
+		}
+		if c.cowpages &^= mask; c.cowpages == 0 {
+			p.cowdrop(c)
+			i--
+		}
+	}
+}
+
+// cowdrop stops c sharing p's memory,
+// without copying the pages it still shares.
+func (p *Proc) cowdrop(c *Proc) {
+	for i, c1 := range p.cowkids {
+		if c1 == c {
+			p.cowkids = append(p.cowkids[:i], p.cowkids[i+1:]...)
+			break
+		}
+	}
+	if len(p.cowkids) == 0 {
+		p.cowkids = nil
+		p.CPU.Mem = &p.Mem
+	}
+	c.cowsrc = nil
+	c.cowpages = 0
+	c.CPU.Mem = &c.Mem
+}
+
+/*
+ * Stop sharing memory, as when p's image
+ * is replaced by exec or freed by exit.
+ * Pages p shares with a parent are dropped,
+ * and pages children share with p are
+ * copied to them.
+ */
+func (p *Proc) cowfree() {
+	if p.cowsrc != nil {
+		p.cowsrc.cowdrop(p)
+	}
+	p.unshare(0, 1<<16)
+}
@@ -0,0 +1,134 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// The controlling terminal device is not in v6; the code is new,
+// after the indirect tty driver (sy) of v7.
+// /dev/tty is whichever tty is the controlling terminal
+// of the process using it, so a program can talk to its user
+// even with its standard input and output redirected.
+
+package v6unix
+
+const ctyMajor = 11 // /dev/tty, the controlling terminal
+
+// ControllingTTY returns p's controlling terminal, or nil if it has none.
+func (p *Proc) ControllingTTY() *TTY {
+	return p.TTY
+}
+
+// acquire makes tty the controlling terminal of p, just opening it,
+// if p has none and either leads its process group or is in none,
+// and tty is not already the controlling terminal of another group.
+// A leader acquiring the tty makes its group the tty's own,
+// and the foreground group if there is none.
+// Other processes inherit their controlling terminal by fork.
+func (tty *TTY) acquire(p *Proc, ttyp int16) {
+	if p.TTY != nil || p.Pgrp != 0 && p.Pgrp != p.Pid {
+		return
+	}
+	if tty.sess != 0 && tty.sess != p.Pgrp {
+		return
+	}
+	p.TTY = tty
+	p.ttyp = ttyp
+	if p.Pgrp != 0 {
+		tty.sess = p.Pgrp
+		if tty.Pgrp == 0 {
+			tty.Pgrp = p.Pgrp
+		}
+	}
+}
+
+// release makes tty no process's controlling terminal,
+// on its last close, by closer, or a hangup, when closer is nil.
+// The processes that had it, other than closer and init,
+// get SIGHUP if they are in the foreground group, or all of
+// them if there is none, as sys.signal would send it.
+// A stopped process is in a group the tty's going has orphaned,
+// with no one left to continue it, so it gets SIGHUP
+// and then SIGCONT, to die of the hangup.
+func (tty *TTY) release(closer *Proc) {
+	for _, p := range tty.Sys.Procs {
+		if p.TTY != tty {
+			continue
+		}
+		if p != closer && p.Pid != 1 {
+			stopped := p.status == _SSTOP && p.flag&_STRC == 0
+			if stopped || tty.Pgrp == 0 || p.Pgrp == tty.Pgrp {
+				tty.Sys.psignal(p, SIGHUP)
+			}
+			if stopped {
+				tty.Sys.psignal(p, SIGCONT)
+			}
+		}
+		p.TTY = nil
+		p.ttyp = 0
+	}
+	tty.sess = 0
+	tty.Pgrp = 0
+}
+
+// ctydev is /dev/tty. Every operation on it
+// is passed on to the caller's controlling terminal.
+type ctydev struct{}
+
+// ctty returns the device and minor number of
+// p's controlling terminal. If p has none,
+// ctty sets ENXIO and returns a nil device.
+func (p *Proc) ctty() (device, uint8) {
+	if p.TTY == nil {
+		p.Error = ENXIO
+		return nil, 0
+	}
+	return p.dev(p.TTY.major, p.TTY.minor), p.TTY.minor
+}
+
+func (ctydev) tty(p *Proc, minor uint8) *TTY {
+	if p.TTY == nil {
+		p.Error = ENXIO
+	}
+	return p.TTY
+}
+
+func (ctydev) open(p *Proc, minor uint8, rw int) {
+	if d, m := p.ctty(); d != nil {
+		d.open(p, m, rw)
+	}
+}
+
+func (ctydev) read(p *Proc, minor uint8, b []byte, off int) int {
+	if d, m := p.ctty(); d != nil {
+		return d.read(p, m, b, off)
+	}
+	return 0
+}
+
+func (ctydev) write(p *Proc, minor uint8, b []byte, off int) int {
+	if d, m := p.ctty(); d != nil {
+		return d.write(p, m, b, off)
+	}
+	return 0
+}
+
+// close does nothing: the terminal itself stays open
+// as long as it is open under its own name.
+func (ctydev) close(p *Proc, minor uint8) {}
+
+func (ctydev) sgtty(p *Proc, minor uint8, in, out *[3]uint16) {
+	if d, m := p.ctty(); d != nil {
+		d.sgtty(p, m, in, out)
+	}
+}
+
+func (ctydev) ioctl(p *Proc, minor uint8, cmd, addr uint16) {
+	d, m := p.ctty()
+	if d == nil {
+		return
+	}
+	if d, ok := d.(ioctler); ok {
+		d.ioctl(p, m, cmd, addr)
+		return
+	}
+	p.Error = ENOTTY
+}
@@ -5,6 +5,10 @@
 package v6unix
 
 import (
+	"io"
+	"math/rand"
+	"slices"
+	"time"
 	"unsafe"
 )
 
@@ -69,23 +73,135 @@ type device interface {
 	sgtty(*Proc, uint8, *[3]uint16, *[3]uint16)
 }
 
+// A flusher is a device that holds delayed writes,
+// such as a block device with a buffer cache.
+// sync calls flush to write them back.
+type flusher interface {
+	flush() error
+}
+
+// A streamer is a device with no notion of position,
+// such as a terminal or printer. lseek on it fails with ESPIPE.
+type streamer interface {
+	stream()
+}
+
+func (ttydev) stream() {}
+func (ptydev) stream() {}
+func (ptmdev) stream() {}
+func (lpdev) stream()  {}
+func (ctydev) stream() {}
+
 // deviceインタフェースのスライス
 // オブジェクトのリストを保持
-var devtab = []device{
+// Systemごとのデバイステーブルはこれをコピーして作る
+var defaultDevtab = []device{
 	errdev{},  // エラーデバイス
 	nulldev{}, // ヌルデバイス
-	memdev{},  // メモリデバイス
+	memdev{},  // メモリデバイス (minor 1 is kmemdev)
 	nulldev{}, // for /dev/swap
 	ttydev{},
+	zerodev{}, // 5: /dev/zero
+	randdev{}, // 6: /dev/random
+	fulldev{}, // 7: /dev/full
+	lpdev{},   // 8: /dev/lp
+	ptydev{},  // 9: /dev/ptyN
+	ptmdev{},  // 10: /dev/ptmN
+	ctydev{},  // 11: /dev/tty
 }
 
-func (p *Proc) dev(major uint8) device {
+// devices returns the system's device table,
+// creating it from defaultDevtab on first use.
+func (sys *System) devices() []device {
+	if sys.devtab == nil {
+		sys.devtab = slices.Clone(defaultDevtab)
+	}
+	return sys.devtab
+}
+
+func (p *Proc) dev(major, minor uint8) device {
+	if major == memMajor && minor == kmemMinor {
+		return kmemdev{}
+	}
+	devtab := p.Sys.devices()
 	if int(major) >= len(devtab) || devtab[major] == nil {
 		major = 0
 	}
 	return devtab[major]
 }
 
+// A Device is a character device driver, installed by RegisterDevice.
+// The kernel calls its routines for the system calls on special files
+// with its major number, passing the calling process and the minor number.
+// They report failure by setting p.Error.
+// Open is passed a nonzero rw when the file is opened for writing.
+// Read and Write are passed the file offset and return the number of bytes
+// transferred. Sgtty sets the device's mode words from in if it is not nil,
+// as stty does, and returns them in out if it is not nil, as gtty does.
+type Device interface {
+	Open(p *Proc, minor uint8, rw int)
+	Read(p *Proc, minor uint8, b []byte, off int) int
+	Write(p *Proc, minor uint8, b []byte, off int) int
+	Close(p *Proc, minor uint8)
+	Sgtty(p *Proc, minor uint8, in, out *[3]uint16)
+}
+
+// A driver is a Device as the kernel calls it.
+type driver struct {
+	d Device
+}
+
+func (x driver) open(p *Proc, minor uint8, rw int) {
+	x.d.Open(p, minor, rw)
+}
+
+func (x driver) read(p *Proc, minor uint8, b []byte, off int) int {
+	return x.d.Read(p, minor, b, off)
+}
+
+func (x driver) write(p *Proc, minor uint8, b []byte, off int) int {
+	return x.d.Write(p, minor, b, off)
+}
+
+func (x driver) close(p *Proc, minor uint8) {
+	x.d.Close(p, minor)
+}
+
+func (x driver) sgtty(p *Proc, minor uint8, in, out *[3]uint16) {
+	x.d.Sgtty(p, minor, in, out)
+}
+
+// RegisterDevice installs d as the character device with the given major number.
+// It returns EBUSY if that major number is already in use.
+func (sys *System) RegisterDevice(major uint8, d Device) error {
+	return sys.install(major, driver{d})
+}
+
+// install is RegisterDevice for the kernel's own devices.
+func (sys *System) install(major uint8, d device) error {
+	devtab := sys.devices()
+	if int(major) < len(devtab) && devtab[major] != nil {
+		return EBUSY
+	}
+	for len(devtab) <= int(major) {
+		devtab = append(devtab, nil)
+	}
+	devtab[major] = d
+	sys.devtab = devtab
+	return nil
+}
+
+// UnregisterDevice removes the device with the given major number.
+// It returns ENXIO if there is no such device.
+func (sys *System) UnregisterDevice(major uint8) error {
+	devtab := sys.devices()
+	if int(major) >= len(devtab) || devtab[major] == nil {
+		return ENXIO
+	}
+	devtab[major] = nil
+	return nil
+}
+
 // エラーデバイス
 // 全ての操作でエラーを返すデバイス
 type errdev struct{}
@@ -134,6 +250,147 @@ func (nulldev) sgtty(p *Proc, minor uint8, in, out *[3]uint16) {
 	p.Error = ENOTTY
 }
 
+// ゼロデバイス
+// 読み込みはバッファをゼロで埋め、書き込みはnulldevと同じく捨てる
+type zerodev struct{}
+
+func (zerodev) open(p *Proc, minor uint8, rw int) {
+}
+
+func (zerodev) read(p *Proc, minor uint8, b []byte, off int) int {
+	clear(b)
+	return len(b)
+}
+
+func (zerodev) write(p *Proc, minor uint8, b []byte, off int) int {
+	return len(b)
+}
+
+func (zerodev) close(p *Proc, minor uint8) {
+}
+
+func (zerodev) sgtty(p *Proc, minor uint8, in, out *[3]uint16) {
+	p.Error = ENOTTY
+}
+
+// 乱数デバイス
+// 読み込みはSystemの乱数源で埋め、書き込みは捨てる
+type randdev struct{}
+
+func (randdev) open(p *Proc, minor uint8, rw int) {
+}
+
+func (randdev) read(p *Proc, minor uint8, b []byte, off int) int {
+	s := p.Sys
+	s.randMu.Lock()
+	defer s.randMu.Unlock()
+	if s.rand == nil {
+		s.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	s.rand.Read(b)
+	return len(b)
+}
+
+func (randdev) write(p *Proc, minor uint8, b []byte, off int) int {
+	return len(b)
+}
+
+func (randdev) close(p *Proc, minor uint8) {
+}
+
+func (randdev) sgtty(p *Proc, minor uint8, in, out *[3]uint16) {
+	p.Error = ENOTTY
+}
+
+// SeedRandom reinitializes the source used by /dev/random,
+// so that the bytes it returns are reproducible.
+func (sys *System) SeedRandom(seed int64) {
+	sys.randMu.Lock()
+	defer sys.randMu.Unlock()
+	sys.rand = rand.New(rand.NewSource(seed))
+}
+
+// 満杯デバイス
+// 読み込みはzerodevと同じ、書き込みは常にENOSPC
+// ディスクが一杯になった時の挙動を試すのに使う
+type fulldev struct{}
+
+func (fulldev) open(p *Proc, minor uint8, rw int) {
+}
+
+func (fulldev) read(p *Proc, minor uint8, b []byte, off int) int {
+	clear(b)
+	return len(b)
+}
+
+func (fulldev) write(p *Proc, minor uint8, b []byte, off int) int {
+	p.Error = ENOSPC
+	return 0
+}
+
+func (fulldev) close(p *Proc, minor uint8) {
+}
+
+func (fulldev) sgtty(p *Proc, minor uint8, in, out *[3]uint16) {
+	p.Error = ENOTTY
+}
+
+const lpMajor = 8 // /dev/lp
+
+// ラインプリンタ
+// 書き込みはwに追記し、読み込みは常に0
+// wがnilならSystemのバッファ（LPOutputで見える）に書く
+// wはSetLPWriterで設定する
+type lpdev struct {
+	w io.Writer
+}
+
+func (d lpdev) writer(p *Proc) io.Writer {
+	if d.w != nil {
+		return d.w
+	}
+	return &p.Sys.lpout
+}
+
+func (lpdev) open(p *Proc, minor uint8, rw int) {
+}
+
+func (lpdev) read(p *Proc, minor uint8, b []byte, off int) int {
+	return 0
+}
+
+func (d lpdev) write(p *Proc, minor uint8, b []byte, off int) int {
+	n, err := d.writer(p).Write(b)
+	if err != nil {
+		p.Error = EIO
+	}
+	return n
+}
+
+// 閉じる時に用紙を送る（LPTrailer、普通はフォームフィード）
+func (d lpdev) close(p *Proc, minor uint8) {
+	if len(p.Sys.LPTrailer) > 0 {
+		if _, err := d.writer(p).Write(p.Sys.LPTrailer); err != nil {
+			p.Error = EIO
+		}
+	}
+}
+
+func (lpdev) sgtty(p *Proc, minor uint8, in, out *[3]uint16) {
+	p.Error = ENOTTY
+}
+
+// LPOutput returns everything printed on /dev/lp so far.
+func (sys *System) LPOutput() []byte {
+	return sys.lpout.Bytes()
+}
+
+// SetLPWriter makes /dev/lp print on w, instead of the buffer
+// that LPOutput returns, or on that buffer again if w is nil.
+func (sys *System) SetLPWriter(w io.Writer) {
+	sys.devices()[lpMajor] = lpdev{w}
+}
+
 const (
 	// as listed in unix kernel
 	// UNIXカーネルに記載されている通り
@@ -145,11 +402,22 @@ const (
 	memTTY     = 0o002000 // to 0o002440  0o002440まで
 	memTTYSize = 16 * 2
 
+	// /dev/mem: process images.
 	// テキストセグメントの開始位置？
-	memText = 0o010000
+	// high enough that p_addr stays positive for a 64K process
+	memText = 0o400000
 )
 
-// 特定のメモリ領域を模倣するデバイス
+// /dev/mem and /dev/kmem share a major number, told apart by minor,
+// as in the nodes in the file system image: /dev/mem is 2,0 and /dev/kmem 2,1.
+const (
+	memMajor  = 2
+	kmemMinor = 1
+)
+
+// 物理メモリを模倣するデバイス
+// プロセスのイメージ（テキスト）を読み出す
+// カーネルのデータは/dev/kmemで見るので、memTextより下はENXIO
 type memdev struct{}
 
 // 特定のプロセス(p)、マイナー番号(minor)、および読み書きモード(rw)を引数に取りますが、現在は何も実行しない
@@ -159,6 +427,54 @@ func (memdev) open(p *Proc, minor uint8, rw int) {
 // オフセットに基づいて動作が異なる
 // 読み出したデータの長さを返す
 func (memdev) read(p *Proc, minor uint8, b []byte, off int) int {
+	if off < memText {
+		p.Error = ENXIO
+		return 0
+	}
+
+	// offがmemTextとmemTextにプロセスの数を64倍して足した値の間で、
+	// offが64の倍数で、
+	// bの長さが512の場合、
+	// 特定のプロセスのメモリを読み出し
+	if memText <= off && off < memText+64*int(len(p.Sys.Procs)) && off&63 == 0 && len(b) == 512 {
+		// offとmemTextはおそらくメモリオフセットとテキストセグメントの開始位置
+		// これらの差を64で割ることで、特定のプロセスを指すインデックスを計算
+		p1 := p.Sys.Procs[(off-memText)/64]
+		// 取得したプロセスp1のメモリ領域から最後の512バイトを取得
+		mem := p1.umemRange(len(p1.Mem)-512, 512)
+		copy(b, mem)
+		return len(b)
+	}
+
+	return 0
+}
+
+// プロセスのイメージへの書き込みはEPERM、その下はENXIO
+func (memdev) write(p *Proc, minor uint8, b []byte, off int) int {
+	if off < memText {
+		p.Error = ENXIO
+		return 0
+	}
+	p.Error = EPERM
+	return 0
+}
+
+func (memdev) close(p *Proc, minor uint8) {
+}
+
+// ENOTTY（不適切な ioctl（入出力制御））エラーを設定するだけ
+func (memdev) sgtty(p *Proc, minor uint8, in, out *[3]uint16) {
+	p.Error = ENOTTY
+}
+
+// カーネルメモリを模倣するデバイス
+// プロセステーブルとTTYテーブルを見せる
+type kmemdev struct{}
+
+func (kmemdev) open(p *Proc, minor uint8, rw int) {
+}
+
+func (kmemdev) read(p *Proc, minor uint8, b []byte, off int) int {
 	// offがmemSwapDevと等しく、bの長さが2の場合、スワップデバイスのマイナーとメジャーを要求
 	if off == memSwapDev && len(b) == 2 {
 		// スワップデバイスのマイナー、メジャーを要求しています。
@@ -173,45 +489,18 @@ func (memdev) read(p *Proc, minor uint8, b []byte, off int) int {
 	// このコードは、プロセステーブルの各エントリに対して特定の操作を行い、その結果をbにコピー
 	if off == memProcs {
 		// プロセステーブルを要求しています。
-		var procs []procState
-		for i, p1 := range p.Sys.Procs {
-			p1.procState.flag |= _SLOAD
-
-			// psは(p1.addr+p1.size-8)<<6をアドレスとして
-			// 512バイトを読み出すつもりです。
-			// p1.size=8を設定すると、加算部分がゼロになり、p1.addrが残ります。
-			// プロセスの基本アドレスを64バイトごとに分けることで、
-			// "メモリ"に多くのプロセスを詰め込むことができます。
-			p1.addr = uint16(memText/64 + i)
-			p1.size = 8
-			procs = append(procs, p1.procState)
-		}
-		pb := unsafe.Slice((*byte)(unsafe.Pointer(&procs[0])), len(procs)*int(unsafe.Sizeof(procState{})))
+		pb := p.Sys.procTable()
 		clear(b)
 		copy(b, pb)
 		return len(pb)
 	}
 
-	// offがmemTextとmemTextにプロセスの数を64倍して足した値の間で、
-	// offが64の倍数で、
-	// bの長さが512の場合、
-	// 特定のプロセスのメモリを読み出し
-	if memText <= off && off < memText+64*int(len(p.Sys.Procs)) && off&63 == 0 && len(b) == 512 {
-		// offとmemTextはおそらくメモリオフセットとテキストセグメントの開始位置
-		// これらの差を64で割ることで、特定のプロセスを指すインデックスを計算
-		p1 := p.Sys.Procs[(off-memText)/64]
-		// 取得したプロセスp1のメモリ領域から最後の512バイトを取得
-		mem := p1.Mem[len(p.Mem)-512:]
-		copy(b, mem)
-		return len(b)
-	}
-
 	// offがmemTTYとmemTTYにTTYの数をmemTTYSize倍した値の間で、
 	// offからmemTTYを引いた値がmemTTYSizeの倍数で、
 	// bの長さがmemTTYSizeの場合
-	if memTTY <= off && off < memTTY+len(p.Sys.TTY)*memTTYSize && (off-memTTY)%memTTYSize == 0 && len(b) == memTTYSize {
+	if memTTY <= off && off < memTTY+len(p.Sys.ttys)*memTTYSize && (off-memTTY)%memTTYSize == 0 && len(b) == memTTYSize {
 		i := (off - memTTY) / memTTYSize
-		tty := &p.Sys.TTY[i]
+		tty := p.Sys.ttys[i]
 		tb := (*[unsafe.Sizeof(TDev{})]byte)(unsafe.Pointer(&tty.TDev))[:]
 		clear(b)
 		copy(b, tb)
@@ -221,16 +510,65 @@ func (memdev) read(p *Proc, minor uint8, b []byte, off int) int {
 	return 0
 }
 
-// EPERM（許可されていない操作）エラーを設定し、0を返すだけ
-func (memdev) write(p *Proc, minor uint8, b []byte, off int) int {
+// procTable returns the process table as /dev/kmem shows it.
+// The memory for it is reallocated only when ProcGeneration
+// has changed since the last call; the entries are copied
+// every time, since priorities, sizes and the like change
+// without a change of generation.
+// プロセステーブルが変わったときだけ作り直す
+func (sys *System) procTable() []byte {
+	if sys.procTab == nil || sys.procTabGen != sys.procGen || len(sys.procTab) != len(sys.Procs) {
+		sys.procTab = make([]procState, len(sys.Procs))
+		sys.procTabGen = sys.procGen
+	}
+	for i, p1 := range sys.Procs {
+		p1.procState.flag |= _SLOAD
+
+		// psは(p1.addr+p1.size-8)<<6をアドレスとして
+		// 512バイトを読み出すつもりです。
+		// p1.sizeには本当の大きさを入れ、p1.addrをその分ずらすと、
+		// 加算の結果はmemText/64+iになります。
+		// プロセスの基本アドレスを64バイトごとに分けることで、
+		// "メモリ"に多くのプロセスを詰め込むことができます。
+		// ps reads 512 bytes at (p1.addr+p1.size-8)<<6.
+		// Report the real size and shift p1.addr to match,
+		// so that the sum is still memText/64+i.
+		p1.size = p1.imageSize()
+		p1.addr = uint16(memText/64 + i + 8 - int(p1.size))
+		sys.procTab[i] = p1.procState
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(unsafe.SliceData(sys.procTab))), len(sys.procTab)*int(unsafe.Sizeof(procState{})))
+}
+
+// ProcGeneration returns a number that changes when a process
+// is created or exits, or changes state between running, sleeping,
+// and stopped. Other changes to a process, such as to its priority,
+// nice value, user ids or size, leave it the same.
+func (sys *System) ProcGeneration() uint64 {
+	return sys.procGen
+}
+
+// TTY領域への書き込みだけを許し、読み出しと同じ条件でTDevに書き戻す
+// それ以外（プロセステーブルなど）はEPERM
+func (kmemdev) write(p *Proc, minor uint8, b []byte, off int) int {
+	if memTTY <= off && off < memTTY+len(p.Sys.ttys)*memTTYSize && (off-memTTY)%memTTYSize == 0 && len(b) == memTTYSize {
+		i := (off - memTTY) / memTTYSize
+		tty := p.Sys.ttys[i]
+		// The device numbers identify the tty; don't let a write change them.
+		minor, major := tty.minor, tty.major
+		tb := (*[unsafe.Sizeof(TDev{})]byte)(unsafe.Pointer(&tty.TDev))[:]
+		copy(tb, b)
+		tty.minor, tty.major = minor, major
+		return len(b)
+	}
+
 	p.Error = EPERM
 	return 0
 }
 
-func (memdev) close(p *Proc, minor uint8) {
+func (kmemdev) close(p *Proc, minor uint8) {
 }
 
-// ENOTTY（不適切な ioctl（入出力制御））エラーを設定するだけ
-func (memdev) sgtty(p *Proc, minor uint8, in, out *[3]uint16) {
+func (kmemdev) sgtty(p *Proc, minor uint8, in, out *[3]uint16) {
 	p.Error = ENOTTY
 }
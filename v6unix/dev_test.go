@@ -0,0 +1,453 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package v6unix
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"unsafe"
+)
+
+func TestRandSeed(t *testing.T) {
+	var sys System
+	p := &Proc{Sys: &sys}
+
+	read := func() []byte {
+		b := make([]byte, 32)
+		if n := (randdev{}).read(p, 0, b, 0); n != len(b) {
+			t.Fatalf("read = %d, want %d", n, len(b))
+		}
+		return b
+	}
+
+	sys.SeedRandom(1)
+	b1 := read()
+	sys.SeedRandom(1)
+	b2 := read()
+	if !bytes.Equal(b1, b2) {
+		t.Errorf("same seed gave different bytes:\n%x\n%x", b1, b2)
+	}
+	sys.SeedRandom(2)
+	if b3 := read(); bytes.Equal(b1, b3) {
+		t.Errorf("different seeds gave same bytes: %x", b1)
+	}
+}
+
+func TestZero(t *testing.T) {
+	var sys System
+	p := &Proc{Sys: &sys}
+	d := p.dev(5, 0)
+	b := []byte("junk")
+	if n := d.read(p, 0, b, 0); n != len(b) || !bytes.Equal(b, make([]byte, len(b))) {
+		t.Errorf("read = %d, %q, want %d zeros", n, b, len(b))
+	}
+	if n := d.write(p, 0, []byte("discarded"), 0); n != len("discarded") || p.Error != 0 {
+		t.Errorf("write = %d, %v, want %d, 0", n, p.Error, len("discarded"))
+	}
+	if n := d.read(p, 0, b, 0); n != len(b) || !bytes.Equal(b, make([]byte, len(b))) {
+		t.Errorf("read after write = %d, %q, want %d zeros", n, b, len(b))
+	}
+}
+
+func TestFull(t *testing.T) {
+	var sys System
+	p := &Proc{Sys: &sys}
+	d := p.dev(7, 0)
+	b := []byte("junk")
+	if n := d.read(p, 0, b, 0); n != len(b) || !bytes.Equal(b, make([]byte, len(b))) {
+		t.Errorf("read = %d, %q, want %d zeros", n, b, len(b))
+	}
+	if n := d.write(p, 0, []byte("x"), 0); n != 0 || p.Error != ENOSPC {
+		t.Errorf("write = %d, %v, want 0, ENOSPC", n, p.Error)
+	}
+}
+
+func TestMemWriteTTY(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Proc{Sys: sys}
+	sys.TTY[2].flags = ECHO
+
+	off := memTTY + 2*memTTYSize
+	b := make([]byte, memTTYSize)
+	if n := (kmemdev{}).read(p, kmemMinor, b, off); n != memTTYSize {
+		t.Fatalf("read = %d, want %d", n, memTTYSize)
+	}
+	b[unsafe.Offsetof(TDev{}.flags)] |= RAW
+	b[unsafe.Offsetof(TDev{}.minor)] = 7
+	if n := (kmemdev{}).write(p, kmemMinor, b, off); n != memTTYSize || p.Error != 0 {
+		t.Fatalf("write = %d, %v, want %d, 0", n, p.Error, memTTYSize)
+	}
+	if tty := &sys.TTY[2]; tty.flags != ECHO|RAW || tty.minor != 2 || tty.major != 4 {
+		t.Errorf("after write: flags=%#o minor=%d major=%d, want %#o 2 4", tty.flags, tty.minor, tty.major, ECHO|RAW)
+	}
+
+	for _, off := range []int{memTTY + 1, memProcs, memText} {
+		p.Error = 0
+		if n := (kmemdev{}).write(p, kmemMinor, b, off); n != 0 || p.Error != EPERM {
+			t.Errorf("write %#o = %d, %v, want 0, EPERM", off, n, p.Error)
+		}
+	}
+}
+
+func TestMemMinor(t *testing.T) {
+	var sys System
+	p := &Proc{Sys: &sys}
+	if _, ok := p.dev(memMajor, 0).(memdev); !ok {
+		t.Errorf("dev(%d, 0) = %T, want memdev", memMajor, p.dev(memMajor, 0))
+	}
+	if _, ok := p.dev(memMajor, kmemMinor).(kmemdev); !ok {
+		t.Errorf("dev(%d, %d) = %T, want kmemdev", memMajor, kmemMinor, p.dev(memMajor, kmemMinor))
+	}
+
+	// kmem does not see process images.
+	b := make([]byte, 512)
+	sys.Procs = []*Proc{p}
+	if n := (kmemdev{}).read(p, kmemMinor, b, memText); n != 0 {
+		t.Errorf("kmem read %#o = %d, want 0", memText, n)
+	}
+	if n := (memdev{}).read(p, 0, b, memText); n != 512 {
+		t.Errorf("mem read %#o = %d, want 512", memText, n)
+	}
+
+	// mem does not see kernel data.
+	for _, off := range []int{memProcs, memTTY} {
+		p.Error = 0
+		if n := (memdev{}).read(p, 0, b, off); n != 0 || p.Error != ENXIO {
+			t.Errorf("mem read %#o = %d, %v, want 0, ENXIO", off, n, p.Error)
+		}
+		p.Error = 0
+		if n := (memdev{}).write(p, 0, b, off); n != 0 || p.Error != ENXIO {
+			t.Errorf("mem write %#o = %d, %v, want 0, ENXIO", off, n, p.Error)
+		}
+	}
+}
+
+// TestMemNodes reads /dev/mem and /dev/kmem through
+// the device nodes in the file system image.
+func TestMemNodes(t *testing.T) {
+	p := rootProc(t)
+	p.Pid = 1
+	p.Sys.Procs = []*Proc{p}
+	read := func(name string, off int) (int, Errno) {
+		p.Error = 0
+		ip, _, _ := p.namei(name, nameFind)
+		if ip == nil {
+			t.Fatalf("%s: %v", name, p.Error)
+		}
+		defer p.iput(ip)
+		p.openi(ip, _FREAD)
+		if p.Error != 0 {
+			t.Fatalf("open %s: %v", name, p.Error)
+		}
+		return p.readi(ip, make([]byte, 512), off), p.Error
+	}
+	if n, err := read("/dev/kmem", memProcs); n == 0 || err != 0 {
+		t.Errorf("/dev/kmem read of process table = %d, %v", n, err)
+	}
+	if n, err := read("/dev/kmem", memText); n != 0 || err != 0 {
+		t.Errorf("/dev/kmem read of process image = %d, %v, want 0", n, err)
+	}
+	if n, err := read("/dev/mem", memText); n != 512 || err != 0 {
+		t.Errorf("/dev/mem read of process image = %d, %v, want 512", n, err)
+	}
+	if n, err := read("/dev/mem", memProcs); n != 0 || err != ENXIO {
+		t.Errorf("/dev/mem read of process table = %d, %v, want 0, ENXIO", n, err)
+	}
+}
+
+func TestProcTableCache(t *testing.T) {
+	p := rootProc(t)
+	sys := p.Sys
+	sys.Procs = []*Proc{p}
+	p.Pid = 1
+	read := func() []byte {
+		b := make([]byte, NPROC*unsafe.Sizeof(procState{}))
+		return b[:(kmemdev{}).read(p, kmemMinor, b, memProcs)]
+	}
+	first := read()
+
+	// With no change to the table, reads reuse the snapshot.
+	gen := sys.ProcGeneration()
+	b := make([]byte, len(first))
+	if n := testing.AllocsPerRun(10, func() { (kmemdev{}).read(p, kmemMinor, b, memProcs) }); n != 0 {
+		t.Errorf("process table read allocates %v times, want 0", n)
+	}
+	if !bytes.Equal(b, first) || sys.ProcGeneration() != gen {
+		t.Errorf("process table changed with no process changes")
+	}
+
+	// A fork changes the generation and the table.
+	c, err := sys.Fork(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sys.ProcGeneration() == gen {
+		t.Errorf("fork did not change the process generation")
+	}
+	second := read()
+	if len(second) != 2*len(first) || !bytes.Equal(second[:len(first)], first) {
+		t.Errorf("after fork: table of %d bytes, want %d starting with the old one", len(second), 2*len(first))
+	}
+	procs := unsafe.Slice((*procState)(unsafe.Pointer(&second[0])), 2)
+	if procs[1].Pid != c.Pid || procs[1].status != c.status {
+		t.Errorf("after fork: entry 1 is pid %d status %d, want %d %d", procs[1].Pid, procs[1].status, c.Pid, c.status)
+	}
+
+	// So does a change of state.
+	gen = sys.ProcGeneration()
+	sys.setrun(c)
+	if sys.ProcGeneration() == gen {
+		t.Errorf("setrun did not change the process generation")
+	}
+	procs = unsafe.Slice((*procState)(unsafe.Pointer(&read()[0])), 2)
+	if procs[1].status != _SRUN {
+		t.Errorf("after setrun: status %d, want %d", procs[1].status, _SRUN)
+	}
+
+	// Changes that leave the generation alone still show.
+	c.CPU.R[0] = 5
+	sysnice(c)
+	procs = unsafe.Slice((*procState)(unsafe.Pointer(&read()[0])), 2)
+	if procs[1].nice != 5 {
+		t.Errorf("after nice: nice %d, want 5", procs[1].nice)
+	}
+}
+
+// testdev is a Device whose reads return "test".
+type testdev struct {
+	reads int
+}
+
+func (d *testdev) Open(p *Proc, minor uint8, rw int) {}
+func (d *testdev) Close(p *Proc, minor uint8)        {}
+
+func (d *testdev) Read(p *Proc, minor uint8, b []byte, off int) int {
+	d.reads++
+	return copy(b, "test")
+}
+
+func (d *testdev) Write(p *Proc, minor uint8, b []byte, off int) int {
+	return len(b)
+}
+
+func (d *testdev) Sgtty(p *Proc, minor uint8, in, out *[3]uint16) {
+	p.Error = ENOTTY
+}
+
+func TestRegisterDevice(t *testing.T) {
+	var sys System
+	p := &Proc{Sys: &sys}
+
+	if err := sys.RegisterDevice(1, new(testdev)); err != EBUSY {
+		t.Errorf("RegisterDevice(1) = %v, want EBUSY", err)
+	}
+	d := new(testdev)
+	const major = 20
+	if err := sys.RegisterDevice(major, d); err != nil {
+		t.Fatalf("RegisterDevice(%d) = %v", major, err)
+	}
+	if err := sys.RegisterDevice(major, d); err != EBUSY {
+		t.Errorf("second RegisterDevice(%d) = %v, want EBUSY", major, err)
+	}
+	b := make([]byte, 10)
+	if n := p.dev(major, 0).read(p, 0, b, 0); n != 4 || d.reads != 1 {
+		t.Errorf("read = %d (reads=%d), want 4 (reads=1)", n, d.reads)
+	}
+
+	if err := sys.UnregisterDevice(major); err != nil {
+		t.Fatalf("UnregisterDevice(%d) = %v", major, err)
+	}
+	if err := sys.UnregisterDevice(major); err != ENXIO {
+		t.Errorf("second UnregisterDevice(%d) = %v, want ENXIO", major, err)
+	}
+	if _, ok := p.dev(major, 0).(errdev); !ok {
+		t.Errorf("dev(%d) = %T after unregister, want errdev", major, p.dev(major, 0))
+	}
+}
+
+func TestDevtabPerSystem(t *testing.T) {
+	var sys1, sys2 System
+	p1 := &Proc{Sys: &sys1}
+	p2 := &Proc{Sys: &sys2}
+	if err := sys1.UnregisterDevice(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := sys1.RegisterDevice(1, new(testdev)); err != nil {
+		t.Fatal(err)
+	}
+	if d, ok := p1.dev(1, 0).(driver); !ok || d.d == nil {
+		t.Errorf("sys1 dev(1) = %T, want the registered device", p1.dev(1, 0))
+	}
+	if _, ok := p2.dev(1, 0).(nulldev); !ok {
+		t.Errorf("sys2 dev(1) = %T, want nulldev", p2.dev(1, 0))
+	}
+}
+
+func TestLP(t *testing.T) {
+	var sys System
+	sys.LPTrailer = []byte("\f")
+	p := &Proc{Sys: &sys}
+	lp := p.dev(8, 0)
+	lp.open(p, 0, 1)
+	lp.write(p, 0, []byte("hello\n"), 0)
+	if n := lp.read(p, 0, make([]byte, 10), 0); n != 0 {
+		t.Errorf("read = %d, want 0", n)
+	}
+	lp.close(p, 0)
+	lp.sgtty(p, 0, nil, nil)
+	if p.Error != ENOTTY {
+		t.Errorf("sgtty: %v, want ENOTTY", p.Error)
+	}
+	if out := string(sys.LPOutput()); out != "hello\n\f" {
+		t.Errorf("LPOutput() = %q, want %q", out, "hello\n\f")
+	}
+
+	var w bytes.Buffer
+	sys.SetLPWriter(&w)
+	p.dev(8, 0).write(p, 0, []byte("again\n"), 0)
+	if w.String() != "again\n" || len(sys.LPOutput()) != len("hello\n\f") {
+		t.Errorf("after SetLPWriter: writer has %q, LPOutput %q", w.String(), sys.LPOutput())
+	}
+}
+
+// memDisk is an in-memory io.ReaderAt and io.WriterAt
+// that counts the calls made to it.
+type memDisk struct {
+	data          []byte
+	reads, writes int
+}
+
+func (m *memDisk) ReadAt(b []byte, off int64) (int, error) {
+	m.reads++
+	if off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n := copy(b, m.data[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *memDisk) WriteAt(b []byte, off int64) (int, error) {
+	m.writes++
+	for int64(len(m.data)) < off+int64(len(b)) {
+		m.data = append(m.data, 0)
+	}
+	return copy(m.data[off:], b), nil
+}
+
+func TestBlockDevice(t *testing.T) {
+	var sys System
+	p := &Proc{Sys: &sys}
+	disk := &memDisk{data: bytes.Repeat([]byte("x"), 4*BSIZE)}
+	const major = 20
+	if err := sys.AttachBlockDevice(major, disk); err != nil {
+		t.Fatal(err)
+	}
+	d := p.dev(major, 0)
+
+	// Unaligned write spanning two blocks stays in the cache.
+	msg := []byte("hello, world")
+	if n := d.write(p, 0, msg, BSIZE-5); n != len(msg) || p.Error != 0 {
+		t.Fatalf("write = %d, %v", n, p.Error)
+	}
+	if disk.writes != 0 {
+		t.Errorf("write went to disk before sync")
+	}
+	b := make([]byte, len(msg))
+	if n := d.read(p, 0, b, BSIZE-5); n != len(msg) || string(b) != string(msg) {
+		t.Errorf("read = %d %q, want %d %q", n, b, len(msg), msg)
+	}
+	reads := disk.reads
+	d.read(p, 0, b, BSIZE-5)
+	if disk.reads != reads {
+		t.Errorf("cached read went to disk")
+	}
+
+	if err := sys.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(disk.data[BSIZE-5 : BSIZE-5+len(msg)]); got != string(msg) {
+		t.Errorf("after sync disk has %q, want %q", got, msg)
+	}
+	if disk.writes != 2 {
+		t.Errorf("sync wrote %d blocks, want 2", disk.writes)
+	}
+
+	// Force eviction of dirty blocks by touching more than NBUF blocks.
+	for i := 0; i <= NBUF; i++ {
+		d.write(p, 0, []byte{byte('a' + i)}, (10+i)*BSIZE)
+	}
+	if disk.data[10*BSIZE] != 'a' {
+		t.Errorf("evicted block not written back")
+	}
+
+	// Reading past the end of the disk is EOF.
+	if n := d.read(p, 0, b, 100*BSIZE); n != 0 || p.Error != 0 {
+		t.Errorf("read past end = %d, %v, want 0, 0", n, p.Error)
+	}
+}
+
+func TestSyscallSync(t *testing.T) {
+	var sys System
+	p := &Proc{Sys: &sys}
+	disk := &memDisk{}
+	if err := sys.AttachBlockDevice(20, disk); err != nil {
+		t.Fatal(err)
+	}
+	p.dev(20, 0).write(p, 0, []byte("data"), 0)
+	p.CPU.R[0] = 1
+	syssync(p)
+	if p.Error != 0 || p.CPU.R[0] != 0 {
+		t.Errorf("sync: r0=%d, %v, want 0, 0", p.CPU.R[0], p.Error)
+	}
+	if string(disk.data[:4]) != "data" {
+		t.Errorf("sync did not write block: %q", disk.data)
+	}
+}
+
+func TestMknod(t *testing.T) {
+	p := rootProc(t)
+	mknod := func(name string, mode, dev uint16) Errno {
+		p.Error = 0
+		p.Args[0] = strArg(p, 0o1000, name)
+		p.Args[1], p.Args[2] = mode, dev
+		sysmknod(p)
+		return p.Error
+	}
+
+	// /tmp/zero is the zero device, major 5.
+	if err := mknod("/tmp/zero", _IFCHR|0o666, 5<<8|0); err != 0 {
+		t.Fatal(err)
+	}
+	ip, _, _ := p.namei("/tmp/zero", nameFind)
+	if ip == nil {
+		t.Fatal(p.Error)
+	}
+	if ip.mode&_IFMT != _IFCHR || ip.major != 5 || ip.minor != 0 {
+		t.Errorf("mode %#o major %d minor %d, want %#o 5 0", ip.mode&_IFMT, ip.major, ip.minor, _IFCHR)
+	}
+	b := []byte("xxxx")
+	if n := p.readi(ip, b, 0); n != 4 || string(b) != "\x00\x00\x00\x00" {
+		t.Errorf("readi = %d, %q, want 4 zeros", n, b)
+	}
+	p.iput(ip)
+
+	if err := mknod("/tmp/zero", _IFCHR|0o666, 1<<8); err != EEXIST {
+		t.Errorf("mknod existing: %v, want EEXIST", err)
+	}
+	p.Uid = 1
+	if err := mknod("/tmp/null", _IFCHR|0o666, 1<<8); err != EPERM {
+		t.Errorf("mknod as non-super-user: %v, want EPERM", err)
+	}
+	if _, err := lookup(p, "/tmp/null"); err != ENOENT {
+		t.Errorf("mknod as non-super-user created file: %v", err)
+	}
+}
@@ -5,9 +5,13 @@
 package v6unix
 
 import (
+	"archive/tar"
 	"bytes"
+	"errors"
+	"io"
 	"strings"
 	"testing"
+	"time"
 )
 
 var disktab = []struct {
@@ -90,6 +94,234 @@ func TestUnlink(t *testing.T) {
 	}
 }
 
+func TestLink(t *testing.T) {
+	p := rootProc(t)
+	link := func(old, new string) Errno {
+		p.Error = 0
+		p.Args[0], p.Args[1] = strArg(p, 0o1000, old), strArg(p, 0o1200, new)
+		syslink(p)
+		return p.Error
+	}
+	unlink := func(name string) Errno {
+		p.Error = 0
+		p.Args[0] = strArg(p, 0o1000, name)
+		sysunlink(p)
+		return p.Error
+	}
+	nlink := func(name string) int8 {
+		var st stat
+		p.Error = 0
+		if p.stat(name, &st); p.Error != 0 {
+			t.Fatalf("stat %s: %v", name, p.Error)
+		}
+		return st.nlink
+	}
+
+	ls, err := p.Sys.ReadFile("/bin/ls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := link("/bin/ls", "/tmp/ls"); err != 0 {
+		t.Fatal(err)
+	}
+	if n := nlink("/bin/ls"); n != 2 {
+		t.Errorf("nlink after link = %d, want 2", n)
+	}
+	if err := link("/bin/ls", "/tmp/ls"); err != EEXIST {
+		t.Errorf("link to existing name: %v, want EEXIST", err)
+	}
+	if err := unlink("/bin/ls"); err != 0 {
+		t.Fatal(err)
+	}
+	if n := nlink("/tmp/ls"); n != 1 {
+		t.Errorf("nlink after unlink = %d, want 1", n)
+	}
+	if _, err := lookup(p, "/bin/ls"); err != ENOENT {
+		t.Errorf("/bin/ls after unlink: %v, want ENOENT", err)
+	}
+
+	// An open file keeps its data until the last close.
+	p.Error = 0
+	p.Args[0], p.Args[1] = strArg(p, 0o1000, "/tmp/ls"), 0
+	if sysopen(p); p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	fd := p.CPU.R[0]
+	ip := p.Files[fd].inode
+	if err := unlink("/tmp/ls"); err != 0 {
+		t.Fatal(err)
+	}
+	if ip.nlink != 0 || p.Sys.Disk.inodes[ip.inum] != ip {
+		t.Errorf("unlinked open file: nlink %d, in table %v", ip.nlink, p.Sys.Disk.inodes[ip.inum] == ip)
+	}
+	b := make([]byte, len(ls))
+	if n := p.readi(ip, b, 0); n != len(ls) || !bytes.Equal(b, ls) {
+		t.Errorf("reading unlinked open file = %d bytes, want %d", n, len(ls))
+	}
+	closefd(p, fd)
+	if p.Sys.Disk.inodes[ip.inum] != nil || ip.data != nil || ip.mode != 0 {
+		t.Errorf("inode not freed after last close")
+	}
+}
+
+func TestMkdirRmdir(t *testing.T) {
+	p := rootProc(t)
+	mkdir := func(name string) Errno {
+		p.Error = 0
+		p.Args[0], p.Args[1] = strArg(p, 0o1000, name), 0o755
+		sysmkdir(p)
+		return p.Error
+	}
+	rmdir := func(name string) Errno {
+		p.Error = 0
+		p.Args[0] = strArg(p, 0o1000, name)
+		sysrmdir(p)
+		return p.Error
+	}
+	nlink := func(name string) int8 {
+		var st stat
+		p.Error = 0
+		if p.stat(name, &st); p.Error != 0 {
+			t.Fatalf("stat %s: %v", name, p.Error)
+		}
+		return st.nlink
+	}
+
+	tmp := nlink("/tmp")
+	if err := mkdir("/tmp/d"); err != 0 {
+		t.Fatal(err)
+	}
+	if n, m := nlink("/tmp/d"), nlink("/tmp"); n != 2 || m != tmp+1 {
+		t.Errorf("after mkdir: nlink %d, parent %d, want 2, %d", n, m, tmp+1)
+	}
+	dot, _ := lookup(p, "/tmp/d/.")
+	dotdot, _ := lookup(p, "/tmp/d/..")
+	d, _ := lookup(p, "/tmp/d")
+	parent, _ := lookup(p, "/tmp")
+	if dot != d || dotdot != parent {
+		t.Errorf("/tmp/d/. is %d, .. is %d, want %d, %d", dot, dotdot, d, parent)
+	}
+	if err := mkdir("/tmp/d"); err != EEXIST {
+		t.Errorf("mkdir of existing name: %v, want EEXIST", err)
+	}
+
+	// A directory with more than . and .. in it stays.
+	if err := mkdir("/tmp/d/e"); err != 0 {
+		t.Fatal(err)
+	}
+	if err := rmdir("/tmp/d"); err != ENOTEMPTY {
+		t.Errorf("rmdir of non-empty directory: %v, want ENOTEMPTY", err)
+	}
+	if err := rmdir("/tmp/d/e"); err != 0 {
+		t.Fatal(err)
+	}
+	if err := rmdir("/tmp/d"); err != 0 {
+		t.Fatal(err)
+	}
+	if n := nlink("/tmp"); n != tmp {
+		t.Errorf("after rmdir: parent nlink %d, want %d", n, tmp)
+	}
+	if _, err := lookup(p, "/tmp/d"); err != ENOENT {
+		t.Errorf("/tmp/d after rmdir: %v, want ENOENT", err)
+	}
+	if p.Sys.Disk.inodes[d] != nil {
+		t.Errorf("removed directory's inode not freed")
+	}
+
+	if err := rmdir("/etc/passwd"); err != ENOTDIR {
+		t.Errorf("rmdir of file: %v, want ENOTDIR", err)
+	}
+	if err := rmdir("/tmp/."); err != EINVAL {
+		t.Errorf("rmdir of .: %v, want EINVAL", err)
+	}
+}
+
+func TestRename(t *testing.T) {
+	p := rootProc(t)
+	rename := func(from, to string) Errno {
+		p.Error = 0
+		p.Args[0], p.Args[1] = strArg(p, 0o1000, from), strArg(p, 0o1200, to)
+		sysrename(p)
+		return p.Error
+	}
+	mkdir := func(name string) {
+		p.Error = 0
+		p.Args[0], p.Args[1] = strArg(p, 0o1000, name), 0o755
+		if sysmkdir(p); p.Error != 0 {
+			t.Fatal(p.Error)
+		}
+	}
+	nlink := func(name string) int8 {
+		var st stat
+		p.Error = 0
+		if p.stat(name, &st); p.Error != 0 {
+			t.Fatalf("stat %s: %v", name, p.Error)
+		}
+		return st.nlink
+	}
+
+	create := func(name, data string) {
+		p.Error = 0
+		p.Args[0], p.Args[1] = strArg(p, 0o1000, name), 0o644
+		if syscreate(p); p.Error != 0 {
+			t.Fatal(p.Error)
+		}
+		fd := p.CPU.R[0]
+		p.writei(p.Files[fd].inode, []byte(data), 0)
+		closefd(p, fd)
+	}
+
+	// Renaming onto an existing file replaces it.
+	create("/tmp/new", "new")
+	create("/tmp/old", "old")
+	newIno, _ := lookup(p, "/tmp/new")
+	oldIno, _ := lookup(p, "/tmp/old")
+	if err := rename("/tmp/new", "/tmp/old"); err != 0 {
+		t.Fatal(err)
+	}
+	if ino, _ := lookup(p, "/tmp/old"); ino != newIno {
+		t.Errorf("/tmp/old is inode %d after rename, want %d", ino, newIno)
+	}
+	if _, err := lookup(p, "/tmp/new"); err != ENOENT {
+		t.Errorf("/tmp/new after rename: %v, want ENOENT", err)
+	}
+	if p.Sys.Disk.inodes[oldIno] != nil {
+		t.Errorf("replaced file's inode not freed")
+	}
+	if b, err := p.Sys.ReadFile("/tmp/old"); string(b) != "new" || err != nil {
+		t.Errorf("/tmp/old = %q, %v, want %q", b, err, "new")
+	}
+
+	// A directory moved to a new parent gets a new ..
+	tmp := nlink("/tmp")
+	mkdir("/tmp/d")
+	mkdir("/tmp/e")
+	if err := rename("/tmp/d", "/tmp/e/d"); err != 0 {
+		t.Fatal(err)
+	}
+	e, _ := lookup(p, "/tmp/e")
+	if dotdot, _ := lookup(p, "/tmp/e/d/.."); dotdot != e {
+		t.Errorf("/tmp/e/d/.. is %d, want %d", dotdot, e)
+	}
+	if n, m := nlink("/tmp"), nlink("/tmp/e"); n != tmp+1 || m != 3 {
+		t.Errorf("after moving directory: nlink /tmp %d, /tmp/e %d, want %d, 3", n, m, tmp+1)
+	}
+
+	// But not into itself.
+	if err := rename("/tmp/e", "/tmp/e/d/x"); err != EINVAL {
+		t.Errorf("moving directory into itself: %v, want EINVAL", err)
+	}
+	if err := rename("/tmp/e", "/tmp/e/x"); err != EINVAL {
+		t.Errorf("moving directory into itself: %v, want EINVAL", err)
+	}
+	if err := rename("/tmp/old", "/tmp/e"); err != EISDIR {
+		t.Errorf("renaming file onto directory: %v, want EISDIR", err)
+	}
+	if err := rename("/tmp/e", "/tmp/old"); err != ENOTDIR {
+		t.Errorf("renaming directory onto file: %v, want ENOTDIR", err)
+	}
+}
+
 func TestEcho(t *testing.T) {
 	sys, err := NewSystem(FS)
 	if err != nil {
@@ -192,3 +424,166 @@ func TestShellDate(t *testing.T) {
 		t.Fatalf("have stdout=%q stderr=%q\nwant stdin=%q stdout=%q stderr=%q\n", stdout.String(), stderr.String(), "", want, "")
 	}
 }
+
+func TestDumpTar(t *testing.T) {
+	p := rootProc(t)
+	if err := symlink(p, "/bin/ls", "/tmp/lslink"); err != 0 {
+		t.Fatal(err)
+	}
+	p.Args[0], p.Args[1] = strArg(p, 0o1000, "/bin/ls"), strArg(p, 0o1200, "/tmp/ls")
+	syslink(p)
+	if p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	ls, err := p.Sys.ReadFile("/bin/ls")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := p.Sys.DumpTar(&buf); err != nil {
+		t.Fatal(err)
+	}
+	hdrs := make(map[string]*tar.Header)
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		hdrs[hdr.Name] = hdr
+		if hdr.Name == "bin/ls" {
+			data, _ := io.ReadAll(tr)
+			if !bytes.Equal(data, ls) {
+				t.Errorf("bin/ls: content differs")
+			}
+		}
+	}
+
+	var st stat
+	p.stat("/bin/ls", &st)
+	mtime := time.Unix(int64(st.mtime[0])<<16|int64(st.mtime[1]), 0)
+	tests := []struct {
+		name     string
+		typeflag byte
+		linkname string
+		major    int64
+		minor    int64
+	}{
+		{"bin/", tar.TypeDir, "", 0, 0},
+		{"bin/ls", tar.TypeReg, "", 0, 0},
+		{"tmp/ls", tar.TypeLink, "bin/ls", 0, 0},
+		{"tmp/lslink", tar.TypeSymlink, "/bin/ls", 0, 0},
+		{"dev/null", tar.TypeChar, "", 1, 0},
+	}
+	for _, tt := range tests {
+		hdr := hdrs[tt.name]
+		if hdr == nil {
+			t.Errorf("%s: missing", tt.name)
+			continue
+		}
+		if hdr.Typeflag != tt.typeflag || hdr.Linkname != tt.linkname || hdr.Devmajor != tt.major || hdr.Devminor != tt.minor {
+			t.Errorf("%s: type %c link %q dev %d,%d, want %c %q %d,%d", tt.name, hdr.Typeflag, hdr.Linkname, hdr.Devmajor, hdr.Devminor, tt.typeflag, tt.linkname, tt.major, tt.minor)
+		}
+	}
+	if hdr := hdrs["bin/ls"]; hdr != nil && (hdr.Mode != int64(st.mode&0o7777) || hdr.Uid != int(st.uid) || !hdr.ModTime.Equal(mtime) || hdr.Size != int64(len(ls))) {
+		t.Errorf("bin/ls: mode %#o uid %d mtime %v size %d, want %#o %d %v %d", hdr.Mode, hdr.Uid, hdr.ModTime, hdr.Size, st.mode&0o7777, st.uid, mtime, len(ls))
+	}
+}
+
+func TestLoadTar(t *testing.T) {
+	mtime := time.Unix(177300290, 0)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range []struct {
+		hdr  tar.Header
+		data string
+	}{
+		{hdr: tar.Header{Name: "usr/src/", Typeflag: tar.TypeDir, Mode: 0o775, Uid: 3}},
+		{hdr: tar.Header{Name: "usr/src/cmd/hello.c", Typeflag: tar.TypeReg, Mode: 0o644, Uid: 3, Gid: 1}, data: "main() {}\n"},
+		{hdr: tar.Header{Name: "./etc/motd", Typeflag: tar.TypeReg, Mode: 0o644}, data: "welcome\n"},
+		{hdr: tar.Header{Name: "dev/zero0", Typeflag: tar.TypeChar, Mode: 0o666, Devmajor: 5}},
+		{hdr: tar.Header{Name: "tmp/hello", Typeflag: tar.TypeSymlink, Linkname: "/usr/src/cmd/hello.c", Mode: 0o777}},
+		{hdr: tar.Header{Name: "tmp/hello.c", Typeflag: tar.TypeLink, Linkname: "usr/src/cmd/hello.c"}},
+		{hdr: tar.Header{Name: "tmp/fifo", Typeflag: tar.TypeFifo, Mode: 0o666}},
+	} {
+		f.hdr.ModTime = mtime
+		f.hdr.Size = int64(len(f.data))
+		if err := tw.WriteHeader(&f.hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(f.data))
+	}
+	tw.Close()
+
+	p := rootProc(t)
+	if err := p.Sys.LoadTar(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/usr/src/cmd/hello.c", "/tmp/hello", "/tmp/hello.c"} {
+		if data, err := p.Sys.ReadFile(name); string(data) != "main() {}\n" {
+			t.Errorf("ReadFile(%s) = %q, %v", name, data, err)
+		}
+	}
+	if data, err := p.Sys.ReadFile("/etc/motd"); string(data) != "welcome\n" {
+		t.Errorf("ReadFile(/etc/motd) = %q, %v", data, err)
+	}
+	if _, err := lookup(p, "/tmp/fifo"); err != ENOENT {
+		t.Errorf("lookup /tmp/fifo: %v, want ENOENT", err)
+	}
+
+	var st stat
+	p.stat("/usr/src/cmd/hello.c", &st)
+	if st.mode != _IALLOC|0o644 || st.uid != 3 || st.gid != 1 || st.nlink != 2 || st.mtime != [2]uint16{177300290 >> 16, 177300290 & 0xFFFF} {
+		t.Errorf("hello.c: mode %#o uid %d gid %d nlink %d mtime %v", st.mode, st.uid, st.gid, st.nlink, st.mtime)
+	}
+	p.stat("/usr/src", &st)
+	if st.mode != _IALLOC|_IFDIR|0o775 || st.uid != 3 {
+		t.Errorf("/usr/src: mode %#o uid %d", st.mode, st.uid)
+	}
+	p.stat("/usr/src/cmd", &st)
+	if st.mode != _IALLOC|_IFDIR|0o755 {
+		t.Errorf("/usr/src/cmd: mode %#o", st.mode)
+	}
+	src, _ := lookup(p, "/usr/src")
+	if up, _ := lookup(p, "/usr/src/cmd/.."); up != src {
+		t.Errorf("/usr/src/cmd/.. is inode %d, want %d", up, src)
+	}
+	p.stat("/dev/zero0", &st)
+	if st.mode != _IALLOC|_IFCHR|0o666 || st.major != 5 || st.minor != 0 {
+		t.Errorf("/dev/zero0: mode %#o dev %d,%d", st.mode, st.major, st.minor)
+	}
+
+	// Names may not climb out of the root.
+	for _, name := range []string{"../x", "a/../../x", "/../x"} {
+		buf.Reset()
+		tw := tar.NewWriter(&buf)
+		tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0o644})
+		tw.Close()
+		if err := p.Sys.LoadTar(&buf); !errors.Is(err, EINVAL) {
+			t.Errorf("LoadTar %s: %v, want EINVAL", name, err)
+		}
+	}
+
+	// What DumpTar writes, LoadTar reads.
+	var dump1, dump2 bytes.Buffer
+	if err := p.Sys.DumpTar(&dump1); err != nil {
+		t.Fatal(err)
+	}
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sys.LoadTar(bytes.NewReader(dump1.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if err := sys.DumpTar(&dump2); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dump1.Bytes(), dump2.Bytes()) {
+		t.Errorf("DumpTar after LoadTar of DumpTar output differs")
+	}
+}
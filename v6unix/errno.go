@@ -4,7 +4,10 @@
 
 package v6unix
 
-import "fmt"
+import (
+	"fmt"
+	"io/fs"
+)
 
 // なぜint8なのか
 type Errno int8
@@ -42,6 +45,15 @@ const (
 	EROFS
 	EMLINK
 	EPIPE
+
+	// v7で追加された（v6にはない）
+	EDOM   Errno = 33
+	ERANGE Errno = 34
+
+	// 4.2BSDで追加された
+	ELOOP     Errno = 62
+	ENOTEMPTY Errno = 66
+
 	EFAULT Errno = 106
 )
 
@@ -60,6 +72,21 @@ func (e Errno) Error() string {
 	return fmt.Sprintf("Errno(%d)", int(e))
 }
 
+// errors.Is(err, fs.ErrNotExist) などで使えるように、
+// io/fsの標準エラーに対応するエラーコードを報告する
+// Errno同士の比較はerrors.Isがそのまま行う
+func (e Errno) Is(target error) bool {
+	switch target {
+	case fs.ErrPermission:
+		return e == EPERM || e == EACCES
+	case fs.ErrExist:
+		return e == EEXIST
+	case fs.ErrNotExist:
+		return e == ENOENT
+	}
+	return false
+}
+
 // エラーコードと文字列の対応
 var enames = []string{
 	"",
@@ -94,4 +121,61 @@ var enames = []string{
 	"EROFS",
 	"EMLINK",
 	"EPIPE",
+	EDOM:   "EDOM",
+	ERANGE: "ERANGE",
+	ELOOP:  "ELOOP",
+
+	ENOTEMPTY: "ENOTEMPTY",
+}
+
+// エラーコードの説明を返す（perrorが表示する文）
+// Error()は互換性のために記号名を返し続ける
+func (e Errno) Description() string {
+	if 0 <= e && int(e) < len(errlist) && errlist[e] != "" {
+		return errlist[e]
+	}
+	if e == EFAULT {
+		return "Bad address"
+	}
+	return fmt.Sprintf("Unknown error %d", int(e))
+}
+
+// エラーコードと説明の対応（v6のperror.cから、EDOMとERANGEはv7から）
+var errlist = []string{
+	EPERM:   "Not super-user",
+	ENOENT:  "No such file or directory",
+	ESRCH:   "No such process",
+	EINTR:   "Interrupted system call",
+	EIO:     "I/O error",
+	ENXIO:   "No such device or address",
+	E2BIG:   "Arg list too long",
+	ENOEXEC: "Exec format error",
+	EBADF:   "Bad file number",
+	ECHILD:  "No children",
+	EAGAIN:  "No more processes",
+	ENOMEM:  "Not enough core",
+	EACCES:  "Permission denied",
+	ENOTBLK: "Block device required",
+	EBUSY:   "Mount device busy",
+	EEXIST:  "File exists",
+	EXDEV:   "Cross-device link",
+	ENODEV:  "No such device",
+	ENOTDIR: "Not a directory",
+	EISDIR:  "Is a directory",
+	EINVAL:  "Invalid argument",
+	ENFILE:  "File table overflow",
+	EMFILE:  "Too many open files",
+	ENOTTY:  "Not a typewriter",
+	ETXTBSY: "Text file busy",
+	EFBIG:   "File too large",
+	ENOSPC:  "No space left on device",
+	ESPIPE:  "Illegal seek",
+	EROFS:   "Read-only file system",
+	EMLINK:  "Too many links",
+	EPIPE:   "Broken pipe",
+	EDOM:    "Argument too large",
+	ERANGE:  "Result too large",
+	ELOOP:   "Too many levels of symbolic links",
+
+	ENOTEMPTY: "Directory not empty",
 }
@@ -0,0 +1,123 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package v6unix
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+)
+
+func TestErrnoIs(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = sys.ReadFile("/no/such/file")
+	if !errors.Is(err, ENOENT) || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ReadFile error %v is not ENOENT and fs.ErrNotExist", err)
+	}
+	if errors.Is(err, fs.ErrExist) || errors.Is(err, EEXIST) {
+		t.Errorf("ReadFile error %v matches EEXIST", err)
+	}
+
+	wrapped := fmt.Errorf("open: %w", EACCES)
+	if !errors.Is(wrapped, EACCES) || !errors.Is(wrapped, fs.ErrPermission) {
+		t.Errorf("%v is not EACCES and fs.ErrPermission", wrapped)
+	}
+	if !errors.Is(EPERM, fs.ErrPermission) || !errors.Is(EEXIST, fs.ErrExist) {
+		t.Errorf("EPERM or EEXIST does not match its fs error")
+	}
+	if ENOENT.Error() != "ENOENT" {
+		t.Errorf("ENOENT.Error() = %q", ENOENT.Error())
+	}
+}
+
+func TestErrnoError(t *testing.T) {
+	tests := []struct {
+		e    Errno
+		want string
+	}{
+		{EPERM, "EPERM"},
+		{ENOENT, "ENOENT"},
+		{ESRCH, "ESRCH"},
+		{EINTR, "EINTR"},
+		{EIO, "EIO"},
+		{ENXIO, "ENXIO"},
+		{E2BIG, "E2BIG"},
+		{ENOEXEC, "ENOEXEC"},
+		{EBADF, "EBADF"},
+		{ECHILD, "ECHILD"},
+		{EAGAIN, "EAGAIN"},
+		{ENOMEM, "ENOMEM"},
+		{EACCES, "EACCES"},
+		{ENOTBLK, "ENOTBLK"},
+		{EBUSY, "EBUSY"},
+		{EEXIST, "EEXIST"},
+		{EXDEV, "EXDEV"},
+		{ENODEV, "ENODEV"},
+		{ENOTDIR, "ENOTDIR"},
+		{EISDIR, "EISDIR"},
+		{EINVAL, "EINVAL"},
+		{ENFILE, "ENFILE"},
+		{EMFILE, "EMFILE"},
+		{ENOTTY, "ENOTTY"},
+		{ETXTBSY, "ETXTBSY"},
+		{EFBIG, "EFBIG"},
+		{ENOSPC, "ENOSPC"},
+		{ESPIPE, "ESPIPE"},
+		{EROFS, "EROFS"},
+		{EMLINK, "EMLINK"},
+		{EPIPE, "EPIPE"},
+		{EDOM, "EDOM"},
+		{ERANGE, "ERANGE"},
+		{EFAULT, "EFAULT"},
+		{0, "Errno(0)"},
+		{32, "Errno(32)"},
+		{35, "Errno(35)"},
+		{-1, "Errno(-1)"},
+	}
+	for _, tt := range tests {
+		if s := tt.e.Error(); s != tt.want {
+			t.Errorf("Errno(%d).Error() = %q, want %q", int(tt.e), s, tt.want)
+		}
+	}
+	if EFAULT != 106 || EDOM != 33 || ERANGE != 34 {
+		t.Errorf("EFAULT, EDOM, ERANGE = %d, %d, %d, want 106, 33, 34", EFAULT, EDOM, ERANGE)
+	}
+}
+
+func TestErrnoDescription(t *testing.T) {
+	for e := Errno(1); e <= ERANGE; e++ {
+		if e == 32 {
+			continue
+		}
+		if d := e.Description(); d == "" || d == fmt.Sprintf("Unknown error %d", int(e)) {
+			t.Errorf("%v has no description", e)
+		}
+	}
+	tests := []struct {
+		e    Errno
+		want string
+	}{
+		{ENOENT, "No such file or directory"},
+		{ENOTTY, "Not a typewriter"},
+		{EPIPE, "Broken pipe"},
+		{EFAULT, "Bad address"},
+		{0, "Unknown error 0"},
+		{32, "Unknown error 32"},
+		{99, "Unknown error 99"},
+		{-3, "Unknown error -3"},
+	}
+	for _, tt := range tests {
+		if d := tt.e.Description(); d != tt.want {
+			t.Errorf("Errno(%d).Description() = %q, want %q", int(tt.e), d, tt.want)
+		}
+	}
+	if ENOENT.Error() != "ENOENT" {
+		t.Errorf("ENOENT.Error() = %q, want ENOENT", ENOENT.Error())
+	}
+}
@@ -40,6 +40,7 @@ func (p *Proc) closef(f *File) {
 	}
 	if f.count <= 1 {
 		p.closei(f.inode, f.flag&_FWRITE)
+		p.Sys.nfile--
 	}
 	f.count--
 }
@@ -57,8 +58,8 @@ func (p *Proc) closef(f *File) {
  */
 func (p *Proc) closei(ip *inode, rw int) {
 	if ip.count <= 1 {
-		if ip.major != 0 {
-			p.dev(ip.major).close(p, ip.minor)
+		if ip.special() {
+			p.dev(ip.major, ip.minor).close(p, ip.minor)
 		}
 	}
 	p.iput(ip)
@@ -72,8 +73,8 @@ func (p *Proc) closei(ip *inode, rw int) {
  * and also on mount.
  */
 func (p *Proc) openi(ip *inode, rw int) {
-	if ip.major != 0 {
-		p.dev(ip.major).open(p, ip.minor, rw)
+	if ip.special() {
+		p.dev(ip.major, ip.minor).open(p, ip.minor, rw)
 	}
 }
 
@@ -94,8 +95,14 @@ func (p *Proc) openi(ip *inode, rw int) {
  */
 func (p *Proc) access(ip *inode, mode uint16) bool {
 	if mode == _IWRITE {
-		// skip EROFS
-		// skip ETXTBSY
+		if ip.readonly() {
+			p.Error = EROFS
+			return false
+		}
+		if ip.itext {
+			p.Error = ETXTBSY
+			return false
+		}
 	}
 	if p.Uid == 0 {
 		if mode == _IEXEC && ip.mode&0o111 == 0 {
@@ -153,9 +160,16 @@ func (p *Proc) suser() bool {
  * Allocate a user file descriptor.
  */
 func (p *Proc) ufalloc() int {
-	for i, f := range p.Files {
-		if f == nil {
+	return p.ufallocFrom(0)
+}
+
+// ufallocFrom is ufalloc, but allocating
+// the lowest free descriptor at least start.
+func (p *Proc) ufallocFrom(start int) int {
+	for i := start; i < NOFILE; i++ {
+		if p.Files[i] == nil {
 			p.CPU.R[0] = uint16(i)
+			p.fdflag[i] = 0
 			return i
 		}
 	}
@@ -163,6 +177,14 @@ func (p *Proc) ufalloc() int {
 	return -1
 }
 
+// maxOpenFiles returns the size of the open file table.
+func (sys *System) maxOpenFiles() int {
+	if sys.MaxOpenFiles > 0 {
+		return sys.MaxOpenFiles
+	}
+	return NFILE
+}
+
 /*
  * Allocate a user file descriptor
  * and a file structure.
@@ -177,7 +199,11 @@ func (p *Proc) falloc() *File {
 	if i < 0 {
 		return nil
 	}
-	// skip ENFILE
+	if p.Sys.nfile >= p.Sys.maxOpenFiles() {
+		p.Error = ENFILE
+		return nil
+	}
+	p.Sys.nfile++
 	f := new(File)
 	f.count = 1
 	p.Files[i] = f
@@ -0,0 +1,701 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package v6unix
+
+import (
+	"slices"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// dup2 calls the dup system call in its dup2 form.
+func dup2(p *Proc, old, new uint16) uint16 {
+	p.Error = 0
+	p.CPU.R[0] = old | 0o100
+	p.CPU.R[1] = new
+	sysdup(p)
+	return p.CPU.R[0]
+}
+
+func TestDup2(t *testing.T) {
+	p, r, w := newPipe(t)
+	rf, wf := p.Files[r], p.Files[w]
+
+	// Onto a closed descriptor.
+	if fd := dup2(p, w, 5); fd != 5 || p.Error != 0 || p.Files[5] != wf || wf.count != 2 {
+		t.Fatalf("dup2(%d, 5) = %d, %v, count %d", w, fd, p.Error, wf.count)
+	}
+
+	// Onto an open descriptor, which is closed first.
+	if fd := dup2(p, r, 5); fd != 5 || p.Error != 0 || p.Files[5] != rf {
+		t.Fatalf("dup2(%d, 5) = %d, %v", r, fd, p.Error)
+	}
+	if wf.count != 1 || rf.count != 2 {
+		t.Errorf("after dup2 over write end: counts %d, %d, want 1, 2", wf.count, rf.count)
+	}
+
+	// Onto itself: no change.
+	if fd := dup2(p, 5, 5); fd != 5 || p.Error != 0 || rf.count != 2 {
+		t.Errorf("dup2(5, 5) = %d, %v, count %d, want 5, 0, 2", fd, p.Error, rf.count)
+	}
+	closefd(p, 5)
+	if p.Files[r] != rf || rf.count != 1 {
+		t.Errorf("closing dup closed original")
+	}
+
+	// Bad descriptors.
+	if dup2(p, 7, 3); p.Error != EBADF {
+		t.Errorf("dup2 of closed fd: %v, want EBADF", p.Error)
+	}
+	if dup2(p, r, NOFILE); p.Error != EBADF {
+		t.Errorf("dup2 to fd %d: %v, want EBADF", NOFILE, p.Error)
+	}
+
+	// Plain dup still takes the lowest free descriptor.
+	p.Error = 0
+	p.CPU.R[0] = w
+	sysdup(p)
+	if p.Error != 0 || p.CPU.R[0] != 2 || p.Files[2] != wf {
+		t.Errorf("dup(%d) = %d, %v, want 2", w, p.CPU.R[0], p.Error)
+	}
+}
+
+// lseek calls the lseek system call and returns the new offset.
+func lseek(p *Proc, fd uint16, off int32, whence uint16) int {
+	p.Error = 0
+	p.CPU.R[0] = fd
+	p.Args[0], p.Args[1], p.Args[2] = uint16(off>>16), uint16(off), whence
+	syslseek(p)
+	return int(p.CPU.R[0])<<16 | int(p.CPU.R[1])
+}
+
+// fcntl calls the fcntl system call.
+func fcntl(p *Proc, fd, cmd, arg uint16) uint16 {
+	p.Error = 0
+	p.CPU.R[0] = fd
+	p.Args[0], p.Args[1] = cmd, arg
+	sysfcntl(p)
+	return p.CPU.R[0]
+}
+
+func TestFcntl(t *testing.T) {
+	p, r, w := newPipe(t)
+	p.Dir = p.iget(ROOTINO)
+	p.CPU.Mem = &p.Mem
+	if fcntl(p, r, F_GETFD, 0); p.Error != 100 {
+		t.Errorf("fcntl without EnableFcntl: %v, want unknown system call", p.Error)
+	}
+	p.Sys.EnableFcntl = true
+
+	// F_DUPFD takes the lowest free descriptor at or above arg.
+	if fd := fcntl(p, r, F_DUPFD, 5); p.Error != 0 || fd != 5 || p.Files[5] != p.Files[r] || p.Files[r].count != 2 {
+		t.Errorf("F_DUPFD 5 = %d, %v, want 5 sharing the file", fd, p.Error)
+	}
+	if fd := fcntl(p, r, F_DUPFD, 5); fd != 6 {
+		t.Errorf("second F_DUPFD 5 = %d, %v, want 6", fd, p.Error)
+	}
+	if fcntl(p, r, F_DUPFD, NOFILE); p.Error != EINVAL {
+		t.Errorf("F_DUPFD NOFILE: %v, want EINVAL", p.Error)
+	}
+	if fcntl(p, 9, F_GETFD, 0); p.Error != EBADF {
+		t.Errorf("F_GETFD of closed fd: %v, want EBADF", p.Error)
+	}
+
+	// Status flags.
+	if fl := fcntl(p, w, F_GETFL, 0); p.Error != 0 || fl != 1 {
+		t.Errorf("F_GETFL of pipe write side = %#o, %v, want 1", fl, p.Error)
+	}
+	p.Sys.EnableNDelay = true
+	fcntl(p, r, F_SETFL, ONDELAY)
+	if fl := fcntl(p, 5, F_GETFL, 0); fl != ONDELAY {
+		t.Errorf("F_GETFL of dup after F_SETFL ONDELAY = %#o, want %#o", fl, ONDELAY)
+	}
+
+	// FD_CLOEXEC belongs to the descriptor, not the file,
+	// and exec closes the descriptors that have it.
+	fcntl(p, 5, F_SETFD, FD_CLOEXEC)
+	if fl := fcntl(p, 5, F_GETFD, 0); fl != FD_CLOEXEC {
+		t.Errorf("F_GETFD after F_SETFD = %d, want FD_CLOEXEC", fl)
+	}
+	if fl := fcntl(p, 6, F_GETFD, 0); fl != 0 {
+		t.Errorf("F_GETFD of other dup = %d, want 0", fl)
+	}
+	fcntl(p, w, F_SETFD, FD_CLOEXEC)
+	p.Args[0], p.Args[1] = strArg(p, 0o1000, "/bin/ls"), 0o1200
+	copy(p.Mem[0o1200:], "\x00\x00")
+	if sysexec(p); p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	if p.Files[5] != nil || p.Files[w] != nil {
+		t.Errorf("exec left close-on-exec descriptors open")
+	}
+	if p.Files[r] == nil || p.Files[6] == nil || p.Files[r].count != 2 {
+		t.Errorf("exec closed descriptors without FD_CLOEXEC")
+	}
+
+	// A new descriptor in the same slot starts without the flag.
+	if fd := fcntl(p, r, F_DUPFD, 5); fd != 5 || fcntl(p, 5, F_GETFD, 0) != 0 {
+		t.Errorf("reused descriptor %d kept FD_CLOEXEC", fd)
+	}
+}
+
+func TestLseek(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Proc{Sys: sys}
+	p.Dir = p.iget(ROOTINO)
+	const name = 0o1000
+	copy(p.Mem[name:], "/tmp/seek\x00")
+	p.Args[0], p.Args[1] = name, 0o666
+	syscreate(p)
+	if p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	fd := p.CPU.R[0]
+	f := p.Files[fd]
+	if n := p.writei(f.inode, []byte("abc"), 0); n != 3 {
+		t.Fatalf("writei = %d", n)
+	}
+	f.offset = 3
+
+	tests := []struct {
+		off    int32
+		whence uint16
+		want   int
+	}{
+		{1, 0, 1},
+		{1, 1, 2},
+		{-2, 1, 0},
+		{0, 2, 3},
+		{-1, 2, 2},
+		{100000, 0, 100000}, // more than 16 bits
+		{5, 2, 8},           // past EOF
+	}
+	for _, tt := range tests {
+		if off := lseek(p, fd, tt.off, tt.whence); off != tt.want || p.Error != 0 || f.offset != tt.want {
+			t.Errorf("lseek(%d, %d) = %d, %v (offset %d), want %d", tt.off, tt.whence, off, p.Error, f.offset, tt.want)
+		}
+	}
+
+	// Writing past EOF leaves a hole of zeros.
+	if n := p.writei(f.inode, []byte("xyz"), f.offset); n != 3 {
+		t.Fatalf("writei = %d", n)
+	}
+	b := make([]byte, 20)
+	if n := p.readi(f.inode, b, 0); string(b[:n]) != "abc\x00\x00\x00\x00\x00xyz" {
+		t.Errorf("read %q, want %q", b[:n], "abc\x00\x00\x00\x00\x00xyz")
+	}
+	if off := lseek(p, fd, 0, 2); off != 11 {
+		t.Errorf("lseek to end = %d, want 11", off)
+	}
+
+	// Errors.
+	if lseek(p, fd, -20, 1); p.Error != EINVAL {
+		t.Errorf("lseek before start: %v, want EINVAL", p.Error)
+	}
+	if lseek(p, fd, 0, 3); p.Error != EINVAL {
+		t.Errorf("lseek whence 3: %v, want EINVAL", p.Error)
+	}
+	p.Error = 0
+	p.open("/dev/tty8", 0)
+	if p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	if lseek(p, p.CPU.R[0], 0, 0); p.Error != ESPIPE {
+		t.Errorf("lseek on tty: %v, want ESPIPE", p.Error)
+	}
+	pp, r, _ := newPipe(t)
+	if lseek(pp, r, 0, 0); pp.Error != ESPIPE {
+		t.Errorf("lseek on pipe: %v, want ESPIPE", pp.Error)
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	p := rootProc(t)
+	for _, name := range []string{"/tmp/a", "/tmp/b"} {
+		p.Args[0], p.Args[1] = strArg(p, 0o1000, name), 0o666
+		if syscreate(p); p.Error != 0 {
+			t.Fatal(p.Error)
+		}
+	}
+	if p.unlink("/tmp/a"); p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+
+	// readdir reads the entries of fd 16 bytes at a time.
+	readdir := func(fd uint16) (names []string) {
+		t.Helper()
+		for {
+			p.CPU.R[0] = fd
+			p.Args[0], p.Args[1] = 0o2000, 2*uint16(direntSize)
+			if p.rdwr(_FREAD); p.Error != 0 {
+				t.Fatal(p.Error)
+			}
+			n := int(p.CPU.R[0])
+			if n == 0 {
+				return names
+			}
+			for off := 0o2000; off < 0o2000+n; off += int(direntSize) {
+				names = append(names, (*dirent)(unsafe.Pointer(&p.Mem[off])).name())
+			}
+		}
+	}
+	open := func(mode int) uint16 {
+		t.Helper()
+		if p.open("/tmp", mode); p.Error != 0 {
+			t.Fatal(p.Error)
+		}
+		return p.CPU.R[0]
+	}
+
+	// Raw reads return the empty slot left by /tmp/a, cooked ones do not.
+	raw := readdir(open(0))
+	cooked := readdir(open(OCOOKED))
+	if !slices.Contains(raw, "") || !slices.Contains(raw, "b") {
+		t.Errorf("raw read of /tmp = %q, want empty slot and b", raw)
+	}
+	if slices.Contains(cooked, "") || slices.Contains(cooked, "a") || !slices.Contains(cooked, "b") {
+		t.Errorf("cooked read of /tmp = %q, want b and no empty slots", cooked)
+	}
+	if len(cooked) >= len(raw) {
+		t.Errorf("cooked read has %d entries, raw %d", len(cooked), len(raw))
+	}
+
+	if p.open("/tmp", 1); p.Error != EISDIR {
+		t.Errorf("open of directory for writing: %v, want EISDIR", p.Error)
+	}
+}
+
+func TestChmodChown(t *testing.T) {
+	p := rootProc(t)
+	chmod := func(name string, mode uint16) Errno {
+		p.Error = 0
+		p.Args[0], p.Args[1] = strArg(p, 0o1000, name), mode
+		syschmod(p)
+		return p.Error
+	}
+	chown := func(name string, uid, gid uint8) Errno {
+		p.Error = 0
+		p.Args[0], p.Args[1] = strArg(p, 0o1000, name), uint16(gid)<<8|uint16(uid)
+		syschown(p)
+		return p.Error
+	}
+	mode := func() (uint16, int8, int8) {
+		var st stat
+		p.Error = 0
+		if p.stat("/tmp/f", &st); p.Error != 0 {
+			t.Fatal(p.Error)
+		}
+		return st.mode & 0o7777, st.uid, st.gid
+	}
+
+	p.Args[0], p.Args[1] = strArg(p, 0o1000, "/tmp/f"), 0o644
+	if syscreate(p); p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	closefd(p, p.CPU.R[0])
+
+	// The super-user can do anything.
+	if err := chmod("/tmp/f", _ISUID|_ISGID|_ISVTX|0o755); err != 0 {
+		t.Fatal(err)
+	}
+	if err := chown("/tmp/f", 3, 4); err != 0 {
+		t.Fatal(err)
+	}
+	if m, uid, gid := mode(); m != _ISUID|_ISGID|_ISVTX|0o755 || uid != 3 || gid != 4 {
+		t.Errorf("after chown: mode %#o uid %d gid %d, want %#o 3 4", m, uid, gid, _ISUID|_ISGID|_ISVTX|0o755)
+	}
+
+	// Another user can do neither.
+	p.Uid, p.Gid = 5, 4
+	if err := chmod("/tmp/f", 0o777); err != EPERM {
+		t.Errorf("chmod by other user: %v, want EPERM", err)
+	}
+	if err := chown("/tmp/f", 5, 4); err != EPERM {
+		t.Errorf("chown by other user: %v, want EPERM", err)
+	}
+
+	// The owner can chmod but not set the sticky bit,
+	// nor set-gid to a group it is not in, nor chown.
+	p.Uid, p.Gid = 3, 4
+	if err := chmod("/tmp/f", _ISUID|_ISGID|_ISVTX|0o700); err != 0 {
+		t.Fatal(err)
+	}
+	if m, _, _ := mode(); m != _ISUID|_ISGID|0o700 {
+		t.Errorf("chmod by owner: mode %#o, want %#o", m, _ISUID|_ISGID|0o700)
+	}
+	p.Gid = 6
+	if err := chmod("/tmp/f", _ISUID|_ISGID|0o700); err != 0 {
+		t.Fatal(err)
+	}
+	if m, _, _ := mode(); m != _ISUID|0o700 {
+		t.Errorf("chmod by owner outside group: mode %#o, want %#o", m, _ISUID|0o700)
+	}
+	if err := chown("/tmp/f", 5, 4); err != EPERM {
+		t.Errorf("chown by owner: %v, want EPERM", err)
+	}
+	if _, uid, gid := mode(); uid != 3 || gid != 4 {
+		t.Errorf("after failed chown: uid %d gid %d, want 3 4", uid, gid)
+	}
+}
+
+func TestAccess(t *testing.T) {
+	p := rootProc(t)
+	open := func(name string, mode uint16) Errno {
+		p.Error = 0
+		p.Args[0], p.Args[1] = strArg(p, 0o1000, name), mode
+		if sysopen(p); p.Error == 0 {
+			closefd(p, p.CPU.R[0])
+		}
+		return p.Error
+	}
+	exec := func(name string) Errno {
+		p.Error = 0
+		p.Args[0] = strArg(p, 0o1000, name)
+		sysexec(p)
+		return p.Error
+	}
+	mknod := func(name string, mode uint16) {
+		p.Error = 0
+		p.Args[0], p.Args[1], p.Args[2] = strArg(p, 0o1000, name), mode, 0
+		if sysmknod(p); p.Error != 0 {
+			t.Fatalf("mknod %s: %v", name, p.Error)
+		}
+		ip, _, _ := p.namei(name, nameFind)
+		ip.uid, ip.gid = 3, 4
+		p.iput(ip)
+	}
+	mknod("/tmp/f", 0o640)
+	mknod("/tmp/d", _IFDIR|0o601)
+
+	tests := []struct {
+		uid, gid int8
+		name     string
+		mode     uint16
+		want     Errno
+	}{
+		{0, 0, "/tmp/f", 2, 0},
+		{3, 0, "/tmp/f", 2, 0},
+		{5, 4, "/tmp/f", 0, 0},
+		{5, 4, "/tmp/f", 1, EACCES},
+		{5, 6, "/tmp/f", 0, EACCES},
+		{3, 4, "/tmp/d", 0, 0},
+		{3, 4, "/tmp/d/x", 0, EACCES},
+		{5, 6, "/tmp/d/x", 0, ENOENT},
+		{0, 0, "/tmp/d/x", 0, ENOENT},
+	}
+	for _, tt := range tests {
+		p.Uid, p.Gid = tt.uid, tt.gid
+		if err := open(tt.name, tt.mode); err != tt.want {
+			t.Errorf("uid %d gid %d: open(%s, %d) = %v, want %v", tt.uid, tt.gid, tt.name, tt.mode, err, tt.want)
+		}
+	}
+
+	// Not even the super-user can execute a file
+	// without execute permission, or a directory.
+	p.Uid, p.Gid = 0, 0
+	if err := exec("/tmp/f"); err != EACCES {
+		t.Errorf("exec without permission: %v, want EACCES", err)
+	}
+	p.Args[0], p.Args[1] = strArg(p, 0o1000, "/tmp/d"), 0o755
+	syschmod(p)
+	if err := exec("/tmp/d"); err != EACCES {
+		t.Errorf("exec directory: %v, want EACCES", err)
+	}
+}
+
+func TestStat(t *testing.T) {
+	p := rootProc(t)
+	const buf = 0o2000
+	statSys := func(name string) *stat {
+		p.Error = 0
+		p.Args[0], p.Args[1] = strArg(p, 0o1000, name), buf
+		clear(p.Mem[buf : buf+unsafe.Sizeof(stat{})])
+		if sysstat(p); p.Error != 0 {
+			t.Fatalf("stat %s: %v", name, p.Error)
+		}
+		return (*stat)(unsafe.Pointer(&p.Mem[buf]))
+	}
+
+	// A directory's size is 16 bytes per entry.
+	st := statSys("/dev")
+	ip, _, _ := p.namei("/dev", nameFind)
+	n := len(p.contents(ip))
+	p.iput(ip)
+	if st.mode&_IFMT != _IFDIR || st.inum != ip.inum || st.size() != n || n%16 != 0 || st.nlink < 2 {
+		t.Errorf("stat /dev: mode %#o inum %d size %d nlink %d, want directory %d of size %d", st.mode, st.inum, st.size(), st.nlink, ip.inum, n)
+	}
+
+	// A special file reports its device numbers.
+	st = statSys("/dev/tty8")
+	if st.mode&_IFMT != _IFCHR || st.minor != 8 || *st.iaddr(0) != uint16(st.major)<<8|8 || st.size() != 0 {
+		t.Errorf("stat /dev/tty8: mode %#o dev %d/%d size %d, want character device with minor 8", st.mode, st.major, st.minor, st.size())
+	}
+
+	// A directory's link count is its name, its ".",
+	// and the ".." of each subdirectory.
+	nlink := 2
+	for _, name := range dirNames(t, p, "/usr") {
+		if name != "." && name != ".." && statSys("/usr/"+name).mode&_IFMT == _IFDIR {
+			nlink++
+		}
+	}
+	if st := statSys("/usr"); int(st.nlink) != nlink {
+		t.Errorf("stat /usr: nlink %d, want %d", st.nlink, nlink)
+	}
+
+	// fstat of an open file matches stat of its name.
+	want := *statSys("/bin/ls")
+	p.Args[0], p.Args[1] = strArg(p, 0o1000, "/bin/ls"), 0
+	if sysopen(p); p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	fd := p.CPU.R[0]
+	clear(p.Mem[buf : buf+unsafe.Sizeof(stat{})])
+	p.CPU.R[0], p.Args[0] = fd, buf
+	if sysfstat(p); p.Error != 0 || *(*stat)(unsafe.Pointer(&p.Mem[buf])) != want {
+		t.Errorf("fstat = %v, %+v, want %+v", p.Error, *(*stat)(unsafe.Pointer(&p.Mem[buf])), want)
+	}
+	if want.size() == 0 || want.mode&_IFMT != 0 || want.mtime == [2]uint16{} {
+		t.Errorf("stat /bin/ls: size %d mode %#o mtime %v", want.size(), want.mode, want.mtime)
+	}
+
+	// Bad descriptors and buffers.
+	p.Error = 0
+	p.CPU.R[0] = 7
+	if sysfstat(p); p.Error != EBADF {
+		t.Errorf("fstat of closed fd: %v, want EBADF", p.Error)
+	}
+	p.Error = 0
+	p.CPU.R[0], p.Args[0] = fd, 0o177770
+	if sysfstat(p); p.Error != EFAULT {
+		t.Errorf("fstat into end of memory: %v, want EFAULT", p.Error)
+	}
+	closefd(p, fd)
+}
+
+func TestTimes(t *testing.T) {
+	p := rootProc(t)
+	// Times are in seconds, so step the clock
+	// instead of sleeping between operations.
+	base := time.Date(1975, 8, 14, 22, 4, 50, 0, time.UTC)
+	unix := func(tm [2]uint16) int64 { return int64(tm[0])<<16 | int64(tm[1]) }
+	step := func(n int) int64 {
+		p.Sys.SetTime(base.Add(time.Duration(n) * time.Second))
+		return base.Unix() + int64(n)
+	}
+	rw := func(call func(*Proc), fd uint16, n int) {
+		p.Error = 0
+		p.CPU.R[0], p.Args[0], p.Args[1] = fd, 0o2000, uint16(n)
+		if call(p); p.Error != 0 {
+			t.Fatal(p.Error)
+		}
+	}
+
+	t0 := step(0)
+	p.Args[0], p.Args[1] = strArg(p, 0o1000, "/tmp/f"), 0o666
+	if syscreate(p); p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	fd := p.CPU.R[0]
+	ip := p.Files[fd].inode
+	if unix(ip.mtime) != t0 || unix(ip.atime) != t0 {
+		t.Errorf("new file: atime %d mtime %d, want %d", unix(ip.atime), unix(ip.mtime), t0)
+	}
+
+	// Writing advances mtime but not atime.
+	t1 := step(1)
+	rw(syswrite, fd, 10)
+	if unix(ip.mtime) != t1 || unix(ip.atime) != t0 {
+		t.Errorf("after write: atime %d mtime %d, want %d %d", unix(ip.atime), unix(ip.mtime), t0, t1)
+	}
+	closefd(p, fd)
+
+	// Reading advances atime but not mtime.
+	t2 := step(2)
+	p.Args[0], p.Args[1] = strArg(p, 0o1000, "/tmp/f"), 0
+	if sysopen(p); p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	fd = p.CPU.R[0]
+	rw(sysread, fd, 10)
+	if unix(ip.atime) != t2 || unix(ip.mtime) != t1 {
+		t.Errorf("after read: atime %d mtime %d, want %d %d", unix(ip.atime), unix(ip.mtime), t2, t1)
+	}
+
+	// With NoATime, reading changes nothing.
+	step(3)
+	p.Sys.NoATime = true
+	lseek(p, fd, 0, 0)
+	rw(sysread, fd, 10)
+	if unix(ip.atime) != t2 || unix(ip.mtime) != t1 {
+		t.Errorf("after read with NoATime: atime %d mtime %d, want %d %d", unix(ip.atime), unix(ip.mtime), t2, t1)
+	}
+	closefd(p, fd)
+
+	// Changing the inode advances mtime, as in v6,
+	// which has no separate change time.
+	t4 := step(4)
+	p.Args[0], p.Args[1] = strArg(p, 0o1000, "/tmp/f"), 0o644
+	if syschmod(p); p.Error != 0 || unix(ip.mtime) != t4 {
+		t.Errorf("after chmod: %v, mtime %d, want %d", p.Error, unix(ip.mtime), t4)
+	}
+}
+
+func TestOpenFileLimits(t *testing.T) {
+	p, r, w := newPipe(t)
+	p.Dir = p.iget(ROOTINO)
+	open := func() uint16 {
+		p.Error = 0
+		p.open("/dev/null", 0)
+		return p.CPU.R[0]
+	}
+
+	// A process can have only NOFILE files open.
+	var fds []uint16
+	for i := 2; i < NOFILE; i++ {
+		if fd := open(); p.Error != 0 {
+			t.Fatalf("open #%d: %v", i, p.Error)
+		} else {
+			fds = append(fds, fd)
+		}
+	}
+	if open(); p.Error != EMFILE {
+		t.Errorf("open past NOFILE: %v, want EMFILE", p.Error)
+	}
+	p.Error = 0
+	p.CPU.R[0] = r
+	if sysdup(p); p.Error != EMFILE {
+		t.Errorf("dup past NOFILE: %v, want EMFILE", p.Error)
+	}
+	p.Error = 0
+	if syspipe(p); p.Error != EMFILE {
+		t.Errorf("pipe past NOFILE: %v, want EMFILE", p.Error)
+	}
+	for _, fd := range fds {
+		closefd(p, fd)
+	}
+	if p.Sys.nfile != 2 {
+		t.Fatalf("nfile = %d after closing, want 2", p.Sys.nfile)
+	}
+
+	// The system has only MaxOpenFiles file structures,
+	// but dup shares one.
+	p.Sys.MaxOpenFiles = 3
+	fd := open()
+	if p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	if open(); p.Error != ENFILE {
+		t.Errorf("open past MaxOpenFiles: %v, want ENFILE", p.Error)
+	}
+	p.Error = 0
+	if syspipe(p); p.Error != ENFILE {
+		t.Errorf("pipe past MaxOpenFiles: %v, want ENFILE", p.Error)
+	}
+	p.Error = 0
+	p.CPU.R[0] = w
+	sysdup(p)
+	if p.Error != 0 {
+		t.Errorf("dup with full file table: %v", p.Error)
+	}
+	closefd(p, p.CPU.R[0])
+	if p.Sys.nfile != 3 {
+		t.Errorf("nfile = %d, want 3", p.Sys.nfile)
+	}
+	closefd(p, fd)
+	closefd(p, r)
+	closefd(p, w)
+	if p.Sys.nfile != 0 {
+		t.Errorf("nfile = %d after closing all, want 0", p.Sys.nfile)
+	}
+}
+
+func TestFileSharing(t *testing.T) {
+	p := rootProc(t)
+	const name, buf = 0o1000, 0o2000
+	copy(p.Mem[name:], "/tmp/share\x00")
+	p.Args[0], p.Args[1] = name, 0o666
+	if syscreate(p); p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	fd := p.CPU.R[0]
+	f := p.Files[fd]
+	write := func(p *Proc, fd uint16, s string) {
+		t.Helper()
+		copy(p.Mem[buf:], s)
+		p.CPU.R[0] = fd
+		p.Args[0], p.Args[1] = buf, uint16(len(s))
+		if p.rdwr(_FWRITE); p.Error != 0 {
+			t.Fatal(p.Error)
+		}
+	}
+	contents := func() string {
+		b := make([]byte, 100)
+		return string(b[:p.readi(f.inode, b, 0)])
+	}
+
+	// A forked child shares the parent's file structure,
+	// so their writes follow each other.
+	c, err := p.Sys.Fork(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Files[fd] != f || f.count != 2 {
+		t.Fatalf("child does not share the file: count = %d", f.count)
+	}
+	write(p, fd, "par")
+	write(c, fd, "child")
+	write(p, fd, "!")
+	if got := contents(); got != "parchild!" || f.offset != 9 {
+		t.Errorf("after shared writes: %q at offset %d, want %q at 9", got, f.offset, "parchild!")
+	}
+
+	// Closing one reference leaves the structure to the other.
+	nfile := p.Sys.nfile
+	closefd(c, fd)
+	if f.count != 1 || p.Sys.nfile != nfile {
+		t.Errorf("after child close: count = %d, nfile = %d, want 1, %d", f.count, p.Sys.nfile, nfile)
+	}
+	write(p, fd, "?")
+	if f.offset != 10 {
+		t.Errorf("offset = %d after write following child close, want 10", f.offset)
+	}
+
+	// A second open has its own structure and pointer.
+	p.open("/tmp/share", 1)
+	if p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	fd2 := p.CPU.R[0]
+	if f2 := p.Files[fd2]; f2 == f || f2.offset != 0 {
+		t.Fatalf("second open shares the first: offset %d", f2.offset)
+	}
+	write(p, fd2, "PA")
+	write(p, fd, "end")
+	if got := contents(); got != "PArchild!?end" || f.offset != 13 || p.Files[fd2].offset != 2 {
+		t.Errorf("after separate writes: %q, offsets %d, %d, want %q, 13, 2", got, f.offset, p.Files[fd2].offset, "PArchild!?end")
+	}
+
+	// A dup shares the structure too.
+	p.CPU.R[0] = fd2
+	if sysdup(p); p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	fd3 := p.CPU.R[0]
+	write(p, fd3, "RC")
+	if off := p.Files[fd2].offset; off != 4 {
+		t.Errorf("offset after write to dup = %d, want 4", off)
+	}
+	closefd(p, fd2)
+	closefd(p, fd3)
+	closefd(p, fd)
+	if p.Sys.nfile != nfile-1 {
+		t.Errorf("nfile = %d after closing all, want %d", p.Sys.nfile, nfile-1)
+	}
+}
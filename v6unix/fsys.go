@@ -21,17 +21,24 @@ const maxInodes = 1 << 15
 
 type Disk struct {
 	inodes []*inode
+	img    *imageFS // disk image holding the inodes, if any
+	dev    uint16   // device number, 0 for the root disk
+	nosuid bool     // mounted with MNOSUID: exec ignores set-uid and set-gid bits
 }
 
-func (p *Proc) ialloc() *inode {
-	d := p.Sys.Disk
+func (p *Proc) ialloc(d *Disk) *inode {
+	if d.img != nil {
+		return p.imgIalloc(d)
+	}
 	for {
 		for i, ip := range d.inodes {
 			if i > 0 && ip == nil {
 				ip := new(inode)
+				ip.disk = d
+				ip.dev = d.dev
 				ip.inum = uint16(i)
 				ip.count = 1
-				p.Sys.Disk.inodes[i] = ip
+				d.inodes[i] = ip
 				return ip
 			}
 		}
@@ -45,7 +52,7 @@ func (p *Proc) ialloc() *inode {
 
 func newDisk(archive []byte) (*Disk, error) {
 	d := new(Disk)
-	d.inodes = []*inode{nil, {stat: stat{inum: 1, nlink: 1, mode: _IALLOC | _IFDIR | 0o555}}}
+	d.inodes = []*inode{nil, {stat: stat{inum: 1, nlink: 2, mode: _IALLOC | _IFDIR | 0o555}, disk: d}}
 
 	var p Proc // root user identity
 	p.Sys = &System{Disk: d}
@@ -109,6 +116,8 @@ func newDisk(archive []byte) (*Disk, error) {
 				return nil, fmt.Errorf("%v: %v", link, p.Error)
 			}
 			p.wdir(lp, path.Base(name), dp, off)
+			lp.nlink++
+			p.iput(lp)
 		} else {
 			if ip == nil {
 				ip = p.maknode(path.Base(name), st.mode, dp, off)
@@ -120,6 +129,8 @@ func newDisk(archive []byte) (*Disk, error) {
 					ip.data = make([]byte, 2*(DIRSIZ+2))
 					p.wdir(ip, ".", ip, 0)
 					p.wdir(dp, "..", ip, DIRSIZ+2)
+					ip.nlink++
+					dp.nlink++
 				}
 			}
 			st.dev = ip.dev
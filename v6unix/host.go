@@ -0,0 +1,324 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Host directories are not in v6; the code is new.
+// MountHost grafts a directory of the host file system
+// onto a directory of the simulated one.
+// The inodes below it are ordinary inodes with a hostFile attached.
+// A host directory reads its entries from the host each time
+// its inode is first referenced, keeping the inode numbers of
+// names it has seen before, and a host file is opened while its
+// inode is referenced, with reads and writes going to the os.File.
+
+package v6unix
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"unsafe"
+)
+
+// A hostFile is the host file or directory behind an inode.
+type hostFile struct {
+	path     string
+	readonly bool
+	parent   uint16            // inode number of ".."
+	f        *os.File          // open file, while the inode is referenced
+	names    map[string]uint16 // directory entries
+}
+
+// MountHost makes the host directory hostDir appear at guestPath,
+// creating guestPath if needed.
+// Anything already in guestPath is hidden until the system is rebuilt.
+// Host names longer than DIRSIZ and host files that are neither
+// regular files nor directories do not appear.
+// Files and directories take the owner of guestPath,
+// and their permission bits from the host.
+func (sys *System) MountHost(guestPath, hostDir string) error {
+	return sys.mountHost(guestPath, hostDir, false)
+}
+
+// MountHostReadOnly is like MountHost,
+// but writing to the mounted tree fails with EROFS.
+func (sys *System) MountHostReadOnly(guestPath, hostDir string) error {
+	return sys.mountHost(guestPath, hostDir, true)
+}
+
+func (sys *System) mountHost(guestPath, hostDir string, readonly bool) error {
+	fi, err := os.Stat(hostDir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return &fs.PathError{Op: "mount", Path: hostDir, Err: ENOTDIR}
+	}
+
+	p := &Proc{Sys: sys}
+	p.Dir = p.iget(ROOTINO)
+	defer p.iput(p.Dir)
+
+	ip, dp, off := p.namei(guestPath, nameCreate)
+	var parent uint16
+	if ip == nil {
+		if dp == nil {
+			return p.Error
+		}
+		parent = dp.inum
+		ip = p.maknode(path.Base(guestPath), _IFDIR|0o755, dp, off)
+		p.iput(dp)
+		if ip == nil {
+			return p.Error
+		}
+	} else {
+		if ip.mode&_IFMT != _IFDIR {
+			p.iput(ip)
+			return ENOTDIR
+		}
+		if ip.host != nil {
+			parent = ip.host.parent
+		} else {
+			parent, _ = dsearch(p.contents(ip), "..")
+		}
+	}
+	ip.host = &hostFile{path: hostDir, readonly: readonly, parent: parent}
+	p.hostStat(ip, fi)
+	p.hostReaddir(ip)
+	p.iput(ip)
+	return nil
+}
+
+/*
+ * Bring a host inode up to date
+ * when it is first referenced.
+ * Called from iget.
+ */
+func (p *Proc) hostOpen(ip *inode) {
+	h := ip.host
+	fi, err := os.Stat(h.path)
+	if err != nil {
+		return
+	}
+	p.hostStat(ip, fi)
+	if fi.IsDir() {
+		p.hostReaddir(ip)
+		return
+	}
+	if !h.readonly {
+		h.f, _ = os.OpenFile(h.path, os.O_RDWR, 0)
+	}
+	if h.f == nil {
+		h.f, _ = os.Open(h.path)
+	}
+}
+
+/*
+ * Let go of the host file
+ * when the last reference is gone.
+ * Called from iput.
+ */
+func (p *Proc) hostClose(ip *inode) {
+	if f := ip.host.f; f != nil {
+		f.Close()
+		ip.host.f = nil
+	}
+}
+
+/*
+ * Copy the host's idea of the file's
+ * type, permissions, size and time
+ * into the inode.
+ */
+func (p *Proc) hostStat(ip *inode, fi fs.FileInfo) {
+	mode := _IALLOC | uint16(fi.Mode().Perm())
+	if ip.host.readonly {
+		mode &^= 0o222
+	}
+	ip.nlink = 1
+	if fi.IsDir() {
+		mode |= _IFDIR
+		ip.nlink = 2
+	} else {
+		ip.setSize(int(fi.Size()))
+	}
+	ip.mode = mode
+	t := fi.ModTime().Unix()
+	ip.mtime = [2]uint16{uint16(t >> 16), uint16(t)}
+	ip.atime = ip.mtime
+}
+
+/*
+ * Rebuild a host directory's entries from the host,
+ * allocating inodes for names not seen before.
+ */
+func (p *Proc) hostReaddir(dp *inode) {
+	d := dp.disk
+	h := dp.host
+	ents, err := os.ReadDir(h.path)
+	if err != nil {
+		p.Error = hostErrno(err)
+		return
+	}
+
+	dp.data = nil
+	p.wdir(dp, ".", dp, 0)
+	if pp := d.inodes[h.parent]; pp != nil {
+		p.wdir(pp, "..", dp, len(dp.data))
+	}
+	names := make(map[string]uint16)
+	for _, e := range ents {
+		name := e.Name()
+		if len(name) > DIRSIZ {
+			continue
+		}
+		fi, err := os.Stat(filepath.Join(h.path, name))
+		if err != nil || !fi.IsDir() && !fi.Mode().IsRegular() {
+			continue
+		}
+		ip := d.inodes[h.names[name]]
+		if h.names[name] == 0 || ip == nil || ip.host == nil {
+			if ip = p.ialloc(dp.disk); ip == nil {
+				break
+			}
+			ip.count = 0
+			ip.img = nil // not really on the image
+			ip.uid = dp.uid
+			ip.gid = dp.gid
+			ip.host = &hostFile{path: filepath.Join(h.path, name), readonly: h.readonly, parent: dp.inum}
+		}
+		if ip.count == 0 {
+			p.hostStat(ip, fi)
+		}
+		names[name] = ip.inum
+		p.wdir(ip, name, dp, len(dp.data))
+	}
+
+	// Forget names that are gone from the host.
+	for name, inum := range h.names {
+		if _, ok := names[name]; ok {
+			continue
+		}
+		if ip := d.inodes[inum]; ip != nil && ip.host != nil {
+			ip.nlink = 0
+			if ip.count == 0 {
+				d.inodes[inum] = nil
+			}
+		}
+	}
+	h.names = names
+}
+
+func (p *Proc) hostRead(ip *inode, b []byte, off int) int {
+	f := ip.host.f
+	if f == nil {
+		p.Error = EIO
+		return 0
+	}
+	n, err := f.ReadAt(b, int64(off))
+	if err != nil && err != io.EOF {
+		p.Error = hostErrno(err)
+	}
+	return n
+}
+
+func (p *Proc) hostWrite(ip *inode, b []byte, off int) int {
+	f := ip.host.f
+	if ip.host.readonly {
+		p.Error = EROFS
+		return 0
+	}
+	if f == nil {
+		p.Error = EIO
+		return 0
+	}
+	n, err := f.WriteAt(b, int64(off))
+	if err != nil {
+		p.Error = hostErrno(err)
+	}
+	if off+n > ip.size() {
+		ip.setSize(off + n)
+	}
+	return n
+}
+
+func (p *Proc) hostTrunc(ip *inode) {
+	if f := ip.host.f; f != nil {
+		if err := f.Truncate(0); err != nil {
+			p.Error = hostErrno(err)
+		}
+	}
+}
+
+/*
+ * Make a new host file
+ * in the host directory dp.
+ * Only regular files can be made.
+ */
+func (p *Proc) hostCreate(name string, mode uint16, dp *inode, off int) *inode {
+	if mode&_IFMT != 0 {
+		p.Error = EPERM
+		return nil
+	}
+	file := filepath.Join(dp.host.path, name)
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE|os.O_EXCL, fs.FileMode(mode&0o777))
+	if err != nil {
+		p.Error = hostErrno(err)
+		return nil
+	}
+	ip := p.ialloc(dp.disk)
+	if ip == nil {
+		f.Close()
+		os.Remove(file)
+		return nil
+	}
+	ip.img = nil
+	ip.host = &hostFile{path: file, readonly: dp.host.readonly, parent: dp.inum, f: f}
+	ip.atime = p.Sys.now()
+	ip.mtime = ip.atime
+	ip.mode = mode | _IALLOC
+	ip.nlink = 1
+	ip.uid = p.Uid
+	ip.gid = p.Gid
+	p.wdir(ip, name, dp, off)
+	dp.host.names[name] = ip.inum
+	return ip
+}
+
+/*
+ * Remove the host file for the
+ * entry at off in the host directory dp.
+ * The . and .. entries are only in
+ * the directory data, as rmdir expects.
+ */
+func (p *Proc) hostRemove(ip, dp *inode, off int) bool {
+	name := (*dirent)(unsafe.Pointer(&dp.data[off])).name()
+	if name == "." || name == ".." {
+		return true
+	}
+	if err := os.Remove(ip.host.path); err != nil {
+		p.Error = hostErrno(err)
+		return false
+	}
+	delete(dp.host.names, name)
+	return true
+}
+
+// hostErrno returns the Errno best describing a host error.
+func hostErrno(err error) Errno {
+	var e Errno
+	switch {
+	case errors.As(err, &e):
+		return e
+	case errors.Is(err, fs.ErrNotExist):
+		return ENOENT
+	case errors.Is(err, fs.ErrExist):
+		return EEXIST
+	case errors.Is(err, fs.ErrPermission):
+		return EACCES
+	}
+	return EIO
+}
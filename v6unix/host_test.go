@@ -0,0 +1,158 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package v6unix
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"unsafe"
+)
+
+// dirNames returns the names in the directory name.
+func dirNames(t *testing.T, p *Proc, name string) []string {
+	t.Helper()
+	ip, _, _ := p.namei(name, nameFind)
+	if ip == nil {
+		t.Fatalf("namei %s: %v", name, p.Error)
+	}
+	defer p.iput(ip)
+	var names []string
+	data := p.contents(ip)
+	for i := 0; i < len(data); i += int(direntSize) {
+		if de := (*dirent)(unsafe.Pointer(&data[i])); de.inum != 0 {
+			names = append(names, de.name())
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// readFile returns the content of the file name, read with readi.
+func readFile(t *testing.T, p *Proc, name string) string {
+	t.Helper()
+	ip, _, _ := p.namei(name, nameFind)
+	if ip == nil {
+		t.Fatalf("namei %s: %v", name, p.Error)
+	}
+	defer p.iput(ip)
+	b := make([]byte, 100)
+	return string(b[:p.readi(ip, b, 0)])
+}
+
+func TestMountHost(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "hello.c"), []byte("main() {}\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "a_very_long_file_name"), nil, 0o644)
+	os.Mkdir(filepath.Join(dir, "sub"), 0o755)
+	os.WriteFile(filepath.Join(dir, "sub", "x"), []byte("x"), 0o600)
+
+	p := rootProc(t)
+	if err := p.Sys.MountHost("/host", dir); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := dirNames(t, p, "/host"), []string{".", "..", "hello.c", "sub"}; !slices.Equal(got, want) {
+		t.Errorf("/host = %q, want %q", got, want)
+	}
+	if got, want := dirNames(t, p, "/host/sub"), []string{".", "..", "x"}; !slices.Equal(got, want) {
+		t.Errorf("/host/sub = %q, want %q", got, want)
+	}
+	if got, want := readFile(t, p, "/host/hello.c"), "main() {}\n"; got != want {
+		t.Errorf("read hello.c = %q, want %q", got, want)
+	}
+	root, _ := lookup(p, "/")
+	if up, _ := lookup(p, "/host/sub/../.."); up != root {
+		t.Errorf("/host/sub/../.. is inode %d, want %d", up, root)
+	}
+
+	var st stat
+	p.stat("/host/sub/x", &st)
+	if st.mode != _IALLOC|0o600 || st.size() != 1 {
+		t.Errorf("stat x: mode %#o size %d, want %#o 1", st.mode, st.size(), _IALLOC|0o600)
+	}
+	p.stat("/host/sub", &st)
+	if st.mode&_IFMT != _IFDIR {
+		t.Errorf("stat sub: mode %#o, want directory", st.mode)
+	}
+
+	// Files created and written in the simulation appear on the host.
+	p.Args[0], p.Args[1] = strArg(p, 0o1000, "/host/new"), 0o644
+	syscreate(p)
+	if p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	f := p.Files[p.CPU.R[0]]
+	p.writei(f.inode, []byte("hello, world\n"), 0)
+	closefd(p, p.CPU.R[0])
+	if b, err := os.ReadFile(filepath.Join(dir, "new")); string(b) != "hello, world\n" {
+		t.Errorf("host new = %q, %v", b, err)
+	}
+
+	// Files created on the host appear in the simulation.
+	os.WriteFile(filepath.Join(dir, "sub", "y"), []byte("y"), 0o644)
+	if got := readFile(t, p, "/host/sub/y"); got != "y" {
+		t.Errorf("read sub/y = %q, want %q", got, "y")
+	}
+
+	// Unlink removes the host file.
+	p.unlink("/host/new")
+	if _, err := os.Stat(filepath.Join(dir, "new")); !os.IsNotExist(err) {
+		t.Errorf("after unlink, host stat new: %v", err)
+	}
+	if _, err := lookup(p, "/host/new"); err != ENOENT {
+		t.Errorf("after unlink, lookup /host/new: %v, want ENOENT", err)
+	}
+
+	// No links across the host boundary.
+	p.Error = 0
+	p.Args[0], p.Args[1] = strArg(p, 0o1000, "/bin/ls"), strArg(p, 0o1200, "/host/ls")
+	syslink(p)
+	if p.Error != EXDEV {
+		t.Errorf("link into host tree: %v, want EXDEV", p.Error)
+	}
+}
+
+func TestMountHostReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "file"), []byte("data"), 0o644)
+
+	p := rootProc(t)
+	if err := p.Sys.MountHostReadOnly("/ro", dir); err != nil {
+		t.Fatal(err)
+	}
+	var st stat
+	p.stat("/ro/file", &st)
+	if st.mode != _IALLOC|0o444 {
+		t.Errorf("mode %#o, want %#o", st.mode, _IALLOC|0o444)
+	}
+	if got := readFile(t, p, "/ro/file"); got != "data" {
+		t.Errorf("read = %q, want %q", got, "data")
+	}
+
+	p.Error = 0
+	p.open("/ro/file", 1)
+	if p.Error != EROFS {
+		t.Errorf("open for writing: %v, want EROFS", p.Error)
+	}
+	p.Error = 0
+	p.Args[0], p.Args[1] = strArg(p, 0o1000, "/ro/new"), 0o644
+	syscreate(p)
+	if p.Error != EROFS {
+		t.Errorf("creat: %v, want EROFS", p.Error)
+	}
+	p.Error = 0
+	p.unlink("/ro/file")
+	if p.Error != EROFS {
+		t.Errorf("unlink: %v, want EROFS", p.Error)
+	}
+	if b, err := os.ReadFile(filepath.Join(dir, "file")); string(b) != "data" {
+		t.Errorf("host file = %q, %v after failed writes", b, err)
+	}
+
+	if err := p.Sys.MountHost("/x", filepath.Join(dir, "file")); err == nil {
+		t.Errorf("MountHost of a regular file succeeded")
+	}
+}
@@ -7,13 +7,40 @@
 
 package v6unix
 
+// iget returns the inode inum on the root disk.
 func (p *Proc) iget(inum uint16) *inode {
-	d := p.Sys.Disk
-	if int(inum) >= len(d.inodes) || d.inodes[inum] == nil {
-		p.Error = EIO
-		return nil
+	return p.igetDisk(p.Sys.Disk, inum)
+}
+
+/*
+ * Look up the inode inum on the disk d.
+ * If it is the directory a file system is
+ * mounted on, return the root of the mounted
+ * file system instead.
+ */
+func (p *Proc) igetDisk(d *Disk, inum uint16) *inode {
+	var ip *inode
+	for {
+		if int(inum) < len(d.inodes) && d.inodes[inum] == nil && d.img != nil {
+			d.inodes[inum] = p.iread(d, inum)
+		}
+		if int(inum) >= len(d.inodes) || d.inodes[inum] == nil {
+			p.Error = EIO
+			return nil
+		}
+		ip = d.inodes[inum]
+		if !ip.mounted {
+			break
+		}
+		m := p.Sys.mountOn(ip)
+		if m == nil {
+			panic("no imt")
+		}
+		d, inum = m.disk, ROOTINO
+	}
+	if ip.count == 0 && ip.host != nil {
+		p.hostOpen(ip)
 	}
-	ip := d.inodes[inum]
 	ip.count++
 	return ip
 }
@@ -22,10 +49,26 @@ func (p *Proc) iput(ip *inode) {
 	if ip == nil {
 		return
 	}
-	d := p.Sys.Disk
+	d := ip.disk
 	ip.count--
 	if ip.count == 0 {
-		if ip.nlink == 0 {
+		if ip.host != nil {
+			p.hostClose(ip)
+		}
+		if ip.onImage() {
+			if ip.nlink <= 0 {
+				p.itrunc(ip)
+				ip.mode = 0
+				p.ifree(ip.img, ip.inum)
+				d.inodes[ip.inum] = nil
+			}
+			p.iupdat(ip)
+			return
+		}
+		if ip.nlink <= 0 {
+			/* the last name is gone: release the data */
+			p.itrunc(ip)
+			ip.mode = 0
 			d.inodes[ip.inum] = nil
 			return
 		}
@@ -33,20 +76,33 @@ func (p *Proc) iput(ip *inode) {
 }
 
 func (p *Proc) itrunc(ip *inode) {
+	ip.dirgen++
+	ip.text = nil
+	if ip.onImage() {
+		p.imgTrunc(ip)
+		return
+	}
 	if ip.mode&(_IFCHR|_IFBLK) != 0 {
 		return
 	}
+	if ip.host != nil {
+		p.hostTrunc(ip)
+	}
 	ip.data = nil
 	ip.writeSize()
-	ip.mtime = now()
+	ip.mtime = p.Sys.now()
 }
 
 func (p *Proc) maknode(name string, mode uint16, dp *inode, off int) *inode {
-	ip := p.ialloc()
+	mode &^= p.Umask & 0o777
+	if dp.host != nil {
+		return p.hostCreate(name, mode, dp, off)
+	}
+	ip := p.ialloc(dp.disk)
 	if ip == nil {
 		return nil
 	}
-	ip.atime = now()
+	ip.atime = p.Sys.now()
 	ip.mtime = ip.atime
 	ip.mode = mode | _IALLOC
 	ip.nlink = 1
@@ -57,9 +113,14 @@ func (p *Proc) maknode(name string, mode uint16, dp *inode, off int) *inode {
 }
 
 func (p *Proc) wdir(ip *inode, name string, dp *inode, off int) {
+	dp.dirgen++
 	var de dirent
 	de.inum = ip.inum
 	copy(de.nam[:], name)
+	if dp.onImage() {
+		p.writei(dp, de.bytes(), off)
+		return
+	}
 	if off == len(dp.data) {
 		dp.data = append(dp.data, de.bytes()...)
 		dp.writeSize()
@@ -0,0 +1,622 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Ported from _fs/usr/sys/ken/alloc.c, and bmap from subr.c,
+// iupdat and itrunc from iget.c, and the block loops of readi
+// and writei from rdwri.c.
+// Disk inodes are read into the in-memory inode table on first use
+// and stay there, written back by iput, so there is no inode locking
+// and no flags for which times to update.
+//
+// Copyright 2001-2002 Caldera International Inc. All rights reserved.
+// Use of this source code is governed by a 4-clause BSD-style
+// license that can be found in the LICENSE file.
+
+package v6unix
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+/*
+ * Definition of the unix super block.
+ * The root super block is allocated and
+ * read in iinit/alloc.c. Subsequently
+ * a super block is allocated and read
+ * with each mount (smount/sys3.c) and
+ * released with unmount (sumount/sys3.c).
+ * A disk block is ripped off for storage.
+ * See alloc.c for general alloc/free
+ * routines for free list and I list.
+ */
+type filsys struct {
+	isize  uint16      /* size in blocks of I list */
+	fsize  uint16      /* size in blocks of entire volume */
+	nfree  int16       /* number of in core free blocks (0-100) */
+	free   [100]uint16 /* in core free blocks */
+	ninode int16       /* number of in core I nodes (0-100) */
+	inode  [100]uint16 /* in core free I nodes */
+	flock  uint8       /* lock during free list manipulation */
+	ilock  uint8       /* lock during I list manipulation */
+	fmod   uint8       /* super block modified flag */
+	ronly  uint8       /* mounted read-only flag */
+	time   [2]uint16   /* current date of last update */
+	pad    [48]uint16  /* v6 has 50, running past the end of the block */
+}
+
+const (
+	SUPERB     = 1 /* block number of the super block */
+	NICFREE    = 100
+	NICINOD    = 100
+	dinodeSize = 32                 /* size of an inode on disk */
+	INOPB      = BSIZE / dinodeSize /* inodes per block */
+)
+
+// An imageFS is a v6 file system in a disk image.
+type imageFS struct {
+	dev *blkdev
+	fs  filsys // in-core super block
+}
+
+func (fs *imageFS) readonly() bool {
+	return fs.fs.ronly != 0
+}
+
+// MountImage makes the v6 file system in the disk image r,
+// which is size bytes long, the root file system.
+// It replaces the file system the System was created with,
+// so it must be called before any processes are started.
+// If r also implements io.WriterAt, the file system is writable;
+// otherwise writes fail with EROFS.
+// Changes are kept in a buffer cache until Sync.
+func (sys *System) MountImage(r io.ReaderAt, size int64) error {
+	dev := &blkdev{r: r}
+	dev.w, _ = r.(io.WriterAt)
+	d, err := openImage(dev, 0, dev.w == nil)
+	if err != nil {
+		return err
+	}
+	if int64(d.img.fs.fsize)*BSIZE > size {
+		return errors.New("v6 file system larger than disk image")
+	}
+	sys.Disk = d
+	return nil
+}
+
+// openImage reads the super block of the v6 file system on dev
+// and returns a Disk for it with device number devno.
+func openImage(dev *blkdev, devno uint16, ronly bool) (*Disk, error) {
+	fs := &imageFS{dev: dev}
+	bp, err := fs.dev.bread(SUPERB)
+	if err != nil {
+		return nil, fmt.Errorf("reading super block: %v", err)
+	}
+	fs.fs = *(*filsys)(unsafe.Pointer(&bp.data))
+	f := &fs.fs
+	if f.isize == 0 || int(f.isize)+2 > int(f.fsize) || int(f.isize)*INOPB >= 1<<16 ||
+		f.nfree < 0 || f.nfree > NICFREE || f.ninode < 0 || f.ninode > NICINOD {
+		return nil, errors.New("not a v6 file system")
+	}
+	f.flock = 0
+	f.ilock = 0
+	f.fmod = 0
+	f.ronly = 0
+	if ronly {
+		f.ronly = 1
+	}
+
+	d := &Disk{
+		inodes: make([]*inode, 1+int(f.isize)*INOPB),
+		img:    fs,
+		dev:    devno,
+	}
+	p := &Proc{Sys: new(System)}
+	root := p.igetDisk(d, ROOTINO)
+	if root == nil || root.mode&_IFMT != _IFDIR {
+		return nil, errors.New("v6 file system has no root directory")
+	}
+	p.iput(root)
+	return d, nil
+}
+
+/*
+ * Write the super block back if it has changed,
+ * and then the delayed-write blocks.
+ * Analogous to update.
+ */
+func (fs *imageFS) flush(time [2]uint16) error {
+	if fs.readonly() {
+		return nil
+	}
+	if fs.fs.fmod != 0 {
+		fs.fs.fmod = 0
+		fs.fs.time = time
+		bp, err := fs.dev.bread(SUPERB)
+		if err != nil {
+			return err
+		}
+		*(*filsys)(unsafe.Pointer(&bp.data)) = fs.fs
+		bp.dirty = true
+	}
+	return fs.dev.flush()
+}
+
+/*
+ * Read the inode inum from the I list,
+ * which starts at block 2.
+ */
+func (p *Proc) iread(d *Disk, inum uint16) *inode {
+	fs := d.img
+	if inum == 0 {
+		return nil
+	}
+	bp, err := fs.dev.bread(int64(int(inum)+31) / INOPB)
+	if err != nil {
+		p.Error = EIO
+		return nil
+	}
+	ip := &inode{disk: d, img: fs}
+	ip.dev = d.dev
+	ip.inum = inum
+	copy(ip.stat.dinode(), bp.data[dinodeSize*((int(inum)+31)%INOPB):])
+	return ip
+}
+
+/*
+ * Copy the inode back into the I list.
+ */
+func (p *Proc) iupdat(ip *inode) {
+	if ip.img.iupdat(ip) != nil {
+		p.Error = EIO
+	}
+}
+
+/*
+ * Copy the inode back into the I list of
+ * its file system, fs, returning any error
+ * reading the block that holds it.
+ */
+func (fs *imageFS) iupdat(ip *inode) error {
+	if fs.readonly() {
+		return nil
+	}
+	bp, err := fs.dev.bread(int64(int(ip.inum)+31) / INOPB)
+	if err != nil {
+		return err
+	}
+	copy(bp.data[dinodeSize*((int(ip.inum)+31)%INOPB):], ip.stat.dinode())
+	bp.dirty = true
+	return nil
+}
+
+/*
+ * Copy the inodes still in use back into the
+ * I list, as update does; iput writes each
+ * of the others when its last use ends.
+ */
+func (d *Disk) iflush() error {
+	var errs []error
+	for _, ip := range d.inodes {
+		if ip != nil && ip.count > 0 && ip.onImage() {
+			errs = append(errs, ip.img.iupdat(ip))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+/*
+ * alloc will obtain the next available
+ * free disk block from the free list of
+ * the specified device.
+ * The super block has up to 100 remembered
+ * free blocks; the last of these is read to
+ * obtain 100 more . . .
+ *
+ * no space on dev x/y -- when
+ * the free list is exhausted.
+ */
+func (p *Proc) alloc(fs *imageFS) *buf {
+	f := &fs.fs
+	var bno uint16
+	for {
+		if f.nfree <= 0 || f.nfree > NICFREE {
+			f.nfree = 0
+			p.Error = ENOSPC
+			return nil
+		}
+		f.nfree--
+		bno = f.free[f.nfree]
+		if bno == 0 {
+			f.nfree = 0
+			p.Error = ENOSPC
+			return nil
+		}
+		if !p.badblock(fs, bno) {
+			break
+		}
+	}
+	if f.nfree <= 0 {
+		bp, err := fs.dev.bread(int64(bno))
+		if err != nil {
+			p.Error = EIO
+			return nil
+		}
+		b := addrs(bp)
+		f.nfree = int16(b[0])
+		copy(f.free[:], b[1:1+NICFREE])
+	}
+	bp, _, err := fs.dev.getblk(int64(bno))
+	if err != nil {
+		p.Error = EIO
+		return nil
+	}
+	clear(bp.data[:])
+	bp.dirty = true
+	f.fmod = 1
+	return bp
+}
+
+/*
+ * place the specified disk block
+ * back on the free list of the
+ * specified device.
+ */
+func (p *Proc) free(fs *imageFS, bno uint16) {
+	f := &fs.fs
+	f.fmod = 1
+	if p.badblock(fs, bno) {
+		return
+	}
+	if f.nfree <= 0 {
+		f.nfree = 1
+		f.free[0] = 0
+	}
+	if f.nfree >= NICFREE {
+		bp, _, err := fs.dev.getblk(int64(bno))
+		if err != nil {
+			p.Error = EIO
+			return
+		}
+		b := addrs(bp)
+		clear(bp.data[:])
+		b[0] = uint16(f.nfree)
+		copy(b[1:1+NICFREE], f.free[:])
+		bp.dirty = true
+		f.nfree = 0
+	}
+	f.free[f.nfree] = bno
+	f.nfree++
+}
+
+/*
+ * Check that a block number is in the
+ * range between the I list and the size
+ * of the device.
+ * This is used mainly to check that a
+ * garbage file system has not been mounted.
+ *
+ * bad block on dev x/y -- not in range
+ */
+func (p *Proc) badblock(fs *imageFS, bno uint16) bool {
+	return bno < fs.fs.isize+2 || bno >= fs.fs.fsize
+}
+
+/*
+ * Allocate an unused I node
+ * on the specified device.
+ * Used with file creation.
+ * The algorithm keeps up to
+ * 100 spare I nodes in the
+ * super block. When this runs out,
+ * a linear search through the
+ * I list is instituted to pick
+ * up 100 more.
+ */
+func (p *Proc) imgIalloc(d *Disk) *inode {
+	fs := d.img
+	f := &fs.fs
+	for {
+		for f.ninode > 0 {
+			f.ninode--
+			ip := p.igetDisk(d, f.inode[f.ninode])
+			if ip == nil {
+				return nil
+			}
+			if ip.mode == 0 {
+				ip.stat = stat{dev: ip.dev, inum: ip.inum}
+				f.fmod = 1
+				return ip
+			}
+			/*
+			 * Inode was allocated after all.
+			 * Look some more.
+			 */
+			p.iput(ip)
+		}
+
+		inum := uint16(0)
+	Search:
+		for i := 0; i < int(f.isize); i++ {
+			bp, err := fs.dev.bread(int64(i + 2))
+			if err != nil {
+				p.Error = EIO
+				return nil
+			}
+			for j := 0; j < BSIZE; j += dinodeSize {
+				inum++
+				if bp.data[j] != 0 || bp.data[j+1] != 0 {
+					continue
+				}
+				if ip := d.inodes[inum]; ip != nil && ip.mode != 0 {
+					continue
+				}
+				f.inode[f.ninode] = inum
+				if f.ninode++; f.ninode >= NICINOD {
+					break Search
+				}
+			}
+		}
+		if f.ninode == 0 {
+			p.Error = ENOSPC
+			return nil
+		}
+	}
+}
+
+/*
+ * Free the specified I node
+ * on the specified device.
+ * The algorithm stores up
+ * to 100 I nodes in the super
+ * block and throws away any more.
+ */
+func (p *Proc) ifree(fs *imageFS, inum uint16) {
+	f := &fs.fs
+	if f.ninode >= NICINOD {
+		return
+	}
+	f.inode[f.ninode] = inum
+	f.ninode++
+	f.fmod = 1
+}
+
+/*
+ * Bmap defines the structure of file system storage
+ * by returning the physical block number on a device given the
+ * inode and the logical block number in a file.
+ * When convenient, it also leaves the physical
+ * block number of the next block of the file in rablock
+ * for use in read-ahead.
+ *
+ * Here a missing block is only allocated if alloc is set;
+ * otherwise bmap returns 0 for it, and readi reads zeros.
+ */
+func (p *Proc) bmap(ip *inode, bn int, alloc bool) uint16 {
+	fs := ip.img
+	if bn&^0o77777 != 0 {
+		p.Error = EFBIG
+		return 0
+	}
+
+	if !ip.large() {
+		/*
+		 * small file algorithm
+		 */
+		if bn&^7 == 0 {
+			nb := *ip.iaddr(bn)
+			if nb == 0 && alloc {
+				bp := p.alloc(fs)
+				if bp == nil {
+					return 0
+				}
+				nb = uint16(bp.blkno)
+				*ip.iaddr(bn) = nb
+			}
+			return nb
+		}
+		if !alloc {
+			return 0
+		}
+		if fileType(ip.mode) == _IFLNK {
+			p.Error = EFBIG
+			return 0
+		}
+
+		/*
+		 * convert small to large
+		 */
+		bp := p.alloc(fs)
+		if bp == nil {
+			return 0
+		}
+		bap := addrs(bp)
+		for i := 0; i < 8; i++ {
+			bap[i] = *ip.iaddr(i)
+			*ip.iaddr(i) = 0
+		}
+		*ip.iaddr(0) = uint16(bp.blkno)
+		ip.mode |= _ILARG
+	}
+
+	/*
+	 * large file algorithm
+	 */
+	i := bn >> 8
+	if bn&0o174000 != 0 {
+		i = 7
+	}
+	bp := p.indir(fs, nil, ip.iaddr(i), alloc)
+	if bp == nil {
+		return 0
+	}
+
+	/*
+	 * "huge" fetch of double indirect block
+	 */
+	if i == 7 {
+		bp = p.indir(fs, bp, &addrs(bp)[(bn>>8)&0o377-7], alloc)
+		if bp == nil {
+			return 0
+		}
+	}
+
+	/*
+	 * normal indirect fetch
+	 */
+	nbp := p.indir(fs, bp, &addrs(bp)[bn&0o377], alloc)
+	if nbp == nil {
+		return 0
+	}
+	return uint16(nbp.blkno)
+}
+
+/*
+ * Return the block whose number is *addr,
+ * allocating it first if alloc is set and *addr is 0.
+ * The address is in the block parent,
+ * or in the inode if parent is nil.
+ */
+func (p *Proc) indir(fs *imageFS, parent *buf, addr *uint16, alloc bool) *buf {
+	if *addr == 0 {
+		if !alloc {
+			return nil
+		}
+		bp := p.alloc(fs)
+		if bp == nil {
+			return nil
+		}
+		*addr = uint16(bp.blkno)
+		if parent != nil {
+			parent.dirty = true
+		}
+		return bp
+	}
+	bp, err := fs.dev.bread(int64(*addr))
+	if err != nil {
+		p.Error = EIO
+		return nil
+	}
+	return bp
+}
+
+// addrs returns the block addresses in an indirect block.
+func addrs(bp *buf) *[256]uint16 {
+	return (*[256]uint16)(unsafe.Pointer(&bp.data))
+}
+
+func (p *Proc) imgRead(ip *inode, b []byte, off int) int {
+	total := 0
+	for len(b) > 0 && off < ip.size() {
+		on := off % BSIZE
+		n := min(BSIZE-on, ip.size()-off, len(b))
+		bn := p.bmap(ip, off/BSIZE, false)
+		if p.Error != 0 {
+			break
+		}
+		if bn == 0 {
+			clear(b[:n])
+		} else {
+			bp, err := ip.img.dev.bread(int64(bn))
+			if err != nil {
+				p.Error = EIO
+				break
+			}
+			copy(b[:n], bp.data[on:])
+		}
+		b = b[n:]
+		off += n
+		total += n
+	}
+	return total
+}
+
+func (p *Proc) imgWrite(ip *inode, b []byte, off int) int {
+	fs := ip.img
+	if fs.readonly() {
+		p.Error = EROFS
+		return 0
+	}
+	total := 0
+	for len(b) > 0 {
+		on := off % BSIZE
+		n := min(BSIZE-on, len(b))
+		bn := p.bmap(ip, off/BSIZE, true)
+		if bn == 0 {
+			break
+		}
+		var bp *buf
+		var err error
+		if n == BSIZE {
+			bp, _, err = fs.dev.getblk(int64(bn))
+		} else {
+			bp, err = fs.dev.bread(int64(bn))
+		}
+		if err != nil {
+			p.Error = EIO
+			break
+		}
+		copy(bp.data[on:], b[:n])
+		bp.dirty = true
+		b = b[n:]
+		off += n
+		total += n
+		if off > ip.size() {
+			ip.setSize(off)
+		}
+	}
+	return total
+}
+
+/*
+ * Free all the disk blocks associated
+ * with the specified inode structure.
+ * The blocks of the file are removed
+ * in reverse order. This FILO
+ * algorithm will tend to maintain
+ * a contiguous free list much longer
+ * than FIFO.
+ */
+func (p *Proc) imgTrunc(ip *inode) {
+	fs := ip.img
+	if ip.special() {
+		return
+	}
+	for i := 7; i >= 0; i-- {
+		addr := ip.iaddr(i)
+		if *addr == 0 {
+			continue
+		}
+		if ip.large() {
+			if bp := p.indir(fs, nil, addr, false); bp != nil {
+				bap := *addrs(bp)
+				for j := 255; j >= 0; j-- {
+					if bap[j] == 0 {
+						continue
+					}
+					if i == 7 {
+						if dp := p.indir(fs, nil, &bap[j], false); dp != nil {
+							dap := *addrs(dp)
+							for k := 255; k >= 0; k-- {
+								if dap[k] != 0 {
+									p.free(fs, dap[k])
+								}
+							}
+						}
+					}
+					p.free(fs, bap[j])
+				}
+			}
+		}
+		p.free(fs, *addr)
+		*addr = 0
+	}
+	if fileType(ip.mode) != _IFLNK {
+		ip.mode &^= _ILARG
+	}
+	ip.setSize(0)
+	ip.mtime = p.Sys.now()
+}
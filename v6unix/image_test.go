@@ -0,0 +1,674 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package v6unix
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"testing"
+	"unsafe"
+)
+
+// mountRoot returns a process in a new system
+// with the v6 root disk image as its file system.
+func mountRoot(t *testing.T, disk *memDisk) *Proc {
+	t.Helper()
+	p := rootProc(t)
+	if err := p.Sys.MountImage(disk, int64(len(disk.data))); err != nil {
+		t.Fatal(err)
+	}
+	p.Dir = p.iget(ROOTINO)
+	return p
+}
+
+func readRoot(t *testing.T) *memDisk {
+	data, err := os.ReadFile("../v6/v6root")
+	if err != nil {
+		t.Skip(err)
+	}
+	return &memDisk{data: data}
+}
+
+// freeBlocks returns the number of blocks on the image's free list.
+func freeBlocks(t *testing.T, p *Proc) int {
+	fs := p.Sys.Disk.img
+	n := 0
+	nfree, free := int(fs.fs.nfree), fs.fs.free[:]
+	for nfree > 0 {
+		n += nfree
+		if free[0] == 0 {
+			return n - 1
+		}
+		bp, err := fs.dev.bread(int64(free[0]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := addrs(bp)
+		nfree, free = int(b[0]), b[1:1+NICFREE]
+	}
+	return n
+}
+
+// fsck checks the v6 file system in the disk image data
+// and returns the number of free blocks.
+// As in icheck, every block after the I list must be
+// in exactly one file or on the free list, and in addition
+// the free inodes in the super block must be unused.
+func fsck(t *testing.T, data []byte) int {
+	t.Helper()
+	block := func(bno uint16) []uint16 {
+		return (*[256]uint16)(unsafe.Pointer(&data[int(bno)*BSIZE]))[:]
+	}
+	fs := (*filsys)(unsafe.Pointer(&data[SUPERB*BSIZE]))
+	owner := make(map[uint16]string)
+	claim := func(bno uint16, who string) bool {
+		if bno == 0 {
+			return false
+		}
+		if bno < fs.isize+2 || bno >= fs.fsize {
+			t.Errorf("%s: bad block %d", who, bno)
+			return false
+		}
+		if o, ok := owner[bno]; ok {
+			t.Errorf("block %d in both %s and %s", bno, o, who)
+			return false
+		}
+		owner[bno] = who
+		return true
+	}
+	dinode := func(inum uint16) []byte {
+		off := 2*BSIZE + (int(inum)-1)*dinodeSize
+		return data[off : off+dinodeSize]
+	}
+
+	for inum := uint16(1); int(inum) <= int(fs.isize)*INOPB; inum++ {
+		var st stat
+		copy(st.dinode(), dinode(inum))
+		ft := fileType(st.mode)
+		if st.mode&_IALLOC == 0 || ft == _IFCHR || ft == _IFBLK {
+			continue
+		}
+		who := fmt.Sprintf("inode %d", inum)
+		for i := 0; i < 8; i++ {
+			a := *st.iaddr(i)
+			if !claim(a, who) || st.mode&_ILARG == 0 || ft == _IFLNK {
+				continue
+			}
+			for _, b := range block(a) {
+				if claim(b, who) && i == 7 {
+					for _, c := range block(b) {
+						claim(c, who)
+					}
+				}
+			}
+		}
+	}
+
+	nfree, free := int(fs.nfree), fs.free[:]
+	for nfree > 0 && nfree <= NICFREE {
+		for _, b := range free[1:nfree] {
+			claim(b, "the free list")
+		}
+		if !claim(free[0], "the free list") {
+			break
+		}
+		b := block(free[0])
+		nfree, free = int(b[0]), b[1:1+NICFREE]
+	}
+	n := 0
+	for bno := fs.isize + 2; bno < fs.fsize; bno++ {
+		switch owner[bno] {
+		case "":
+			t.Errorf("block %d missing: in no file and not free", bno)
+		case "the free list":
+			n++
+		}
+	}
+
+	for _, inum := range fs.inode[:fs.ninode] {
+		if d := dinode(inum); d[0] != 0 || d[1] != 0 {
+			t.Errorf("free inode %d is allocated", inum)
+		}
+	}
+	return n
+}
+
+func TestMountImage(t *testing.T) {
+	want := rootProc(t)
+	p := mountRoot(t, readRoot(t))
+	for _, name := range []string{"/bin/ls", "/unix", "/etc/rc"} {
+		data, err := p.Sys.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantData, _ := want.Sys.ReadFile(name)
+		if !bytes.Equal(data, wantData) {
+			t.Errorf("%s: image has %d bytes, different from disk.txtar's %d", name, len(data), len(wantData))
+		}
+	}
+	if _, err := lookup(p, "/usr/nonexistent"); err != ENOENT {
+		t.Errorf("lookup /usr/nonexistent: %v, want ENOENT", err)
+	}
+	p.Error = 0
+	if got, want := dirNames(t, p, "/dev")[:3], []string{".", "..", "kmem"}; !slices.Equal(got, want) {
+		t.Errorf("/dev = %q..., want %q...", got, want)
+	}
+
+	var st stat
+	p.stat("/dev/tty8", &st)
+	if st.mode&_IFMT != _IFCHR || st.major != 0 || st.minor != 0 {
+		t.Errorf("/dev/tty8: mode %#o dev %d,%d, want character special 0,0", st.mode, st.major, st.minor)
+	}
+	p.stat("/unix", &st)
+	if st.mode&_ILARG == 0 {
+		t.Errorf("/unix is not a large file")
+	}
+}
+
+func TestMountImageWrite(t *testing.T) {
+	disk := readRoot(t)
+	p := mountRoot(t, disk)
+	free := freeBlocks(t, p)
+
+	// A file big enough to need the large file algorithm.
+	data := make([]byte, 100*BSIZE+17)
+	for i := range data {
+		data[i] = byte(i * 7 / 5)
+	}
+	p.Args[0], p.Args[1] = strArg(p, 0o1000, "/tmp/big"), 0o644
+	syscreate(p)
+	if p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	fd := p.CPU.R[0]
+	f := p.Files[fd]
+	for off := 0; off < len(data); off += 1000 {
+		p.writei(f.inode, data[off:min(off+1000, len(data))], off)
+	}
+	if p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	closefd(p, fd)
+	if got := freeBlocks(t, p); got != free-101-1 {
+		t.Errorf("free blocks = %d after writing file, want %d", got, free-101-1)
+	}
+	if err := p.Sys.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read it back from a fresh mount of the image.
+	p = mountRoot(t, disk)
+	got, err := p.Sys.ReadFile("/tmp/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("read back %d bytes, not what was written", len(got))
+	}
+	var st stat
+	p.stat("/tmp/big", &st)
+	if st.mode != _IALLOC|_ILARG|0o644 || st.size() != len(data) {
+		t.Errorf("/tmp/big: mode %#o size %d, want %#o %d", st.mode, st.size(), _IALLOC|_ILARG|0o644, len(data))
+	}
+
+	// Removing it frees its blocks and its inode.
+	p.unlink("/tmp/big")
+	if p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	if got := freeBlocks(t, p); got != free {
+		t.Errorf("free blocks = %d after unlink, want %d", got, free)
+	}
+	p.Sys.Sync()
+	p = mountRoot(t, disk)
+	if _, err := lookup(p, "/tmp/big"); err != ENOENT {
+		t.Errorf("lookup /tmp/big after unlink: %v, want ENOENT", err)
+	}
+	if ip := p.iget(st.inum); ip == nil || ip.mode != 0 {
+		t.Errorf("inode %d still allocated after unlink", st.inum)
+	}
+}
+
+func TestMountImageReadOnly(t *testing.T) {
+	disk := readRoot(t)
+	p := rootProc(t)
+	if err := p.Sys.MountImage(bytes.NewReader(disk.data), int64(len(disk.data))); err != nil {
+		t.Fatal(err)
+	}
+	p.Dir = p.iget(ROOTINO)
+	p.Args[0], p.Args[1] = strArg(p, 0o1000, "/tmp/x"), 0o644
+	syscreate(p)
+	if p.Error != EROFS {
+		t.Errorf("creat: %v, want EROFS", p.Error)
+	}
+
+	if err := p.Sys.MountImage(bytes.NewReader(make([]byte, 10*BSIZE)), 10*BSIZE); err == nil {
+		t.Errorf("MountImage of zeros succeeded")
+	}
+}
+
+func TestMount(t *testing.T) {
+	data, err := os.ReadFile("../v6/v6src")
+	if err != nil {
+		t.Skip(err)
+	}
+	p := rootProc(t)
+	const major = 20
+	if err := p.Sys.AttachBlockDevice(major, &memDisk{data: data}); err != nil {
+		t.Fatal(err)
+	}
+	call := func(fn func(*Proc), args ...string) Errno {
+		p.Error = 0
+		for i, s := range args {
+			p.Args[i] = strArg(p, 0o1000+0o200*uint16(i), s)
+		}
+		fn(p)
+		return p.Error
+	}
+	p.Args[1], p.Args[2] = _IFBLK|0o600, major<<8
+	if err := call(sysmknod, "/dev/src"); err != 0 {
+		t.Fatal(err)
+	}
+	p.Args[2] = 0 // read-write
+	if err := call(sysmount, "/tmp", "/tmp"); err != ENOTBLK {
+		t.Errorf("mount directory: %v, want ENOTBLK", err)
+	}
+	root, _ := lookup(p, "/")
+	tmp, _ := lookup(p, "/tmp")
+	if err := call(sysmount, "/dev/src", "/tmp"); err != 0 {
+		t.Fatal(err)
+	}
+	if err := call(sysmount, "/dev/src", "/usr"); err != EBUSY {
+		t.Errorf("mount twice: %v, want EBUSY", err)
+	}
+
+	p.Error = 0
+	names := dirNames(t, p, "/tmp")
+	if !slices.Contains(names, "s1") {
+		t.Fatalf("mounted /tmp has %q, want s1", names)
+	}
+	if inum, err := lookup(p, "/tmp"); err != 0 || inum != ROOTINO {
+		t.Errorf("/tmp = %d, %v, want mounted root %d", inum, err, ROOTINO)
+	}
+	if inum, err := lookup(p, "/tmp/.."); err != 0 || inum != root {
+		t.Errorf("/tmp/.. = %d, %v, want %d", inum, err, root)
+	}
+	if inum, err := lookup(p, "/tmp/s1/../.."); err != 0 || inum != root {
+		t.Errorf("/tmp/s1/../.. = %d, %v, want %d", inum, err, root)
+	}
+	if _, err := lookup(p, "/tmp/s1/ls.c"); err != 0 {
+		t.Errorf("/tmp/s1/ls.c: %v", err)
+	}
+
+	p.Args[0], p.Args[1] = strArg(p, 0o1000, "/tmp/s1/ls.c"), strArg(p, 0o1200, "/ls.c")
+	p.Error = 0
+	if syslink(p); p.Error != EXDEV {
+		t.Errorf("link across file systems: %v, want EXDEV", p.Error)
+	}
+	p.Error = 0
+
+	// An open file keeps the file system busy.
+	ip, _, _ := p.namei("/tmp/s1/ls.c", nameFind)
+	if ip == nil {
+		t.Fatal(p.Error)
+	}
+	if err := call(sysumount, "/dev/src"); err != EBUSY {
+		t.Errorf("umount with open file: %v, want EBUSY", err)
+	}
+	p.iput(ip)
+	if err := call(sysumount, "/dev/src"); err != 0 {
+		t.Fatal(err)
+	}
+	if inum, err := lookup(p, "/tmp"); err != 0 || inum != tmp {
+		t.Errorf("after umount /tmp = %d, %v, want %d", inum, err, tmp)
+	}
+	if err := call(sysumount, "/dev/src"); err != EINVAL {
+		t.Errorf("umount twice: %v, want EINVAL", err)
+	}
+}
+
+func TestRamDisk(t *testing.T) {
+	data, err := os.ReadFile("../v6/v6src")
+	if err != nil {
+		t.Skip(err)
+	}
+	p := rootProc(t)
+	blocks := len(data) / BSIZE
+	major, err := p.Sys.NewRamDisk(blocks)
+	if major != uint8(len(defaultDevtab)) || err != nil {
+		t.Errorf("NewRamDisk = major %d, %v, want first free %d", major, err, len(defaultDevtab))
+	}
+	if major2, err := p.Sys.NewRamDisk(1); major2 != major+1 || err != nil {
+		t.Errorf("second NewRamDisk = major %d, %v, want %d", major2, err, major+1)
+	}
+	if _, err := p.Sys.NewRamDisk(-1); err != EINVAL {
+		t.Errorf("NewRamDisk(-1): %v, want EINVAL", err)
+	}
+	rd := p.Sys.RamDisk(major)
+	if rd == nil || len(rd.Bytes()) != blocks*BSIZE || p.Sys.RamDisk(memMajor) != nil {
+		t.Fatalf("RamDisk does not find the RAM disk")
+	}
+	if n, err := rd.ReadAt(make([]byte, 2*BSIZE), int64(blocks-1)*BSIZE); n != BSIZE || err != io.EOF {
+		t.Errorf("ReadAt of last block and past end = %d, %v, want %d, EOF", n, err, BSIZE)
+	}
+
+	// Copy the file system onto the RAM disk through the device.
+	d := p.dev(major, 0)
+	if n := d.write(p, 0, data[:blocks*BSIZE], 0); n != blocks*BSIZE || p.Error != 0 {
+		t.Fatalf("writing image: %d, %v", n, p.Error)
+	}
+	if d.write(p, 0, []byte("x"), blocks*BSIZE); p.Error != EIO {
+		t.Errorf("write past end: %v, want EIO", p.Error)
+	}
+	p.Error = 0
+	if d.read(p, 0, make([]byte, 1), blocks*BSIZE); p.Error != EIO {
+		t.Errorf("read past end: %v, want EIO", p.Error)
+	}
+	p.Error = 0
+
+	call := func(fn func(*Proc), args ...string) Errno {
+		p.Error = 0
+		for i, s := range args {
+			p.Args[i] = strArg(p, 0o1000+0o200*uint16(i), s)
+		}
+		fn(p)
+		return p.Error
+	}
+	p.Args[1], p.Args[2] = _IFBLK|0o600, uint16(major)<<8
+	if err := call(sysmknod, "/dev/rd0"); err != 0 {
+		t.Fatal(err)
+	}
+	p.Args[2] = 0 // read-write
+	if err := call(sysmount, "/dev/rd0", "/tmp"); err != 0 {
+		t.Fatal(err)
+	}
+	if _, err := lookup(p, "/tmp/s1/ls.c"); err != 0 {
+		t.Errorf("/tmp/s1/ls.c: %v", err)
+	}
+	p.Args[1] = 0o666
+	if err := call(syscreate, "/tmp/ramfile"); err != 0 {
+		t.Fatal(err)
+	}
+	fd := p.CPU.R[0]
+	const msg = "kept in memory"
+	if n := p.writei(p.Files[fd].inode, []byte(msg), 0); n != len(msg) {
+		t.Fatalf("writei = %d", n)
+	}
+	closefd(p, fd)
+	if err := call(sysumount, "/dev/rd0"); err != 0 {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(rd.Bytes(), []byte(msg)) {
+		t.Errorf("RAM disk does not hold the file written to it")
+	}
+}
+
+func TestFreeLists(t *testing.T) {
+	data, err := os.ReadFile("../v6/v6src")
+	if err != nil {
+		t.Skip(err)
+	}
+	p := rootProc(t)
+	major, err := p.Sys.NewRamDisk(len(data) / BSIZE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rd := p.Sys.RamDisk(major)
+	copy(rd.Bytes(), data)
+	free := fsck(t, rd.Bytes())
+
+	call := func(fn func(*Proc), args ...string) Errno {
+		p.Error = 0
+		for i, s := range args {
+			p.Args[i] = strArg(p, 0o1000+0o200*uint16(i), s)
+		}
+		fn(p)
+		return p.Error
+	}
+	p.Args[1], p.Args[2] = _IFBLK|0o600, uint16(major)<<8
+	if err := call(sysmknod, "/dev/rd0"); err != 0 {
+		t.Fatal(err)
+	}
+	p.Args[2] = 0 // read-write
+	if err := call(sysmount, "/dev/rd0", "/tmp"); err != 0 {
+		t.Fatal(err)
+	}
+	dirBlocks := func() int {
+		var st stat
+		p.stat("/tmp", &st)
+		return (st.size() + BSIZE - 1) / BSIZE
+	}
+	dir := dirBlocks()
+	create := func(name string, size int) Errno {
+		p.Args[1] = 0o644
+		if err := call(syscreate, name); err != 0 {
+			return err
+		}
+		fd := p.CPU.R[0]
+		ip := p.Files[fd].inode
+		b := make([]byte, 8*BSIZE)
+		for off := 0; off < size && p.Error == 0; off += len(b) {
+			p.writei(ip, b[:min(len(b), size-off)], off)
+		}
+		err := p.Error
+		closefd(p, fd)
+		return err
+	}
+
+	// Sync writes the super block of a mounted file system,
+	// so the image is consistent while still mounted.
+	for i := 0; i < 40; i++ {
+		if err := create(fmt.Sprintf("/tmp/f%d", i), i*300); err != 0 {
+			t.Fatalf("create f%d: %v", i, err)
+		}
+	}
+	for i := 0; i < 40; i += 2 {
+		if err := call(sysunlink, fmt.Sprintf("/tmp/f%d", i)); err != 0 {
+			t.Fatalf("unlink f%d: %v", i, err)
+		}
+	}
+	if err := p.Sys.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if n := fsck(t, rd.Bytes()); n >= free {
+		t.Errorf("free blocks = %d after creating files, want fewer than %d", n, free)
+	}
+
+	// Filling the file system fails with ENOSPC,
+	// and unlinking everything frees it all again.
+	if err := create("/tmp/full", len(data)); err != ENOSPC {
+		t.Errorf("writing more than the disk holds: %v, want ENOSPC", err)
+	}
+	p.Sys.Sync()
+	if n := fsck(t, rd.Bytes()); n != 0 {
+		t.Errorf("free blocks = %d after filling the disk, want 0", n)
+	}
+	// (The directory keeps the blocks it grew into.)
+	call(sysunlink, "/tmp/full")
+	for i := 1; i < 40; i += 2 {
+		call(sysunlink, fmt.Sprintf("/tmp/f%d", i))
+	}
+	want := free - (dirBlocks() - dir)
+	if err := call(sysumount, "/dev/rd0"); err != 0 {
+		t.Fatal(err)
+	}
+	if n := fsck(t, rd.Bytes()); n != want {
+		t.Errorf("free blocks = %d after removing the files, want %d", n, want)
+	}
+}
+
+// mkfs makes an empty v6 file system of the given size
+// with isize blocks of inodes on a new RAM disk,
+// as /etc/mkfs does, and returns the disk's major number.
+func mkfs(t *testing.T, p *Proc, blocks, isize int) uint8 {
+	t.Helper()
+	major, err := p.Sys.NewRamDisk(blocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := &imageFS{dev: p.Sys.RamDisk(major).dev}
+	fs.fs.isize = uint16(isize)
+	fs.fs.fsize = uint16(blocks)
+	root := uint16(isize + 2)
+	for bno := blocks - 1; bno > int(root); bno-- {
+		p.free(fs, uint16(bno))
+	}
+
+	// The root directory, holding . and .. in its one block.
+	ip := &inode{stat: stat{mode: _IALLOC | _IFDIR | 0o777, nlink: 2}, img: fs}
+	ip.inum = ROOTINO
+	ip.setSize(2 * 16)
+	*ip.iaddr(0) = root
+	p.iupdat(ip)
+	bp, _, _ := fs.dev.getblk(int64(root))
+	clear(bp.data[:])
+	copy(bp.data[:], "\x01\x00.\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x00..")
+	bp.dirty = true
+	fs.fs.fmod = 1
+	if err := fs.flush(p.Sys.now()); err != nil {
+		t.Fatal(err)
+	}
+	return major
+}
+
+func TestLargeFile(t *testing.T) {
+	p := rootProc(t)
+	const blocks = 8000
+	major := mkfs(t, p, blocks, 16)
+	if err := p.Sys.MountImage(p.Sys.RamDisk(major), blocks*BSIZE); err != nil {
+		t.Fatal(err)
+	}
+	if probs, err := p.Sys.Check(0); err != nil || len(probs) != 0 {
+		t.Fatalf("Check of new file system = %v, %v", probs, err)
+	}
+	p.Dir = p.iget(ROOTINO)
+
+	// 3 MB is past the 7*256 blocks of single indirection,
+	// so the file uses the double indirect block too.
+	data := make([]byte, 3<<20+123)
+	for i := range data {
+		data[i] = byte(i ^ i>>9 ^ i>>17)
+	}
+	p.Args[0], p.Args[1] = strArg(p, 0o1000, "/big"), 0o644
+	if syscreate(p); p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	fd := p.CPU.R[0]
+	ip := p.Files[fd].inode
+	for off := 0; off < len(data); off += 5000 {
+		p.writei(ip, data[off:min(off+5000, len(data))], off)
+	}
+	if p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	if ip.mode&_ILARG == 0 || *ip.iaddr(7) == 0 {
+		t.Errorf("3 MB file: mode %#o addr[7] %d, want large with a double indirect block", ip.mode, *ip.iaddr(7))
+	}
+	closefd(p, fd)
+
+	// The largest size an inode holds is one byte less than 16 MB.
+	const maxFileSize = 1<<24 - 1
+	p.Args[0] = strArg(p, 0o1000, "/edge")
+	if syscreate(p); p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	fd = p.CPU.R[0]
+	ip = p.Files[fd].inode
+	b := []byte("xy")
+	ip.mtime = [2]uint16{}
+	if n := p.writei(ip, b, maxFileSize-1); n != 0 || p.Error != EFBIG || ip.mtime != [2]uint16{} {
+		t.Errorf("write past the largest size = %d, %v, mtime %v, want 0, EFBIG, unchanged", n, p.Error, ip.mtime)
+	}
+	p.Error = 0
+	if n := p.writei(ip, b[:1], maxFileSize-1); n != 1 || p.Error != 0 || ip.size() != maxFileSize {
+		t.Errorf("write of the last byte = %d, %v, size %d, want 1, 0, %d", n, p.Error, ip.size(), maxFileSize)
+	}
+	if ip.mtime == [2]uint16{} {
+		t.Errorf("write of the last byte left mtime unset")
+	}
+	if n := p.readi(ip, b, maxFileSize-1); n != 1 || b[0] != 'x' {
+		t.Errorf("read of the last byte = %d, %q, want 1, x", n, b[:n])
+	}
+	closefd(p, fd)
+	if probs, err := p.Sys.Check(0); err != nil || len(probs) != 0 {
+		t.Errorf("Check after writing = %v, %v", probs, err)
+	}
+	if err := p.Sys.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read it back from another system with its own buffer cache.
+	p2 := rootProc(t)
+	if err := p2.Sys.MountImage(p.Sys.RamDisk(major), blocks*BSIZE); err != nil {
+		t.Fatal(err)
+	}
+	got, err := p2.Sys.ReadFile("/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("read back %d bytes, not the %d written", len(got), len(data))
+	}
+}
+
+func TestCreat(t *testing.T) {
+	p := mountRoot(t, readRoot(t))
+	free := freeBlocks(t, p)
+	p.Uid, p.Gid = 5, 6 // effective ids
+	p.RUid, p.RGid = 7, 8
+	p.Umask = 0o027
+
+	creat := func(name string, mode uint16) (uint16, Errno) {
+		p.Error = 0
+		p.Args[0], p.Args[1] = strArg(p, 0o1000, name), mode
+		syscreate(p)
+		return p.CPU.R[0], p.Error
+	}
+
+	// A new file takes the effective ids and the mode less the umask,
+	// and its descriptor is write-only.
+	fd, err := creat("/tmp/c", 0o666)
+	if err != 0 {
+		t.Fatal(err)
+	}
+	ip := p.Files[fd].inode
+	if ip.mode != _IALLOC|0o640 || ip.uid != 5 || ip.gid != 6 || ip.size() != 0 {
+		t.Errorf("new file: mode %#o uid %d gid %d size %d, want %#o 5 6 0", ip.mode, ip.uid, ip.gid, ip.size(), _IALLOC|0o640)
+	}
+	data := make([]byte, 20*BSIZE)
+	p.CPU.R[0] = fd
+	p.Args[0], p.Args[1] = 0o2000, 5
+	if p.rdwr(_FREAD); p.Error != EBADF {
+		t.Errorf("read of created file: %v, want EBADF", p.Error)
+	}
+	p.Error = 0
+	p.writei(ip, data, 0)
+	closefd(p, fd)
+	if got := freeBlocks(t, p); got != free-21 {
+		t.Errorf("free blocks = %d after writing, want %d", got, free-21)
+	}
+
+	// Creating it again truncates it, freeing its blocks,
+	// but keeps its inode, owner, and mode.
+	p.Uid, p.Gid = 0, 0
+	fd, err = creat("/tmp/c", 0o600)
+	if err != 0 {
+		t.Fatal(err)
+	}
+	if p.Files[fd].inode != ip || ip.size() != 0 || ip.mode != _IALLOC|0o640 || ip.uid != 5 || *ip.iaddr(0) != 0 {
+		t.Errorf("truncated file: mode %#o uid %d size %d addr %d", ip.mode, ip.uid, ip.size(), *ip.iaddr(0))
+	}
+	if got := freeBlocks(t, p); got != free {
+		t.Errorf("free blocks = %d after truncating, want %d", got, free)
+	}
+	closefd(p, fd)
+
+	if _, err := creat("/tmp", 0o666); err != EISDIR {
+		t.Errorf("creat of directory: %v, want EISDIR", err)
+	}
+}
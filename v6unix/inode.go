@@ -7,9 +7,17 @@ package v6unix
 import "unsafe"
 
 type inode struct {
-	count int
+	count   int
+	mounted bool // IMOUNT: a file system is mounted on this directory
+	itext   bool // ITEXT: the prototype of a running pure program
 	stat
+	disk *Disk // disk holding the inode
 	data []byte
+	host *hostFile // set under a MountHost directory
+	img  *imageFS  // set for a file on a disk image
+
+	dirgen uint32 // bumped when the entries of a directory change, for the name cache
+	text   []byte // program saved by a sticky (ISVTX) exec, until the file changes
 }
 
 type stat struct {
@@ -29,11 +37,66 @@ type stat struct {
 }
 
 func (s *stat) size() int {
-	return int(s.sizeHi<<8) | int(s.sizeLo)
+	return int(s.sizeHi)<<16 | int(s.sizeLo)
+}
+
+// dinode returns the bytes of s that are the inode on disk,
+// from mode through mtime.
+func (s *stat) dinode() []byte {
+	return unsafe.Slice((*byte)(unsafe.Pointer(&s.mode)), dinodeSize)
+}
+
+// iaddr returns a pointer to v6's i_addr[i].
+// For a special file, i_addr[0] holds the minor and major numbers.
+func (s *stat) iaddr(i int) *uint16 {
+	if i == 0 {
+		return (*uint16)(unsafe.Pointer(&s.minor))
+	}
+	return &s.addr[i-1]
+}
+
+// fileType returns the type bits of mode,
+// telling symbolic links apart from character special files.
+func fileType(mode uint16) uint16 {
+	if mode&(_IFMT|_ILARG) == _IFLNK {
+		return _IFLNK
+	}
+	return mode & _IFMT
+}
+
+// large reports whether s uses the large file algorithm.
+// A symbolic link has _ILARG set as part of its type
+// and is always small.
+func (s *stat) large() bool {
+	return s.mode&_ILARG != 0 && fileType(s.mode) != _IFLNK
+}
+
+// special reports whether s is a character or block special file,
+// with major and minor numbers selecting the device.
+func (s *stat) special() bool {
+	t := fileType(s.mode)
+	return t == _IFCHR || t == _IFBLK
+}
+
+// onImage reports whether ip's data is in the blocks of a disk image,
+// as opposed to ip.data or a host file.
+func (ip *inode) onImage() bool {
+	return ip.img != nil && ip.host == nil
+}
+
+// readonly reports whether ip is on a file system mounted read-only.
+func (ip *inode) readonly() bool {
+	if ip.host != nil {
+		return ip.host.readonly
+	}
+	return ip.img != nil && ip.img.readonly()
 }
 
 func (ip *inode) writeSize() {
-	n := len(ip.data)
+	ip.setSize(len(ip.data))
+}
+
+func (ip *inode) setSize(n int) {
 	if n >= 1<<24 {
 		n = 1<<24 - 1
 	}
@@ -69,6 +132,7 @@ const (
 	_IFDIR  uint16 = 040000  /* directory */
 	_IFCHR  uint16 = 020000  /* character special */
 	_IFBLK  uint16 = 060000  /* block special, 0 is regular */
+	_IFLNK  uint16 = 030000  /* symbolic link (not in v6): character special with _ILARG, always small */
 	_ILARG  uint16 = 010000  /* large addressing algorithm */
 	_ISUID  uint16 = 04000   /* set user id on execution */
 	_ISGID  uint16 = 02000   /* set group id on execution */
@@ -82,4 +146,6 @@ const (
 	_FREAD int = 1 << iota
 	_FWRITE
 	_FPIPE
+	_FCOOKED /* directory read without unused entries (not in v6) */
+	_FNDELAY /* reads do not wait for input (not in v6) */
 )
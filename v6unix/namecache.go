@@ -0,0 +1,69 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// The name cache is not in v6; the code is new.
+// It remembers the inode numbers of names namei has found,
+// keyed by the directory inode and a generation count
+// that changes whenever the directory's entries do,
+// so that a changed directory's old names are never seen
+// again and simply age out of the cache.
+
+package v6unix
+
+import "container/list"
+
+// nameCacheSize is the number of names the cache holds.
+const nameCacheSize = 512
+
+type nameCache struct {
+	lru    list.List // of *nameEntry, most recently used first
+	m      map[nameKey]*list.Element
+	hits   uint64
+	misses uint64
+}
+
+type nameKey struct {
+	dp   *inode
+	gen  uint32 // dp.dirgen when the name was entered
+	name string
+}
+
+type nameEntry struct {
+	key  nameKey
+	inum uint16
+}
+
+// NameCacheStats returns the number of path elements
+// the name cache has resolved (hits) and the number
+// that had to be looked up in their directory (misses).
+func (sys *System) NameCacheStats() (hits, misses uint64) {
+	return sys.names.hits, sys.names.misses
+}
+
+// lookup returns the cached inode number for name in dp.
+func (c *nameCache) lookup(dp *inode, name string) (uint16, bool) {
+	e, ok := c.m[nameKey{dp, dp.dirgen, name}]
+	if !ok {
+		c.misses++
+		return 0, false
+	}
+	c.hits++
+	c.lru.MoveToFront(e)
+	return e.Value.(*nameEntry).inum, true
+}
+
+// enter records that name in dp is inode inum,
+// making room by forgetting the least recently used name.
+func (c *nameCache) enter(dp *inode, name string, inum uint16) {
+	if c.m == nil {
+		c.m = make(map[nameKey]*list.Element)
+	}
+	key := nameKey{dp, dp.dirgen, name}
+	if c.lru.Len() >= nameCacheSize {
+		e := c.lru.Back()
+		delete(c.m, e.Value.(*nameEntry).key)
+		c.lru.Remove(e)
+	}
+	c.m[key] = c.lru.PushFront(&nameEntry{key, inum})
+}
@@ -16,12 +16,25 @@ const (
 	nameFind   = 0
 	nameCreate = 1
 	nameDelete = 2
+
+	// nameNoFollow, or'ed into one of the others,
+	// says not to follow a symbolic link in the final element.
+	// (A link in the final element is never followed for nameDelete.)
+	nameNoFollow = 4
 )
 
+// maxSymlinks is the number of symbolic links namei follows
+// in one lookup before giving up with ELOOP.
+const maxSymlinks = 8
+
 func (p *Proc) namei(name string, op int) (ip, dp *inode, off int) {
+	follow := op&nameNoFollow == 0 && op != nameDelete
+	op &^= nameNoFollow
+	links := 0
+
 	d := p.Sys.Disk
 	if name != "" && name[0] == '/' {
-		dp = d.inodes[1]
+		dp = p.rootdir()
 	} else {
 		dp = p.Dir
 	}
@@ -43,13 +56,49 @@ func (p *Proc) namei(name string, op int) (ip, dp *inode, off int) {
 		}
 		elem, rest := nextElem(name)
 		if elem == "" {
-			panic("namei")
+			// Followed a symbolic link to a directory, like "/".
+			return dp, nil, 0
 		}
 
-		inum, off := dsearch(dp.data, elem)
+		/*
+		 * A changed root acts as its own parent.
+		 */
+		if elem == ".." && dp == p.Root {
+			elem = "."
+		}
+
+		/*
+		 * At the root of a mounted file system,
+		 * .. is looked up in the directory
+		 * it is mounted on (as in v7).
+		 */
+		if elem == ".." && dp.inum == ROOTINO && dp.disk != d {
+			if m := p.Sys.mountOf(dp.disk); m != nil {
+				p.iput(dp)
+				dp = m.inodp
+				dp.count++
+			}
+		}
+
+		/*
+		 * Look in the name cache, except for a name
+		 * being deleted, whose offset is needed.
+		 */
+		var inum uint16
+		var off int
+		hit := false
+		if rest != "" || op != nameDelete {
+			inum, hit = p.Sys.names.lookup(dp, elem)
+		}
+		if !hit {
+			inum, off = dsearch(p.contents(dp), elem)
+			if inum != 0 {
+				p.Sys.names.enter(dp, elem, inum)
+			}
+		}
 		if inum == 0 {
 			if rest == "" && op == nameCreate && p.access(dp, _IWRITE) {
-				dp.mtime = now()
+				dp.mtime = p.Sys.now()
 				return nil, dp, off
 			}
 			if p.Error == 0 {
@@ -58,7 +107,7 @@ func (p *Proc) namei(name string, op int) (ip, dp *inode, off int) {
 			p.iput(dp)
 			return nil, nil, 0
 		}
-		ip := p.iget(inum)
+		ip := p.igetDisk(dp.disk, inum)
 		if rest == "" && op == nameDelete {
 			if !p.access(dp, _IWRITE) {
 				p.iput(ip)
@@ -67,10 +116,39 @@ func (p *Proc) namei(name string, op int) (ip, dp *inode, off int) {
 			}
 			return ip, dp, off
 		}
-		p.iput(dp)
 		if ip == nil {
+			p.iput(dp)
 			return nil, nil, 0
 		}
+		if ip.mode&(_IFMT|_ILARG) == _IFLNK && (rest != "" || follow) {
+			/*
+			 * Symbolic link: continue the walk
+			 * with the link text in place of elem.
+			 */
+			target := string(p.contents(ip))
+			p.iput(ip)
+			if links++; links > maxSymlinks {
+				p.Error = ELOOP
+				p.iput(dp)
+				return nil, nil, 0
+			}
+			if target == "" {
+				p.Error = ENOENT
+				p.iput(dp)
+				return nil, nil, 0
+			}
+			if target[0] == '/' {
+				p.iput(dp)
+				dp = p.rootdir()
+				dp.count++
+			}
+			name = target
+			if rest != "" {
+				name += "/" + rest
+			}
+			continue
+		}
+		p.iput(dp)
 		if rest == "" {
 			return ip, nil, 0
 		}
@@ -79,6 +157,14 @@ func (p *Proc) namei(name string, op int) (ip, dp *inode, off int) {
 	}
 }
 
+// rootdir returns the directory absolute names start from.
+func (p *Proc) rootdir() *inode {
+	if p.Root != nil {
+		return p.Root
+	}
+	return p.Sys.Disk.inodes[ROOTINO]
+}
+
 func dsearch(data []byte, elem string) (inum uint16, off int) {
 	slot := len(data)
 	for i := 0; i < len(data); i += int(direntSize) {
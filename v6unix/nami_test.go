@@ -0,0 +1,191 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package v6unix
+
+import "testing"
+
+// rootProc returns a super-user process in a new system,
+// in the root directory.
+func rootProc(t testing.TB) *Proc {
+	t.Helper()
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Proc{Sys: sys}
+	p.Dir = p.iget(ROOTINO)
+	return p
+}
+
+// runningProc returns a root process with process id pid,
+// the only process in its system, set up as the running
+// process so that it can fork and wait for children.
+func runningProc(t testing.TB, pid int16) *Proc {
+	t.Helper()
+	p := rootProc(t)
+	p.sched = make(chan bool)
+	p.status = _SRUN
+	p.CPU.Mem = &p.Mem
+	p.Pid = pid
+	p.Sys.NextPid = pid + 1
+	p.Sys.Procs = []*Proc{p}
+	return p
+}
+
+// strArg copies s into p's memory at addr as a C string and returns addr.
+func strArg(p *Proc, addr uint16, s string) uint16 {
+	copy(p.umem()[addr:], s+"\x00")
+	return addr
+}
+
+func symlink(p *Proc, target, name string) Errno {
+	p.Error = 0
+	p.Args[0] = strArg(p, 0o1000, target)
+	p.Args[1] = strArg(p, 0o1200, name)
+	syssymlink(p)
+	return p.Error
+}
+
+// lookup returns the inode number name resolves to, or the error.
+func lookup(p *Proc, name string) (uint16, Errno) {
+	p.Error = 0
+	ip, _, _ := p.namei(name, nameFind)
+	if ip == nil {
+		return 0, p.Error
+	}
+	p.iput(ip)
+	return ip.inum, 0
+}
+
+func TestSymlink(t *testing.T) {
+	p := rootProc(t)
+	ls, err := lookup(p, "/bin/ls")
+	if err != 0 {
+		t.Fatal(err)
+	}
+	root, _ := lookup(p, "/")
+
+	for _, l := range []struct{ target, name string }{
+		{"/bin", "/xbin"},
+		{"ls", "/bin/list"},
+		{"../bin/ls", "/tmp/ls"},
+		{"/", "/tmp/root"},
+		{"/nonexistent", "/tmp/dangle"},
+		{"/tmp/loop2", "/tmp/loop1"},
+		{"loop1", "/tmp/loop2"},
+		{"/tmp/ls", "/tmp/ls2"},
+	} {
+		if err := symlink(p, l.target, l.name); err != 0 {
+			t.Fatalf("symlink(%q, %q): %v", l.target, l.name, err)
+		}
+	}
+	if err := symlink(p, "x", "/tmp/ls"); err != EEXIST {
+		t.Errorf("symlink over existing name: %v, want EEXIST", err)
+	}
+
+	tests := []struct {
+		name string
+		inum uint16
+		err  Errno
+	}{
+		{"/xbin/ls", ls, 0},
+		{"/bin/list", ls, 0},
+		{"/tmp/ls", ls, 0},
+		{"/tmp/ls2", ls, 0},
+		{"/tmp/root", root, 0},
+		{"/tmp/root/bin/ls", ls, 0},
+		{"/tmp/dangle", 0, ENOENT},
+		{"/tmp/loop1", 0, ELOOP},
+		{"/tmp/loop1/x", 0, ELOOP},
+	}
+	for _, tt := range tests {
+		if inum, err := lookup(p, tt.name); inum != tt.inum || err != tt.err {
+			t.Errorf("lookup %s = %d, %v, want %d, %v", tt.name, inum, err, tt.inum, tt.err)
+		}
+	}
+
+	// readlink
+	p.Error = 0
+	p.Args[0] = strArg(p, 0o1000, "/tmp/dangle")
+	p.Args[1] = 0o2000
+	p.Args[2] = 100
+	sysreadlink(p)
+	if n := p.CPU.R[0]; p.Error != 0 || string(p.Mem[0o2000:0o2000+n]) != "/nonexistent" {
+		t.Errorf("readlink = %q, %v, want %q", p.Mem[0o2000:0o2000+n], p.Error, "/nonexistent")
+	}
+	p.Args[0] = strArg(p, 0o1000, "/bin/ls")
+	sysreadlink(p)
+	if p.Error != EINVAL {
+		t.Errorf("readlink of regular file: %v, want EINVAL", p.Error)
+	}
+
+	// unlink removes the link, not its target.
+	p.Error = 0
+	p.Args[0] = strArg(p, 0o1000, "/bin/list")
+	sysunlink(p)
+	if _, err := lookup(p, "/bin/list"); err != ENOENT {
+		t.Errorf("after unlink, lookup /bin/list: %v, want ENOENT", err)
+	}
+	if _, err := lookup(p, "/bin/ls"); err != 0 {
+		t.Errorf("after unlink of link, lookup /bin/ls: %v", err)
+	}
+}
+
+func TestNameCache(t *testing.T) {
+	p := rootProc(t)
+	ls, _ := lookup(p, "/bin/ls")
+	hits, misses := p.Sys.NameCacheStats()
+	if inum, err := lookup(p, "/bin/ls"); err != 0 || inum != ls {
+		t.Fatalf("second lookup /bin/ls = %d, %v, want %d", inum, err, ls)
+	}
+	if h, m := p.Sys.NameCacheStats(); h != hits+2 || m != misses {
+		t.Errorf("second lookup: hits %d misses %d, want %d %d", h, m, hits+2, misses)
+	}
+
+	// Creating, removing and replacing names
+	// is seen by later lookups.
+	create := func(name string) uint16 {
+		p.Error = 0
+		p.Args[0], p.Args[1] = strArg(p, 0o1000, name), 0o666
+		if syscreate(p); p.Error != 0 {
+			t.Fatal(p.Error)
+		}
+		fd := p.CPU.R[0]
+		inum := p.Files[fd].inode.inum
+		closefd(p, fd)
+		return inum
+	}
+	if _, err := lookup(p, "/tmp/x"); err != ENOENT {
+		t.Fatalf("lookup /tmp/x = %v, want ENOENT", err)
+	}
+	x := create("/tmp/x")
+	if inum, err := lookup(p, "/tmp/x"); err != 0 || inum != x {
+		t.Errorf("lookup after create = %d, %v, want %d", inum, err, x)
+	}
+	if p.unlink("/tmp/x"); p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	if _, err := lookup(p, "/tmp/x"); err != ENOENT {
+		t.Errorf("lookup after unlink = %v, want ENOENT", err)
+	}
+	create("/tmp/y") // may take x's old inode number
+	x = create("/tmp/x")
+	if inum, err := lookup(p, "/tmp/x"); err != 0 || inum != x {
+		t.Errorf("lookup after re-create = %d, %v, want %d", inum, err, x)
+	}
+
+	// Cached names still stop at a changed root.
+	tmp, _ := lookup(p, "/tmp")
+	p.Args[0] = strArg(p, 0o1000, "/tmp")
+	if syschroot(p); p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	if inum, err := lookup(p, "/.."); err != 0 || inum != tmp {
+		t.Errorf("lookup /.. in chroot = %d, %v, want %d", inum, err, tmp)
+	}
+	if inum, err := lookup(p, "/x"); err != 0 || inum != x {
+		t.Errorf("lookup /x in chroot = %d, %v, want %d", inum, err, x)
+	}
+}
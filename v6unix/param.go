@@ -16,9 +16,10 @@ package v6unix
 const (
 	NBUF = 15 /* size of buffer cache */
 	// NINODE  = 100       /* number of in core inodes */
-	// NFILE   = 100       /* number of in core file structures */
-	// NMOUNT  = 5         /* number of mountable file systems */
+	NFILE  = 100 /* number of in core file structures; see System.MaxOpenFiles */
+	NMOUNT = 5   /* number of mountable file systems */
 	// NEXEC   = 3         /* number of simultaneous exec's */
+	NCARGS  = 510       /* max bytes of exec arguments (v7 name) */
 	MAXMEM  = (64 * 32) /* max core per process - first # is Kw */
 	SSIZE   = 20        /* initial stack size (*64 bytes) */
 	SINCR   = 20        /* increment of stack (*64 bytes) */
@@ -67,6 +68,14 @@ const (
 	SIGSEG  = 11 /* segmentation violation */
 	SIGSYS  = 12 /* sys */
 	SIGPIPE = 13 /* end of pipe */
+	SIGALRM = 14 /* alarm clock (v7) */
+
+	/* job control (not in v6) */
+	SIGSTOP = 16 /* stop; cannot be caught or ignored */
+	SIGCONT = 17 /* continue after a stop; ignored by default */
+	SIGTTIN = 18 /* stop for reading the tty in the background */
+
+	SIGWINCH = 19 /* window size change (not in v6); ignored by default */
 )
 
 /*
@@ -98,6 +107,10 @@ const (
 	_STRC  uint8 = 020 /* process is being traced */
 	_SWTED uint8 = 040 /* another tracing flag */
 
+	_SVFORK uint8 = 0100 /* child of vfork, using the parent's memory (4BSD) */
+
+	_TBIT = 020 /* PS trace bit */
+
 	/* priorities */
 	_PSWP   int8 = -100
 	_PINOD  int8 = -90
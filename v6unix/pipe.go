@@ -8,21 +8,25 @@
 // Use of this source code is governed by a 4-clause BSD-style
 // license that can be found in the LICENSE file.
 
-// TODO: The handling of signals and sleeps might not be right.
-
 package v6unix
 
-import "sync"
+/*
+ * The pipe is a ring buffer of PIPSIZ bytes.
+ * Readers sleep on &pip.n waiting for data;
+ * writers sleep on &pip.buf waiting for space.
+ * The other end is gone when the inode has
+ * fewer than two file references.
+ */
+const PIPSIZ = 4096
 
 type pipe struct {
-	read  sync.Cond
-	write sync.Cond
-	n     int
-	buf   [4096]byte
+	buf [PIPSIZ]byte
+	r   int /* index in buf of first unread byte */
+	n   int /* number of unread bytes */
 }
 
 func syspipe(p *Proc) {
-	ip := p.ialloc()
+	ip := p.ialloc(p.Sys.Disk)
 	if ip == nil {
 		return
 	}
@@ -35,6 +39,7 @@ func syspipe(p *Proc) {
 	wf := p.falloc()
 	if wf == nil {
 		p.Files[r] = nil
+		p.Sys.nfile--
 		p.iput(ip)
 		return
 	}
@@ -42,8 +47,6 @@ func syspipe(p *Proc) {
 	p.CPU.R[0] = r
 
 	pip := new(pipe)
-	pip.read.L = &p.Sys.Big
-	pip.write.L = &p.Sys.Big
 
 	wf.flag = _FWRITE | _FPIPE
 	wf.inode = ip
@@ -54,45 +57,109 @@ func syspipe(p *Proc) {
 	rf.pipe = pip
 
 	ip.count = 2
-	ip.atime = now()
+	ip.atime = p.Sys.now()
 	ip.mtime = ip.atime
 	ip.mode = _IALLOC
 }
 
+/*
+ * Read call directed to a pipe.
+ */
 func (p *Proc) readp(f *File, b []byte) int {
-	for f.pipe.n == 0 && f.inode.count >= 2 {
-		f.pipe.read.Wait()
+	pip := f.pipe
+	if len(b) == 0 {
+		return 0
 	}
-	n := copy(b, f.pipe.buf[:f.pipe.n])
-	copy(f.pipe.buf[:0], f.pipe.buf[n:f.pipe.n])
-	f.pipe.n -= n
-	f.offset += n
-	f.pipe.write.Broadcast()
-	return n
+
+	/*
+	 * If nothing in the pipe, wait
+	 * unless the writer is gone (EOF).
+	 */
+	for pip.n == 0 {
+		if f.inode.count < 2 {
+			return 0
+		}
+		p.sleep(&pip.n, 'p', PPIPE)
+	}
+
+	total := 0
+	for len(b) > 0 && pip.n > 0 {
+		end := min(pip.r+pip.n, len(pip.buf))
+		n := copy(b, pip.buf[pip.r:end])
+		pip.r = (pip.r + n) % len(pip.buf)
+		pip.n -= n
+		b = b[n:]
+		total += n
+	}
+	if pip.n == 0 {
+		pip.r = 0
+	}
+	p.Sys.wakeup(&pip.buf)
+	return total
 }
 
+/*
+ * Write call directed to a pipe.
+ * Blocks until all of b is written.
+ */
 func (p *Proc) writep(f *File, b []byte) int {
+	pip := f.pipe
 	total := 0
 	for len(b) > 0 {
-		for f.pipe.n == len(f.pipe.buf) && f.inode.count >= 2 {
-			f.pipe.write.Wait()
-		}
+		/*
+		 * If there are not both read and
+		 * write sides of the pipe active,
+		 * return error and signal too.
+		 */
 		if f.inode.count < 2 {
 			p.Error = EPIPE
-			// psignal(p, SIGPIPE)
-			return 0
+			p.Sys.psignal(p, SIGPIPE)
+			return total
 		}
-		n := copy(f.pipe.buf[f.pipe.n:], b)
-		f.pipe.n += n
-		total += n
+
+		/*
+		 * If the pipe is full,
+		 * wait for a reader to make room.
+		 */
+		if pip.n == len(pip.buf) {
+			p.sleep(&pip.buf, 'p', PPIPE)
+			continue
+		}
+
+		w := (pip.r + pip.n) % len(pip.buf)
+		end := len(pip.buf)
+		if w < pip.r {
+			end = pip.r
+		}
+		n := copy(pip.buf[w:end], b)
+		pip.n += n
 		b = b[n:]
-		f.offset += n
-		f.pipe.read.Broadcast()
+		total += n
+		p.Sys.wakeup(&pip.n)
 	}
 	return total
 }
 
+/*
+ * Report whether a read or write of the pipe f
+ * would not block, for select: there is something
+ * to read or room to write, or the other side is gone.
+ */
+func (f *File) pipeReady(rw int) bool {
+	if f.inode.count < 2 {
+		return true
+	}
+	if rw == _FREAD {
+		return f.pipe.n > 0
+	}
+	return f.pipe.n < len(f.pipe.buf)
+}
+
+/*
+ * Wake up anyone waiting on the pipe,
+ * so that they notice when the other side closes.
+ */
 func (p *Proc) closep(f *File) {
-	f.pipe.read.Broadcast()
-	f.pipe.write.Broadcast()
+	p.Sys.wakeup(&f.pipe.n)
+	p.Sys.wakeup(&f.pipe.buf)
 }
@@ -0,0 +1,115 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package v6unix
+
+import (
+	"bytes"
+	"testing"
+)
+
+// newPipe makes a pipe in a new process and returns the process
+// and the read and write file descriptors.
+func newPipe(t *testing.T) (p *Proc, r, w uint16) {
+	t.Helper()
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p = &Proc{Sys: sys, sched: make(chan bool)}
+	p.status = _SRUN
+	sys.Procs = []*Proc{p}
+	syspipe(p)
+	if p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	return p, p.CPU.R[0], p.CPU.R[1]
+}
+
+func closefd(p *Proc, fd uint16) {
+	p.CPU.R[0] = fd
+	sysclose(p)
+}
+
+func TestPipe(t *testing.T) {
+	p, r, w := newPipe(t)
+	if r == w {
+		t.Fatalf("pipe returned %d, %d", r, w)
+	}
+	rf, wf := p.Files[r], p.Files[w]
+
+	// Keep the pipe partly full so the data wraps around the ring.
+	var want, got []byte
+	b := make([]byte, 2000)
+	for i := 0; i < 5; i++ {
+		chunk := bytes.Repeat([]byte{'a' + byte(i)}, 1500)
+		if n := p.writep(wf, chunk); n != len(chunk) {
+			t.Fatalf("writep = %d, want %d", n, len(chunk))
+		}
+		want = append(want, chunk...)
+		n := p.readp(rf, b[:1000])
+		got = append(got, b[:n]...)
+	}
+	for len(got) < len(want) {
+		n := p.readp(rf, b)
+		got = append(got, b[:n]...)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("read back %d bytes, not what was written", len(got))
+	}
+
+	// Closing a dup of the write end does not make EOF.
+	p.CPU.R[0] = w
+	sysdup(p)
+	w2 := p.CPU.R[0]
+	closefd(p, w)
+	p.writep(p.Files[w2], []byte("x"))
+	if n := p.readp(rf, b); string(b[:n]) != "x" {
+		t.Errorf("read %q, want %q", b[:n], "x")
+	}
+
+	closefd(p, w2)
+	if n := p.readp(rf, b); n != 0 || p.Error != 0 {
+		t.Errorf("read after writers closed = %d, %v, want EOF", n, p.Error)
+	}
+}
+
+func TestPipeEPIPE(t *testing.T) {
+	p, r, w := newPipe(t)
+	closefd(p, r)
+	if n := p.writep(p.Files[w], []byte("hello")); n != 0 || p.Error != EPIPE {
+		t.Errorf("write with no reader = %d, %v, want 0, EPIPE", n, p.Error)
+	}
+	if p.sig != SIGPIPE {
+		t.Errorf("sig = %d, want SIGPIPE", p.sig)
+	}
+}
+
+func TestPipeBlock(t *testing.T) {
+	p, r, w := newPipe(t)
+
+	// A second process reads the empty pipe and blocks.
+	reader := &Proc{Sys: p.Sys, sched: make(chan bool)}
+	reader.status = _SRUN
+	p.Sys.Procs = append(p.Sys.Procs, reader)
+	p.status = _SWAIT // not competing for the CPU
+	done := make(chan string)
+	go func() {
+		b := make([]byte, 10)
+		n := reader.readp(p.Files[r], b)
+		done <- string(b[:n])
+	}()
+	<-p.Sys.idle
+
+	p.status = _SRUN
+	p.writep(p.Files[w], []byte("wake"))
+	if reader.status != _SRUN {
+		t.Fatalf("writer did not wake reader")
+	}
+	p.status = _SWAIT
+	reader.sched <- true
+	if s := <-done; s != "wake" {
+		t.Errorf("blocked read = %q, want %q", s, "wake")
+	}
+}
@@ -28,11 +28,14 @@ package v6unix
 import (
 	"bytes"
 	_ "embed"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math/rand"
 	"os"
 	"runtime"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -56,7 +59,7 @@ type Proc struct {
 	CPU pdp11.CPU // cpu state
 
 	// プロセスメモリ
-	Mem pdp11.ArrayMem // process memory
+	Mem pdp11.ArrayMem // process memory, except pages still shared with the parent after fork (see cow.go)
 
 	// システムコールの引数
 	Args [4]uint16 // syscall args
@@ -64,6 +67,9 @@ type Proc struct {
 	// システムコールのエラー
 	Error Errno // syscall error
 
+	// 読み書き中のファイルのフラグ
+	fflag int // flags of the open file being read or written, for device drivers
+
 	// 実効グループID
 	Gid int8 // effective group id
 
@@ -73,15 +79,35 @@ type Proc struct {
 	// 実グループID
 	RGid int8 // real group id
 
+	// 保存ユーザID
+	SUid int8 // saved user id, set by exec
+
+	// 保存グループID
+	SGid int8 // saved group id, set by exec
+
+	// ファイル作成マスク
+	Umask uint16 // mode bits cleared in new files (v7)
+
 	// 保留中のシグナル
+	//
+	// Deprecated: Sig is always 0. The pending signal is unexported.
 	Sig int8 // pending signal
 
+	// プロセスグループ
+	Pgrp int16 // process group, for job control (not in v6); 0 if none
+
 	// ディレクトリ
 	Dir *inode // directory
 
+	// ルートディレクトリ
+	Root *inode // root directory, set by chroot; nil means the file system root
+
 	// ファイルディスクリプたテーブル
 	Files [NOFILE]*File // fd table
 
+	// ディスクリプタフラグ
+	fdflag [NOFILE]uint8 // descriptor flags, such as FD_CLOEXEC (4.2BSD)
+
 	// シグナルハンドラ
 	Signals [NSIG]uint16 // signal handlers
 
@@ -90,6 +116,9 @@ type Proc struct {
 	// 時間情報
 	Times
 	// 優先度
+	//
+	// Deprecated: Nice is a copy of the nice value the scheduler uses,
+	// which is unexported; setting it has no effect.
 	Nice int16
 	// テキストサイズ
 	TextSize uint16
@@ -97,10 +126,22 @@ type Proc struct {
 	DataStart uint16
 	// データサイズ
 	DataSize uint16
+	// 共有テキスト
+	text *text // shared text of a pure program, or nil
+	// vforkで借りたメモリ
+	vmem *pdp11.ArrayMem // parent's memory, borrowed by vfork (4BSD), or nil
+	// コピーオンライトで共有するメモリ
+	cowsrc   *Proc   // parent whose memory p shares copy-on-write (not in v6), or nil
+	cowpages uint64  // pages of cowsrc's memory p still shares, a bit per cowPage bytes
+	cowkids  []*Proc // children sharing p's memory copy-on-write
 	//
 	wkey any
 	// スケジューリング情報を表すブール型のチャネル
 	sched chan bool
+	// アラームの時刻
+	clktim time.Time // when to send SIGALRM, if not zero (v7)
+	// システムコール中
+	insys bool // in a system call, so clock ticks count as system time
 	// 端末情報
 	TTY *TTY
 }
@@ -131,13 +172,131 @@ type System struct {
 	NextPid  int16
 	curpri   int8
 	runrun   int8
+	lbolt    time.Time // time of the last clock tick
+	ticks    int       // clock ticks since the last second
 	swtchpos int
 	Timer    time.Time
 	TTYRead  uint16     // 1<<X bit means ttyX has a pending read
 	TTY      [1 + 8]TTY // TTY[1]..TTY[8] is /dev/tty1..tty8
+	ttys     []*TTY     // ttys[N] is /dev/ttyN: &TTY[0]..&TTY[8], then those from AddTTY
+	ptys     []*PTY     // ptys[N] is /dev/ptyN
+
+	idle     chan bool
+	ttyReady chan struct{} // input for typeInput
+	consIn   chan<- byte   // set by ConsoleIn
+	consOut  <-chan byte   // set by ConsoleOut
+
+	rec         io.Writer  // set by RecordConsole
+	recStart    time.Time  // system time when recording began
+	replay      []recEvent // input from ReplayConsole not yet typed
+	replayStart time.Time  // when ReplayConsole began
+
+	Trace    bool
+	trace    TraceFunc        // set by SetTrace
+	sysTrace SyscallTraceFunc // set by SetSyscallTrace
+
+	stopped     chan *Proc      // set while Step, Run or Continue waits for a process to stop
+	stepping    *Proc           // process to stop after one instruction, for Step
+	breakpc     int             // pc at which to stop, for Run, or -1
+	breakpoints map[uint16]bool // set by SetBreakpoint
+	budgeted    bool            // set while RunN runs
+	budget      uint64          // instructions RunN has left to run
+
+	// RealtimeTTY makes tty output take as long as it would
+	// at the line speed set by stty, instead of no time at all.
+	RealtimeTTY bool
+
+	// NoATime stops reads from updating the access times of files.
+	// Modification times are kept up to date regardless.
+	NoATime bool
+
+	// Deterministic makes the scheduling of processes depend only
+	// on what they do, not on how fast the host runs them,
+	// so that the same inputs give the same interleaving every time.
+	// A process runs until it blocks in a system call or has run
+	// for its quantum of Quantum emulated instructions
+	// (DefaultQuantum if Quantum is zero), and then the next
+	// runnable process in the table takes its turn.
+	// The clock ticks every InstPerTick instructions
+	// (DefaultInstPerTick if InstPerTick is zero), and the
+	// system's time, which alarms and sleep follow, is only
+	// the ticks, skipping ahead to the next alarm or sleeper
+	// when every process is asleep.
+	// Terminal input still arrives when the host sends it.
+	Deterministic bool
+	Quantum       int
+	InstPerTick   int
+	slice         int // instructions left in the current quantum
+	insts         int // instructions since the last tick
+
+	// ClockHz is the line frequency of the clock,
+	// the number of times it ticks per second,
+	// or HZ if it is zero.
+	ClockHz int
+	elapsed time.Duration // time added to the host's by Tick; all of it in deterministic mode
+
+	// EnableProfiling makes system calls record their counts
+	// and durations, as reported by SyscallProfile.
+	EnableProfiling bool
+	prof            [64]ProfileStat // indexed by system call number
+
+	// EnableCoreDumps makes a process killed by a signal such as
+	// SIGQIT or SIGSEG write its image to the file "core" in its
+	// current directory, as v6 always did, and report the dump
+	// in its wait status.
+	EnableCoreDumps bool
+
+	// CoreLimit, if positive, is the largest core file to write,
+	// in bytes. A larger image is cut short.
+	CoreLimit int
+
+	// EnableSelect enables the select system call (4.2BSD),
+	// which waits for any of a set of files to be ready to read
+	// or write. Without it, select fails as an unknown system call.
+	EnableSelect bool
+	selwait      int // sleep channel for select
 
-	idle  chan bool
-	Trace bool
+	// EnableNDelay lets open take the ONDELAY flag, which makes
+	// a read of a terminal with no input waiting fail with EAGAIN
+	// instead of sleeping. Without it, ONDELAY is ignored.
+	// Opening a terminal never waits for carrier in any case.
+	EnableNDelay bool
+
+	// EnableFcntl enables the fcntl system call (4.2BSD),
+	// which duplicates descriptors, marks them to be closed on exec,
+	// and gets and sets the ONDELAY and OCOOKED flags of open files.
+	// Without it, fcntl fails as an unknown system call.
+	EnableFcntl bool
+
+	// MaxOpenFiles is the size of the system's open file table,
+	// shared by all processes, or NFILE if it is zero.
+	// Opening a file while the table is full fails with ENFILE;
+	// each process can also have at most NOFILE files open,
+	// past which opening one fails with EMFILE.
+	MaxOpenFiles int
+	nfile        int // entries in use in the open file table
+
+	timeBase int64     // system time, in seconds since 1970, at timeSet
+	timeSet  time.Time // when SetTime was called
+
+	names nameCache // inode numbers of names looked up by namei
+
+	ipc ipc // ptrace request from a parent to its traced child
+
+	texts [NTEXT]text // text table
+
+	procGen    uint64      // bumped when the process table changes
+	procTab    []procState // /dev/kmem process table, allocated as of procTabGen
+	procTabGen uint64
+
+	devtab []device // device switch, indexed by major number
+	mounts []*mount // mount table, for the mount system call
+
+	LPTrailer []byte       // written to /dev/lp on close
+	lpout     bytes.Buffer // /dev/lp output
+
+	randMu sync.Mutex
+	rand   *rand.Rand // source for /dev/random
 }
 
 func (s *System) lookpid(pid int16) *Proc {
@@ -182,14 +341,27 @@ func (ps ProcState) String() string {
 	return fmt.Sprintf("ProcState(%d)", ps)
 }
 
+// umem returns the memory p runs in: p.Mem,
+// or its parent's while p is the child of a vfork.
+// Pages p shares copy-on-write are made its own first.
+func (p *Proc) umem() *pdp11.ArrayMem {
+	if p.vmem != nil {
+		return p.vmem
+	}
+	p.unshare(0, 1<<16)
+	return &p.Mem
+}
+
 func (p *Proc) str(addr uint16) string {
-	b := p.Mem[addr:]
-	b, _, ok := bytes.Cut(b, []byte("\x00"))
-	if !ok {
-		p.Error = EFAULT
-		return ""
+	var s []byte
+	for a := int(addr); a < 1<<16; a += len(p.page(uint16(a))) {
+		b, _, ok := bytes.Cut(p.page(uint16(a)), []byte("\x00"))
+		if s = append(s, b...); ok {
+			return string(s)
+		}
 	}
-	return string(b)
+	p.Error = EFAULT
+	return ""
 }
 
 func (p *Proc) mem(addr, count uint16) []byte {
@@ -197,7 +369,118 @@ func (p *Proc) mem(addr, count uint16) []byte {
 		p.Error = EFAULT
 		return nil
 	}
-	return p.Mem[addr : addr+count]
+	return p.umemRange(int(addr), int(count))
+}
+
+// ReadMem returns a copy of the n bytes of p's memory at addr.
+// It returns EFAULT if any of them is outside p's address space,
+// which is made of the 8K pages the segmentation registers would map:
+// those holding the text and data, from address 0 up to the break,
+// and those holding the stack, from the stack pointer up to the top.
+func (p *Proc) ReadMem(addr uint16, n int) ([]byte, error) {
+	if !p.mapped(addr, n) {
+		return nil, EFAULT
+	}
+	return bytes.Clone(p.umemRange(int(addr), n)), nil
+}
+
+// WriteMem copies data into p's memory at addr.
+// It returns EFAULT, writing nothing, if data does not fit
+// in p's address space, as described for ReadMem.
+// Unlike the process itself, WriteMem can write the text segment,
+// as a debugger setting a breakpoint instruction needs to.
+func (p *Proc) WriteMem(addr uint16, data []byte) error {
+	if !p.mapped(addr, len(data)) {
+		return EFAULT
+	}
+	copy(p.umemRange(int(addr), len(data)), data)
+	return nil
+}
+
+// ReadText returns a copy of the n bytes of p's instruction space at addr.
+// For a split I&D (0411) program, that is the text, in a 64K space of its own,
+// and ReadText returns EFAULT if any of the bytes is past the text's last page.
+// Otherwise instructions and data share one space, and ReadText is ReadMem.
+func (p *Proc) ReadText(addr uint16, n int) ([]byte, error) {
+	imem := p.imem()
+	if imem == nil {
+		return p.ReadMem(addr, n)
+	}
+	if !p.imapped(addr, n) {
+		return nil, EFAULT
+	}
+	return bytes.Clone(imem[addr : int(addr)+n]), nil
+}
+
+// WriteText copies data into p's instruction space at addr,
+// as described for ReadText.
+// The text of a split I&D program may be shared with other processes
+// running the same program; WriteText gives p a private copy first.
+func (p *Proc) WriteText(addr uint16, data []byte) error {
+	if p.imem() == nil {
+		return p.WriteMem(addr, data)
+	}
+	if !p.imapped(addr, len(data)) {
+		return EFAULT
+	}
+	copy(p.itextw()[addr:], data)
+	return nil
+}
+
+// Registers returns p's general registers r0 through r7.
+// Index pdp11.SP (6) is the stack pointer and pdp11.PC (7)
+// the program counter.
+func (p *Proc) Registers() [8]uint16 {
+	return p.CPU.R
+}
+
+// SetRegister sets register n of p to v,
+// with n numbered as in Registers.
+// It returns EINVAL, changing nothing, if n is not between 0 and 7.
+func (p *Proc) SetRegister(n int, v uint16) error {
+	if n < 0 || n >= len(p.CPU.R) {
+		return EINVAL
+	}
+	p.CPU.R[n] = v
+	return nil
+}
+
+// PS returns p's processor status word,
+// which holds the condition codes.
+func (p *Proc) PS() pdp11.PS {
+	return p.CPU.PS
+}
+
+// SetPS sets p's processor status word.
+func (p *Proc) SetPS(ps pdp11.PS) {
+	p.CPU.PS = ps
+}
+
+/*
+ * Report whether the n bytes at addr
+ * are all in mapped pages, as copyin
+ * and copyout would find.
+ */
+func (p *Proc) mapped(addr uint16, n int) bool {
+	const page = 8192
+	end := int(addr) + n
+	if n < 0 || end > 1<<16 {
+		return false
+	}
+	data := (p.brk() + page - 1) &^ (page - 1)
+	stack := 1 << 16 // no stack yet
+	if sp := p.CPU.R[pdp11.SP]; sp != 0 {
+		stack = int(sp) &^ (page - 1)
+	}
+	return n == 0 || end <= data || int(addr) >= stack || data >= stack
+}
+
+// imapped reports whether the n bytes at addr are in
+// the pages holding the text of a split I&D program.
+func (p *Proc) imapped(addr uint16, n int) bool {
+	const page = 8192
+	end := int(addr) + n
+	return n >= 0 && end <= (int(p.TextSize)+page-1)&^(page-1)
 }
 
 type Times struct {
@@ -207,50 +490,103 @@ type Times struct {
 	CSTime [2]int16
 }
 
+/*
+ * One file structure is allocated
+ * for each open/creat/pipe call.
+ * Main use is to hold the read/write
+ * pointer associated with each open
+ * file. Dup and fork share the structure,
+ * and so the pointer; closef releases it
+ * when the last reference goes away.
+ */
 type File struct {
 	flag   int
-	count  int
-	offset int
-	inode  *inode
+	count  int    /* reference count */
+	offset int    /* read/write character pointer */
+	inode  *inode /* pointer to inode structure */
 	pipe   *pipe
 }
 
 type Signal struct {
 }
 
+// Fork makes a new process that is a copy of parent
+// and returns it, not yet running.
+// It returns EAGAIN if the process table is full.
 func (sys *System) Fork(parent *Proc) (*Proc, error) {
+	return sys.fork(parent, false)
+}
+
+// fork is Fork, except that if vfork is set, the new process
+// borrows parent's memory instead of copying it.
+func (sys *System) fork(parent *Proc, vfork bool) (*Proc, error) {
 	if len(sys.Procs) >= NPROC {
-		return nil, fmt.Errorf("too many procs")
+		return nil, EAGAIN
 	}
 
 	p := sys.newProc()
 	p.CPU.R = parent.CPU.R
 	p.CPU.PS = parent.CPU.PS
-	p.Mem = parent.Mem
+	if vfork {
+		p.flag |= _SVFORK
+		p.vmem = parent.umem()
+		p.CPU.Mem = p.vmem
+	} else {
+		parent.cowfork(p)
+	}
 	p.Ppid = parent.Pid
 	p.Uid = parent.Uid
-	p.RUid = p.Uid
+	p.RUid = parent.RUid
+	p.SUid = parent.SUid
 	p.Gid = parent.Gid
-	p.RGid = p.Gid
+	p.RGid = parent.RGid
+	p.SGid = parent.SGid
+	p.Umask = parent.Umask
+	p.Pgrp = parent.Pgrp
+	p.TextSize = parent.TextSize
+	p.DataStart = parent.DataStart
+	p.DataSize = parent.DataSize
+	if p.text = parent.text; p.text != nil {
+		p.text.count++
+	}
+	p.CPU.IMem = parent.CPU.IMem
 	p.Dir = parent.Dir
+	p.Root = parent.Root
 	p.Files = parent.Files
+	p.fdflag = parent.fdflag
 	p.Signals = parent.Signals
+	p.Prof = parent.Prof
 	p.TTY = parent.TTY
 	p.ttyp = parent.ttyp
+	p.nice = parent.nice
+	p.Nice = parent.Nice
+	p.Dir.count++
+	if p.Root != nil {
+		p.Root.count++
+	}
 	for _, f := range p.Files {
 		if f != nil {
 			f.count++
 		}
 	}
 	sys.Procs = append(sys.Procs, p)
+	sys.procGen++
 
 	return p, nil
 }
 
+// imageSize returns the size of p's image in 64-byte units,
+// counting the user block, text and data, and stack, as v6 did.
+func (p *Proc) imageSize() int16 {
+	stack := (1<<16 - int(p.CPU.R[pdp11.SP])) & 0xffff
+	return int16(USIZE + (p.brk()+63)/64 + (stack+63)/64)
+}
+
 func (sys *System) newProc() *Proc {
 	p := new(Proc)
 	p.Sys = sys
 	p.CPU.Mem = &p.Mem
+	p.Umask = 0o022
 	p.status = _SIDL
 
 Retry:
@@ -284,10 +620,14 @@ func NewSystem(archive []byte) (*System, error) {
 		return nil, err
 	}
 	sys.Disk = d
+	sys.devtab = slices.Clone(defaultDevtab)
+	sys.LPTrailer = []byte("\f")
 	sys.idle = make(chan bool)
-	for i := range sys.TTY {
-		sys.TTY[i].Sys = sys
+	sys.ttyReady = make(chan struct{}, 1)
+	for i := 0; i < 1+8; i++ {
+		sys.newTTY()
 	}
+	sys.mknod("/dev/tty", _IFCHR|0o666, ctyMajor, 0)
 	return sys, nil
 }
 
@@ -303,25 +643,27 @@ func (sys *System) ReadFile(name string) ([]byte, error) {
 		return nil, p.Error
 	}
 	defer p.iput(ip)
-	return ip.data, nil
+	return p.contents(ip), nil
 }
 
+// Start starts exe with arguments argv as process 1,
+// printing the output of the console, /dev/tty8, on stdout.
+// If stdout is nil, the console's output is left as it is,
+// such as sent on the channel returned by ConsoleOut.
 func (sys *System) Start(exe []byte, argv []string, stdout io.Writer) (*Proc, error) {
 	p := sys.newProc()
 	p.Pid = 1
 	p.Ppid = 0
 	p.Dir = p.iget(1)
 	sys.Exit1.L = &sys.Big
-	sys.TTY[8].Print = func(b []byte, echo bool) (int, Errno) {
-		n, err := stdout.Write(b)
-		if err != nil {
-			return 0, EIO
+	if stdout != nil {
+		sys.TTY[8].Print = func(b []byte, echo bool) (int, Errno) {
+			n, err := stdout.Write(b)
+			if err != nil {
+				return 0, EIO
+			}
+			return n, 0
 		}
-		return n, 0
-	}
-	for i := range sys.TTY {
-		sys.TTY[i].major = 4
-		sys.TTY[i].minor = uint8(i)
 	}
 
 	p.exec(exe, argv, nil)
@@ -329,15 +671,210 @@ func (sys *System) Start(exe []byte, argv []string, stdout io.Writer) (*Proc, er
 		return nil, fmt.Errorf("exec: %v", p.Error)
 	}
 
+	p.status = _SRUN
 	sys.Procs = append(sys.Procs, p)
+	sys.procGen++
 	return p, nil
 }
 
-func (sys *System) Wait() {
-	if !sys.Timer.IsZero() && !time.Now().Before(sys.Timer) {
-		sys.Timer = time.Time{}
-		sys.wakeup(&sys.Timer)
+var (
+	// ErrIdle is the error Step returns when the instruction
+	// is a system call that waits for something to happen,
+	// and Run returns when no process is left to run.
+	ErrIdle = errors.New("no process can run")
+
+	// ErrExited is the error Step returns when the process
+	// has exited, during the step or before it.
+	ErrExited = errors.New("process has exited")
+
+	// ErrBudgetExceeded is the error RunN returns when
+	// it has run as many instructions as it was allowed.
+	ErrBudgetExceeded = errors.New("instruction budget exceeded")
+
+	// ErrAllBlocked is the error Run and Continue return when
+	// every process left is waiting for something that cannot happen:
+	// no timer is pending, and none waits for terminal input or output.
+	// It wraps ErrIdle, so errors.Is(ErrAllBlocked, ErrIdle) is true.
+	ErrAllBlocked = fmt.Errorf("%w: all processes blocked", ErrIdle)
+)
+
+// Step runs p for one instruction and returns,
+// leaving p stopped so that its registers and memory
+// can be examined.
+// A trap, including a whole system call, counts as one instruction.
+// While p is in a system call, other processes may run;
+// if the call waits for something, like terminal input,
+// that has not happened when the system goes idle,
+// Step returns ErrIdle and the call completes later.
+// Step must not be called while the system is running,
+// as in Wait, and p must have been made by Start or fork.
+func (p *Proc) Step() error {
+	sys := p.Sys
+	if p.status == _SZOMB {
+		return ErrExited
+	}
+	sys.stopped = make(chan *Proc)
+	sys.stepping = p
+	sys.breakpc = -1
+	p.sched <- true
+	if _, err := sys.stop(); p.status != _SZOMB {
+		return err
+	}
+	return ErrExited
+}
+
+// Run runs the system until some process is about to
+// execute the instruction at untilPC or at a breakpoint,
+// and returns that process, stopped as by Step.
+// While the processes wait for a timer, such as a sleep or alarm,
+// Run waits for it too. If no process is left to run first,
+// Run returns ErrIdle, or ErrAllBlocked if none ever can.
+func (sys *System) Run(untilPC uint16) (*Proc, error) {
+	return sys.run1(int(untilPC))
+}
+
+// Continue runs the system until some process is about to
+// execute the instruction at a breakpoint,
+// and returns that process, stopped as by Step.
+// If no process is left to run first, because they have
+// all exited or are waiting for input, Continue returns ErrIdle,
+// and if they are waiting for something else that cannot happen,
+// ErrAllBlocked. Like Run, Continue waits for any timer.
+func (sys *System) Continue() (*Proc, error) {
+	return sys.run1(-1)
+}
+
+// RunN runs the system as Continue does, but for at most
+// maxInsns instructions, counting every process's, and returns
+// the count of instructions run. If the count reaches maxInsns,
+// RunN returns ErrBudgetExceeded, leaving the process that was
+// running stopped as by Step. A system call counts as one
+// instruction, however long it waits. RunN also stops, with
+// a nil error, when a process reaches a breakpoint.
+func (sys *System) RunN(maxInsns uint64) (executed uint64, err error) {
+	if maxInsns == 0 {
+		return 0, ErrBudgetExceeded
+	}
+	sys.budgeted, sys.budget = true, maxInsns
+	defer func() {
+		sys.budgeted = false
+	}()
+	_, err = sys.run1(-1)
+	if err == nil && sys.budget == 0 {
+		err = ErrBudgetExceeded
+	}
+	return maxInsns - sys.budget, err
+}
+
+func (sys *System) run1(pc int) (*Proc, error) {
+	for {
+		sys.stopped = make(chan *Proc)
+		sys.breakpc = pc
+		sys.skipIdle()
+		sys.clock()
+		sys.typeInput()
+		sys.Procs[0].sched <- true
+		p, err := sys.stop()
+		if err != ErrIdle {
+			return p, err
+		}
+		switch {
+		case !sys.Timer.IsZero():
+			if !sys.Deterministic {
+				time.Sleep(time.Until(sys.Timer))
+			}
+		case sys.Deterministic && len(sys.replay) > 0:
+			// typeInput types the next event
+		case sys.allBlocked():
+			return nil, ErrAllBlocked
+		default:
+			return nil, ErrIdle
+		}
+	}
+}
+
+// allBlocked reports whether there are processes left
+// but none can ever run again: each is stopped or asleep,
+// and none waits for input or output on a terminal,
+// which the host could still type or start.
+// The caller must have checked that no timer is pending.
+func (sys *System) allBlocked() bool {
+	blocked := false
+	for _, p := range sys.Procs {
+		if p.status == _SZOMB {
+			continue
+		}
+		blocked = true
+		if p.wkey == &sys.selwait {
+			return false
+		}
+		for _, t := range sys.ttys {
+			if p.wkey == &t.Delct || p.wkey == &t.outq {
+				return false
+			}
+		}
+		for _, pt := range sys.ptys {
+			if p.wkey == &pt.Delct || p.wkey == &pt.outq {
+				return false
+			}
+		}
+	}
+	return blocked
+}
+
+// SetBreakpoint makes Run, Continue and Step stop any process
+// that is about to execute the instruction at addr.
+func (sys *System) SetBreakpoint(addr uint16) {
+	if sys.breakpoints == nil {
+		sys.breakpoints = make(map[uint16]bool)
+	}
+	sys.breakpoints[addr] = true
+}
+
+// ClearBreakpoint removes the breakpoint at addr, if any.
+func (sys *System) ClearBreakpoint(addr uint16) {
+	delete(sys.breakpoints, addr)
+}
+
+// stopHere reports whether p, having executed an instruction,
+// should stop for Step, Run or Continue.
+func (sys *System) stopHere(p *Proc) bool {
+	pc := p.CPU.R[pdp11.PC]
+	return sys.stepping == p || int(pc) == sys.breakpc || sys.breakpoints[pc] ||
+		sys.budgeted && sys.budget == 0
+}
+
+// stop waits for Step or Run to stop a process.
+func (sys *System) stop() (*Proc, error) {
+	defer func() {
+		sys.stopped = nil
+		sys.stepping = nil
+	}()
+	select {
+	case p := <-sys.stopped:
+		return p, nil
+	case <-sys.idle:
+		return nil, ErrIdle
 	}
+}
+
+// A TraceFunc is called before a process executes
+// the instruction at pc, whose first word is op,
+// with the process's registers r.
+type TraceFunc func(p *Proc, pc, op uint16, r [8]uint16)
+
+// SetTrace arranges for f to be called before every
+// instruction executed by every process,
+// or turns tracing off if f is nil.
+// Tracing makes the processes run one instruction at a time.
+func (sys *System) SetTrace(f TraceFunc) {
+	sys.trace = f
+}
+
+func (sys *System) Wait() {
+	sys.skipIdle()
+	sys.clock()
+	sys.typeInput()
 	// Every proc is waiting on p.sched in p.swtch; waking up any of them is fine
 	// since their scheduler loop will find the right next process to run.
 	sys.Procs[0].sched <- true
@@ -347,7 +884,7 @@ func (sys *System) Wait() {
 func sysfork(p *Proc) {
 	c, err := p.Sys.Fork(p)
 	if err != nil {
-		p.Error = EIO
+		p.Error = EAGAIN
 		return
 	}
 	p.CPU.R[0] = uint16(c.Pid)
@@ -359,24 +896,96 @@ func sysfork(p *Proc) {
 	p.Sys.setrun(c)
 }
 
+/*
+ * vfork system call (4BSD).
+ * Like fork, but the child runs in the parent's
+ * memory instead of a copy, and the parent sleeps
+ * until the child gives the memory back by
+ * calling exec or exit, so there is no copy to make.
+ * Whatever the child stores, the parent sees:
+ * it must not return from the function that called
+ * vfork, since that pops the frame the parent will
+ * return through, and it must not change anything
+ * else the parent needs.
+ */
+func sysvfork(p *Proc) {
+	c, err := p.Sys.fork(p, true)
+	if err != nil {
+		p.Error = EAGAIN
+		return
+	}
+	p.CPU.R[0] = uint16(c.Pid)
+	c.CPU.R[0] = uint16(p.Pid)
+	p.CPU.R[pdp11.PC] += 2
+	if p.Sys.Trace {
+		fmt.Fprintf(os.Stderr, "[pid %d] vfork -> %d\n", p.Pid, c.Pid)
+	}
+	p.Sys.setrun(c)
+	for c.flag&_SVFORK != 0 {
+		p.sleep(&c.vmem, 'v', _PSWP)
+	}
+}
+
+/*
+ * Give the memory a vfork child has
+ * been using back to its parent,
+ * which is waiting for it.
+ */
+func (p *Proc) vrelse() {
+	if p.flag&_SVFORK == 0 {
+		return
+	}
+	p.flag &^= _SVFORK
+	p.vmem = nil
+	p.CPU.Mem = &p.Mem
+	p.Sys.wakeup(&p.vmem)
+}
+
 func (sys *System) run(p *Proc) {
 	<-p.sched
 	if p.status == _SZOMB {
 		runtime.Goexit()
 	}
+	sys.newSlice()
+	stepped := false
 	for {
+		if stepped && sys.stopped != nil && sys.stopHere(p) {
+			sys.stepping = nil
+			sys.stopped <- p
+			<-p.sched
+		}
+		stepped = false
 		if p.issig() {
 			p.psig()
 		}
+		if sys.runrun > 0 {
+			p.setpri(p)
+			p.swtch()
+		}
 		pc := p.CPU.R[pdp11.PC]
 		n := 100
+		if sys.trace != nil {
+			inst, _ := p.CPU.ReadIW(pc)
+			sys.trace(p, pc, inst, p.CPU.R)
+			n = 1
+		}
+		if sys.stopped != nil && (sys.stepping != nil || sys.breakpc >= 0 || len(sys.breakpoints) > 0) {
+			n = 1
+		}
+		if sys.Deterministic {
+			n = min(n, sys.slice, sys.instPerTick()-sys.insts)
+		}
+		tbit := p.CPU.PS&_TBIT != 0
+		if tbit {
+			n = 1
+		}
 		if p.Sys.Trace {
 			text, next, err := p.CPU.Disasm(pc)
 			if err != nil {
 				text = "???"
 			}
 			op, _, _ := strings.Cut(text, " ")
-			inst, _ := p.CPU.ReadW(pc)
+			inst, _ := p.CPU.ReadIW(pc)
 			fmt.Fprintf(os.Stderr, "%06o %06o %4s %06o %06o %06o %06o %06o %06o %06o   NZVC1 %04b\n", p.CPU.R[pdp11.PC], inst, strings.ToLower(op),
 				p.CPU.R[0], p.CPU.R[1], p.CPU.R[2], p.CPU.R[3], p.CPU.R[4], p.CPU.R[5], p.CPU.R[6], p.CPU.PS)
 			fmt.Fprintf(os.Stderr, "f0=%v f1=%v f2=%v f3=%v f4=%v f5=%v fps=%v\n",
@@ -384,7 +993,30 @@ func (sys *System) run(p *Proc) {
 			fmt.Fprintf(os.Stderr, "# %06o %v (nextPC=%06o)\n", pc, text, next)
 			n = 1
 		}
-		err := p.CPU.Step(n)
+		if sys.budgeted {
+			n = int(min(uint64(n), sys.budget))
+		}
+		done, err := p.CPU.StepN(n)
+		if sys.budgeted {
+			sys.budget -= uint64(done)
+		}
+		stepped = true
+		if tbit && err == nil {
+			/*
+			 * trace trap; none after a trap instruction,
+			 * as the kernel's rtt does not take one
+			 */
+			sys.psignal(p, SIGTRC)
+		}
+		if sys.Deterministic {
+			if sys.slice -= done; sys.slice <= 0 {
+				sys.runrun++
+			}
+		}
+		sys.clockStep(p, done)
+		if !sys.Timer.IsZero() {
+			sys.clock()
+		}
 		var sig int
 		switch err {
 		case pdp11.ErrTrap:
@@ -396,6 +1028,8 @@ func (sys *System) run(p *Proc) {
 		case pdp11.ErrInst:
 			sig = SIGINS
 		case pdp11.ErrBPT:
+			/* trap with pc past the bpt, as debuggers expect */
+			p.CPU.R[pdp11.PC] += 2
 			sig = SIGTRC
 		case pdp11.ErrIOT:
 			sig = SIGIOT
@@ -405,7 +1039,11 @@ func (sys *System) run(p *Proc) {
 			sig = SIGFPT
 		case pdp11.ErrMem:
 			sig = SIGSEG
-			// TODO stack growth
+		case nil:
+			// The stack has grown into the data segment.
+			if !segfit(p.brk(), p.CPU.R[pdp11.SP]) {
+				sig = SIGSEG
+			}
 		}
 		if sig != 0 {
 			sys.psignal(p, sig)
@@ -0,0 +1,194 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Pseudo-terminals are not in v6; the code is new.
+// A pty is a pair of devices: the slave /dev/ptyN is an ordinary tty
+// with the usual line discipline, and the master /dev/ptmN is the
+// other end of the wire. Bytes written to the master are typed
+// on the slave, and bytes the slave prints are read from the master.
+
+package v6unix
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+)
+
+const (
+	ptyMajor = 9  // /dev/ptyN, the slave side
+	ptmMajor = 10 // /dev/ptmN, the master side
+)
+
+// A PTY is a pseudo-terminal.
+// Its TTY is the slave side, with the same modes as a console tty.
+// Its Read and Write methods are the master side, for use by the host.
+type PTY struct {
+	TTY
+	out bytes.Buffer // printed by the slave, not yet read by the master
+}
+
+// OpenPTY allocates a new pseudo-terminal and returns its master side,
+// along with the name of the slave device in the file system.
+// Programs in the simulated system can open the slave name as a tty.
+func (sys *System) OpenPTY() (*PTY, string, error) {
+	minor := len(sys.ptys)
+	if minor > 255 {
+		return nil, "", ENXIO
+	}
+	pt := new(PTY)
+	pt.Sys = sys
+	pt.major = ptyMajor
+	pt.minor = uint8(minor)
+	pt.tchars = defaultTchars
+	pt.Print = func(b []byte, echo bool) (int, Errno) {
+		pt.out.Write(b)
+		sys.wakeup(&pt.out)
+		return len(b), 0
+	}
+
+	slave := fmt.Sprintf("/dev/pty%d", minor)
+	if err := sys.mknod(slave, _IFCHR|0o666, ptyMajor, uint8(minor)); err != nil {
+		return nil, "", err
+	}
+	if err := sys.mknod(fmt.Sprintf("/dev/ptm%d", minor), _IFCHR|0o600, ptmMajor, uint8(minor)); err != nil {
+		return nil, "", err
+	}
+	sys.ptys = append(sys.ptys, pt)
+	return pt, slave, nil
+}
+
+// Read reads output printed by the slave side.
+// It does not wait: if there is no output, it returns 0, io.EOF.
+func (pt *PTY) Read(b []byte) (int, error) {
+	return pt.out.Read(b)
+}
+
+// Write types b on the slave side.
+func (pt *PTY) Write(b []byte) (int, error) {
+	for _, c := range b {
+		pt.WriteByte(c)
+	}
+	return len(b), nil
+}
+
+// mknod creates the special file name as the super-user,
+// or updates its device numbers if it already exists.
+func (sys *System) mknod(name string, mode uint16, major, minor uint8) error {
+	p := &Proc{Sys: sys}
+	p.Dir = p.iget(ROOTINO)
+	defer p.iput(p.Dir)
+
+	ip, dp, off := p.namei(name, nameCreate)
+	defer p.iput(dp)
+	if ip == nil {
+		if p.Error != 0 {
+			return p.Error
+		}
+		ip = p.maknode(path.Base(name), mode, dp, off)
+		if ip == nil {
+			return p.Error
+		}
+	}
+	ip.major = major
+	ip.minor = minor
+	p.iput(ip)
+	return nil
+}
+
+func (p *Proc) pty(minor uint8) *PTY {
+	if int(minor) >= len(p.Sys.ptys) {
+		p.Error = ENXIO
+		return nil
+	}
+	return p.Sys.ptys[minor]
+}
+
+// ptydev is the slave side of a pty.
+type ptydev struct{}
+
+func (ptydev) open(p *Proc, minor uint8, rw int) {
+	if pt := p.pty(minor); pt != nil {
+		pt.open(p, 0)
+	}
+}
+
+func (ptydev) tty(p *Proc, minor uint8) *TTY {
+	if pt := p.pty(minor); pt != nil {
+		return &pt.TTY
+	}
+	return nil
+}
+
+func (ptydev) read(p *Proc, minor uint8, b []byte, off int) int {
+	if pt := p.pty(minor); pt != nil {
+		return pt.read(p, b)
+	}
+	return 0
+}
+
+func (ptydev) write(p *Proc, minor uint8, b []byte, off int) int {
+	if pt := p.pty(minor); pt != nil {
+		return pt.write(p, b)
+	}
+	return 0
+}
+
+func (ptydev) close(p *Proc, minor uint8) {
+	if pt := p.pty(minor); pt != nil {
+		pt.close(p)
+	}
+}
+
+func (ptydev) sgtty(p *Proc, minor uint8, in, out *[3]uint16) {
+	if pt := p.pty(minor); pt != nil {
+		pt.sgtty(p, in, out)
+	}
+}
+
+func (ptydev) ioctl(p *Proc, minor uint8, cmd, addr uint16) {
+	if pt := p.pty(minor); pt != nil {
+		pt.ioctl(p, cmd, addr)
+	}
+}
+
+// ptmdev is the master side of a pty.
+type ptmdev struct{}
+
+func (ptmdev) open(p *Proc, minor uint8, rw int) {
+	p.pty(minor)
+}
+
+func (ptmdev) read(p *Proc, minor uint8, b []byte, off int) int {
+	pt := p.pty(minor)
+	if pt == nil || len(b) == 0 {
+		return 0
+	}
+	for pt.out.Len() == 0 {
+		p.sleep(&pt.out, 'o', PSLEP)
+	}
+	n, _ := pt.out.Read(b)
+	return n
+}
+
+func (ptmdev) sel(p *Proc, minor uint8, rw int) bool {
+	pt := p.pty(minor)
+	return pt == nil || rw != _FREAD || pt.out.Len() > 0
+}
+
+func (ptmdev) write(p *Proc, minor uint8, b []byte, off int) int {
+	pt := p.pty(minor)
+	if pt == nil {
+		return 0
+	}
+	n, _ := pt.Write(b)
+	return n
+}
+
+func (ptmdev) close(p *Proc, minor uint8) {
+}
+
+func (ptmdev) sgtty(p *Proc, minor uint8, in, out *[3]uint16) {
+	p.Error = ENOTTY
+}
@@ -8,9 +8,17 @@
 package v6unix
 
 func (p *Proc) readi(ip *inode, b []byte, off int) int {
-	ip.atime = now()
-	if ip.major != 0 {
-		return p.dev(ip.major).read(p, ip.minor, b, off)
+	if !p.Sys.NoATime {
+		ip.atime = p.Sys.now()
+	}
+	if ip.special() {
+		return p.dev(ip.major, ip.minor).read(p, ip.minor, b, off)
+	}
+	if ip.host != nil && ip.mode&_IFMT == 0 {
+		return p.hostRead(ip, b, off)
+	}
+	if ip.onImage() {
+		return p.imgRead(ip, b, off)
 	}
 	if off < 0 || off >= len(ip.data) {
 		return 0
@@ -19,20 +27,30 @@ func (p *Proc) readi(ip *inode, b []byte, off int) int {
 }
 
 func (p *Proc) writei(ip *inode, b []byte, off int) int {
-	const maxFileSize = 1<<24 - 1
+	const maxFileSize = 1<<24 - 1 /* largest size the 24-bit size of an inode holds */
 
-	ip.atime = now()
-	ip.mtime = ip.atime
-	if ip.major != 0 {
-		return p.dev(ip.major).write(p, ip.minor, b, off)
+	if ip.special() {
+		return p.dev(ip.major, ip.minor).write(p, ip.minor, b, off)
 	}
-	if off < 0 || off+len(b) > maxFileSize {
+	ip.text = nil
+	if off < 0 {
 		p.Error = EIO
 		return 0
 	}
+	if off+len(b) > maxFileSize {
+		p.Error = EFBIG
+		return 0
+	}
 	if len(b) == 0 {
 		return 0
 	}
+	ip.mtime = p.Sys.now()
+	if ip.host != nil && ip.mode&_IFMT == 0 {
+		return p.hostWrite(ip, b, off)
+	}
+	if ip.onImage() {
+		return p.imgWrite(ip, b, off)
+	}
 	if off+len(b) > len(ip.data) {
 		old := len(ip.data)
 		new := off + len(b)
@@ -43,6 +61,15 @@ func (p *Proc) writei(ip *inode, b []byte, off int) int {
 		ip.data = ip.data[:new]
 		ip.writeSize()
 	}
-	ip.mtime = now()
 	return copy(ip.data[off:], b)
 }
+
+// contents returns the whole content of the file ip,
+// for the callers that work on a byte slice.
+func (p *Proc) contents(ip *inode) []byte {
+	if ip.host == nil && ip.img == nil {
+		return ip.data
+	}
+	b := make([]byte, ip.size())
+	return b[:p.readi(ip, b, 0)]
+}
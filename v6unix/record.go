@@ -0,0 +1,128 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Recording and replaying terminal sessions is not a port; the code is new.
+// A recording is text, one line per event:
+//
+//	seconds dir minor "bytes"
+//
+// where seconds is the system time since the recording began,
+// dir is i for input typed on the terminal or o for output printed on it,
+// minor is the minor number of the terminal, /dev/ttyN,
+// and bytes is a Go quoted string.
+
+package v6unix
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// A recEvent is an input event read by ReplayConsole.
+type recEvent struct {
+	at    time.Duration // since the beginning of the replay
+	minor uint8
+	b     []byte
+}
+
+// RecordConsole starts recording the input and output of the terminals
+// /dev/ttyN on w, or stops recording if w is nil.
+// Pseudo-terminals are not recorded.
+// Recording stops at the first error writing to w.
+func (sys *System) RecordConsole(w io.Writer) {
+	sys.rec = w
+	sys.recStart = sys.clockTime()
+}
+
+// record records b as input (dir 'i') or output (dir 'o') of t.
+func (t *TTY) record(dir byte, b []byte) {
+	sys := t.Sys
+	if sys == nil || sys.rec == nil || t.major != ttyMajor || len(b) == 0 {
+		return
+	}
+	at := sys.clockTime().Sub(sys.recStart)
+	if _, err := fmt.Fprintf(sys.rec, "%.6f %c %d %q\n", at.Seconds(), dir, t.minor, b); err != nil {
+		sys.rec = nil
+	}
+}
+
+// print prints b on t, recording it.
+func (t *TTY) print(b []byte, echo bool) (int, Errno) {
+	t.record('o', b)
+	return t.Print(b, echo)
+}
+
+// ReplayConsole reads a recording made by RecordConsole from r
+// and types its input again on the same terminals, ignoring its output.
+// The input is typed during Wait, as the input given to AddTTY is,
+// each event once as much time has gone by since the replay began
+// as had gone by since the recording began.
+// In deterministic mode, the times are ignored instead:
+// each Wait, Run or Continue types the next event.
+// InputReady receives a value whenever an event is due.
+func (sys *System) ReplayConsole(r io.Reader) error {
+	var evs []recEvent
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := s.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var (
+			secs  float64
+			dir   byte
+			minor uint8
+			b     string
+		)
+		if _, err := fmt.Sscanf(line, "%f %c %d %q", &secs, &dir, &minor, &b); err != nil || dir != 'i' && dir != 'o' {
+			return fmt.Errorf("replay: line %d: malformed event %q", n, line)
+		}
+		if dir == 'i' {
+			evs = append(evs, recEvent{time.Duration(secs * float64(time.Second)), minor, []byte(b)})
+		}
+	}
+	if err := s.Err(); err != nil {
+		return fmt.Errorf("replay: %v", err)
+	}
+
+	start := time.Now()
+	sys.replay = evs
+	sys.replayStart = start
+	if sys.Deterministic {
+		sys.inputReady()
+		return nil
+	}
+	go func() {
+		for _, ev := range evs {
+			time.Sleep(time.Until(start.Add(ev.at)))
+			sys.inputReady()
+		}
+	}()
+	return nil
+}
+
+// typeReplay types the input of ReplayConsole that is due.
+func (sys *System) typeReplay() {
+	for len(sys.replay) > 0 {
+		ev := sys.replay[0]
+		if !sys.Deterministic && time.Since(sys.replayStart) < ev.at {
+			break
+		}
+		sys.replay = sys.replay[1:]
+		if int(ev.minor) < len(sys.ttys) {
+			for _, c := range ev.b {
+				sys.ttys[ev.minor].WriteByte(c)
+			}
+		}
+		if sys.Deterministic {
+			if len(sys.replay) > 0 {
+				sys.inputReady()
+			}
+			break
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package v6unix
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRecordConsole(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sys.Deterministic = true
+	var rec bytes.Buffer
+	sys.RecordConsole(&rec)
+	p := &Proc{Sys: sys}
+	p.Dir = p.iget(ROOTINO)
+	d := p.dev(ttyMajor, 8)
+	d.open(p, 8, 2)
+	sys.TTY[8].Print = func(b []byte, echo bool) (int, Errno) { return len(b), 0 }
+	sys.TTY[8].flags &^= ECHO | LCASE
+	typeString(&sys.TTY[8], "ls\n")
+	sys.Tick()
+	d.write(p, 8, []byte("ok\n"), 0)
+	sys.TTY[1].WriteByte('x')
+	sys.RecordConsole(nil)
+	sys.TTY[1].WriteByte('y')
+
+	want := `0.000000 i 8 "l"
+0.000000 i 8 "s"
+0.000000 i 8 "\n"
+0.016667 o 8 "ok\r\n"
+0.016667 i 1 "x"
+`
+	if rec.String() != want {
+		t.Fatalf("recording:\n%s\nwant:\n%s", rec.String(), want)
+	}
+
+	// The replay types the input again,
+	// in deterministic mode one event each time.
+	sys, err = NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sys.Deterministic = true
+	if err := sys.ReplayConsole(strings.NewReader(want)); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{"l", "ls", "ls\n\377", "ls\n\377"} {
+		<-sys.InputReady()
+		sys.typeInput()
+		if s := sys.TTY[8].Raw.String(); s != want {
+			t.Fatalf("after %d events, tty8 has %q, want %q", i+1, s, want)
+		}
+	}
+	if s := sys.TTY[1].Raw.String(); s != "x" {
+		t.Errorf("tty1 has %q, want %q", s, "x")
+	}
+	select {
+	case <-sys.InputReady():
+		t.Errorf("input ready after replay is done")
+	default:
+	}
+
+	// Outside deterministic mode, the input waits for its time.
+	sys, err = NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sys.ReplayConsole(strings.NewReader("0 i 8 \"a\"\n0.05 i 8 \"b\"\n")); err != nil {
+		t.Fatal(err)
+	}
+	for sys.TTY[8].Raw.String() != "ab" {
+		<-sys.InputReady()
+		sys.typeInput()
+		if s := sys.TTY[8].Raw.String(); s == "b" {
+			t.Fatalf("second event typed before the first")
+		}
+	}
+
+	if err := sys.ReplayConsole(strings.NewReader("0 x 8 \"a\"\n")); err == nil {
+		t.Errorf("replay of malformed recording succeeded")
+	}
+}
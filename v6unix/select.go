@@ -0,0 +1,127 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// The select system call is not a port; the code is new,
+// after select in 4.2BSD's sys_generic.c. Since a process
+// has only NOFILE files, each set of descriptors is a single
+// word, and the timeout is a count of clock ticks.
+
+package v6unix
+
+import (
+	"time"
+	"unsafe"
+)
+
+// A selecter is a device that can say whether
+// a read or write (rw is _FREAD or _FWRITE) would not block.
+// Terminals answer through ttyer instead,
+// and other devices are always ready.
+type selecter interface {
+	sel(p *Proc, minor uint8, rw int) bool
+}
+
+/*
+ * select system call (4.2BSD 93), if System.EnableSelect.
+ * r0 is the number of descriptors to look at.
+ * The arguments are the addresses of the read and
+ * write masks, either of which may be 0, and the
+ * timeout in clock ticks, or 0177777 to wait forever.
+ * Wait until some descriptor in the masks is ready
+ * or the time is up, rewrite the masks to hold just the
+ * ready ones, and return how many there are.
+ */
+func sysselect(p *Proc) {
+	if !p.Sys.EnableSelect {
+		sysnone(p)
+		return
+	}
+	nfd := min(int(p.CPU.R[0]), NOFILE)
+	addrs := [2]uint16{p.Args[0], p.Args[1]}
+	var masks [2]uint16
+	for i, a := range addrs {
+		if a == 0 {
+			continue
+		}
+		b := p.mem(a, 2)
+		if b == nil {
+			return
+		}
+		masks[i] = *(*uint16)(unsafe.Pointer(&b[0])) & (1<<nfd - 1)
+	}
+	var end time.Time
+	if t := p.Args[2]; t != 0o177777 {
+		end = p.Sys.clockTime().Add(time.Duration(t) * time.Second / time.Duration(p.Sys.hz()))
+	}
+
+	var ready [2]uint16
+	var n int
+	for {
+		ready, n = p.selscan(masks)
+		if n > 0 || p.Error != 0 || !end.IsZero() && !p.Sys.clockTime().Before(end) {
+			break
+		}
+		if !end.IsZero() {
+			p.Sys.setTimer(end)
+		}
+		p.sleep(&p.Sys.selwait, 's', PSLEP)
+	}
+	if p.Error != 0 {
+		return
+	}
+	for i, a := range addrs {
+		if a != 0 {
+			*(*uint16)(unsafe.Pointer(&p.mem(a, 2)[0])) = ready[i]
+		}
+	}
+	p.CPU.R[0] = uint16(n)
+}
+
+/*
+ * Look at the descriptors in the read and
+ * write masks, returning the masks of the
+ * ready ones and their count.
+ */
+func (p *Proc) selscan(masks [2]uint16) (ready [2]uint16, n int) {
+	for i, rw := range [2]int{_FREAD, _FWRITE} {
+		for fd := uint16(0); fd < NOFILE; fd++ {
+			if masks[i]&(1<<fd) == 0 {
+				continue
+			}
+			f := p.getf(fd)
+			if f == nil {
+				return ready, 0
+			}
+			if p.selready(f, rw) {
+				ready[i] |= 1 << fd
+				n++
+			}
+			if p.Error != 0 {
+				return ready, 0
+			}
+		}
+	}
+	return ready, n
+}
+
+// selready reports whether a read or write of f
+// (rw is _FREAD or _FWRITE) would not block.
+func (p *Proc) selready(f *File, rw int) bool {
+	if f.flag&_FPIPE != 0 {
+		return f.pipeReady(rw)
+	}
+	ip := f.inode
+	if !ip.special() {
+		return true
+	}
+	switch d := p.dev(ip.major, ip.minor).(type) {
+	case selecter:
+		return d.sel(p, ip.minor, rw)
+	case ttyer:
+		if t := d.tty(p, ip.minor); t != nil {
+			return t.sel(rw)
+		}
+	}
+	return true
+}
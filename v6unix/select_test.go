@@ -0,0 +1,121 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package v6unix
+
+import (
+	"testing"
+
+	"rsc.io/unix/pdp11"
+)
+
+func TestSelect(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Proc{Sys: sys, sched: make(chan bool)}
+	p.status = _SRUN
+	p.CPU.Mem = &p.Mem
+	p.Dir = p.iget(ROOTINO)
+	sys.Procs = []*Proc{p}
+	tty := &sys.TTY[1]
+	tty.Print = func(b []byte, echo bool) (int, Errno) { return len(b), 0 }
+
+	const (
+		rmask = 0o1000
+		wmask = 0o1002
+	)
+	// sel runs sys select; rmask; wmask; timeout
+	// with the given masks, letting wait run while it blocks,
+	// and returns the count, the masks, and the error.
+	sel := func(r, w, timeout uint16, wait func()) (n int, rr, ww uint16, err Errno) {
+		t.Helper()
+		p.Mem.WriteW(rmask, r)
+		p.Mem.WriteW(wmask, w)
+		p.Mem.WriteW(0o100, 0o104467) // sys select
+		p.Mem.WriteW(0o102, rmask)
+		p.Mem.WriteW(0o104, wmask)
+		p.Mem.WriteW(0o106, timeout)
+		p.CPU.R[0] = NOFILE
+		p.CPU.R[pdp11.PC] = 0o100
+		p.CPU.Inst = 0o104467
+		done := make(chan error)
+		go func() { done <- Trap(p) }()
+		if wait != nil {
+			<-sys.idle
+			wait()
+			p.sched <- true
+		}
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		rr, _ = p.Mem.ReadW(rmask)
+		ww, _ = p.Mem.ReadW(wmask)
+		return int(p.CPU.R[0]), rr, ww, p.Error
+	}
+
+	if _, _, _, err := sel(0, 0, 0, nil); err != 100 {
+		t.Errorf("select without EnableSelect: %v, want unknown system call", err)
+	}
+	sys.EnableSelect = true
+	p.Error = 0
+
+	p.open("/dev/tty1", 2)
+	if p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	tfd := p.CPU.R[0]
+	if syspipe(p); p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	rfd, wfd := p.CPU.R[0], p.CPU.R[1]
+	bit := func(fd uint16) uint16 { return 1 << fd }
+
+	// With nothing typed or written, only the write sides are ready.
+	n, r, w, errno := sel(bit(tfd)|bit(rfd), bit(tfd)|bit(wfd), 0, nil)
+	if n != 2 || r != 0 || w != bit(tfd)|bit(wfd) || errno != 0 {
+		t.Errorf("poll = %d, %#o, %#o, %v, want 2, 0, %#o", n, r, w, errno, bit(tfd)|bit(wfd))
+	}
+
+	// Data in the pipe makes its read side ready.
+	p.CPU.R[0] = wfd
+	p.Args[0], p.Args[1] = 0o2000, 3
+	if p.rdwr(_FWRITE); p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	if n, r, _, _ := sel(bit(tfd)|bit(rfd), 0, 0, nil); n != 1 || r != bit(rfd) {
+		t.Errorf("poll after pipe write = %d, %#o, want 1, %#o", n, r, bit(rfd))
+	}
+
+	// A partial line is not ready to read,
+	// and a blocked select wakes up when the line is done.
+	typeString(tty, "par")
+	if n, r, _, _ := sel(bit(tfd), 0, 0, nil); n != 0 || r != 0 {
+		t.Errorf("poll after partial line = %d, %#o, want 0, 0", n, r)
+	}
+	n, r, _, errno = sel(bit(tfd), 0, 0o177777, func() { typeString(tty, "is\n") })
+	if n != 1 || r != bit(tfd) || errno != 0 {
+		t.Errorf("select for typed line = %d, %#o, %v, want 1, %#o", n, r, errno, bit(tfd))
+	}
+	tty.flushInput()
+
+	// The timeout is in clock ticks.
+	n, r, _, errno = sel(bit(tfd), 0, 1, sys.Tick)
+	if n != 0 || r != 0 || errno != 0 {
+		t.Errorf("select timing out = %d, %#o, %v, want 0, 0", n, r, errno)
+	}
+
+	// A signal interrupts the wait.
+	p.Signals[SIGINT] = 0o2000 // caught, so the process survives
+	_, _, _, errno = sel(bit(tfd), 0, 0o177777, func() { sys.psignal(p, SIGINT) })
+	if errno != EINTR {
+		t.Errorf("select interrupted by signal: %v, want EINTR", errno)
+	}
+	p.sig = 0
+
+	if _, _, _, err := sel(bit(9), 0, 0, nil); err != EBADF {
+		t.Errorf("select of closed fd: %v, want EBADF", err)
+	}
+}
@@ -18,10 +18,24 @@ import "rsc.io/unix/pdp11"
  * controlling teletype.
  * Called by tty.c for quits and
  * interrupts.
+ * If the tty has a foreground process
+ * group, only that group is signaled.
  */
 func (sys *System) signal(tty *TTY, sig int) {
 	for _, p := range sys.Procs {
-		if p.TTY == tty {
+		if p.TTY == tty && (tty.Pgrp == 0 || p.Pgrp == tty.Pgrp) {
+			sys.psignal(p, sig)
+		}
+	}
+}
+
+/*
+ * Send the specified signal to
+ * all processes in process group pgrp.
+ */
+func (sys *System) gsignal(pgrp int16, sig int) {
+	for _, p := range sys.Procs {
+		if p.Pgrp == pgrp {
 			sys.psignal(p, sig)
 		}
 	}
@@ -35,9 +49,18 @@ func (sys *System) psignal(p *Proc, sig int) {
 	if sig >= NSIG {
 		return
 	}
+	if sig == SIGCONT && p.status == _SSTOP && p.flag&_STRC == 0 {
+		sys.setrun(p)
+	}
+	if (sig == SIGWINCH || sig == SIGCONT) && p.Signals[sig] == 0 {
+		return // default action is to ignore
+	}
 	if p.sig != SIGKIL {
 		p.sig = int8(sig)
 	}
+	if sig == SIGKIL && p.status == _SSTOP && p.flag&_STRC == 0 {
+		sys.setrun(p)
+	}
 	/*BUG: should be pri
 	if p.stat > PUSER {
 		p.stat = PUSER
@@ -87,6 +110,7 @@ Loop:
 			if p1.Pid == p.Ppid {
 				p.Sys.wakeup(p1)
 				p.status = _SSTOP
+				p.Sys.procGen++
 				p.swtch()
 				if p.flag&_STRC == 0 || p.procxmt() {
 					return
@@ -107,8 +131,8 @@ Loop:
  *	}
  */
 func (p *Proc) psig() {
-	sig := p.sig
-	p.sig = sig
+	sig := int(p.sig)
+	p.sig = 0
 	if pc := p.Signals[sig]; pc != 0 {
 		p.Error = 0
 		if sig != SIGINS && sig != SIGTRC {
@@ -116,15 +140,18 @@ func (p *Proc) psig() {
 		}
 		sp := p.CPU.R[pdp11.SP] - 4
 		p.grow(sp)
-		p.Mem.WriteW(sp+2, uint16(p.CPU.PS))
-		p.Mem.WriteW(sp, uint16(p.CPU.R[pdp11.PC]))
+		p.CPU.Mem.WriteW(sp+2, uint16(p.CPU.PS))
+		p.CPU.Mem.WriteW(sp, uint16(p.CPU.R[pdp11.PC]))
 		p.CPU.R[pdp11.SP] = sp
-		// TODO p.CPU.PS &^= _TBIT
+		p.CPU.PS &^= _TBIT
 		p.CPU.R[pdp11.PC] = pc
 		return
 	}
 
 	switch sig {
+	case SIGSTOP, SIGTTIN:
+		p.jobstop(sig)
+		return
 	case SIGQIT,
 		SIGINS,
 		SIGTRC,
@@ -134,16 +161,34 @@ func (p *Proc) psig() {
 		SIGBUS,
 		SIGSEG,
 		SIGSYS:
-		p.Args[0] = uint16(sig)
-		if p.core() {
-			p.Args[0] |= 0o200
+		if p.Sys.EnableCoreDumps && p.core() {
+			sig += 0o200
 		}
 	}
-	// TODO: This overwrites the p.Args[0] set in the switch
-	p.Args[0] = p.CPU.R[0]<<8 | uint16(sig)
+	p.Args[0] = uint16(sig) /* as in v7; v6 put r0 in the high byte */
 	p.exit()
 }
 
+/*
+ * Stop for job control (not in v6).
+ * The parent is told, as for a traced
+ * process, and the process stays stopped
+ * until SIGCONT or SIGKIL sets it running.
+ */
+func (p *Proc) jobstop(sig int) {
+	p.sig = int8(sig) /* for wait to report */
+	p.flag &^= _SWTED
+	p.status = _SSTOP
+	p.Sys.procGen++
+	if pp := p.Sys.lookpid(p.Ppid); pp != nil {
+		p.Sys.wakeup(pp)
+	}
+	p.swtch()
+	if p.sig == int8(sig) {
+		p.sig = 0
+	}
+}
+
 /*
  * Create a core image on the file "core"
  * If you are looking for protection glitches,
@@ -155,41 +200,48 @@ func (p *Proc) psig() {
  * data+stack segments.
  */
 func (p *Proc) core() bool {
-	return false
-	/*
-		register s, *ip;
-		extern schar;
-
-		u.u_error = 0;
-		u.u_dirp = "core";
-		ip = namei(&schar, 1);
-		if(ip == NULL) {
-			if(u.u_error)
-				return(0);
-			ip = maknode(0666);
-			if(ip == NULL)
-				return(0);
+	p.Error = 0
+	ip, dp, off := p.namei("core", nameCreate)
+	if ip == nil {
+		if p.Error != 0 {
+			return false
 		}
-		if(!access(ip, IWRITE) &&
-		   (ip->i_mode&IFMT) == 0 &&
-		   u.u_uid == u.u_ruid) {
-			itrunc(ip);
-			u.u_offset[0] = 0;
-			u.u_offset[1] = 0;
-			u.u_base = &u;
-			u.u_count = USIZE*64;
-			u.u_segflg = 1;
-			writei(ip);
-			s = u.u_procp->p_size - USIZE;
-			estabur(0, s, 0, 0);
-			u.u_base = 0;
-			u.u_count = s*64;
-			u.u_segflg = 0;
-			writei(ip);
+		ip = p.maknode("core", 0o666, dp, off)
+		p.iput(dp)
+		if ip == nil {
+			return false
 		}
-		iput(ip);
-		return(u.u_error==0);
-	*/
+	}
+	if p.access(ip, _IWRITE) &&
+		ip.mode&_IFMT == 0 &&
+		p.Uid == p.RUid {
+		p.itrunc(ip)
+		p.writei(ip, p.coreImage(), 0)
+	}
+	p.iput(ip)
+	return p.Error == 0
+}
+
+/*
+ * Return the contents of the core file.
+ * There is no user block to write,
+ * so its place is left as zeros.
+ * The data and stack segments follow,
+ * each rounded out to 64-byte clicks,
+ * and the whole is cut short at
+ * CoreLimit, if set.
+ */
+func (p *Proc) coreImage() []byte {
+	b := make([]byte, USIZE*64)
+	mem := p.umem()
+	b = append(b, mem[p.DataStart:(p.brk()+63)&^63]...)
+	if sp := p.CPU.R[pdp11.SP]; sp != 0 {
+		b = append(b, mem[sp&^63:]...)
+	}
+	if lim := p.Sys.CoreLimit; lim > 0 && len(b) > lim {
+		b = b[:lim]
+	}
+	return b
 }
 
 /*
@@ -221,44 +273,76 @@ func (p *Proc) grow(sp uint16) bool {
 	*/
 }
 
+/*
+ * Tracing variables.
+ * Used to pass trace command from
+ * parent to child being traced.
+ * This data base cannot be
+ * shared and is locked
+ * per user.
+ */
+type ipc struct {
+	lock int16 /* pid of the traced child, while in use */
+	req  int16
+	addr uint16
+	data uint16
+}
+
+const _IPCPRI int8 = -1
+
+/*
+ * The saved registers r0-r7 and ps as
+ * ptrace sees them in the user block:
+ * uar0 is the word offset of r0, and
+ * regloc the offsets of the others from it.
+ * There is no user block; the words
+ * stand for the CPU's registers.
+ */
+const uar0 = USIZE*32 - 3
+
+var regloc = [9]int{0, -2, -9, -8, -7, -6, -3, 1, 2}
+
 /*
  * sys-trace system call.
  */
 func sysptrace(p *Proc) {
-	return
-	/*
-			if p.Args[2] <= 0 {
-				p.flag |= _STRC
-				return
-			}
-
-			for _, p1 := range p.Sys.Procs {
-				if
-			for (p=proc; p < &proc[NPROC]; p++)
-				if (p->p_stat==SSTOP
-				 && p->p_pid==u.u_arg[0]
-				 && p->p_ppid==u.u_procp->p_pid)
-					goto found;
-			u.u_error = ESRCH;
-			return;
+	if int16(p.Args[2]) <= 0 {
+		p.flag |= _STRC
+		return
+	}
+	var c *Proc
+	for _, p1 := range p.Sys.Procs {
+		if p1.status == _SSTOP &&
+			p1.Pid == int16(p.Args[0]) &&
+			p1.Ppid == p.Pid {
+			c = p1
+			break
+		}
+	}
+	if c == nil {
+		p.Error = ESRCH
+		return
+	}
 
-		    found:
-			while (ipc.ip_lock)
-				sleep(&ipc, IPCPRI);
-			ipc.ip_lock = p->p_pid;
-			ipc.ip_data = u.u_ar0[R0];
-			ipc.ip_addr = u.u_arg[1] & ~01;
-			ipc.ip_req = u.u_arg[2];
-			p->p_flag =& ~SWTED;
-			setrun(p);
-			while (ipc.ip_req > 0)
-				sleep(&ipc, IPCPRI);
-			u.u_ar0[R0] = ipc.ip_data;
-			if (ipc.ip_req < 0)
-				u.u_error = EIO;
-			ipc.ip_lock = 0;
-			wakeup(&ipc);
-	*/
+	ipc := &p.Sys.ipc
+	for ipc.lock != 0 {
+		p.sleep(ipc, 'i', _IPCPRI)
+	}
+	ipc.lock = c.Pid
+	ipc.data = p.CPU.R[0]
+	ipc.addr = p.Args[1] &^ 1
+	ipc.req = int16(p.Args[2])
+	c.flag &^= _SWTED
+	p.Sys.setrun(c)
+	for ipc.req > 0 {
+		p.sleep(ipc, 'i', _IPCPRI)
+	}
+	p.CPU.R[0] = ipc.data
+	if ipc.req < 0 {
+		p.Error = EIO
+	}
+	ipc.lock = 0
+	p.Sys.wakeup(ipc)
 }
 
 /*
@@ -267,84 +351,97 @@ func sysptrace(p *Proc) {
  * of the parent process in tracing.
  */
 func (p *Proc) procxmt() bool {
-	return false
-	/*
-		register int i;
-		register int *p;
-
-		if (ipc.ip_lock != u.u_procp->p_pid)
-			return(0);
-		i = ipc.ip_req;
-		ipc.ip_req = 0;
-		wakeup(&ipc);
-		switch (i) {
-
-		/* read user I * /
-		case 1:
-			if (fuibyte(ipc.ip_addr) == -1)
-				goto error;
-			ipc.ip_data = fuiword(ipc.ip_addr);
-			break;
-
-		/* read user D * /
-		case 2:
-			if (fubyte(ipc.ip_addr) == -1)
-				goto error;
-			ipc.ip_data = fuword(ipc.ip_addr);
-			break;
+	ipc := &p.Sys.ipc
+	if ipc.lock != p.Pid {
+		return false
+	}
+	i := ipc.req
+	ipc.req = 0
+	p.Sys.wakeup(ipc)
+	switch i {
 
-		/* read u * /
-		case 3:
-			i = ipc.ip_addr;
-			if (i<0 || i >= (USIZE<<6))
-				goto error;
-			ipc.ip_data = u.inta[i>>1];
-			break;
+	/* read user I or D */
+	case 1, 2:
+		if i == 1 && p.imem() != nil {
+			ipc.data, _ = p.imem().ReadW(ipc.addr)
+			return false
+		}
+		if !p.mapped(ipc.addr, 2) {
+			break
+		}
+		ipc.data, _ = p.umem().ReadW(ipc.addr)
+		return false
 
-		/* write user I (for now, always an error) * /
-		case 4:
-			if (suiword(ipc.ip_addr, 0) < 0)
-				goto error;
-			suiword(ipc.ip_addr, ipc.ip_data);
-			break;
+	/* read u */
+	case 3:
+		if int(ipc.addr) >= USIZE<<6 {
+			break
+		}
+		ipc.data = 0
+		if r := p.ureg(ipc.addr); r >= 0 {
+			ipc.data = p.regs()[r]
+		}
+		return false
 
-		/* write user D * /
-		case 5:
-			if (suword(ipc.ip_addr, 0) < 0)
-				goto error;
-			suword(ipc.ip_addr, ipc.ip_data);
-			break;
+	/* write user I or D */
+	case 4, 5:
+		if i == 4 && p.imem() != nil {
+			/* write a copy, not the shared text */
+			p.itextw().WriteW(ipc.addr, ipc.data)
+			return false
+		}
+		if !p.mapped(ipc.addr, 2) {
+			break
+		}
+		p.umem().WriteW(ipc.addr, ipc.data)
+		return false
 
-		/* write u * /
-		case 6:
-			p = &u.inta[ipc.ip_addr>>1];
-			if (p >= u.u_fsav && p < &u.u_fsav[25])
-				goto ok;
-			for (i=0; i<9; i++)
-				if (p == &u.u_ar0[regloc[i]])
-					goto ok;
-			goto error;
-		ok:
-			if (p == &u.u_ar0[RPS]) {
-				ipc.ip_data =| 0170000;	/* assure user space * /
-				ipc.ip_data =& ~0340;	/* priority 0 * /
-			}
-			*p = ipc.ip_data;
-			break;
+	/* write u (only the registers) */
+	case 6:
+		r := p.ureg(ipc.addr)
+		if r < 0 {
+			break
+		}
+		if r == len(regloc)-1 {
+			/* user mode, priority 0: just the T bit and codes */
+			p.CPU.PS = pdp11.PS(ipc.data & 0o37)
+		} else {
+			p.CPU.R[r] = ipc.data
+		}
+		return false
 
-		/* set signal and continue * /
-		case 7:
-			u.u_procp->p_sig = ipc.ip_data;
-			return(1);
+	/* set signal and continue */
+	case 7:
+		p.sig = int8(ipc.data)
+		return true
 
-		/* force exit * /
-		case 8:
-			exit();
+	/* force exit */
+	case 8:
+		p.Args[0] = 0
+		p.exit()
+	}
+	ipc.req = -1
+	return false
+}
 
-		default:
-		error:
-			ipc.ip_req = -1;
+/*
+ * Return the index in regloc of the
+ * register at byte offset off in the
+ * user block, or -1 if there is none.
+ */
+func (p *Proc) ureg(off uint16) int {
+	for i, r := range regloc {
+		if int(off) == 2*(uar0+r) {
+			return i
 		}
-		return(0);
-	*/
+	}
+	return -1
+}
+
+// regs returns p's registers in regloc order.
+func (p *Proc) regs() [9]uint16 {
+	var r [9]uint16
+	copy(r[:], p.CPU.R[:])
+	r[8] = uint16(p.CPU.PS)
+	return r
 }
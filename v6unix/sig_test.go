@@ -0,0 +1,263 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package v6unix
+
+import (
+	"testing"
+
+	"rsc.io/unix/pdp11"
+)
+
+// sysTrap runs the system call made of words at 0o100 in p,
+// letting other processes run while p sleeps,
+// and returns r0 and the error.
+func sysTrap(t *testing.T, p *Proc, words ...uint16) (uint16, Errno) {
+	t.Helper()
+	const pc = 0o100
+	for i, w := range words {
+		p.Mem.WriteW(pc+2*uint16(i), w)
+	}
+	p.CPU.R[pdp11.PC] = pc
+	p.CPU.Inst = words[0]
+	done := make(chan error)
+	go func() { done <- Trap(p) }()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	return p.CPU.R[0], p.Error
+}
+
+func TestPtrace(t *testing.T) {
+	p := runningProc(t, 2)
+	p.DataSize = 0o1000 // data pages are 0 to 0o20000
+	p.CPU.R[pdp11.SP] = 0o177000
+
+	// The child runs this, traced.
+	p.Mem.WriteW(0o200, 0o005200) // inc r0
+	p.Mem.WriteW(0o202, 0o000003) // bpt
+	p.Mem.WriteW(0o204, 0o104401) // sys exit
+	p.CPU.R[pdp11.PC] = 0o200
+	c, err := p.Sys.Fork(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Args[2] = 0
+	sysptrace(c)
+	p.Sys.setrun(c)
+
+	wait := func() (pid uint16, w WaitStatus) {
+		t.Helper()
+		pid, err := sysTrap(t, p, 0o104407)
+		if err != 0 {
+			t.Fatalf("wait: %v", err)
+		}
+		return pid, WaitStatus(p.CPU.R[1])
+	}
+	ptrace := func(req, addr, data uint16) (uint16, Errno) {
+		p.CPU.R[0] = data
+		return sysTrap(t, p, 0o104432, uint16(c.Pid), addr, req)
+	}
+	const ( // as in cdb
+		RUSER  = 1
+		RUREGS = 3
+		WUSER  = 4
+		WUREGS = 6
+		CONTIN = 7
+		EXIT   = 8
+		uPS    = 2 * (512 - 1)
+		uPC    = 2 * (512 - 2)
+		uR0    = 2 * (512 - 3)
+	)
+
+	// The breakpoint stops the child.
+	if pid, w := wait(); pid != uint16(c.Pid) || !w.WIfStopped() || w.WStopSig() != SIGTRC {
+		t.Fatalf("wait = %d, %#o, want %d stopped by SIGTRC", pid, w, c.Pid)
+	}
+	if r0, err := ptrace(RUREGS, uR0, 0); r0 != 1 || err != 0 {
+		t.Errorf("read r0 = %d, %v, want 1", r0, err)
+	}
+	if pc, err := ptrace(RUREGS, uPC, 0); pc != 0o204 || err != 0 {
+		t.Errorf("read pc = %#o, %v, want 0o204", pc, err)
+	}
+	if w, err := ptrace(RUSER, 0o202, 0); w != 3 || err != 0 {
+		t.Errorf("read 0o202 = %#o, %v, want 3", w, err)
+	}
+	if _, err := ptrace(RUSER, 0o100000, 0); err != EIO {
+		t.Errorf("read unmapped memory: %v, want EIO", err)
+	}
+	if _, err := ptrace(WUREGS, 2, 0); err != EIO {
+		t.Errorf("write non-register word of u: %v, want EIO", err)
+	}
+
+	// Back up to the inc and single-step it.
+	ptrace(WUREGS, uPC, 0o200)
+	ptrace(WUREGS, uR0, 0o1234)
+	ptrace(WUREGS, uPS, 0o170000|_TBIT)
+	if _, err := ptrace(CONTIN, 0, 0); err != 0 {
+		t.Fatal(err)
+	}
+	if _, w := wait(); !w.WIfStopped() || w.WStopSig() != SIGTRC {
+		t.Fatalf("after step: wait status %#o, want stopped by SIGTRC", w)
+	}
+	if r0, _ := ptrace(RUREGS, uR0, 0); r0 != 0o1235 {
+		t.Errorf("after step: r0 = %#o, want 0o1235", r0)
+	}
+	if pc, _ := ptrace(RUREGS, uPC, 0); pc != 0o202 {
+		t.Errorf("after step: pc = %#o, want 0o202", pc)
+	}
+
+	// Replace the bpt with a nop and let the child finish.
+	ptrace(WUSER, 0o202, 0o000240)
+	ptrace(WUREGS, uPS, 0)
+	ptrace(CONTIN, 0, 0)
+	if pid, w := wait(); pid != uint16(c.Pid) || !w.WIfExited() || w.WExitStatus() != 0o235 {
+		t.Errorf("wait = %d, %#o, want %d exited with 0o235", pid, w, c.Pid)
+	}
+	if _, err := ptrace(RUREGS, uR0, 0); err != ESRCH {
+		t.Errorf("ptrace of exited child: %v, want ESRCH", err)
+	}
+
+	// A signal stops a traced child too, and the parent can kill it.
+	p.CPU.R[pdp11.PC] = 0o202
+	p.Mem.WriteW(0o204, 0o000776) // br .
+	c, err = p.Sys.Fork(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.flag |= _STRC
+	p.Sys.setrun(c)
+	p.Sys.psignal(c, SIGINT)
+	if _, w := wait(); !w.WIfStopped() || w.WStopSig() != SIGINT {
+		t.Fatalf("wait status %#o, want stopped by SIGINT", w)
+	}
+	ptrace(EXIT, 0, 0)
+	if pid, w := wait(); pid != uint16(c.Pid) || !w.WIfExited() {
+		t.Errorf("wait = %d, %#o, want %d exited", pid, w, c.Pid)
+	}
+}
+
+func TestPtraceExec(t *testing.T) {
+	p := rootProc(t)
+	p.flag |= _STRC
+	execAs(t, p, "/bin/ls", 5, 3)
+	if p.sig != SIGTRC {
+		t.Errorf("traced exec: signal %d, want SIGTRC", p.sig)
+	}
+	if r, e := getuid(p); r != 5 || e != 5 {
+		t.Errorf("traced exec of set-uid file: ruid %d euid %d, want 5 5", r, e)
+	}
+}
+
+func TestSetpgrp(t *testing.T) {
+	p := rootProc(t)
+	p.Pid = 2
+	p.Sys.NextPid = 3
+	other := &Proc{Sys: p.Sys}
+	other.Pid, other.Ppid = 10, 1
+	p.Sys.Procs = []*Proc{p, other}
+	setpgrp := func(pid, pgrp int16) Errno {
+		p.Error = 0
+		p.CPU.R[0], p.Args[0] = uint16(pid), uint16(pgrp)
+		syssetpgrp(p)
+		return p.Error
+	}
+	getpgrp := func(pid int16) (int16, Errno) {
+		p.Error = 0
+		p.CPU.R[0] = uint16(pid)
+		sysgetpgrp(p)
+		return int16(p.CPU.R[0]), p.Error
+	}
+
+	if err := setpgrp(0, 0); err != 0 || p.Pgrp != 2 {
+		t.Errorf("setpgrp(0, 0) = %v, pgrp %d, want 2", err, p.Pgrp)
+	}
+	c, err := p.Sys.Fork(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, err := getpgrp(c.Pid); g != 2 || err != 0 {
+		t.Errorf("child getpgrp = %d, %v, want inherited 2", g, err)
+	}
+	if err := setpgrp(c.Pid, 0); err != 0 || c.Pgrp != c.Pid {
+		t.Errorf("setpgrp(child, 0) = %v, pgrp %d, want %d", err, c.Pgrp, c.Pid)
+	}
+	if err := setpgrp(c.Pid, 2); err != 0 || c.Pgrp != 2 {
+		t.Errorf("setpgrp(child, 2) = %v, pgrp %d, want 2", err, c.Pgrp)
+	}
+	if g, err := getpgrp(0); g != 2 || err != 0 {
+		t.Errorf("getpgrp(0) = %d, %v, want 2", g, err)
+	}
+	if err := setpgrp(other.Pid, 2); err != ESRCH {
+		t.Errorf("setpgrp of unrelated process = %v, want ESRCH", err)
+	}
+	if _, err := getpgrp(99); err != ESRCH {
+		t.Errorf("getpgrp(99) = %v, want ESRCH", err)
+	}
+
+	p.Args[0], p.Args[1] = SIGSTOP, 0o2000
+	if syssig(p); p.Error != EINVAL {
+		t.Errorf("catching SIGSTOP: %v, want EINVAL", p.Error)
+	}
+}
+
+func TestJobControl(t *testing.T) {
+	p := runningProc(t, 2)
+	p.Pgrp = 2
+	p.DataSize = 0o2000
+	p.CPU.R[pdp11.SP] = 0o177000
+	tty := &p.Sys.TTY[1]
+	tty.Print = func(b []byte, echo bool) (int, Errno) { return len(b), 0 }
+	p.open("/dev/tty1", 0)
+	if p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	tty.Pgrp = p.Pgrp
+
+	// The child reads the tty in a background group.
+	p.Mem.WriteW(0o200, 0o104403) // sys read; 0o1000; 10
+	p.Mem.WriteW(0o202, 0o1000)
+	p.Mem.WriteW(0o204, 10)
+	p.Mem.WriteW(0o206, 0o104401) // sys exit
+	p.CPU.R[pdp11.PC] = 0o200
+	c, err := p.Sys.Fork(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Pgrp = c.Pid
+	p.Sys.setrun(c)
+	wait := func() WaitStatus {
+		t.Helper()
+		if pid, err := sysTrap(t, p, 0o104407); pid != uint16(c.Pid) || err != 0 {
+			t.Fatalf("wait = %d, %v, want %d", pid, err, c.Pid)
+		}
+		return WaitStatus(p.CPU.R[1])
+	}
+	if w := wait(); !w.WIfStopped() || w.WStopSig() != SIGTTIN {
+		t.Fatalf("background read: wait status %#o, want stopped by SIGTTIN", w)
+	}
+
+	// Interrupts go only to the foreground group.
+	typeString(tty, "\177")
+	if p.sig != SIGINT || c.sig != SIGTTIN {
+		t.Errorf("after DEL: signals %d, %d, want SIGINT to parent only", p.sig, c.sig)
+	}
+	p.sig = 0
+
+	// Brought to the foreground and continued, the child reads.
+	const addr = 0o1000
+	p.Mem.WriteW(addr, uint16(c.Pgrp))
+	tty.ioctl(p, TIOCSPGRP, addr)
+	p.Mem.WriteW(addr, 0)
+	if tty.ioctl(p, TIOCGPGRP, addr); tty.Pgrp != c.Pgrp || p.Mem[addr] != uint8(c.Pgrp) {
+		t.Errorf("TIOCSPGRP, TIOCGPGRP: foreground %d, got %d, want %d", tty.Pgrp, p.Mem[addr], c.Pgrp)
+	}
+	typeString(tty, "hi\n")
+	if p.kill(-c.Pgrp, SIGCONT); p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	if w := wait(); !w.WIfExited() || w.WExitStatus() != 3 {
+		t.Errorf("continued child: wait status %#o, want exit 3 after reading 3 bytes", w)
+	}
+}
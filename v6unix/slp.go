@@ -12,7 +12,10 @@
 
 package v6unix
 
-import "runtime"
+import (
+	"runtime"
+	"time"
+)
 
 /*
  * Give up the processor till a wakeup occurs
@@ -27,24 +30,79 @@ import "runtime"
  */
 func (p *Proc) sleep(wkey any, wchan int16, pri int8) {
 	if pri >= 0 && p.issig() {
-		panic("sleep interrupted")
+		panic(sleepInterrupted)
 	}
 
 	p.wkey = wkey
 	p.wchan = wchan
 	p.status = _SWAIT
+	p.pri = pri
+	p.Sys.procGen++
 	p.swtch()
 	if pri >= 0 && p.issig() {
-		panic("qsav")
+		panic(sleepInterrupted) // aretu(u.u_qsav)
+	}
+}
+
+// sleepInterrupted is the panic value for a sleep cut short by a signal.
+// Trap recovers it and fails the system call with EINTR.
+const sleepInterrupted = "sleep interrupted"
+
+/*
+ * Sleep until the time end,
+ * using the system timer.
+ */
+func (p *Proc) sleepUntil(end time.Time, pri int8) {
+	for p.Sys.clockTime().Before(end) {
+		p.Sys.setTimer(end)
+		p.sleep(&p.Sys.Timer, 't', pri)
+	}
+}
+
+// setTimer makes sure the system timer goes off by t.
+func (sys *System) setTimer(t time.Time) {
+	if sys.Timer.IsZero() || sys.Timer.After(t) {
+		sys.Timer = t
+	}
+}
+
+/*
+ * The clock, called when the system timer
+ * may have gone off: from Wait, when every
+ * process is asleep, and between instructions.
+ * Wake the sleepers on the timer and send
+ * SIGALRM to processes whose alarm is due,
+ * then set the timer for the next alarm.
+ */
+func (sys *System) clock() {
+	now := sys.clockTime()
+	if sys.Timer.IsZero() || now.Before(sys.Timer) {
+		return
+	}
+	sys.Timer = time.Time{}
+	sys.wakeup(&sys.Timer)
+	for _, p := range sys.Procs {
+		if p.clktim.IsZero() {
+			continue
+		}
+		if !now.Before(p.clktim) {
+			p.clktim = time.Time{}
+			sys.psignal(p, SIGALRM)
+			continue
+		}
+		sys.setTimer(p.clktim)
 	}
 }
 
 /*
- * Wake up all processes sleeping on chan.
+ * Wake up all processes sleeping on chan,
+ * and those in select, which look again
+ * at the files they are waiting for.
+ * (4.2BSD wakes select only from the drivers.)
  */
 func (sys *System) wakeup(wkey any) {
 	for _, p := range sys.Procs {
-		if p.wkey == wkey {
+		if p.wkey == wkey || p.wkey == &sys.selwait {
 			sys.setrun(p)
 		}
 	}
@@ -61,6 +119,7 @@ func (sys *System) setrun(p *Proc) {
 	p.wkey = nil
 	p.wchan = 0
 	p.status = _SRUN
+	sys.procGen++
 	if p.pri < sys.curpri {
 		sys.runrun++
 	}
@@ -87,25 +146,35 @@ func (p *Proc) setpri(p1 *Proc) {
 // Note: There is no sched, because everything is in core.
 
 func (p *Proc) swtch() {
-	var next *Proc
-
 	for {
 		/*
-		 * Search for highest-priority runnable process
+		 * Search for highest-priority runnable process,
+		 * starting after the last one chosen, so that
+		 * processes of equal priority take turns.
 		 */
-		i := p.Sys.swtchpos
+		var next *Proc
+		i := p.Sys.swtchpos + 1
 		for j := range p.Sys.Procs {
 			i := (i + j) % len(p.Sys.Procs)
 			p1 := p.Sys.Procs[i]
-			if p1.status == _SRUN && (next == nil || p.pri < next.pri) {
+			if p1.status == _SRUN && (next == nil || p1.pri < next.pri) {
 				next = p1
 				p.Sys.swtchpos = i
 			}
 		}
+		p.Sys.runrun = 0
 
 		/*
 		 * If no process is runnable, idle.
+		 * If the best is this one, keep running.
 		 */
+		if next != nil {
+			p.Sys.curpri = next.pri
+		}
+		if next == p {
+			p.Sys.newSlice()
+			return
+		}
 		if next != nil {
 			if next.sched == nil {
 				panic("swtch")
@@ -122,4 +191,19 @@ func (p *Proc) swtch() {
 			break
 		}
 	}
+	p.Sys.newSlice()
+}
+
+// DefaultQuantum is the number of instructions a process
+// runs before giving up the processor in deterministic mode,
+// when System.Quantum is zero.
+const DefaultQuantum = 10000
+
+// newSlice starts the quantum of the process
+// about to run, in deterministic mode.
+func (sys *System) newSlice() {
+	sys.slice = sys.Quantum
+	if sys.slice <= 0 {
+		sys.slice = DefaultQuantum
+	}
 }
@@ -37,14 +37,23 @@ func sysexec(p *Proc) {
 	if !p.access(ip, _IEXEC) {
 		return
 	}
-	if ip.mode&_IFMT != 0 || len(ip.data) < 4*2 {
+	if ip.mode&_IFMT != 0 {
+		p.Error = EACCES
+		return
+	}
+	data := p.program(ip)
+	if len(data) < 4*2 {
 		p.Error = ENOEXEC
 		return
 	}
 
-	// load arguments
-	const maxArgv = 256
+	/*
+	 * load arguments;
+	 * the strings, with their NULs,
+	 * may take at most NCARGS bytes
+	 */
 	var argv []string
+	nc := 0
 	for addr := p.Args[1]; ; addr += 2 {
 		ap, err := p.CPU.ReadW(addr)
 		if err != nil {
@@ -58,14 +67,32 @@ func sysexec(p *Proc) {
 		if p.Error != 0 {
 			return
 		}
-		argv = append(argv, s)
-		if len(argv) > maxArgv {
+		if nc += len(s) + 1; nc > NCARGS {
 			p.Error = E2BIG
 			return
 		}
+		argv = append(argv, s)
 	}
 
-	p.exec(ip.data, argv, ip)
+	p.exec(data, argv, ip)
+}
+
+/*
+ * Return the program in ip.
+ * The program of a sticky (save text)
+ * file is kept with the inode after use,
+ * as v6 kept its text on the swap device,
+ * so the next exec need not read it again.
+ */
+func (p *Proc) program(ip *inode) []byte {
+	if ip.mode&_ISVTX == 0 {
+		ip.text = nil
+		return p.contents(ip)
+	}
+	if ip.text == nil {
+		ip.text = p.contents(ip)
+	}
+	return ip.text
 }
 
 func (p *Proc) exec(aout []byte, argv []string, ip *inode) {
@@ -85,37 +112,52 @@ func (p *Proc) exec(aout []byte, argv []string, ip *inode) {
 		ds = int(hdr[2])
 		sep = hdr[0]&1 != 0
 	}
-	_ = sep
+	bs := int(hdr[3])
 	if 0o20+ts+ds > len(aout) || (ts|ds)&1 != 0 {
 		p.Error = ENOEXEC
 		return
 	}
+	if ts != 0 && ip != nil && !ip.itext && ip.count != 1 {
+		p.Error = ETXTBSY /* open for writing, perhaps */
+		return
+	}
+	/*
+	 * split I&D programs have 64K
+	 * for each of text and data,
+	 * limited only by segfit below
+	 */
 	const maxText = 50000
-	if ts+ds > maxText {
+	if !sep && ts+ds > maxText {
 		p.Error = E2BIG
 		return
 	}
 
+	/*
+	 * split I&D text has its own
+	 * space, with data starting at 0
+	 */
 	const round = 0o20000
 	tsr := (ts + round - 1) &^ (round - 1)
+	if sep {
+		tsr = 0
+	}
 
 	// lay out new memory image
 	var mem pdp11.ArrayMem
-	copy(mem[:ts], aout[0o20:])
+	if !sep {
+		copy(mem[:ts], aout[0o20:])
+	}
 	copy(mem[tsr:tsr+ds], aout[0o20+ts:])
 
-	na := (1 + len(argv) + 1) * 2
+	nc := 0
 	for _, s := range argv {
-		na += len(s) + 1
+		nc += len(s) + 1
 	}
-	cp := uint16(0)
-	for i := len(argv) - 1; i >= 0; i-- {
-		cp -= uint16(len(argv[i]) + 1)
-	}
-	if -cp > 510 {
+	if nc > NCARGS {
 		p.Error = E2BIG
 		return
 	}
+	cp := -uint16(nc)
 	ap := cp - cp&1
 	ap -= 2
 	*(*uint16)(unsafe.Pointer(&mem[ap])) = ^uint16(0)
@@ -131,29 +173,59 @@ func (p *Proc) exec(aout []byte, argv []string, ip *inode) {
 	ap -= 2
 	*(*uint16)(unsafe.Pointer(&mem[ap])) = uint16(len(argv))
 	sp := ap
+	if !segfit(tsr+ds+bs, sp) {
+		p.Error = ENOMEM
+		return
+	}
 
+	p.vrelse()
+	p.xfree()
+	p.xalloc(ip, ts)
+	p.CPU.IMem = nil
+	if sep {
+		var imem *pdp11.ArrayMem
+		if p.text != nil {
+			imem = p.text.imem
+		}
+		if imem == nil {
+			imem = new(pdp11.ArrayMem)
+			copy(imem[:], aout[0o20:0o20+ts])
+			if p.text != nil {
+				p.text.imem = imem
+			}
+		}
+		p.CPU.IMem = ispace{imem}
+	}
+	p.cowfree()
 	p.Mem = mem
 	if hdr[0] == 0o407 {
 		p.TextSize = hdr[1]
 		p.DataStart = hdr[1]
-		p.DataSize = hdr[2]
+		p.DataSize = hdr[2] + uint16(bs)
 	} else {
 		p.TextSize = uint16(ts)
 		p.DataStart = uint16(tsr)
-		p.DataSize = uint16(ds)
+		p.DataSize = uint16(ds + bs)
 	}
 
-	// TODO check STRC
-	if true && ip != nil {
-		if ip.mode&_ISUID != 0 {
-			if p.Uid != 0 {
+	/*
+	 * set SUID/SGID protections, if no tracing
+	 * and not on a file system mounted nosuid
+	 */
+	if p.flag&_STRC == 0 {
+		if ip != nil && !ip.disk.nosuid {
+			if ip.mode&_ISUID != 0 && p.Uid != 0 {
 				p.Uid = ip.uid
 			}
+			if ip.mode&_ISGID != 0 {
+				p.Gid = ip.gid
+			}
 		}
-		if ip.mode&_ISGID != 0 {
-			p.Gid = ip.gid
-		}
+	} else {
+		p.Sys.psignal(p, SIGTRC)
 	}
+	p.SUid = p.Uid
+	p.SGid = p.Gid
 
 	// clear sigs, regs, and return
 	for i := range p.Signals {
@@ -161,6 +233,13 @@ func (p *Proc) exec(aout []byte, argv []string, ip *inode) {
 			p.Signals[i] = 0
 		}
 	}
+	p.Prof[3] = 0 // stop profiling (v7)
+	for i, f := range p.Files {
+		if f != nil && p.fdflag[i]&FD_CLOEXEC != 0 {
+			p.Files[i] = nil
+			p.closef(f)
+		}
+	}
 	clear(p.CPU.R[:])
 	p.CPU.R[pdp11.SP] = sp
 
@@ -187,7 +266,7 @@ func sysexit(p *Proc) {
  * and dispose of children.
  */
 func (p *Proc) exit() {
-	// p.flag &^= _STRC
+	p.flag &^= _STRC
 	for i := range p.Signals {
 		p.Signals[i] = 1
 	}
@@ -197,7 +276,12 @@ func (p *Proc) exit() {
 		}
 	}
 	p.iput(p.Dir)
+	p.iput(p.Root)
+	p.vrelse()
+	p.xfree()
+	p.cowfree()
 	p.status = _SZOMB
+	p.Sys.procGen++
 
 	parent := p.Sys.lookpid(p.Ppid)
 	if parent == nil {
@@ -220,6 +304,46 @@ func (p *Proc) exit() {
 	p.swtch()
 }
 
+// A WaitStatus is the status of a process as reported by wait:
+// the low byte is the signal that killed the process or 0 if it exited,
+// with 0o200 set if it dumped core, and the high byte is its exit code.
+// A low byte of 0o177 means the process is stopped for tracing,
+// with the stopping signal in the high byte.
+type WaitStatus uint16
+
+// WIfExited reports whether the process exited by calling exit.
+func (w WaitStatus) WIfExited() bool { return w&0o377 == 0 }
+
+// WIfSignaled reports whether the process was killed by a signal.
+func (w WaitStatus) WIfSignaled() bool { return w&0o177 != 0 && w&0o177 != 0o177 }
+
+// WIfStopped reports whether the process is stopped for tracing.
+func (w WaitStatus) WIfStopped() bool { return w&0o377 == 0o177 }
+
+// WExitStatus returns the exit code of a process that exited.
+func (w WaitStatus) WExitStatus() int { return int(w >> 8) }
+
+// WTermSig returns the signal that killed the process.
+func (w WaitStatus) WTermSig() int { return int(w & 0o177) }
+
+// WCoreDump reports whether the killed process dumped core.
+func (w WaitStatus) WCoreDump() bool { return w.WIfSignaled() && w&0o200 != 0 }
+
+// WStopSig returns the signal that stopped the process.
+func (w WaitStatus) WStopSig() int { return int(w >> 8) }
+
+/*
+ * Add n to the 32-bit count a,
+ * high word first, as the PDP-11 keeps longs.
+ */
+func dpadd(a *[2]int16, n int16) {
+	lo := uint16(a[1]) + uint16(n)
+	if lo < uint16(n) {
+		a[0]++
+	}
+	a[1] = int16(lo)
+}
+
 func syswait(p *Proc) {
 	for {
 		found := 0
@@ -228,23 +352,28 @@ func syswait(p *Proc) {
 				found++
 				if p1.status == _SZOMB {
 					p.Sys.Procs = slices.Delete(p.Sys.Procs, i, i+1)
+					p.Sys.procGen++
 					p.CSTime[0] += p1.CSTime[0]
-					p.CSTime[1] += p1.CSTime[1]
+					dpadd(&p.CSTime, p1.CSTime[1])
+					dpadd(&p.CSTime, p1.STime)
 					p.CUTime[0] += p1.CUTime[0]
-					p.CUTime[1] += p1.CUTime[1]
+					dpadd(&p.CUTime, p1.CUTime[1])
+					dpadd(&p.CUTime, p1.UTime)
 					p.CPU.R[0] = uint16(p1.Pid)
 					p.CPU.R[1] = p1.Args[0] // wait status
 					return
 				}
 				if p1.status == _SSTOP {
 					if p1.flag&_SWTED == 0 {
-						p.flag |= _SWTED
+						p1.flag |= _SWTED
 						p.CPU.R[0] = uint16(p1.Pid)
 						p.CPU.R[1] = uint16(p1.sig)<<8 | 0o177
 						return
 					}
-					p1.flag &^= _STRC | _SWTED
-					p.Sys.setrun(p1)
+					if p1.flag&_STRC != 0 {
+						p1.flag &^= _STRC | _SWTED
+						p.Sys.setrun(p1)
+					}
 				}
 			}
 		}
@@ -256,6 +385,44 @@ func syswait(p *Proc) {
 	}
 }
 
+/*
+ * break system call.
+ *  -- bad planning: "break" is a dirty word in C.
+ * The C library's brk and sbrk are built on it.
+ */
 func sysbreak(p *Proc) {
+	/*
+	 * set n to the new end of data,
+	 * which cannot be below its start
+	 */
+	n := int(p.Args[0])
+	if n < int(p.DataStart) {
+		n = int(p.DataStart)
+	}
+	if !segfit(n, p.CPU.R[pdp11.SP]) {
+		p.Error = ENOMEM
+		return
+	}
+	a := p.brk()
+	if n > a {
+		clear(p.umemRange(a, n-a))
+	}
+	p.DataSize = uint16(n - int(p.DataStart))
+}
+
+/*
+ * Report whether a data segment ending at brk
+ * and a stack reaching down to sp fit in the
+ * eight 8K pages of the address space without
+ * sharing one, as the segmentation registers
+ * would require (estabur in v6).
+ */
+func segfit(brk int, sp uint16) bool {
+	const page = 8192
+	return (brk+page-1)&^(page-1) <= int(sp)&^(page-1)
+}
 
+// brk returns the address just past p's data segment.
+func (p *Proc) brk() int {
+	return int(p.DataStart) + int(p.DataSize)
 }
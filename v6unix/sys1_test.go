@@ -0,0 +1,960 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package v6unix
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"testing"
+	"unsafe"
+
+	"rsc.io/unix/pdp11"
+)
+
+func TestWaitStatus(t *testing.T) {
+	tests := []struct {
+		w                      WaitStatus
+		exited, signaled, core bool
+		code, sig              int
+	}{
+		{0, true, false, false, 0, 0},
+		{3 << 8, true, false, false, 3, 0},
+		{SIGKIL, false, true, false, 0, SIGKIL},
+		{0o200 | SIGSEG, false, true, true, 0, SIGSEG},
+	}
+	for _, tt := range tests {
+		if tt.w.WIfExited() != tt.exited || tt.w.WIfSignaled() != tt.signaled || tt.w.WCoreDump() != tt.core ||
+			tt.w.WExitStatus() != tt.code || tt.w.WTermSig() != tt.sig || tt.w.WIfStopped() {
+			t.Errorf("%#o: exited %v signaled %v core %v code %d sig %d", tt.w,
+				tt.w.WIfExited(), tt.w.WIfSignaled(), tt.w.WCoreDump(), tt.w.WExitStatus(), tt.w.WTermSig())
+		}
+	}
+	if w := WaitStatus(SIGTRC<<8 | 0o177); !w.WIfStopped() || w.WIfSignaled() || w.WStopSig() != SIGTRC {
+		t.Errorf("%#o: stopped %v signaled %v stopsig %d", w, w.WIfStopped(), w.WIfSignaled(), w.WStopSig())
+	}
+}
+
+// killChild forks a child of p, sends it sig,
+// and returns the status wait reports for it.
+func killChild(t *testing.T, p *Proc, sig int) WaitStatus {
+	t.Helper()
+	c, err := p.Sys.Fork(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kill(t, p, c, sig)
+}
+
+// kill sends sig to p's child c and returns
+// the status wait reports for it.
+func kill(t testing.TB, p, c *Proc, sig int) WaitStatus {
+	t.Helper()
+	p.Sys.setrun(c)
+	p.Sys.psignal(c, sig)
+
+	// sys wait
+	const pc = 0o100
+	p.CPU.Mem.WriteW(pc, 0o104407)
+	p.CPU.R[pdp11.PC] = pc
+	p.CPU.Inst = 0o104407
+	done := make(chan error)
+	go func() { done <- Trap(p) }()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if p.CPU.PS.C() != 0 || p.CPU.R[0] != uint16(c.Pid) {
+		t.Fatalf("wait: C=%d r0=%d, want C=0 r0=%d", p.CPU.PS.C(), p.CPU.R[0], c.Pid)
+	}
+	return WaitStatus(p.CPU.R[1])
+}
+
+func TestWaitKilled(t *testing.T) {
+	p := runningProc(t, 1)
+	p.Sys.EnableCoreDumps = true
+
+	w := killChild(t, p, SIGKIL)
+	if !w.WIfSignaled() || w.WTermSig() != SIGKIL || w.WCoreDump() {
+		t.Errorf("killed child: status %#o, want SIGKIL without core", w)
+	}
+	if _, err := lookup(p, "/core"); err != ENOENT {
+		t.Errorf("SIGKIL left a core file")
+	}
+
+	w = killChild(t, p, SIGSEG)
+	if !w.WIfSignaled() || w.WTermSig() != SIGSEG || !w.WCoreDump() {
+		t.Errorf("child with segmentation fault: status %#o, want SIGSEG with core", w)
+	}
+	if _, err := lookup(p, "/core"); err != 0 {
+		t.Errorf("no core file: %v", err)
+	}
+}
+
+func TestCoreDump(t *testing.T) {
+	p := runningProc(t, 1)
+	p.DataStart = 0o100
+	p.DataSize = 0o50 // rounds up to 0o100 bytes
+	p.CPU.R[pdp11.SP] = 0o177010
+	p.Mem[0o102] = 1 // after the wait trap killChild writes
+	p.Mem[0o177000] = 2
+	core := func() []byte {
+		t.Helper()
+		ip, _, _ := p.namei("/core", nameFind)
+		if ip == nil {
+			return nil
+		}
+		defer p.iput(ip)
+		return p.contents(ip)
+	}
+
+	// Off by default.
+	if w := killChild(t, p, SIGSEG); w.WCoreDump() || core() != nil {
+		t.Errorf("core dumps disabled: status %#o, core file %v", w, core() != nil)
+	}
+
+	// The user block, then the data, then the stack from sp's click.
+	p.Sys.EnableCoreDumps = true
+	if w := killChild(t, p, SIGQIT); w.WTermSig() != SIGQIT || !w.WCoreDump() {
+		t.Errorf("quit: status %#o, want SIGQIT with core", w)
+	}
+	b := core()
+	if want := USIZE*64 + 0o100 + 0o1000; len(b) != want {
+		t.Fatalf("core is %d bytes, want %d", len(b), want)
+	}
+	if b[USIZE*64+2] != 1 || b[USIZE*64+0o100] != 2 {
+		t.Errorf("core data and stack begin %d, %d, want 1, 2", b[USIZE*64+2], b[USIZE*64+0o100])
+	}
+
+	// CoreLimit cuts the file short.
+	p.Sys.CoreLimit = USIZE*64 + 10
+	if w := killChild(t, p, SIGSEG); !w.WCoreDump() {
+		t.Errorf("limited dump: status %#o, want core", w)
+	}
+	if b := core(); len(b) != p.Sys.CoreLimit {
+		t.Errorf("limited core is %d bytes, want %d", len(b), p.Sys.CoreLimit)
+	}
+}
+
+func TestForkTableFull(t *testing.T) {
+	p := rootProc(t)
+	p.Sys.Procs = []*Proc{p}
+	for len(p.Sys.Procs) < NPROC {
+		p.Sys.Procs = append(p.Sys.Procs, &Proc{Sys: p.Sys})
+	}
+	p.CPU.R[pdp11.PC] = 0o100
+	if sysfork(p); p.Error != EAGAIN {
+		t.Errorf("fork with full process table: %v, want EAGAIN", p.Error)
+	}
+	if len(p.Sys.Procs) != NPROC || p.CPU.R[pdp11.PC] != 0o100 {
+		t.Errorf("failed fork changed the process table or pc")
+	}
+}
+
+func TestTextBusy(t *testing.T) {
+	p := rootProc(t)
+	p.CPU.Mem = &p.Mem
+	p.Args[0], p.Args[1] = strArg(p, 0o1000, "/bin/ls"), 0o1200 // pure (0410)
+	copy(p.Mem[0o1200:], "\x00\x00")
+	if sysexec(p); p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	c, err := p.Sys.Fork(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.text == nil || c.text != p.text || p.text.count != 2 {
+		t.Fatalf("forked child does not share the text")
+	}
+
+	canWrite := func() Errno {
+		p.Error = 0
+		p.open("/bin/ls", 1)
+		if p.Error == 0 {
+			closefd(p, p.CPU.R[0])
+		}
+		return p.Error
+	}
+	if err := canWrite(); err != ETXTBSY {
+		t.Errorf("open of running program for writing: %v, want ETXTBSY", err)
+	}
+	p.Args[0], p.Args[1] = strArg(p, 0o1000, "/bin/ls"), 0o755
+	p.Error = 0
+	if syscreate(p); p.Error != ETXTBSY {
+		t.Errorf("creat of running program: %v, want ETXTBSY", p.Error)
+	}
+
+	// The text is busy until the last process using it lets go, as in exit.
+	p.xfree()
+	if err := canWrite(); err != ETXTBSY {
+		t.Errorf("open for writing while the child runs: %v, want ETXTBSY", err)
+	}
+	c.xfree()
+	if err := canWrite(); err != 0 {
+		t.Errorf("open for writing after the last exit: %v, want success", err)
+	}
+}
+
+func TestExecArgs(t *testing.T) {
+	p := rootProc(t)
+	p.CPU.Mem = &p.Mem
+	exec := func(argv []string) Errno {
+		t.Helper()
+		p.Error = 0
+		p.Args[0], p.Args[1] = strArg(p, 0o1000, "/bin/ls"), 0o1200
+		cp := uint16(0o2000)
+		for i, s := range argv {
+			p.Mem.WriteW(0o1200+2*uint16(i), strArg(p, cp, s))
+			cp += uint16(len(s) + 1)
+		}
+		p.Mem.WriteW(0o1200+2*uint16(len(argv)), 0)
+		sysexec(p)
+		return p.Error
+	}
+
+	// NCARGS bytes of strings, with their NULs, is the most exec copies.
+	argv := make([]string, NCARGS/5)
+	for i := range argv {
+		argv[i] = fmt.Sprintf("a%03d", i)
+	}
+	if err := exec(argv); err != 0 {
+		t.Fatalf("exec of %d argument bytes: %v", NCARGS, err)
+	}
+	sp := p.CPU.R[pdp11.SP]
+	if argc, _ := p.Mem.ReadW(sp); int(argc) != len(argv) {
+		t.Fatalf("argc = %d, want %d", argc, len(argv))
+	}
+	for i, s := range argv {
+		ap, _ := p.Mem.ReadW(sp + 2 + 2*uint16(i))
+		if got := p.str(ap); got != s {
+			t.Fatalf("argv[%d] = %q, want %q", i, got, s)
+		}
+	}
+	if end, _ := p.Mem.ReadW(sp + 2 + 2*uint16(len(argv))); end != 0o177777 {
+		t.Errorf("argv ends with %#o, want -1", end)
+	}
+
+	// One more byte is too many, and the old image stays.
+	argv[0] += "x"
+	if err := exec(argv); err != E2BIG {
+		t.Errorf("exec of %d argument bytes: %v, want E2BIG", NCARGS+1, err)
+	}
+	if p.CPU.R[pdp11.SP] != sp {
+		t.Errorf("failed exec changed the stack pointer")
+	}
+	ls, err := p.Sys.ReadFile("/bin/ls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	long := string(make([]byte, 0o100000))
+	p.Error = 0
+	if p.exec(ls, []string{long, long, "x"}, nil); p.Error != E2BIG {
+		t.Errorf("exec of 64K of arguments: %v, want E2BIG", p.Error)
+	}
+}
+
+func TestZombie(t *testing.T) {
+	p := runningProc(t, 1)
+
+	c, err := p.Sys.Fork(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gc := &Proc{Sys: p.Sys}
+	gc.Pid, gc.Ppid = 99, c.Pid
+	p.Sys.Procs = append(p.Sys.Procs, gc)
+	p.Sys.setrun(c)
+	p.Sys.psignal(c, SIGKIL)
+
+	// sys sleep; the child runs and dies while the parent sleeps.
+	const pc = 0o100
+	p.Mem.WriteW(pc, 0o104443)
+	p.CPU.R[pdp11.PC] = pc
+	p.CPU.Inst = 0o104443
+	p.CPU.R[0] = 3600
+	p.Signals[SIGINT] = 0o2000
+	done := make(chan error)
+	go func() { done <- Trap(p) }()
+	<-p.Sys.idle
+
+	if c.status != _SZOMB || p.Sys.lookpid(c.Pid) != c {
+		t.Errorf("dead child: status %d, in table %v, want zombie in table", c.status, p.Sys.lookpid(c.Pid) != nil)
+	}
+	if gc.Ppid != 1 {
+		t.Errorf("orphan's parent = %d, want 1", gc.Ppid)
+	}
+	b := make([]byte, NPROC*unsafe.Sizeof(procState{}))
+	n := kmemdev{}.read(p, kmemMinor, b, memProcs)
+	procs := unsafe.Slice((*procState)(unsafe.Pointer(&b[0])), n/int(unsafe.Sizeof(procState{})))
+	found := false
+	for _, ps := range procs {
+		if ps.Pid == c.Pid {
+			found = ps.status == _SZOMB
+		}
+	}
+	if !found {
+		t.Errorf("zombie not shown in /dev/kmem process table")
+	}
+
+	sys := p.Sys
+	sys.psignal(p, SIGINT)
+	p.sched <- true
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	p.Error = 0
+	if syswait(p); p.Error != 0 || p.CPU.R[0] != uint16(c.Pid) || WaitStatus(p.CPU.R[1]).WTermSig() != SIGKIL {
+		t.Errorf("wait = %d, status %#o, %v; want %d, SIGKIL", p.CPU.R[0], p.CPU.R[1], p.Error, c.Pid)
+	}
+	if sys.lookpid(c.Pid) != nil {
+		t.Errorf("zombie still in process table after wait")
+	}
+}
+
+func TestBreak(t *testing.T) {
+	p := rootProc(t)
+	execAs(t, p, "/bin/ls", 0, 0)
+	brk := func(addr int) Errno {
+		p.Error = 0
+		p.Args[0] = uint16(addr)
+		sysbreak(p)
+		return p.Error
+	}
+
+	// Growing zero-fills the new memory.
+	end := p.brk()
+	for i := end; i < end+100; i++ {
+		p.Mem[i] = 0xff
+	}
+	if err := brk(end + 100); err != 0 || p.brk() != end+100 {
+		t.Fatalf("break(%#o) = %v, break now %#o", end+100, err, p.brk())
+	}
+	for i := end; i < end+100; i++ {
+		if p.Mem[i] != 0 {
+			t.Fatalf("new memory at %#o = %#o, want 0", i, p.Mem[i])
+		}
+	}
+
+	// The data can shrink but not below its start.
+	if err := brk(0); err != 0 || p.brk() != int(p.DataStart) {
+		t.Errorf("break(0) = %v, break now %#o, want %#o", err, p.brk(), p.DataStart)
+	}
+
+	// The data cannot grow into the stack's page.
+	sp := p.CPU.R[pdp11.SP]
+	if err := brk(int(sp) - 2); err != ENOMEM || p.brk() != int(p.DataStart) {
+		t.Errorf("break into stack = %v, break now %#o, want ENOMEM, %#o", err, p.brk(), p.DataStart)
+	}
+	if err := brk(int(sp) &^ 8191); err != 0 {
+		t.Errorf("break up to the stack's page = %v, want 0", err)
+	}
+	if segfit(p.brk(), sp-8192) {
+		t.Errorf("stack grown into the data's page fits")
+	}
+
+	// /dev/kmem reports the size, and ps finds the stack from it.
+	p.Sys.Procs = []*Proc{p}
+	b := make([]byte, unsafe.Sizeof(procState{}))
+	kmemdev{}.read(p, kmemMinor, b, memProcs)
+	ps := (*procState)(unsafe.Pointer(&b[0]))
+	if want := USIZE + (int(sp)&^8191)/64 + (1<<16-int(sp)+63)/64; ps.size != int16(want) {
+		t.Errorf("proc size = %d, want %d", ps.size, want)
+	}
+	off := int(ps.addr+uint16(ps.size)-8) << 6
+	stack := make([]byte, 512)
+	if n := (memdev{}).read(p, 0, stack, off); n != 512 || !bytes.Equal(stack, p.Mem[1<<16-512:]) {
+		t.Errorf("mem read at %#o = %d, not the stack", off, n)
+	}
+}
+
+// BenchmarkFork measures fork of a process using its whole
+// address space, with and without an exec in the child,
+// and vfork followed by exec.
+// Fork shares the 64K memory copy-on-write; for comparison,
+// the eager cases copy it all at once, as fork used to.
+// Exec replaces the memory with a new image.
+// Vfork shares nothing, though the child's own memory,
+// which exec fills, is still allocated with the process.
+// Each child is then killed and waited for, untimed.
+func BenchmarkFork(b *testing.B) {
+	p := runningProc(b, 1)
+	for i := range p.Mem {
+		p.Mem[i] = byte(i)
+	}
+	ls, err := p.Sys.ReadFile("/bin/ls")
+	if err != nil {
+		b.Fatal(err)
+	}
+	run := func(b *testing.B, vfork, eager, exec bool) {
+		b.SetBytes(int64(len(p.Mem)))
+		for i := 0; i < b.N; i++ {
+			c, err := p.Sys.fork(p, vfork)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if eager {
+				c.umem()
+			}
+			if exec {
+				c.exec(ls, []string{"ls"}, nil)
+			}
+			b.StopTimer()
+			kill(b, p, c, SIGKIL)
+			b.StartTimer()
+		}
+	}
+	b.Run("cow", func(b *testing.B) { run(b, false, false, false) })
+	b.Run("eager", func(b *testing.B) { run(b, false, true, false) })
+	b.Run("cow+exec", func(b *testing.B) { run(b, false, false, true) })
+	b.Run("eager+exec", func(b *testing.B) { run(b, false, true, true) })
+	b.Run("vfork+exec", func(b *testing.B) { run(b, true, false, true) })
+}
+
+func TestForkCopyOnWrite(t *testing.T) {
+	p := runningProc(t, 1)
+	p.Mem[0o1000] = 1
+	p.Mem[0o4000] = 2
+	c, err := p.Sys.Fork(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := func(p *Proc, addr uint16) uint8 {
+		t.Helper()
+		b, err := p.CPU.Mem.ReadB(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	// Each write is seen only by the process making it.
+	c.CPU.Mem.WriteB(0o1000, 3)
+	p.CPU.Mem.WriteB(0o4000, 4)
+	if a, b := read(p, 0o1000), read(p, 0o4000); a != 1 || b != 4 {
+		t.Errorf("parent reads %d, %d, want 1, 4", a, b)
+	}
+	if a, b := read(c, 0o1000), read(c, 0o4000); a != 3 || b != 2 {
+		t.Errorf("child reads %d, %d, want 3, 2", a, b)
+	}
+	if c.cowpages != ^uint64(0)&^(1<<(0o1000/cowPage)|1<<(0o4000/cowPage)) {
+		t.Errorf("child shares pages %#x, want all but two", c.cowpages)
+	}
+
+	// Growing the data segment copies only the pages it zeroes.
+	shared := c.cowpages
+	c.DataStart, c.DataSize = 0o20000, 0
+	c.CPU.R[pdp11.SP] = 0o170000
+	c.Args[0] = 0o20000 + cowPage
+	if sysbreak(c); c.Error != 0 {
+		t.Fatal(c.Error)
+	}
+	if want := shared &^ (1 << (0o20000 / cowPage)); c.cowpages != want {
+		t.Errorf("child after break shares pages %#x, want %#x", c.cowpages, want)
+	}
+
+	// The kernel's access to the whole memory copies the rest.
+	p.Mem[0o10000] = 5
+	if mem := c.umem(); mem[0o10000] != 5 || mem[0o1000] != 3 || c.cowsrc != nil || p.cowkids != nil {
+		t.Errorf("child after umem: %d, %d, sharing %v, want 5, 3, false", mem[0o10000], mem[0o1000], c.cowsrc != nil)
+	}
+
+	// Exit stops the sharing.
+	c, err = p.Sys.Fork(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kill(t, p, c, SIGKIL)
+	if c.cowsrc != nil || p.cowkids != nil || p.CPU.Mem != &p.Mem {
+		t.Errorf("dead child still shares memory")
+	}
+}
+
+func TestVfork(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aout := []byte{
+		0o07, 0o01, 24, 0, 0, 0, 0o200, 0, 0, 0, 0, 0, 0, 0, 0, 0, // 0407, 24 bytes of text, 0o200 of bss
+		0o065, 0o211, // 0: sys vfork
+		0o004, 0o001, // 2: br 12 (child)
+		0o300, 0o027, 0o100, 0, // 4: mov @#0o100, r0 (parent)
+		0o001, 0o211, // 8: sys exit
+		0o240, 0o000, // 10: nop
+		0o337, 0o025, 7, 0, 0o100, 0, // 12: mov $7, @#0o100
+		0o001, 0o211, // 18: sys exit
+		0o240, 0o000, // 20: nop
+		0o240, 0o000, // 22: nop
+	}
+	parent, err := sys.Start(aout, []string{"a.out"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sys.SetBreakpoint(8)
+
+	// The parent goes on only after the child exits,
+	// and it sees what the child stored.
+	p, err := sys.Continue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != parent || p.CPU.R[0] != 7 {
+		t.Errorf("pid %d at exit: r0 = %d, want pid %d, r0 7 from the child", p.Pid, p.CPU.R[0], parent.Pid)
+	}
+	for _, c := range sys.Procs {
+		if c.vmem != nil || c.flag&_SVFORK != 0 {
+			t.Errorf("pid %d still borrows its parent's memory", c.Pid)
+		}
+	}
+}
+
+func TestSetTrace(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aout := []byte{
+		0o07, 0o01, 8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, // 0407, 8 bytes of text
+		0o300, 0o025, 5, 0, // mov $5, r0
+		0o001, 0o211, // sys exit
+		0o000, 0o000, // halt
+	}
+	type step struct {
+		pc, op, r0 uint16
+	}
+	var steps []step
+	sys.SetTrace(func(p *Proc, pc, op uint16, r [8]uint16) {
+		steps = append(steps, step{pc, op, r[0]})
+	})
+	if _, err := sys.Start(aout, []string{"a.out"}, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	sys.Wait()
+	want := []step{{0, 0o012700, 0}, {4, 0o104401, 5}}
+	if !slices.Equal(steps, want) {
+		t.Errorf("traced %o, want %o", steps, want)
+	}
+}
+
+func TestStep(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aout := []byte{
+		0o07, 0o01, 16, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, // 0407, 16 bytes of text
+		0o300, 0o025, 5, 0, // 0: mov $5, r0
+		0o200, 0o012, // 4: inc r0
+		0o024, 0o211, // 6: sys getpid
+		0o301, 0o025, 7, 0, // 8: mov $7, r1
+		0o201, 0o012, // 12: inc r1
+		0o001, 0o211, // 14: sys exit
+	}
+	p, err := sys.Start(aout, []string{"a.out"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []struct{ pc, r0 uint16 }{
+		{4, 5},
+		{6, 6},
+		{8, uint16(p.Pid)}, // the system call is one step
+	} {
+		if err := p.Step(); err != nil {
+			t.Fatal(err)
+		}
+		if pc, r0 := p.CPU.R[pdp11.PC], p.CPU.R[0]; pc != want.pc || r0 != want.r0 {
+			t.Fatalf("after step: pc %d r0 %d, want %d %d", pc, r0, want.pc, want.r0)
+		}
+	}
+
+	q, err := sys.Run(12)
+	if err != nil || q != p || p.CPU.R[pdp11.PC] != 12 || p.CPU.R[1] != 7 {
+		t.Fatalf("Run(12) = %v, %v with pc %d r1 %d, want process at pc 12 with r1 7", q, err, p.CPU.R[pdp11.PC], p.CPU.R[1])
+	}
+	if err := p.Step(); err != nil || p.CPU.R[1] != 8 {
+		t.Fatalf("step at 12: %v, r1 %d, want r1 8", err, p.CPU.R[1])
+	}
+	if err := p.Step(); err != ErrExited {
+		t.Errorf("step of exit = %v, want ErrExited", err)
+	}
+	if err := p.Step(); err != ErrExited {
+		t.Errorf("step after exit = %v, want ErrExited", err)
+	}
+}
+
+func TestSplitID(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aout := []byte{
+		0o11, 0o01, 10, 0, 2, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, // 0411, 10 bytes of text, 2 of data, 2 of bss
+		0o300, 0o027, 0, 0, // 0: mov @#0, r0
+		0o301, 0o025, 7, 0, // 4: mov $7, r1
+		0o001, 0o211, // 8: sys exit
+		0o064, 0o022, // data 0: 0o11064
+	}
+	p, err := sys.Start(aout, []string{"a.out"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.TextSize != 10 || p.DataStart != 0 || p.DataSize != 4 || p.Mem[0] != 0o064 {
+		t.Errorf("text %d, data %d at %#o, D space 0 = %#o, want 10, 4 at 0, 0o064", p.TextSize, p.DataSize, p.DataStart, p.Mem[0])
+	}
+
+	// The text is readable and writable only through ReadText and WriteText.
+	if b, err := p.ReadText(4, 4); err != nil || !bytes.Equal(b, aout[0o24:0o30]) {
+		t.Errorf("ReadText(4, 4) = %v, %v, want %v", b, err, aout[0o24:0o30])
+	}
+	if err := p.WriteText(10, []byte{0o003, 0}); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := p.ReadText(10, 2); b[0] != 0o003 || p.Mem[10] == 0o003 {
+		t.Errorf("after WriteText: I space %v, D space %#o, want bpt in I space only", b, p.Mem[10])
+	}
+	if _, err := p.ReadText(0o20000-2, 4); err != EFAULT {
+		t.Errorf("ReadText past the text = %v, want EFAULT", err)
+	}
+	for _, want := range []struct{ pc, r0, r1 uint16 }{
+		{4, 0o11064, 0}, // data from D space, where 0 is not the instruction
+		{8, 0o11064, 7}, // immediate from I space
+	} {
+		if err := p.Step(); err != nil {
+			t.Fatal(err)
+		}
+		if pc, r0, r1 := p.CPU.R[pdp11.PC], p.CPU.R[0], p.CPU.R[1]; pc != want.pc || r0 != want.r0 || r1 != want.r1 {
+			t.Fatalf("after step: pc %d r0 %#o r1 %d, want %d %#o %d", pc, r0, r1, want.pc, want.r0, want.r1)
+		}
+	}
+	if err := p.Step(); err != ErrExited {
+		t.Errorf("step of exit = %v, want ErrExited", err)
+	}
+
+	// Text and data each get their own 64K.
+	const bigText, bigData = 0o40000, 0o150000
+	big := make([]byte, 0o20+bigText+bigData)
+	copy(big, []byte{0o11, 0o01, 0, bigText >> 8, 0, bigData >> 8})
+	if p, err := sys.Start(big, []string{"a.out"}, io.Discard); err != nil {
+		t.Errorf("Start of %d bytes of text and %d of data: %v", bigText, bigData, err)
+	} else if p.TextSize != bigText || p.DataSize != bigData {
+		t.Errorf("text %d, data %d, want %d, %d", p.TextSize, p.DataSize, bigText, bigData)
+	}
+	big[0] = 0o10 // 0410
+	if _, err := sys.Start(big, []string{"a.out"}, io.Discard); err == nil {
+		t.Errorf("Start of 0410 program too big for 64K succeeded")
+	}
+
+	aout[0] = 0o12 // 0412
+	if _, err := sys.Start(aout, []string{"a.out"}, io.Discard); err == nil {
+		t.Errorf("Start of bad magic number succeeded")
+	}
+}
+
+func TestBreakpoint(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aout := []byte{
+		0o07, 0o01, 10, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, // 0407, 10 bytes of text
+		0o002, 0o211, // 0: sys fork
+		0o001, 0o001, // 2: br 6 (child)
+		0o240, 0o000, // 4: nop (parent)
+		0o201, 0o012, // 6: inc r1
+		0o001, 0o211, // 8: sys exit
+	}
+	if _, err := sys.Start(aout, []string{"a.out"}, io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	sys.SetBreakpoint(6)
+	sys.SetBreakpoint(7) // never reached
+
+	// Both the parent and the child stop at the breakpoint.
+	var pids []int16
+	for i := 0; i < 2; i++ {
+		p, err := sys.Continue()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pc := p.CPU.R[pdp11.PC]; pc != 6 {
+			t.Fatalf("pid %d stopped at pc %d, want 6", p.Pid, pc)
+		}
+		pids = append(pids, p.Pid)
+	}
+	if pids[0] == pids[1] {
+		t.Errorf("stopped pids %v, want parent and child", pids)
+	}
+
+	sys.ClearBreakpoint(6)
+	if p, err := sys.Continue(); !errors.Is(err, ErrIdle) {
+		t.Errorf("Continue after clearing = %v, %v, want ErrIdle", p, err)
+	}
+}
+
+func TestAllBlocked(t *testing.T) {
+	run := func(text ...byte) (*System, error) {
+		t.Helper()
+		sys, err := NewSystem(FS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sys.Deterministic = true
+		aout := append([]byte{0o07, 0o01, byte(len(text)), 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, text...)
+		if _, err := sys.Start(aout, []string{"a.out"}, io.Discard); err != nil {
+			t.Fatal(err)
+		}
+		_, err = sys.Continue()
+		return sys, err
+	}
+
+	// Reading a pipe that only the reader can write never finishes.
+	_, err := run(
+		0o052, 0o211, // sys pipe
+		0o003, 0o211, 0o000, 0o002, 0o001, 0o000, // sys read; 0o1000; 1
+		0o001, 0o211, // sys exit
+	)
+	if err != ErrAllBlocked || !errors.Is(err, ErrIdle) {
+		t.Errorf("read of own pipe: Continue = %v, want ErrAllBlocked", err)
+	}
+
+	// A sleep is waited out.
+	sys, err := run(
+		0o300, 0o025, 0o001, 0o000, // mov $1, r0
+		0o043, 0o211, // sys sleep
+		0o001, 0o211, // sys exit
+	)
+	if !errors.Is(err, ErrIdle) || sys.elapsed.Seconds() < 1 {
+		t.Errorf("sleep and exit: Continue = %v after %v, want ErrIdle after 1s", err, sys.elapsed)
+	}
+	if sys.allBlocked() {
+		t.Errorf("allBlocked with every process exited")
+	}
+
+	// Terminal input could come at any time.
+	p := sys.Procs[0]
+	p.status, p.wkey = _SWAIT, &sys.TTY[8].Delct
+	if sys.allBlocked() {
+		t.Errorf("allBlocked with a process reading a tty")
+	}
+	p.wkey = &sys.Timer
+	if !sys.allBlocked() {
+		t.Errorf("allBlocked = false with a process asleep")
+	}
+}
+
+func TestRunN(t *testing.T) {
+	start := func(text ...byte) (*System, *Proc) {
+		t.Helper()
+		sys, err := NewSystem(FS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		aout := append([]byte{0o07, 0o01, byte(len(text)), 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, text...)
+		p, err := sys.Start(aout, []string{"a.out"}, io.Discard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sys, p
+	}
+
+	// A loop runs out its budget, and can be run further.
+	sys, p := start(
+		0o200, 0o012, // 0: inc r0
+		0o376, 0o001, // 2: br 0
+	)
+	for _, n := range []uint64{1000, 501} {
+		r0 := p.CPU.R[0]
+		if executed, err := sys.RunN(n); executed != n || err != ErrBudgetExceeded {
+			t.Fatalf("RunN(%d) = %d, %v, want %d, ErrBudgetExceeded", n, executed, err, n)
+		}
+		if inc := p.CPU.R[0] - r0; inc != uint16((n+1)/2) {
+			t.Errorf("RunN(%d): r0 up by %d, want %d", n, inc, (n+1)/2)
+		}
+	}
+	if err := p.Step(); err != nil || p.CPU.R[pdp11.PC] != 0 {
+		t.Errorf("Step after RunN = %v, pc %d, want nil, 0 after the br", err, p.CPU.R[pdp11.PC])
+	}
+	if executed, err := sys.RunN(0); executed != 0 || err != ErrBudgetExceeded {
+		t.Errorf("RunN(0) = %d, %v, want 0, ErrBudgetExceeded", executed, err)
+	}
+
+	// A program that exits first uses only part of its budget.
+	sys, _ = start(
+		0o200, 0o012, // inc r0
+		0o200, 0o012, // inc r0
+		0o001, 0o211, // sys exit
+	)
+	if executed, err := sys.RunN(1000); executed != 3 || !errors.Is(err, ErrIdle) {
+		t.Errorf("RunN(1000) of exiting program = %d, %v, want 3, ErrIdle", executed, err)
+	}
+}
+
+func TestReadWriteMem(t *testing.T) {
+	p := new(Proc)
+	p.CPU.Mem = &p.Mem
+	p.TextSize = 0o100
+	p.DataStart = 0o100
+	p.DataSize = 0o100           // data pages are 0 to 0o20000
+	p.CPU.R[pdp11.SP] = 0o177000 // stack page is 0o160000 up
+
+	for _, tt := range []struct {
+		addr uint16
+		n    int
+		ok   bool
+	}{
+		{0, 4, true},
+		{0o17777, 1, true}, // break rounds up to the page
+		{0o17777, 2, false},
+		{0o20000, 0, true},
+		{0o20000, 1, false},
+		{0o157777, 1, false},
+		{0o157777, 2, false},
+		{0o160000, 1, true},
+		{0o177775, 3, true},
+		{0o177777, 1, true},
+		{0o177777, 2, false},
+		{0o100, -1, false},
+	} {
+		_, err := p.ReadMem(tt.addr, tt.n)
+		if (err == nil) != tt.ok {
+			t.Errorf("ReadMem(%#o, %d) = %v, want ok=%v", tt.addr, tt.n, err, tt.ok)
+		}
+		if tt.n < 0 {
+			continue
+		}
+		err = p.WriteMem(tt.addr, make([]byte, tt.n))
+		if (err == nil) != tt.ok {
+			t.Errorf("WriteMem(%#o, %d bytes) = %v, want ok=%v", tt.addr, tt.n, err, tt.ok)
+		}
+	}
+
+	// Odd addresses work byte by byte, and ReadMem returns a copy.
+	p.Mem[0o100] = 0o377
+	if err := p.WriteMem(0o101, []byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := p.ReadMem(0o100, 5)
+	if want := []byte{0o377, 1, 2, 3, 0}; err != nil || !bytes.Equal(b, want) {
+		t.Errorf("ReadMem(0o100, 5) = %v, %v, want %v", b, err, want)
+	}
+	b[0] = 0
+	if p.Mem[0o100] != 0o377 {
+		t.Errorf("changing ReadMem result changed memory")
+	}
+
+	// A failed write writes nothing, even in the mapped part.
+	if err := p.WriteMem(0o17776, []byte{9, 9, 9}); err != EFAULT {
+		t.Errorf("WriteMem across the break = %v, want EFAULT", err)
+	}
+	if p.Mem[0o17776] != 0 || p.Mem[0o17777] != 0 {
+		t.Errorf("failed WriteMem wrote memory")
+	}
+
+	// When the data reaches the stack's page, all memory is mapped.
+	p.DataSize = 0o157000
+	if _, err := p.ReadMem(0o157777, 2); err != nil {
+		t.Errorf("ReadMem across data and stack = %v", err)
+	}
+}
+
+func TestRegisters(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aout := []byte{
+		0o07, 0o01, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, // 0407, 4 bytes of text
+		0o200, 0o012, // 0: inc r0
+		0o001, 0o211, // 2: sys exit
+	}
+	p, err := sys.Start(aout, []string{"a.out"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		r0, want uint16
+		ps       pdp11.PS
+	}{
+		{0o177777, 0, pdp11.PS_Z | pdp11.PS_C}, // inc leaves C alone
+		{0o077777, 0o100000, pdp11.PS_N | pdp11.PS_V | pdp11.PS_C},
+		{5, 6, pdp11.PS_C},
+	} {
+		p.SetRegister(int(pdp11.PC), 0)
+		p.SetRegister(0, tt.r0)
+		p.SetPS(pdp11.PS_C)
+		if err := p.Step(); err != nil {
+			t.Fatal(err)
+		}
+		r := p.Registers()
+		if r[pdp11.PC] != 2 || r[0] != tt.want || p.PS() != tt.ps {
+			t.Errorf("inc %#o: pc %d r0 %#o ps %#o, want pc 2 r0 %#o ps %#o", tt.r0, r[pdp11.PC], r[0], p.PS(), tt.want, tt.ps)
+		}
+	}
+	if _, err := sys.Continue(); !errors.Is(err, ErrIdle) {
+		t.Errorf("Continue to exit = %v, want ErrIdle", err)
+	}
+
+	for _, n := range []int{-1, 8} {
+		if err := p.SetRegister(n, 0); err != EINVAL {
+			t.Errorf("SetRegister(%d, 0) = %v, want EINVAL", n, err)
+		}
+	}
+}
+
+func TestDeterministic(t *testing.T) {
+	aout := []byte{
+		0o07, 0o01, 14, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, // 0407, 14 bytes of text
+		0o002, 0o211, // 0: sys fork
+		0o240, 0o000, // 2: nop (child)
+		0o201, 0o012, // 4: inc r1
+		0o301, 0o045, 0o054, 0o001, // 6: cmp r1, $300.
+		0o374, 0o002, // 10: bne 4
+		0o001, 0o211, // 12: sys exit
+	}
+	// turns runs the program and returns the number of
+	// instructions each process ran in each of its turns.
+	type turn struct {
+		pid int16
+		n   int
+	}
+	turns := func() []turn {
+		sys, err := NewSystem(FS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sys.Deterministic = true
+		sys.Quantum = 50
+		var ts []turn
+		sys.SetTrace(func(p *Proc, pc, op uint16, r [8]uint16) {
+			if len(ts) == 0 || ts[len(ts)-1].pid != p.Pid {
+				ts = append(ts, turn{p.Pid, 0})
+			}
+			ts[len(ts)-1].n++
+		})
+		if _, err := sys.Start(aout, []string{"a.out"}, io.Discard); err != nil {
+			t.Fatal(err)
+		}
+		sys.Wait()
+		return ts
+	}
+
+	ts := turns()
+	if len(ts) < 10 {
+		t.Fatalf("turns = %v, want processes taking many turns", ts)
+	}
+	// The first turn ends in the fork, and the last two in exits.
+	for _, tt := range ts[1 : len(ts)-2] {
+		if tt.n != 50 {
+			t.Errorf("turns = %v, want 50 instructions each in between", ts)
+			break
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if ts1 := turns(); !slices.Equal(ts, ts1) {
+			t.Fatalf("turns differ between runs:\n%v\n%v", ts, ts1)
+		}
+	}
+}
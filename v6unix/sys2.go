@@ -44,6 +44,9 @@ func (p *Proc) rdwr(mode int) {
 		return
 	}
 	b := p.mem(p.Args[0], p.Args[1])
+	/* cleared however the read or write ends, even by a signal */
+	p.fflag = f.flag
+	defer func() { p.fflag = 0 }()
 	var n int
 	if f.flag&_FPIPE != 0 {
 		if mode == _FREAD {
@@ -53,16 +56,47 @@ func (p *Proc) rdwr(mode int) {
 		}
 	} else {
 		off := f.offset
-		if mode == _FREAD {
+		if mode == _FREAD && f.flag&_FCOOKED != 0 && f.inode.mode&_IFMT == _IFDIR {
+			n, f.offset = p.readdir(f.inode, b, off)
+		} else if mode == _FREAD {
 			n = p.readi(f.inode, b, off)
+			f.offset += n
 		} else {
 			n = p.writei(f.inode, b, off)
+			f.offset += n
 		}
-		f.offset += n
 	}
 	p.CPU.R[0] = uint16(n)
 }
 
+/*
+ * Read the entries of the directory ip
+ * from off into b, skipping unused slots
+ * (inode 0), for a directory opened with
+ * OCOOKED. Only whole entries are read.
+ * Return the count of bytes read and
+ * the offset of the next entry.
+ */
+func (p *Proc) readdir(ip *inode, b []byte, off int) (n, next int) {
+	var d dirent
+	for len(b)-n >= int(direntSize) {
+		if p.readi(ip, d.bytes(), off) < int(direntSize) || p.Error != 0 {
+			break
+		}
+		off += int(direntSize)
+		if d.inum != 0 {
+			n += copy(b[n:], d.bytes())
+		}
+	}
+	return n, off
+}
+
+/* open mode flags (not in v6) */
+const (
+	ONDELAY = 004 /* reads fail with EAGAIN instead of waiting, if System.EnableNDelay */
+	OCOOKED = 010 /* read a directory without its unused entries */
+)
+
 /*
  * open system call
  */
@@ -75,11 +109,23 @@ func (p *Proc) open(name string, omode int) {
 	if ip == nil {
 		return
 	}
-	p.open1(ip, omode+1, 0)
+	mode := omode&^(OCOOKED|ONDELAY) + 1
+	if omode&OCOOKED != 0 {
+		mode |= _FCOOKED
+	}
+	if omode&ONDELAY != 0 && p.Sys.EnableNDelay {
+		mode |= _FNDELAY
+	}
+	p.open1(ip, mode, 0)
 }
 
 /*
- * create system call
+ * create system call.
+ * An existing file is truncated,
+ * keeping its owner and mode; otherwise
+ * a new one is made with the mode less
+ * the umask, owned by the effective ids.
+ * Either way it is opened for writing.
  */
 func syscreate(p *Proc) {
 	name := p.str(p.Args[0])
@@ -129,7 +175,7 @@ func (p *Proc) open1(ip *inode, mode, trf int) {
 		p.iput(ip)
 		return
 	}
-	f.flag = mode & (_FREAD | _FWRITE)
+	f.flag = mode & (_FREAD | _FWRITE | _FCOOKED | _FNDELAY)
 	f.inode = ip
 	fd := p.CPU.R[0]
 	p.openi(ip, mode&_FWRITE)
@@ -137,6 +183,7 @@ func (p *Proc) open1(ip *inode, mode, trf int) {
 		return
 	}
 	p.Files[fd] = nil
+	p.Sys.nfile--
 	p.iput(ip)
 }
 
@@ -183,6 +230,48 @@ func sysseek(p *Proc) {
 	f.offset = off
 }
 
+/*
+ * lseek system call (from v7).
+ * fd in r0; 32-bit offset (high word first)
+ * and whence inline. The new offset is
+ * returned in r0, r1.
+ */
+func syslseek(p *Proc) {
+	f := p.getf(p.CPU.R[0])
+	if f == nil {
+		return
+	}
+	if f.flag&_FPIPE != 0 {
+		p.Error = ESPIPE
+		return
+	}
+	if ip := f.inode; ip.mode&_IFMT == _IFCHR {
+		if _, ok := p.dev(ip.major, ip.minor).(streamer); ok {
+			p.Error = ESPIPE
+			return
+		}
+	}
+	off := int(int32(uint32(p.Args[0])<<16 | uint32(p.Args[1])))
+	switch p.Args[2] {
+	case 0:
+		// nothing
+	case 1:
+		off += f.offset
+	case 2:
+		off += f.inode.size()
+	default:
+		p.Error = EINVAL
+		return
+	}
+	if off < 0 {
+		p.Error = EINVAL
+		return
+	}
+	f.offset = off
+	p.CPU.R[0] = uint16(off >> 16)
+	p.CPU.R[1] = uint16(off)
+}
+
 /*
  * link system call
  */
@@ -212,10 +301,68 @@ func syslink(p *Proc) {
 	if p.Error != 0 {
 		return
 	}
-	// skip EXDEV
+	if ip.disk != dp.disk || ip.host != nil || dp.host != nil {
+		p.Error = EXDEV
+		return
+	}
 	p.wdir(ip, path.Base(name), dp, off)
 	ip.nlink++
-	ip.mtime = now()
+	ip.mtime = p.Sys.now()
+}
+
+/*
+ * symlink system call (from 4.2BSD).
+ * Creates the second name as a
+ * symbolic link with the first as its text.
+ */
+func syssymlink(p *Proc) {
+	target := p.str(p.Args[0])
+	name := p.str(p.Args[1])
+	if p.Error != 0 {
+		return
+	}
+	ip, dp, off := p.namei(name, nameCreate|nameNoFollow)
+	if ip != nil {
+		p.Error = EEXIST
+		p.iput(ip)
+		return
+	}
+	if dp == nil {
+		return
+	}
+	defer p.iput(dp)
+	ip = p.maknode(path.Base(name), _IFLNK|0o777, dp, off)
+	if ip == nil {
+		return
+	}
+	p.writei(ip, []byte(target), 0)
+	p.iput(ip)
+}
+
+/*
+ * readlink system call (from 4.2BSD).
+ * Copies the text of a symbolic link
+ * into the buffer and returns its length.
+ */
+func sysreadlink(p *Proc) {
+	name := p.str(p.Args[0])
+	if p.Error != 0 {
+		return
+	}
+	ip, _, _ := p.namei(name, nameFind|nameNoFollow)
+	if ip == nil {
+		return
+	}
+	defer p.iput(ip)
+	if ip.mode&(_IFMT|_ILARG) != _IFLNK {
+		p.Error = EINVAL
+		return
+	}
+	b := p.mem(p.Args[1], p.Args[2])
+	if b == nil {
+		return
+	}
+	p.CPU.R[0] = uint16(copy(b, p.contents(ip)))
 }
 
 /*
@@ -239,7 +386,8 @@ func sysmknod(p *Proc) {
 	if ip == nil {
 		return
 	}
-	ip.addr[0] = p.Args[2]
+	ip.minor = uint8(p.Args[2]) /* ip->i_addr[0] = u.u_arg[1] */
+	ip.major = uint8(p.Args[2] >> 8)
 	p.iput(ip)
 }
 
@@ -248,11 +396,24 @@ func sysmknod(p *Proc) {
  * not to be confused with the sleep internal routine.
  */
 func syssleep(p *Proc) {
-	end := time.Now().Add(time.Duration(p.CPU.R[0]) * time.Second)
-	for time.Now().Before(end) {
-		if p.Sys.Timer.IsZero() || p.Sys.Timer.After(end) {
-			p.Sys.Timer = end
-		}
-		p.sleep(&p.Sys.Timer, 't', PSLEP)
+	p.sleepUntil(p.Sys.clockTime().Add(time.Duration(p.CPU.R[0])*time.Second), PSLEP)
+}
+
+/*
+ * alarm system call (from v7).
+ * Send SIGALRM after r0 seconds,
+ * or never if r0 is 0, and return
+ * the seconds left on the old alarm.
+ */
+func sysalarm(p *Proc) {
+	var left uint16
+	if !p.clktim.IsZero() {
+		left = uint16(max(1, (p.clktim.Sub(p.Sys.clockTime())+time.Second-1)/time.Second))
+	}
+	p.clktim = time.Time{}
+	if n := p.CPU.R[0]; n != 0 {
+		p.clktim = p.Sys.clockTime().Add(time.Duration(n) * time.Second)
+		p.Sys.setTimer(p.clktim)
 	}
+	p.CPU.R[0] = left
 }
@@ -11,6 +11,7 @@
 package v6unix
 
 import (
+	"slices"
 	"unsafe"
 )
 
@@ -18,11 +19,13 @@ import (
  * the fstat system call.
  */
 func sysfstat(p *Proc) {
-	p.fstat(p.CPU.R[0], (*stat)(unsafe.Pointer(&p.mem(p.Args[0], uint16(unsafe.Sizeof(stat{})))[0])))
+	if st := p.statbuf(p.Args[0]); st != nil {
+		p.fstat(p.CPU.R[0], st)
+	}
 }
 
 func (p *Proc) fstat(fd uint16, st *stat) {
-	f := p.getf(p.CPU.R[0])
+	f := p.getf(fd)
 	if f == nil {
 		return
 	}
@@ -33,7 +36,9 @@ func (p *Proc) fstat(fd uint16, st *stat) {
  * the stat system call.
  */
 func sysstat(p *Proc) {
-	p.stat(p.str(p.Args[0]), (*stat)(unsafe.Pointer(&p.mem(p.Args[1], uint16(unsafe.Sizeof(stat{})))[0])))
+	if st := p.statbuf(p.Args[1]); st != nil {
+		p.stat(p.str(p.Args[0]), st)
+	}
 }
 
 func (p *Proc) stat(name string, st *stat) {
@@ -45,32 +50,265 @@ func (p *Proc) stat(name string, st *stat) {
 	p.iput(ip)
 }
 
+/*
+ * Return the user's stat buffer at addr,
+ * or nil with EFAULT if it runs off the
+ * end of memory.
+ */
+func (p *Proc) statbuf(addr uint16) *stat {
+	b := p.mem(addr, uint16(unsafe.Sizeof(stat{})))
+	if b == nil {
+		return nil
+	}
+	return (*stat)(unsafe.Pointer(&b[0]))
+}
+
 /*
  * the dup system call.
+ * As in v7, if r0 has the 0100 bit set,
+ * it is dup2: the new descriptor is in r1,
+ * and whatever it referred to is closed first.
  */
 func sysdup(p *Proc) {
+	m := p.CPU.R[0] &^ 0o77
+	p.CPU.R[0] &= 0o77
 	f := p.getf(p.CPU.R[0])
 	if f == nil {
 		return
 	}
-	i := p.ufalloc()
-	if i < 0 {
+	var i int
+	if m&0o100 == 0 {
+		if i = p.ufalloc(); i < 0 {
+			return
+		}
+	} else {
+		i = int(p.CPU.R[1])
+		if i >= NOFILE {
+			p.Error = EBADF
+			return
+		}
+		p.CPU.R[0] = uint16(i)
+	}
+	if p.Files[i] != f {
+		if old := p.Files[i]; old != nil {
+			p.Files[i] = nil
+			p.closef(old)
+		}
+		p.Files[i] = f
+		p.fdflag[i] = 0
+		f.count++
+	}
+}
+
+/* fcntl commands (4.2BSD) */
+const (
+	F_DUPFD = 0 /* duplicate onto the lowest free descriptor >= arg */
+	F_GETFD = 1 /* get descriptor flags */
+	F_SETFD = 2 /* set descriptor flags */
+	F_GETFL = 3 /* get file status flags */
+	F_SETFL = 4 /* set file status flags */
+)
+
+/* descriptor flag */
+const FD_CLOEXEC = 1 /* close on exec */
+
+/*
+ * fcntl system call (4.2BSD 92), if System.EnableFcntl.
+ * fd in r0; command and argument inline.
+ * The status flags are those that open takes
+ * besides the mode: ONDELAY and OCOOKED.
+ * F_GETFL returns the mode as well.
+ */
+func sysfcntl(p *Proc) {
+	if !p.Sys.EnableFcntl {
+		sysnone(p)
+		return
+	}
+	fd, cmd, arg := p.CPU.R[0], p.Args[0], p.Args[1]
+	f := p.getf(fd)
+	if f == nil {
 		return
 	}
-	p.Files[i] = f
-	f.count++
+	switch cmd {
+	default:
+		p.Error = EINVAL
+
+	case F_DUPFD:
+		if arg >= NOFILE {
+			p.Error = EINVAL
+			return
+		}
+		if i := p.ufallocFrom(int(arg)); i >= 0 {
+			p.Files[i] = f
+			f.count++
+		}
+
+	case F_GETFD:
+		p.CPU.R[0] = uint16(p.fdflag[fd])
+
+	case F_SETFD:
+		p.fdflag[fd] = uint8(arg & FD_CLOEXEC)
+
+	case F_GETFL:
+		fl := f.flag&(_FREAD|_FWRITE) - 1
+		if f.flag&_FNDELAY != 0 {
+			fl |= ONDELAY
+		}
+		if f.flag&_FCOOKED != 0 {
+			fl |= OCOOKED
+		}
+		p.CPU.R[0] = uint16(fl)
+
+	case F_SETFL:
+		f.flag &^= _FNDELAY | _FCOOKED
+		if arg&ONDELAY != 0 && p.Sys.EnableNDelay {
+			f.flag |= _FNDELAY
+		}
+		if arg&OCOOKED != 0 {
+			f.flag |= _FCOOKED
+		}
+	}
 }
 
+/* mount flag (not in v6) */
+const MNOSUID = 02 /* ignore set-uid and set-gid bits on exec */
+
 /*
  * the mount system call.
+ * Any flag but MNOSUID
+ * mounts read-only, as in v6.
  */
 func sysmount(p *Proc) {
-	p.Error = EINVAL
+	dev := p.getmdev()
+	if p.Error != 0 {
+		return
+	}
+	ip, _, _ := p.namei(p.str(p.Args[1]), nameFind)
+	if ip == nil {
+		return
+	}
+	if ip.count != 1 || ip.special() || len(p.Sys.mounts) >= NMOUNT {
+		goto out
+	}
+	for _, mp := range p.Sys.mounts {
+		if mp.dev == dev {
+			goto out
+		}
+	}
+	{
+		bd, ok := p.dev(uint8(dev>>8), uint8(dev)).(*blkdev)
+		if !ok {
+			p.Error = ENOTBLK
+			goto out
+		}
+		ronly := p.Args[2]&^MNOSUID != 0
+		rw := 1
+		if ronly {
+			rw = 0
+		}
+		bd.open(p, uint8(dev), rw)
+		if p.Error != 0 {
+			goto out
+		}
+		d, err := openImage(bd, dev, ronly)
+		if err != nil {
+			bd.close(p, uint8(dev))
+			p.Error = EINVAL
+			p.iput(ip)
+			return
+		}
+		d.nosuid = p.Args[2]&MNOSUID != 0
+		p.Sys.mounts = append(p.Sys.mounts, &mount{dev: dev, disk: d, inodp: ip})
+		ip.mounted = true
+		return
+	}
+
+out:
+	if p.Error == 0 {
+		p.Error = EBUSY
+	}
+	p.iput(ip)
 }
 
 /*
  * the umount system call.
  */
 func sysumount(p *Proc) {
-	p.Error = EINVAL
+	dev := p.getmdev()
+	if p.Error != 0 {
+		return
+	}
+	i := slices.IndexFunc(p.Sys.mounts, func(mp *mount) bool { return mp.dev == dev })
+	if i < 0 {
+		p.Error = EINVAL
+		return
+	}
+	mp := p.Sys.mounts[i]
+	for _, ip := range mp.disk.inodes {
+		if ip != nil && ip.count > 0 {
+			p.Error = EBUSY
+			return
+		}
+	}
+	if err := mp.disk.img.flush(p.Sys.now()); err != nil {
+		p.Error = EIO
+	}
+	p.dev(uint8(dev>>8), uint8(dev)).close(p, uint8(dev))
+	p.Sys.mounts = slices.Delete(p.Sys.mounts, i, i+1)
+	mp.inodp.mounted = false
+	p.iput(mp.inodp)
+}
+
+/*
+ * Common code for mount and umount.
+ * Check that the user's argument is a reasonable
+ * thing on which to mount, and return the device number if so.
+ */
+func (p *Proc) getmdev() uint16 {
+	ip, _, _ := p.namei(p.str(p.Args[0]), nameFind)
+	if ip == nil {
+		return 0
+	}
+	defer p.iput(ip)
+	if ip.mode&_IFMT != _IFBLK {
+		p.Error = ENOTBLK
+		return 0
+	}
+	if _, ok := p.dev(ip.major, ip.minor).(*blkdev); !ok {
+		p.Error = ENXIO
+		return 0
+	}
+	return uint16(ip.major)<<8 | uint16(ip.minor)
+}
+
+/*
+ * Mount structure.
+ * One allocated on every mount.
+ * Used to find the super block.
+ */
+type mount struct {
+	dev   uint16 /* device mounted */
+	disk  *Disk  /* the mounted file system */
+	inodp *inode /* pointer to mounted on inode */
+}
+
+// mountOn returns the mount table entry for the file system
+// mounted on ip, or nil.
+func (sys *System) mountOn(ip *inode) *mount {
+	for _, mp := range sys.mounts {
+		if mp.inodp == ip {
+			return mp
+		}
+	}
+	return nil
+}
+
+// mountOf returns the mount table entry for d, or nil.
+func (sys *System) mountOf(d *Disk) *mount {
+	for _, mp := range sys.mounts {
+		if mp.disk == d {
+			return mp
+		}
+	}
+	return nil
 }
@@ -11,6 +11,7 @@
 package v6unix
 
 import (
+	"path"
 	"time"
 	"unsafe"
 )
@@ -26,8 +27,24 @@ var start = time.Now()
 // since date cannot display years like 2023.
 const boottime = 177300290
 
-func now() [2]uint16 {
-	t := boottime + int64(time.Since(start).Seconds())
+// SetTime sets the system clock to t,
+// from which it advances with the clock.
+// A system whose time has not been set
+// counts from boottime instead.
+func (sys *System) SetTime(t time.Time) {
+	sys.timeBase = t.Unix()
+	sys.timeSet = sys.clockTime()
+}
+
+// now returns the system time
+// in seconds since 1970 as a v6 time pair.
+func (sys *System) now() [2]uint16 {
+	var t int64
+	if sys.timeSet.IsZero() {
+		t = boottime + int64(sys.clockTime().Sub(start).Seconds())
+	} else {
+		t = sys.timeBase + int64(sys.clockTime().Sub(sys.timeSet).Seconds())
+	}
 	var tm [2]uint16
 	tm[0] = uint16(t >> 16)
 	tm[1] = uint16(t)
@@ -35,23 +52,33 @@ func now() [2]uint16 {
 }
 
 func systime(p *Proc) {
-	t := now()
+	t := p.Sys.now()
 	p.CPU.R[0] = t[0]
 	p.CPU.R[1] = t[1]
 }
 
 func sysstime(p *Proc) {
 	if p.suser() {
-		// TODO set time from p.CPU.R[0] and p.CPU.R[1]
-		// TODO wake up any sleepers
+		p.Sys.SetTime(time.Unix(int64(p.CPU.R[0])<<16|int64(p.CPU.R[1]), 0))
+		p.Sys.wakeup(&p.Sys.Timer)
 	}
 }
 
+/*
+ * The super-user sets all three user ids;
+ * anyone else may set the effective id
+ * back to the real or the saved one.
+ */
 func syssetuid(p *Proc) {
 	uid := int8(p.CPU.R[0])
-	if p.RUid == uid || p.suser() {
+	if p.Uid == 0 {
 		p.Uid = uid
 		p.RUid = uid
+		p.SUid = uid
+		return
+	}
+	if p.RUid == uid || p.SUid == uid || p.suser() {
+		p.Uid = uid
 	}
 }
 
@@ -61,9 +88,14 @@ func sysgetuid(p *Proc) {
 
 func syssetgid(p *Proc) {
 	gid := int8(p.CPU.R[0])
-	if p.RGid == gid || p.suser() {
+	if p.Uid == 0 {
 		p.Gid = gid
 		p.RGid = gid
+		p.SGid = gid
+		return
+	}
+	if p.RGid == gid || p.SGid == gid || p.suser() {
+		p.Gid = gid
 	}
 }
 
@@ -71,12 +103,96 @@ func sysgetgid(p *Proc) {
 	p.CPU.R[0] = uint16(p.Gid)<<8 | uint16(p.RGid)
 }
 
+/*
+ * umask system call (from v7).
+ * Set the file creation mask
+ * and return the old one.
+ */
+func sysumask(p *Proc) {
+	t := p.Umask
+	p.Umask = p.CPU.R[0] & 0o777
+	p.CPU.R[0] = t
+}
+
+/*
+ * getpid system call.
+ * As in v7, the parent's pid is
+ * returned in r1; the library's
+ * getppid is getpid returning r1.
+ */
 func sysgetpid(p *Proc) {
 	p.CPU.R[0] = uint16(p.Pid)
+	p.CPU.R[1] = uint16(p.Ppid)
 }
 
+/*
+ * setpgrp system call (from 4BSD).
+ * Put the process with pid in r0, or the
+ * caller if r0 is 0, in process group pgrp;
+ * a pgrp of 0 means the process's own pid.
+ * Only the caller and its children can be moved.
+ */
+func syssetpgrp(p *Proc) {
+	p1 := p.pgrpProc(int16(p.CPU.R[0]))
+	if p1 == nil {
+		return
+	}
+	if p1 != p && p1.Ppid != p.Pid {
+		p.Error = ESRCH
+		return
+	}
+	if p.Uid != 0 && p1.Uid != p.Uid {
+		p.Error = EPERM
+		return
+	}
+	pgrp := int16(p.Args[0])
+	if pgrp == 0 {
+		pgrp = p1.Pid
+	}
+	p1.Pgrp = pgrp
+}
+
+/*
+ * getpgrp system call (from 4BSD).
+ * Return the process group of the
+ * process with pid in r0, or the caller's
+ * if r0 is 0.
+ */
+func sysgetpgrp(p *Proc) {
+	if p1 := p.pgrpProc(int16(p.CPU.R[0])); p1 != nil {
+		p.CPU.R[0] = uint16(p1.Pgrp)
+	}
+}
+
+// pgrpProc returns the process named by pid for setpgrp
+// and getpgrp, or nil with ESRCH.
+func (p *Proc) pgrpProc(pid int16) *Proc {
+	if pid == 0 {
+		return p
+	}
+	p1 := p.Sys.lookpid(pid)
+	if p1 == nil || p1.status == _SZOMB {
+		p.Error = ESRCH
+		return nil
+	}
+	return p1
+}
+
+/*
+ * sync system call.
+ * V6 update writes the super blocks, then the inodes, then bflush.
+ * Sync does the same for each mounted file system and the
+ * image from MountImage: it writes the inodes in use and the
+ * changed super block into the buffer cache, then flushes the
+ * devices. The built-in file system lives in memory and has
+ * nothing to write. The kernel runs one process
+ * at a time and Sync does not sleep, so no process can change
+ * the process table or the buffers while sync is running.
+ * sync cannot fail; a device that cannot be written keeps its buffers.
+ */
 func syssync(p *Proc) {
-	// TODO
+	p.Sys.Sync()
+	p.CPU.R[0] = 0
 }
 
 func sysnice(p *Proc) {
@@ -87,6 +203,10 @@ func sysnice(p *Proc) {
 	if n < 0 && !p.suser() {
 		n = 0
 	}
+	if n < -20 {
+		n = -20
+	}
+	p.nice = int8(n)
 	p.Nice = n
 }
 
@@ -110,12 +230,248 @@ func (p *Proc) unlink(name string) {
 		return
 	}
 
-	clear(dp.data[off : off+DIRSIZ+2])
+	if !p.rmentry(ip, dp, off) {
+		return
+	}
 	ip.nlink--
-	ip.mtime = now()
+	ip.mtime = p.Sys.now()
+}
+
+/*
+ * Clear the entry for ip at off
+ * in the directory dp.
+ */
+func (p *Proc) rmentry(ip, dp *inode, off int) bool {
+	if dp.host != nil && !p.hostRemove(ip, dp, off) {
+		return false
+	}
+	dp.dirgen++
+	if dp.onImage() {
+		p.writei(dp, make([]byte, DIRSIZ+2), off)
+	} else {
+		clear(dp.data[off : off+DIRSIZ+2])
+	}
+	return true
+}
+
+/*
+ * mkdir system call (from 4.2BSD).
+ * Make the directory with its . and ..
+ * entries in one step, instead of the
+ * mknod and links of the v6 mkdir command.
+ */
+func sysmkdir(p *Proc) {
+	name := p.str(p.Args[0])
+	ip, dp, off := p.namei(name, nameCreate)
+	defer p.iput(dp)
+	if ip != nil {
+		p.Error = EEXIST
+		p.iput(ip)
+		return
+	}
+	if p.Error != 0 {
+		return
+	}
+	if dp.nlink >= 127 {
+		p.Error = EMLINK
+		return
+	}
+	ip = p.maknode(path.Base(name), _IFDIR|p.Args[1]&0o777, dp, off)
+	if ip == nil {
+		return
+	}
+	p.wdir(ip, ".", ip, 0)
+	p.wdir(dp, "..", ip, int(direntSize))
+	ip.nlink++
+	dp.nlink++
+	p.iput(ip)
+}
+
+/*
+ * rmdir system call (from 4.2BSD).
+ * Remove an empty directory,
+ * with its . and .. entries.
+ */
+func sysrmdir(p *Proc) {
+	name := p.str(p.Args[0])
+	if base := path.Base(name); base == "." || base == ".." {
+		p.Error = EINVAL
+		return
+	}
+	ip, dp, off := p.namei(name, nameDelete)
+	if ip == nil {
+		return
+	}
+	defer p.iput(ip)
+	defer p.iput(dp)
+
+	if ip.mode&_IFMT != _IFDIR {
+		p.Error = ENOTDIR
+		return
+	}
+	if ip.inum == ROOTINO || ip == p.Dir || ip == p.Root {
+		p.Error = EBUSY
+		return
+	}
+	if !p.dirEmpty(ip) {
+		p.Error = ENOTEMPTY
+		return
+	}
+
+	if !p.rmentry(ip, dp, off) {
+		return
+	}
+	ip.nlink -= 2 /* its name and . */
+	dp.nlink--    /* its .. */
+	dp.mtime = p.Sys.now()
+}
+
+/*
+ * Report whether the directory ip
+ * has nothing in it but . and ..
+ */
+func (p *Proc) dirEmpty(ip *inode) bool {
+	data := p.contents(ip)
+	for i := 0; i < len(data); i += int(direntSize) {
+		d := (*dirent)(unsafe.Pointer(&data[i]))
+		if d.inum != 0 && d.name() != "." && d.name() != ".." {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+ * rename system call (from 4.2BSD).
+ * Move the entry for the first name
+ * to the second, replacing whatever
+ * the second name was, in one step.
+ * A directory moved to a new parent
+ * has its .. entry changed to match.
+ */
+func sysrename(p *Proc) {
+	from, to := p.str(p.Args[0]), p.str(p.Args[1])
+	for _, name := range []string{from, to} {
+		if base := path.Base(name); base == "." || base == ".." {
+			p.Error = EINVAL
+			return
+		}
+	}
+	ip, odp, ooff := p.namei(from, nameDelete)
+	if ip == nil {
+		return
+	}
+	defer p.iput(ip)
+	defer p.iput(odp)
+
+	/*
+	 * Find the slot for the new name:
+	 * the entry it replaces, or a free one.
+	 */
+	xp, dp, off := p.namei(to, nameDelete)
+	if xp == nil && dp == nil && p.Error == ENOENT {
+		p.Error = 0
+		xp, dp, off = p.namei(to, nameCreate)
+	}
+	if dp == nil {
+		p.iput(xp)
+		return
+	}
+	defer p.iput(dp)
+	defer p.iput(xp)
+	if xp == ip {
+		return
+	}
+	if ip.disk != dp.disk || ip.host != nil || dp.host != nil {
+		p.Error = EXDEV
+		return
+	}
+
+	isdir := ip.mode&_IFMT == _IFDIR
+	if isdir {
+		if p.ancestor(ip, dp) {
+			p.Error = EINVAL /* would make a cycle */
+			return
+		}
+		if dp != odp && dp.nlink >= 127 {
+			p.Error = EMLINK
+			return
+		}
+	}
+	if xp != nil {
+		switch {
+		case xp.mode&_IFMT != _IFDIR && isdir:
+			p.Error = ENOTDIR
+		case xp.mode&_IFMT == _IFDIR && !isdir:
+			p.Error = EISDIR
+		case isdir && !p.dirEmpty(xp):
+			p.Error = ENOTEMPTY
+		}
+		if p.Error != 0 {
+			return
+		}
+	}
+
+	p.wdir(ip, path.Base(to), dp, off)
+	if xp != nil {
+		xp.nlink--
+		if isdir {
+			xp.nlink-- /* its . */
+			dp.nlink-- /* its .. */
+		}
+	}
+	p.rmentry(ip, odp, ooff)
+	if isdir && dp != odp {
+		_, off := dsearch(p.contents(ip), "..")
+		p.wdir(dp, "..", ip, off)
+		odp.nlink--
+		dp.nlink++
+	}
+	odp.mtime = p.Sys.now()
+	dp.mtime = odp.mtime
+}
+
+/*
+ * Report whether the directory ip is dp
+ * or one of dp's ancestors on its file system.
+ */
+func (p *Proc) ancestor(ip, dp *inode) bool {
+	dp.count++
+	for dp != ip && dp.inum != ROOTINO {
+		inum, _ := dsearch(p.contents(dp), "..")
+		var pp *inode
+		if inum != 0 {
+			pp = p.igetDisk(dp.disk, inum)
+		}
+		p.iput(dp)
+		if pp == nil {
+			return false
+		}
+		dp = pp
+	}
+	p.iput(dp)
+	return dp == ip
 }
 
 func syschdir(p *Proc) {
+	p.chdirec(&p.Dir)
+}
+
+/*
+ * chroot system call (from v7).
+ */
+func syschroot(p *Proc) {
+	if !p.suser() {
+		return
+	}
+	p.chdirec(&p.Root)
+}
+
+/*
+ * Change *ipp to the directory named
+ * by the first argument.
+ */
+func (p *Proc) chdirec(ipp **inode) {
 	ip, _, _ := p.namei(p.str(p.Args[0]), 0)
 	if ip == nil {
 		return
@@ -129,8 +485,33 @@ func syschdir(p *Proc) {
 		p.iput(ip)
 		return
 	}
-	p.iput(p.Dir)
-	p.Dir = ip
+	p.iput(*ipp)
+	*ipp = ip
+}
+
+/*
+ * access system call (from v7):
+ * check the modes in the second argument
+ * (04 read, 02 write, 01 execute, 0 existence)
+ * against the real user and group ids.
+ */
+func sysaccess(p *Proc) {
+	svuid, svgid := p.Uid, p.Gid
+	p.Uid, p.Gid = p.RUid, p.RGid
+	ip, _, _ := p.namei(p.str(p.Args[0]), 0)
+	if ip != nil {
+		if p.Args[1]&(_IREAD>>6) != 0 {
+			p.access(ip, _IREAD)
+		}
+		if p.Args[1]&(_IWRITE>>6) != 0 {
+			p.access(ip, _IWRITE)
+		}
+		if p.Args[1]&(_IEXEC>>6) != 0 {
+			p.access(ip, _IEXEC)
+		}
+		p.iput(ip)
+	}
+	p.Uid, p.Gid = svuid, svgid
 }
 
 func syschmod(p *Proc) {
@@ -141,9 +522,13 @@ func syschmod(p *Proc) {
 	ip.mode &^= 0o7777
 	if p.Uid != 0 {
 		p.Args[1] &^= _ISVTX
+		/* only a member of the group may make a file set-gid to it */
+		if ip.gid != p.Gid {
+			p.Args[1] &^= _ISGID
+		}
 	}
 	ip.mode |= p.Args[1] & 0o7777
-	ip.mtime = now()
+	ip.mtime = p.Sys.now()
 	p.iput(ip)
 }
 
@@ -155,9 +540,13 @@ func syschown(p *Proc) {
 	if ip == nil {
 		return
 	}
+	/*
+	 * only the super-user gets here,
+	 * so set-uid and set-gid bits are kept.
+	 */
 	ip.uid = int8(p.Args[1])
 	ip.gid = int8(p.Args[1] >> 8)
-	ip.mtime = now()
+	ip.mtime = p.Sys.now()
 	p.iput(ip)
 }
 
@@ -179,14 +568,14 @@ func syssmdate(p *Proc) {
 
 func syssig(p *Proc) {
 	a := p.Args[0]
-	if a >= NSIG || a == SIGKIL {
+	if a >= NSIG || a == SIGKIL || a == SIGSTOP {
 		p.Error = EINVAL
 		return
 	}
 	p.CPU.R[0] = p.Signals[a]
 	p.Signals[a] = p.Args[1]
-	if p.Sig == int8(a) {
-		p.Sig = 0
+	if p.sig == int8(a) {
+		p.sig = 0
 	}
 }
 
@@ -194,19 +583,61 @@ func syskill(p *Proc) {
 	p.kill(int16(p.CPU.R[0]), int(p.Args[0]))
 }
 
+/*
+ * Send sig to process pid.
+ * Pid 0 means every process with the same
+ * controlling teletype, which serves as the
+ * process group; pid -1 means every process
+ * (as in v7); and other negative pids mean
+ * the group of process -pid.
+ * Once setpgrp has made process groups,
+ * they are the groups instead: pid 0 means
+ * the caller's and -pgrp means pgrp.
+ */
 func (p *Proc) kill(pid int16, sig int) {
-	found := 0
+	tty := p.TTY
+	var pgrp int16
+	if pid == 0 {
+		pgrp = p.Pgrp
+	}
+	if pid < -1 {
+		for _, p1 := range p.Sys.Procs {
+			if p1.Pgrp == -pid {
+				pgrp = -pid
+			}
+		}
+	}
+	if pid < -1 && pgrp == 0 {
+		tty = nil
+		for _, p1 := range p.Sys.Procs {
+			if p1.Pid == -pid {
+				tty = p1.TTY
+			}
+		}
+		if tty == nil {
+			p.Error = ESRCH
+			return
+		}
+	}
+	found, denied := 0, 0
 	for _, p1 := range p.Sys.Procs {
 		if p1 == p {
 			continue
 		}
-		if pid != 0 && p1.Pid != pid {
+		if pid > 0 && p1.Pid != pid {
 			continue
 		}
-		if pid == 0 && (p1.TTY != p.TTY || p1.Pid == 1) {
+		if pgrp != 0 && p1.Pgrp != pgrp {
+			continue
+		}
+		if pgrp == 0 && pid <= 0 && pid != -1 && p1.TTY != tty {
+			continue
+		}
+		if pid <= 0 && p1.Pid == 1 {
 			continue
 		}
 		if p.Uid != 0 && p1.Uid != p.Uid {
+			denied++
 			continue
 		}
 		found++
@@ -214,6 +645,9 @@ func (p *Proc) kill(pid int16, sig int) {
 	}
 	if found == 0 {
 		p.Error = ESRCH
+		if denied != 0 {
+			p.Error = EPERM
+		}
 	}
 }
 
@@ -0,0 +1,795 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package v6unix
+
+import (
+	"errors"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"rsc.io/unix/pdp11"
+)
+
+func setuid(p *Proc, uid int8) Errno {
+	p.Error = 0
+	p.CPU.R[0] = uint16(uint8(uid))
+	syssetuid(p)
+	return p.Error
+}
+
+func getuid(p *Proc) (ruid, euid int8) {
+	sysgetuid(p)
+	return int8(p.CPU.R[0]), int8(p.CPU.R[0] >> 8)
+}
+
+// execAs runs exec of name in p as the user uid,
+// with the file made set-uid to owner.
+func execAs(t *testing.T, p *Proc, name string, uid, owner int8) {
+	t.Helper()
+	ip, _, _ := p.namei(name, nameFind)
+	if ip == nil {
+		t.Fatal(p.Error)
+	}
+	ip.mode |= _ISUID
+	ip.uid = owner
+	p.iput(ip)
+
+	p.Uid, p.RUid, p.SUid = uid, uid, uid
+	if p.CPU.Mem == nil {
+		p.CPU.Mem = &p.Mem
+	}
+	p.Error = 0
+	p.Args[0], p.Args[1] = strArg(p, 0o1000, name), 0o1200
+	copy(p.umem()[0o1200:], "\x00\x00")
+	if sysexec(p); p.Error != 0 {
+		t.Fatalf("exec %s: %v", name, p.Error)
+	}
+}
+
+func TestSetuid(t *testing.T) {
+	p := rootProc(t)
+	execAs(t, p, "/bin/ls", 5, 3)
+	if r, e := getuid(p); r != 5 || e != 3 || p.SUid != 3 {
+		t.Fatalf("after exec of set-uid file: ruid %d euid %d suid %d, want 5 3 3", r, e, p.SUid)
+	}
+
+	// Switching between the real and saved ids works both ways.
+	if err := setuid(p, 5); err != 0 || p.Uid != 5 {
+		t.Errorf("setuid(real) = %v, euid %d, want 0, 5", err, p.Uid)
+	}
+	if err := setuid(p, 3); err != 0 || p.Uid != 3 {
+		t.Errorf("setuid(saved) = %v, euid %d, want 0, 3", err, p.Uid)
+	}
+	if err := setuid(p, 7); err != EPERM || p.Uid != 3 {
+		t.Errorf("setuid(other) = %v, euid %d, want EPERM, 3", err, p.Uid)
+	}
+
+	// A set-uid-root program dropping privileges
+	// with setuid(getuid()) cannot get them back.
+	execAs(t, p, "/bin/ls", 5, 0)
+	if r, e := getuid(p); r != 5 || e != 0 {
+		t.Fatalf("after exec of set-uid-root file: ruid %d euid %d, want 5 0", r, e)
+	}
+	r, _ := getuid(p)
+	if err := setuid(p, r); err != 0 {
+		t.Fatal(err)
+	}
+	if p.Uid != 5 || p.RUid != 5 || p.SUid != 5 {
+		t.Errorf("after setuid(getuid()): euid %d ruid %d suid %d, want 5 5 5", p.Uid, p.RUid, p.SUid)
+	}
+	if err := setuid(p, 0); err != EPERM || p.Uid != 5 {
+		t.Errorf("setuid(0) after dropping = %v, euid %d, want EPERM, 5", err, p.Uid)
+	}
+}
+
+func TestSetgid(t *testing.T) {
+	p := rootProc(t)
+	p.Gid, p.RGid, p.SGid = 4, 4, 6
+	p.Uid = 5
+	for _, tt := range []struct {
+		gid  int8
+		want Errno
+	}{
+		{6, 0},
+		{4, 0},
+		{7, EPERM},
+	} {
+		p.Error = 0
+		p.CPU.R[0] = uint16(tt.gid)
+		if syssetgid(p); p.Error != tt.want {
+			t.Errorf("setgid(%d) = %v, want %v", tt.gid, p.Error, tt.want)
+		}
+	}
+	sysgetgid(p)
+	if p.CPU.R[0] != 4<<8|4 {
+		t.Errorf("getgid = %#o, want %#o", p.CPU.R[0], 4<<8|4)
+	}
+}
+
+func TestExecModeBits(t *testing.T) {
+	// A file system mounted nosuid ignores set-uid and set-gid bits.
+	p := rootProc(t)
+	p.Sys.Disk.nosuid = true
+	ip, _, _ := p.namei("/bin/ls", nameFind)
+	ip.mode |= _ISGID
+	ip.gid = 9
+	p.iput(ip)
+	p.Gid = 4
+	execAs(t, p, "/bin/ls", 5, 0)
+	if r, e := getuid(p); r != 5 || e != 5 || p.Gid != 4 {
+		t.Errorf("exec of set-uid-root file on nosuid file system: ruid %d euid %d gid %d, want 5 5 4", r, e, p.Gid)
+	}
+	p.Sys.Disk.nosuid = false
+	execAs(t, p, "/bin/ls", 5, 0)
+	if _, e := getuid(p); e != 0 || p.Gid != 9 {
+		t.Errorf("exec of set-uid-root, set-gid file: euid %d gid %d, want 0 9", e, p.Gid)
+	}
+
+	// A sticky program is kept after exec until the file changes.
+	ip, _, _ = p.namei("/bin/ls", nameFind)
+	defer p.iput(ip)
+	ip.mode |= _ISVTX
+	execAs(t, p, "/bin/ls", 0, 0)
+	if ip.text == nil {
+		t.Fatalf("sticky program not saved after exec")
+	}
+	p.writei(ip, ip.text[:2], 0)
+	if ip.text != nil {
+		t.Errorf("sticky program still saved after write")
+	}
+	ip.mode &^= _ISVTX
+	execAs(t, p, "/bin/ls", 0, 0)
+	if ip.text != nil {
+		t.Errorf("non-sticky program saved after exec")
+	}
+}
+
+func TestUmask(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := sys.newProc()
+	p.Dir = p.iget(ROOTINO)
+	p.CPU.R[0] = 0o077
+	sysumask(p)
+	if p.CPU.R[0] != 0o022 {
+		t.Errorf("umask returned %#o, want default %#o", p.CPU.R[0], 0o022)
+	}
+
+	p.Args[0], p.Args[1] = strArg(p, 0o1000, "/tmp/f"), 0o666
+	if syscreate(p); p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	closefd(p, p.CPU.R[0])
+	var st stat
+	if p.stat("/tmp/f", &st); p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	if st.mode&0o7777 != 0o600 {
+		t.Errorf("created with umask 077: mode %#o, want %#o", st.mode&0o7777, 0o600)
+	}
+
+	// Creating an existing file leaves its mode alone.
+	p.CPU.R[0] = 0o777
+	sysumask(p)
+	if syscreate(p); p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	closefd(p, p.CPU.R[0])
+	if p.stat("/tmp/f", &st); st.mode&0o7777 != 0o600 {
+		t.Errorf("recreated: mode %#o, want %#o", st.mode&0o7777, 0o600)
+	}
+}
+
+func TestKill(t *testing.T) {
+	var sys System
+	tty1, tty2 := new(TTY), new(TTY)
+	procs := []struct {
+		pid int16
+		uid int8
+		tty *TTY
+	}{
+		{1, 0, tty1},
+		{2, 5, tty1}, // the killer
+		{3, 5, tty1},
+		{4, 6, tty1},
+		{5, 5, tty2},
+		{6, 6, tty2},
+	}
+	for _, pp := range procs {
+		p := &Proc{Sys: &sys, TTY: pp.tty}
+		p.Pid, p.Uid = pp.pid, pp.uid
+		sys.Procs = append(sys.Procs, p)
+	}
+	p := sys.Procs[1]
+
+	tests := []struct {
+		uid  int8
+		pid  int16
+		want Errno
+		sent []int16
+	}{
+		{5, 3, 0, []int16{3}},
+		{5, 4, EPERM, nil},
+		{5, 9, ESRCH, nil},
+		{5, 0, 0, []int16{3}},
+		{0, 0, 0, []int16{3, 4}},
+		{5, -1, 0, []int16{3, 5}},
+		{0, -1, 0, []int16{3, 4, 5, 6}},
+		{0, -6, 0, []int16{5, 6}},
+		{6, -5, 0, []int16{6}},
+		{7, -5, EPERM, nil},
+		{5, -9, ESRCH, nil},
+	}
+	for _, tt := range tests {
+		for _, p1 := range sys.Procs {
+			p1.sig = 0
+		}
+		p.Uid = tt.uid
+		p.Error = 0
+		p.CPU.R[0] = uint16(tt.pid)
+		p.Args[0] = SIGINT
+		syskill(p)
+		var sent []int16
+		for _, p1 := range sys.Procs {
+			if p1.sig == SIGINT {
+				sent = append(sent, p1.Pid)
+			}
+		}
+		if p.Error != tt.want || !slices.Equal(sent, tt.sent) {
+			t.Errorf("uid %d: kill(%d) = %v, signaled %v, want %v, %v", tt.uid, tt.pid, p.Error, sent, tt.want, tt.sent)
+		}
+	}
+}
+
+func TestSignal(t *testing.T) {
+	var sys System
+	p := &Proc{Sys: &sys}
+	p.CPU.Mem = &p.Mem
+	signal := func(sig, handler uint16) (uint16, Errno) {
+		p.Error = 0
+		p.Args[0], p.Args[1] = sig, handler
+		syssig(p)
+		return p.CPU.R[0], p.Error
+	}
+
+	if _, err := signal(SIGKIL, 1); err != EINVAL {
+		t.Errorf("signal(SIGKIL) = %v, want EINVAL", err)
+	}
+	if _, err := signal(NSIG, 1); err != EINVAL {
+		t.Errorf("signal(NSIG) = %v, want EINVAL", err)
+	}
+	if old, err := signal(SIGINT, 0o2000); err != 0 || old != 0 {
+		t.Errorf("signal(SIGINT) = %#o, %v, want 0, 0", old, err)
+	}
+	if old, _ := signal(SIGINT, 0o2000); old != 0o2000 {
+		t.Errorf("signal(SIGINT) again = %#o, want %#o", old, 0o2000)
+	}
+
+	// Setting a disposition discards that signal if pending.
+	sys.psignal(p, SIGQIT)
+	signal(SIGQIT, 1)
+	if p.issig() {
+		t.Errorf("ignored signal still pending")
+	}
+
+	// A caught signal pushes the PS and PC
+	// and goes to the handler, once.
+	p.CPU.R[pdp11.SP] = 0o10000
+	p.CPU.R[pdp11.PC] = 0o1234
+	p.CPU.PS = 0o17
+	sys.psignal(p, SIGQIT)
+	if p.issig() {
+		t.Errorf("ignored signal reported by issig")
+	}
+	sys.psignal(p, SIGINT)
+	if !p.issig() {
+		t.Fatal("caught signal not reported by issig")
+	}
+	p.psig()
+	sp := p.CPU.R[pdp11.SP]
+	if sp != 0o10000-4 || p.CPU.R[pdp11.PC] != 0o2000 {
+		t.Errorf("after psig: sp %#o pc %#o, want %#o %#o", sp, p.CPU.R[pdp11.PC], 0o10000-4, 0o2000)
+	}
+	pc, _ := p.Mem.ReadW(sp)
+	ps, _ := p.Mem.ReadW(sp + 2)
+	if pc != 0o1234 || ps != 0o17 {
+		t.Errorf("signal frame pc %#o ps %#o, want %#o %#o", pc, ps, 0o1234, 0o17)
+	}
+	if p.issig() {
+		t.Errorf("signal still pending after delivery")
+	}
+	if p.Signals[SIGINT] != 0 {
+		t.Errorf("handler not reset to default after delivery")
+	}
+}
+
+func TestAlarm(t *testing.T) {
+	var sys System
+	p := &Proc{Sys: &sys}
+	sys.Procs = []*Proc{p}
+	alarm := func(n uint16) uint16 {
+		p.CPU.R[0] = n
+		sysalarm(p)
+		return p.CPU.R[0]
+	}
+	if left := alarm(10); left != 0 {
+		t.Errorf("first alarm returned %d, want 0", left)
+	}
+	if sys.Timer.IsZero() || time.Until(sys.Timer) > 10*time.Second {
+		t.Errorf("alarm(10) set timer to %v from now", time.Until(sys.Timer))
+	}
+	if left := alarm(5); left != 10 {
+		t.Errorf("alarm(5) returned %d, want 10", left)
+	}
+	if left := alarm(0); left != 5 || !p.clktim.IsZero() {
+		t.Errorf("alarm(0) returned %d, clktim %v, want 5, zero", left, p.clktim)
+	}
+
+	// An alarm that is due sends SIGALRM,
+	// and the timer is set for the next one.
+	q := &Proc{Sys: &sys}
+	sys.Procs = append(sys.Procs, q)
+	q.clktim = time.Now().Add(time.Hour)
+	p.clktim = time.Now().Add(-time.Second)
+	sys.Timer = p.clktim
+	sys.clock()
+	if p.sig != SIGALRM || !p.clktim.IsZero() || q.sig != 0 {
+		t.Errorf("after clock: sig %d, %d, clktim %v, want SIGALRM, 0, zero", p.sig, q.sig, p.clktim)
+	}
+	if !sys.Timer.Equal(q.clktim) {
+		t.Errorf("timer %v after clock, want next alarm %v", sys.Timer, q.clktim)
+	}
+}
+
+func TestClockTick(t *testing.T) {
+	var sys System
+	sys.Deterministic = true // time is only the ticks
+	sys.ClockHz = 10
+	p := &Proc{Sys: &sys}
+	sys.Procs = []*Proc{p}
+	t0 := sys.now()
+
+	p.CPU.R[0] = 1
+	sysalarm(p)
+	for i := 1; i < 10; i++ {
+		if sys.Tick(); p.sig != 0 {
+			t.Fatalf("SIGALRM after %d ticks, want 10", i)
+		}
+	}
+	sys.Tick()
+	if p.sig != SIGALRM || !p.clktim.IsZero() {
+		t.Errorf("after a second of ticks: sig %d, clktim %v, want SIGALRM, zero", p.sig, p.clktim)
+	}
+	if t1 := sys.now(); t1[1]-t0[1] != 1 {
+		t.Errorf("time went from %v to %v, want 1 second", t0, t1)
+	}
+
+	// Stepping the clock counts instructions instead.
+	sys.InstPerTick = 100
+	sys.clockStep(p, 99)
+	if p.UTime != 0 {
+		t.Errorf("utime %d after 99 instructions, want 0", p.UTime)
+	}
+	sys.clockStep(p, 1)
+	if p.UTime != 1 {
+		t.Errorf("utime %d after 100 instructions, want 1", p.UTime)
+	}
+}
+
+func TestAlarmInterruptsSleep(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Proc{Sys: sys, sched: make(chan bool)}
+	p.status = _SRUN
+	p.CPU.Mem = &p.Mem
+	sys.Procs = []*Proc{p}
+	p.Signals[SIGALRM] = 0o2000 // caught, so the process survives
+
+	// sys sleep with an alarm due long before it ends
+	const pc = 0o100
+	p.Mem.WriteW(pc, 0o104443)
+	p.CPU.R[pdp11.PC] = pc
+	p.CPU.Inst = 0o104443
+	p.CPU.R[0] = 3600
+	p.clktim = time.Now().Add(10 * time.Millisecond)
+	sys.setTimer(p.clktim)
+
+	done := make(chan error)
+	go func() { done <- Trap(p) }()
+	<-sys.idle // blocked in sleep
+	time.Sleep(time.Until(sys.Timer))
+	sys.clock()
+	p.sched <- true
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if p.CPU.PS.C() == 0 || Errno(p.CPU.R[0]) != EINTR || p.sig != SIGALRM {
+		t.Errorf("interrupted sleep: C=%d r0=%d sig=%d, want C=1 r0=EINTR sig=SIGALRM", p.CPU.PS.C(), p.CPU.R[0], p.sig)
+	}
+}
+
+func TestTime(t *testing.T) {
+	var sys System
+	p := &Proc{Sys: &sys}
+	gettime := func() int64 {
+		systime(p)
+		return int64(p.CPU.R[0])<<16 | int64(p.CPU.R[1])
+	}
+	if tm := gettime(); tm < boottime || tm > boottime+60 {
+		t.Errorf("time before SetTime = %d, want about %d", tm, boottime)
+	}
+
+	base := time.Date(1975, time.June, 1, 12, 0, 0, 0, time.UTC)
+	sys.SetTime(base)
+	if tm := gettime(); tm != base.Unix() {
+		t.Errorf("time after SetTime = %d, want %d", tm, base.Unix())
+	}
+
+	// stime is for the super-user only.
+	p.Uid = 5
+	p.CPU.R[0], p.CPU.R[1] = 0, 1000
+	if sysstime(p); p.Error != EPERM {
+		t.Errorf("stime by other user: %v, want EPERM", p.Error)
+	}
+	p.Uid = 0
+	p.Error = 0
+	p.CPU.R[0], p.CPU.R[1] = 0o1234, 0o5670
+	if sysstime(p); p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	if tm := gettime(); tm != 0o1234<<16|0o5670 {
+		t.Errorf("time after stime = %#o, want %#o", tm, 0o1234<<16|0o5670)
+	}
+	if tm := sys.now(); tm != [2]uint16{0o1234, 0o5670} {
+		t.Errorf("now = %#o, want [0o1234 0o5670]", tm)
+	}
+}
+
+func TestNice(t *testing.T) {
+	var sys System
+	p := &Proc{Sys: &sys}
+	nice := func(n int16) Errno {
+		p.Error = 0
+		p.CPU.R[0] = uint16(n)
+		sysnice(p)
+		return p.Error
+	}
+	for _, tt := range []struct {
+		uid  int8
+		n    int16
+		want int8
+		err  Errno
+	}{
+		{5, 4, 4, 0},
+		{5, 99, 20, 0},
+		{5, -4, 0, EPERM},
+		{0, -4, -4, 0},
+		{0, -99, -20, 0},
+	} {
+		p.Uid = tt.uid
+		if err := nice(tt.n); err != tt.err || p.nice != tt.want {
+			t.Errorf("uid %d: nice(%d) = %v, nice %d, want %v, %d", tt.uid, tt.n, err, p.nice, tt.err, tt.want)
+		}
+	}
+
+	// A process's user priority worsens with cpu use and nice.
+	p.nice = 5
+	p.cpu = 64
+	p.setpri(p)
+	if p.pri != _PUSER+4+5 {
+		t.Errorf("pri = %d, want %d", p.pri, _PUSER+4+5)
+	}
+
+	// Every second the clock decays cpu use,
+	// recomputes user priorities, and asks for a reschedule.
+	q := &Proc{Sys: &sys}
+	q.cpu = 5
+	q.pri = _PSLEP
+	sys.Procs = []*Proc{p, q}
+	sys.ticks = HZ - 1
+	sys.runrun = 0
+	sys.tick(p)
+	if p.cpu != 64+1-10 || q.cpu != 0 || p.UTime != 1 {
+		t.Errorf("after a second: cpu %d, %d, utime %d, want %d, 0, 1", p.cpu, q.cpu, p.UTime, 64+1-10)
+	}
+	if p.pri != _PUSER+3+5 || q.pri != _PSLEP || sys.runrun == 0 {
+		t.Errorf("after a second: pri %d, %d, runrun %d, want %d, %d, >0", p.pri, q.pri, sys.runrun, _PUSER+3+5, _PSLEP)
+	}
+
+	// The scheduler keeps running p if nothing better is runnable.
+	p.status, q.status = _SRUN, _SRUN
+	q.pri = _PUSER + 20
+	p.swtch()
+	if sys.curpri != p.pri || sys.runrun != 0 {
+		t.Errorf("swtch: curpri %d runrun %d, want %d 0", sys.curpri, sys.runrun, p.pri)
+	}
+}
+
+func chdir(p *Proc, name string) Errno {
+	p.Error = 0
+	p.Args[0] = strArg(p, 0o1000, name)
+	syschdir(p)
+	return p.Error
+}
+
+func chroot(p *Proc, name string) Errno {
+	p.Error = 0
+	p.Args[0] = strArg(p, 0o1000, name)
+	syschroot(p)
+	return p.Error
+}
+
+func TestChroot(t *testing.T) {
+	p := rootProc(t)
+	bin, _ := lookup(p, "/bin")
+	ls, _ := lookup(p, "/bin/ls")
+
+	if err := chdir(p, "/bin"); err != 0 {
+		t.Fatal(err)
+	}
+	if inum, err := lookup(p, "ls"); err != 0 || inum != ls {
+		t.Errorf("ls in /bin = %d, %v, want %d", inum, err, ls)
+	}
+	if err := chdir(p, "/bin/ls"); err != ENOTDIR {
+		t.Errorf("chdir to file = %v, want ENOTDIR", err)
+	}
+	if err := chroot(p, "/bin/ls"); err != ENOTDIR {
+		t.Errorf("chroot to file = %v, want ENOTDIR", err)
+	}
+
+	// Only the super-user can chroot,
+	// and chdir needs search permission.
+	p.Uid = 5
+	if err := chroot(p, "/bin"); err != EPERM {
+		t.Errorf("chroot as user = %v, want EPERM", err)
+	}
+	ip, _, _ := p.namei("/tmp", nameFind)
+	ip.mode &^= 0o111
+	p.iput(ip)
+	if err := chdir(p, "/tmp"); err != EACCES {
+		t.Errorf("chdir to unsearchable dir = %v, want EACCES", err)
+	}
+	if err := chroot(p, "/tmp"); err != EPERM {
+		t.Errorf("chroot as user = %v, want EPERM", err)
+	}
+	p.Uid = 0
+
+	// After chroot, / and .. stop at the new root,
+	// in the process and in its children.
+	if err := chroot(p, "/bin"); err != 0 {
+		t.Fatal(err)
+	}
+	count := p.Root.count
+	child, err := p.Sys.Fork(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []*Proc{p, child} {
+		for _, name := range []string{"/", "..", "/..", "../..", "/../."} {
+			if inum, err := lookup(q, name); err != 0 || inum != bin {
+				t.Errorf("lookup %s = %d, %v, want %d", name, inum, err, bin)
+			}
+		}
+		if inum, err := lookup(q, "/../ls"); err != 0 || inum != ls {
+			t.Errorf("lookup /../ls = %d, %v, want %d", inum, err, ls)
+		}
+		if _, err := lookup(q, "/bin/ls"); err != ENOENT {
+			t.Errorf("lookup /bin/ls = %v, want ENOENT", err)
+		}
+	}
+	// p.Dir is /bin too, so the child holds two references.
+	if child.Root != p.Root || p.Root.count != count+2 {
+		t.Errorf("root count %d after fork, want %d", p.Root.count, count+2)
+	}
+}
+
+func TestAccessCall(t *testing.T) {
+	p := rootProc(t)
+	access := func(name string, mode uint16) Errno {
+		p.Error = 0
+		p.Args[0], p.Args[1] = strArg(p, 0o1000, name), mode
+		sysaccess(p)
+		return p.Error
+	}
+	p.Args[0], p.Args[1] = strArg(p, 0o1000, "/tmp/f"), 0o600
+	if syscreate(p); p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	closefd(p, p.CPU.R[0])
+	ip, _, _ := p.namei("/tmp/f", nameFind)
+	ip.uid, ip.gid = 3, 3
+	p.iput(ip)
+
+	// Existence.
+	if err := access("/tmp/f", 0); err != 0 {
+		t.Errorf("access(/tmp/f, 0) = %v, want 0", err)
+	}
+	if err := access("/tmp/nonexistent", 0); err != ENOENT {
+		t.Errorf("access(/tmp/nonexistent, 0) = %v, want ENOENT", err)
+	}
+
+	// The effective user 3 can open the file,
+	// but access asks about the real user 5.
+	p.Uid, p.RUid, p.Gid, p.RGid = 3, 5, 3, 5
+	p.Error = 0
+	p.Args[0], p.Args[1] = strArg(p, 0o1000, "/tmp/f"), 2
+	if sysopen(p); p.Error != 0 {
+		t.Fatalf("open as effective owner: %v", p.Error)
+	}
+	closefd(p, p.CPU.R[0])
+	for _, mode := range []uint16{4, 2, 6} {
+		if err := access("/tmp/f", mode); err != EACCES {
+			t.Errorf("access(/tmp/f, %o) as real user 5 = %v, want EACCES", mode, err)
+		}
+	}
+	if err := access("/tmp/f", 0); err != 0 {
+		t.Errorf("access(/tmp/f, 0) as real user 5 = %v, want 0", err)
+	}
+	if p.Uid != 3 || p.Gid != 3 {
+		t.Errorf("access changed effective ids to %d, %d", p.Uid, p.Gid)
+	}
+
+	// The other way round: the real owner may.
+	p.Uid, p.RUid = 5, 3
+	if err := access("/tmp/f", 6); err != 0 {
+		t.Errorf("access(/tmp/f, 6) as real owner = %v, want 0", err)
+	}
+	if err := access("/tmp/f", 1); err != EACCES {
+		t.Errorf("access(/tmp/f, 1) = %v, want EACCES", err)
+	}
+}
+
+func TestGetpid(t *testing.T) {
+	p := runningProc(t, 1)
+	getpid := func(p *Proc) (pid, ppid int16) {
+		sysgetpid(p)
+		return int16(p.CPU.R[0]), int16(p.CPU.R[1])
+	}
+
+	p.CPU.R[pdp11.PC] = 0o100
+	if sysfork(p); p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	c := p.Sys.lookpid(int16(p.CPU.R[0]))
+	if pid, ppid := getpid(c); pid != c.Pid || ppid != 1 {
+		t.Errorf("child getpid = %d, %d, want %d, 1", pid, ppid, c.Pid)
+	}
+	execAs(t, c, "/bin/ls", 0, 0)
+	if pid, _ := getpid(c); pid != c.Pid {
+		t.Errorf("getpid after exec = %d, want %d", pid, c.Pid)
+	}
+
+	// When the child dies, its own child is left to init.
+	gc := &Proc{Sys: p.Sys}
+	gc.Pid, gc.Ppid = 99, c.Pid
+	p.Sys.Procs = append(p.Sys.Procs, gc)
+	if _, ppid := getpid(gc); ppid != c.Pid {
+		t.Errorf("grandchild getppid = %d, want %d", ppid, c.Pid)
+	}
+	p.Sys.psignal(c, SIGKIL)
+	p.CPU.R[pdp11.PC] = 0o100
+	p.CPU.Inst = 0o104407 // sys wait
+	p.Mem.WriteW(0o100, p.CPU.Inst)
+	done := make(chan error)
+	go func() { done <- Trap(p) }()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if pid, ppid := getpid(gc); pid != 99 || ppid != 1 {
+		t.Errorf("orphan getpid = %d, %d, want 99, 1", pid, ppid)
+	}
+}
+
+func TestProfil(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sys.Deterministic = true
+	const buf = 0o1000
+	aout := []byte{
+		0o07, 0o01, 12, 0, 0, 0, 0o100, 0o002, 0, 0, 0, 0, 0, 0, 0, 0, // 0407, 12 bytes of text, 0o1100 of bss
+		0o054, 0o211, // 0: sys prof; buf; 0o100; 0; 0o177777
+		0o000, 0o002,
+		0o100, 0o000,
+		0o000, 0o000,
+		0o377, 0o377,
+		0o377, 0o001, // 10: br .
+	}
+	p, err := sys.Start(aout, []string{"a.out"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sys.RunN(100 * DefaultInstPerTick); err != ErrBudgetExceeded {
+		t.Fatalf("RunN = %v, want ErrBudgetExceeded", err)
+	}
+	b, err := p.ReadMem(buf, 0o100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(b); i += 2 {
+		n := int(b[i]) | int(b[i+1])<<8
+		if i == 10 {
+			// Every tick but perhaps one before the prof call hits the br.
+			if n == 0 || n < int(p.UTime)-1 {
+				t.Errorf("count for pc 10 = %d, want about %d", n, p.UTime)
+			}
+		} else if n != 0 {
+			t.Errorf("count for pc %d = %d, want 0", i, n)
+		}
+	}
+
+	// At half the scale, a pc counts at half its offset.
+	p.WriteMem(buf, make([]byte, 0o100))
+	p.Prof = [4]uint16{buf, 0o100, 0, 0o100000 >> 1}
+	p.incupc(0o20)
+	if b, _ := p.ReadMem(buf+0o10, 2); b[0] != 1 {
+		t.Errorf("count for pc 0o20 at half scale = %d, want 1 at buf+0o10", b[0])
+	}
+
+	p.exec(aout, []string{"a.out"}, nil)
+	if p.Prof[3] != 0 {
+		t.Errorf("exec left profiling on")
+	}
+}
+
+func TestTimesSyscall(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sys.Deterministic = true
+	aout := []byte{0o07, 0o01, 24, 0, 0, 0, 0o100, 0o002, 0, 0, 0, 0, 0, 0, 0, 0} // 0407, 24 bytes of text, 0o1100 of bss
+	for _, w := range []uint16{
+		0o012701, 60000, // 0: mov $60000., r1
+		0o005301,      // 4: dec r1
+		0o001376,      // 6: bne 4
+		0o012702, 200, // 8: mov $200., r2
+		0o104424,         // 12: sys getpid
+		0o005302,         // 14: dec r2
+		0o001375,         // 16: bne 12
+		0o104453, 0o1000, // 18: sys times; 0o1000
+		0o104401, // 22: sys exit
+	} {
+		aout = append(aout, byte(w), byte(w>>8))
+	}
+	p, err := sys.Start(aout, []string{"a.out"}, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sys.Continue(); !errors.Is(err, ErrIdle) {
+		t.Fatalf("Continue = %v, want ErrIdle", err)
+	}
+	var tms [6]uint16
+	for i := range tms {
+		tms[i], _ = p.Mem.ReadW(0o1000 + 2*uint16(i))
+	}
+	// The loop is 120000 instructions and the system calls
+	// 200 of 100 each: 24 ticks of user time and 4 of system.
+	if utime, stime := tms[0], tms[1]; utime < 23 || utime > 25 || stime < 3 || stime > 5 {
+		t.Errorf("times = %d user, %d system, want about 24, 4", utime, stime)
+	}
+
+	// A waited-for child's times add to the children's.
+	q := &Proc{Sys: sys}
+	q.Pid = 2
+	q.Times = Times{CUTime: [2]int16{0, 1}, CSTime: [2]int16{0, 2}}
+	c := &Proc{Sys: sys}
+	c.Pid, c.Ppid, c.status = 3, 2, _SZOMB
+	c.Times = Times{UTime: 10, STime: 20, CUTime: [2]int16{1, -1}, CSTime: [2]int16{0, 5}}
+	sys.Procs = []*Proc{q, c}
+	if syswait(q); q.Error != 0 {
+		t.Fatal(q.Error)
+	}
+	if q.CUTime != [2]int16{2, 10} || q.CSTime != [2]int16{0, 27} {
+		t.Errorf("children's times = %v, %v, want [2 10], [0 27]", q.CUTime, q.CSTime)
+	}
+}
@@ -7,7 +7,9 @@ package v6unix
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"rsc.io/unix/pdp11"
 )
@@ -24,7 +26,7 @@ func Trap(p *Proc) error {
 		p.CPU.R[pdp11.PC] += 2 // consume argp
 		var err error
 		// old := argp
-		argp, err = p.CPU.ReadW(argp)
+		argp, err = p.CPU.ReadIW(argp)
 		if err != nil {
 			return err
 		}
@@ -45,10 +47,15 @@ func Trap(p *Proc) error {
 		return fmt.Errorf("invalid syscall %#o", trap)
 	}
 	old := argp
+	regs := p.CPU.R
 	sys := &sysent[trap]
+	read := p.CPU.ReadW
+	if otrap != 0 {
+		read = p.CPU.ReadIW /* the arguments follow the trap, in I space */
+	}
 	for i := 0; i < int(sys.args); i++ {
 		var err error
-		p.Args[i], err = p.CPU.ReadW(argp)
+		p.Args[i], err = read(argp)
 		if err != nil {
 			return err
 		}
@@ -97,11 +104,17 @@ func Trap(p *Proc) error {
 
 	p.Error = 0
 	interrupted := false
+	var start time.Time
+	if p.Sys.EnableProfiling {
+		start = time.Now()
+	}
 	func() {
 		defer func() {
 			if e := recover(); e != nil {
-				fmt.Fprintf(os.Stderr, "[pid %d] trap INTR %06o %s %06o %06o\n", p.Pid, old, desc, p.CPU.R[:], p.Args[:sys.args])
-				if e == "sleep interrupted" {
+				if p.Sys.Trace {
+					fmt.Fprintf(os.Stderr, "[pid %d] trap INTR %06o %s %06o %06o\n", p.Pid, old, desc, p.CPU.R[:], p.Args[:sys.args])
+				}
+				if e == sleepInterrupted {
 					interrupted = true
 					return
 				}
@@ -110,6 +123,10 @@ func Trap(p *Proc) error {
 		}()
 		sys.impl(p)
 	}()
+	if !start.IsZero() {
+		p.Sys.prof[trap].add(time.Since(start))
+	}
+	p.Sys.clockSys(p)
 	if p.Sys.Trace {
 		fmt.Fprintf(os.Stderr, "[pid %d] trap DONE %06o %s %06o %06o\n", p.Pid, old, desc, p.CPU.R[:], p.Args[:sys.args])
 	}
@@ -121,6 +138,18 @@ func Trap(p *Proc) error {
 		p.CPU.PS.SetC(true)
 		p.CPU.R[0] = uint16(p.Error)
 	}
+	p.setpri(p)
+
+	if f := p.Sys.sysTrace; f != nil {
+		call, _, _ := strings.Cut(sys.name, ")")
+		args := append([]uint16(nil), regs[:strings.Count(call, "%r")]...)
+		args = append(args, p.Args[:sys.args]...)
+		ret := int(p.CPU.R[0])
+		if p.Error != 0 {
+			ret = -1
+		}
+		f(p, trap, args, ret, p.Error)
+	}
 
 	if p.Sys.Trace {
 		if p.Error != 0 {
@@ -153,6 +182,73 @@ func Trap(p *Proc) error {
 	return nil
 }
 
+// A SyscallTraceFunc is called after each system call a process makes,
+// with the call number, the arguments, the result, and the error, if any.
+// The arguments are the registers, for the calls that take
+// arguments there, followed by the words after the trap instruction.
+// The result is r0, or -1 if the call failed.
+// A call interrupted by a signal fails with EINTR.
+type SyscallTraceFunc func(p *Proc, num uint16, args []uint16, ret int, err Errno)
+
+// SetSyscallTrace arranges for f to be called after every system call
+// made by every process, or turns the tracing off if f is nil.
+func (sys *System) SetSyscallTrace(f SyscallTraceFunc) {
+	sys.sysTrace = f
+}
+
+// SyscallName returns the name of system call num, like "read",
+// or the empty string if num is not a system call.
+func SyscallName(num uint16) string {
+	if int(num) >= len(sysent) {
+		return ""
+	}
+	name, _, _ := strings.Cut(sysent[num].name, "(")
+	if name == "" || '0' <= name[0] && name[0] <= '9' {
+		return ""
+	}
+	return name
+}
+
+// A ProfileStat records the executions of one system call
+// while profiling is enabled.
+// The durations are wall-clock time, including any time
+// the calling process spent asleep in the call.
+type ProfileStat struct {
+	Count int           // calls made
+	Total time.Duration // time spent in all calls
+	Max   time.Duration // time spent in the longest call
+}
+
+func (s *ProfileStat) add(d time.Duration) {
+	s.Count++
+	s.Total += d
+	s.Max = max(s.Max, d)
+}
+
+// SyscallProfile returns the statistics gathered for each
+// system call since profiling was enabled or last reset,
+// keyed by the names SyscallName returns.
+// Calls that do not return, like exit, are not counted.
+func (sys *System) SyscallProfile() map[string]ProfileStat {
+	m := make(map[string]ProfileStat)
+	for i, s := range sys.prof {
+		if s.Count == 0 {
+			continue
+		}
+		name := SyscallName(uint16(i))
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		m[name] = s
+	}
+	return m
+}
+
+// ResetProfile discards the statistics gathered so far.
+func (sys *System) ResetProfile() {
+	clear(sys.prof[:])
+}
+
 func sysnull(p *Proc) {
 }
 
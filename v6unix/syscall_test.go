@@ -0,0 +1,144 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package v6unix
+
+import (
+	"slices"
+	"testing"
+
+	"rsc.io/unix/pdp11"
+)
+
+func TestSyscallName(t *testing.T) {
+	for _, tt := range []struct {
+		num  uint16
+		name string
+	}{
+		{1, "exit"},
+		{3, "read"},
+		{33, "access"},
+		{29, ""},
+		{64, ""},
+		{1000, ""},
+	} {
+		if name := SyscallName(tt.num); name != tt.name {
+			t.Errorf("SyscallName(%d) = %q, want %q", tt.num, name, tt.name)
+		}
+	}
+}
+
+func TestSyscallTrace(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Proc{Sys: sys, sched: make(chan bool)}
+	p.status = _SRUN
+	p.CPU.Mem = &p.Mem
+	p.Pid = 7
+	sys.Procs = []*Proc{p}
+	p.Signals[SIGINT] = 0o2000 // caught, so the process survives
+
+	type call struct {
+		num  uint16
+		args []uint16
+		ret  int
+		err  Errno
+	}
+	var calls []call
+	sys.SetSyscallTrace(func(p *Proc, num uint16, args []uint16, ret int, err Errno) {
+		calls = append(calls, call{num, args, ret, err})
+	})
+	trap := func(pc, inst uint16) {
+		p.Mem.WriteW(pc, inst)
+		p.CPU.R[pdp11.PC] = pc
+		p.CPU.Inst = inst
+	}
+
+	// sys sleep, interrupted by a signal
+	trap(0o100, 0o104443)
+	p.CPU.R[0] = 3600
+	done := make(chan error)
+	go func() { done <- Trap(p) }()
+	<-sys.idle
+	sys.psignal(p, SIGINT)
+	p.sched <- true
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	p.sig = 0
+
+	// sys getpid, which works as usual afterward
+	trap(0o200, 0o104424)
+	if err := Trap(p); err != nil {
+		t.Fatal(err)
+	}
+
+	// sys indir; 0o300, running sys close with a bad descriptor
+	trap(0o210, 0o104400)
+	p.Mem.WriteW(0o212, 0o300)
+	p.Mem.WriteW(0o300, 0o104406)
+	p.CPU.R[0] = 9
+	if err := Trap(p); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []call{
+		{35, []uint16{3600}, -1, EINTR},
+		{20, []uint16{}, 7, 0},
+		{6, []uint16{9}, -1, EBADF},
+	}
+	if !slices.EqualFunc(calls, want, func(x, y call) bool {
+		return x.num == y.num && slices.Equal(x.args, y.args) && x.ret == y.ret && x.err == y.err
+	}) {
+		t.Errorf("traced %v, want %v", calls, want)
+	}
+}
+
+func TestSyscallProfile(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Proc{Sys: sys, sched: make(chan bool)}
+	p.status = _SRUN
+	p.CPU.Mem = &p.Mem
+	sys.Procs = []*Proc{p}
+	call := func(inst uint16) {
+		t.Helper()
+		p.Mem.WriteW(0o100, inst)
+		p.CPU.R[pdp11.PC] = 0o100
+		p.CPU.Inst = inst
+		if err := Trap(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	call(0o104424) // getpid, not counted
+	sys.EnableProfiling = true
+	call(0o104424) // getpid
+	call(0o104424) // getpid
+	p.CPU.R[0] = 9
+	call(0o104406) // close, failing
+	prof := sys.SyscallProfile()
+	if len(prof) != 2 || prof["getpid"].Count != 2 || prof["close"].Count != 1 {
+		t.Errorf("profile = %v, want 2 getpid, 1 close", prof)
+	}
+	for name, s := range prof {
+		if s.Max > s.Total || s.Total < 0 {
+			t.Errorf("%s: max %v, total %v", name, s.Max, s.Total)
+		}
+	}
+
+	sys.ResetProfile()
+	if prof := sys.SyscallProfile(); len(prof) != 0 {
+		t.Errorf("profile after reset = %v, want empty", prof)
+	}
+	sys.EnableProfiling = false
+	call(0o104424)
+	if prof := sys.SyscallProfile(); len(prof) != 0 {
+		t.Errorf("profile with profiling off = %v, want empty", prof)
+	}
+}
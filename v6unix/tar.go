@@ -0,0 +1,314 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Tar archives are not in v6; the code is new.
+
+package v6unix
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// DumpTar writes the file system to w as a tar archive,
+// walking the directory tree from the root.
+// Names in the archive are relative to the root, as in "bin/ls".
+// A file with more than one name is written in full the first time
+// and as a hard link to that name after that.
+// Special files are written as character or block devices,
+// and symbolic links as symbolic links.
+func (sys *System) DumpTar(w io.Writer) error {
+	p := &Proc{Sys: sys}
+	p.Dir = p.iget(ROOTINO)
+	defer p.iput(p.Dir)
+
+	tw := tar.NewWriter(w)
+	seen := make(map[*inode]string)
+	if err := p.dumpTar(tw, p.Dir, "", seen); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func (p *Proc) dumpTar(tw *tar.Writer, dp *inode, dir string, seen map[*inode]string) error {
+	seen[dp] = dir
+	data := p.contents(dp)
+	for i := 0; i+int(direntSize) <= len(data); i += int(direntSize) {
+		de := (*dirent)(unsafe.Pointer(&data[i]))
+		name := de.name()
+		if de.inum == 0 || name == "." || name == ".." {
+			continue
+		}
+		ip := p.igetDisk(dp.disk, de.inum)
+		if ip == nil {
+			p.Error = 0
+			continue
+		}
+		err := p.dumpTarFile(tw, ip, dir+name, seen)
+		p.iput(ip)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Proc) dumpTarFile(tw *tar.Writer, ip *inode, name string, seen map[*inode]string) error {
+	mt := int64(ip.mtime[0])<<16 | int64(ip.mtime[1])
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    int64(ip.mode & 07777),
+		Uid:     int(uint8(ip.uid)),
+		Gid:     int(uint8(ip.gid)),
+		ModTime: time.Unix(mt, 0),
+		Format:  tar.FormatUSTAR,
+	}
+	var data []byte
+	switch {
+	case ip.mode&(_IFMT|_ILARG) == _IFLNK:
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = string(p.contents(ip))
+	case ip.mode&_IFMT == _IFDIR:
+		if _, ok := seen[ip]; ok {
+			// Another name for a directory already written.
+			return nil
+		}
+		hdr.Typeflag = tar.TypeDir
+		hdr.Name += "/"
+	case seen[ip] != "":
+		hdr.Typeflag = tar.TypeLink
+		hdr.Linkname = seen[ip]
+	case ip.mode&_IFMT == _IFCHR:
+		hdr.Typeflag = tar.TypeChar
+		hdr.Devmajor = int64(ip.major)
+		hdr.Devminor = int64(ip.minor)
+	case ip.mode&_IFMT == _IFBLK:
+		hdr.Typeflag = tar.TypeBlock
+		hdr.Devmajor = int64(ip.major)
+		hdr.Devminor = int64(ip.minor)
+	default:
+		hdr.Typeflag = tar.TypeReg
+		data = p.contents(ip)
+		hdr.Size = int64(len(data))
+	}
+	if hdr.Typeflag != tar.TypeDir && seen[ip] == "" {
+		seen[ip] = name
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+	if hdr.Typeflag == tar.TypeDir {
+		return p.dumpTar(tw, ip, hdr.Name, seen)
+	}
+	return nil
+}
+
+// LoadTar reads a tar archive from r and adds its files
+// to the file system, making directories as needed.
+// A file that already exists is replaced by the one in the archive,
+// which must be of the same type.
+// Entries with no v6 equivalent, like FIFOs, are ignored,
+// and names that climb out of the root with ".." are an error (EINVAL).
+func (sys *System) LoadTar(r io.Reader) error {
+	p := &Proc{Sys: sys}
+	p.Dir = p.iget(ROOTINO)
+	defer p.iput(p.Dir)
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := p.loadTarFile(tr, hdr); err != nil {
+			return fmt.Errorf("%s: %w", hdr.Name, err)
+		}
+	}
+}
+
+func (p *Proc) loadTarFile(tr *tar.Reader, hdr *tar.Header) error {
+	name, ok := tarName(hdr.Name)
+	if !ok {
+		return EINVAL
+	}
+	if name == "/" {
+		return nil
+	}
+
+	var mode uint16
+	switch hdr.Typeflag {
+	default:
+		return nil
+	case tar.TypeLink:
+		return p.loadTarLink(hdr.Linkname, name)
+	case tar.TypeReg:
+		mode = 0
+	case tar.TypeDir:
+		mode = _IFDIR
+	case tar.TypeChar:
+		mode = _IFCHR
+	case tar.TypeBlock:
+		mode = _IFBLK
+	case tar.TypeSymlink:
+		mode = _IFLNK
+	}
+	mode |= uint16(hdr.Mode & 07777)
+
+	ip, err := p.tarCreate(name, mode)
+	if err != nil {
+		return err
+	}
+	defer p.iput(ip)
+	switch hdr.Typeflag {
+	case tar.TypeReg:
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		p.itrunc(ip)
+		p.writei(ip, data, 0)
+	case tar.TypeChar, tar.TypeBlock:
+		ip.major = uint8(hdr.Devmajor)
+		ip.minor = uint8(hdr.Devminor)
+	case tar.TypeSymlink:
+		p.writei(ip, []byte(hdr.Linkname), 0)
+	}
+	if p.Error != 0 {
+		return p.Error
+	}
+	ip.mode = _IALLOC | mode
+	ip.uid = int8(hdr.Uid)
+	ip.gid = int8(hdr.Gid)
+	t := hdr.ModTime.Unix()
+	ip.mtime = [2]uint16{uint16(t >> 16), uint16(t)}
+	if !hdr.AccessTime.IsZero() {
+		t = hdr.AccessTime.Unix()
+	}
+	ip.atime = [2]uint16{uint16(t >> 16), uint16(t)}
+	return nil
+}
+
+// tarName returns the file name for the archive name,
+// which is taken to be relative to the root.
+// It reports false if the name climbs out of the root with "..".
+func tarName(name string) (string, bool) {
+	depth := 0
+	for _, elem := range strings.Split(name, "/") {
+		switch elem {
+		case "", ".":
+		case "..":
+			if depth--; depth < 0 {
+				return "", false
+			}
+		default:
+			depth++
+		}
+	}
+	return path.Clean("/" + name), true
+}
+
+/*
+ * Return the inode for name,
+ * making it with the given mode if needed,
+ * along with the directories leading to it.
+ */
+func (p *Proc) tarCreate(name string, mode uint16) (*inode, error) {
+	ip, dp, off := p.namei(name, nameCreate|nameNoFollow)
+	if ip != nil {
+		if fileType(ip.mode) != fileType(mode) {
+			p.iput(ip)
+			return nil, EEXIST
+		}
+		return ip, nil
+	}
+	if dp == nil {
+		if p.Error != ENOENT {
+			return nil, p.Error
+		}
+		p.Error = 0
+		pp, err := p.tarCreate(path.Dir(name), _IFDIR|0o755)
+		if err != nil {
+			return nil, err
+		}
+		p.iput(pp)
+		return p.tarCreate(name, mode)
+	}
+	defer p.iput(dp)
+	ip = p.maknode(path.Base(name), mode, dp, off)
+	if ip == nil {
+		return nil, p.Error
+	}
+	if mode&_IFMT == _IFDIR {
+		p.wdir(ip, ".", ip, 0)
+		p.wdir(dp, "..", ip, DIRSIZ+2)
+		ip.nlink++
+		dp.nlink++
+	}
+	return ip, nil
+}
+
+/*
+ * Make name another link to the file
+ * target, replacing any existing file.
+ */
+func (p *Proc) loadTarLink(target, name string) error {
+	target, ok := tarName(target)
+	if !ok {
+		return EINVAL
+	}
+	ip, _, _ := p.namei(target, nameFind|nameNoFollow)
+	if ip == nil {
+		return p.Error
+	}
+	defer p.iput(ip)
+	if ip.mode&_IFMT == _IFDIR {
+		return EPERM
+	}
+
+	xp, _, _ := p.namei(name, nameFind|nameNoFollow)
+	if xp != nil {
+		p.iput(xp)
+		if xp == ip {
+			return nil
+		}
+		if xp.mode&_IFMT == _IFDIR {
+			return EEXIST
+		}
+		if p.unlink(name); p.Error != 0 {
+			return p.Error
+		}
+	}
+	p.Error = 0
+	dp, err := p.tarCreate(path.Dir(name), _IFDIR|0o755)
+	if err != nil {
+		return err
+	}
+	p.iput(dp)
+	xp, dp, off := p.namei(name, nameCreate|nameNoFollow)
+	if xp != nil {
+		p.iput(xp)
+		return EEXIST
+	}
+	if dp == nil {
+		return p.Error
+	}
+	defer p.iput(dp)
+	if ip.disk != dp.disk || ip.host != nil || dp.host != nil {
+		return EXDEV
+	}
+	p.wdir(ip, path.Base(name), dp, off)
+	ip.nlink++
+	return nil
+}
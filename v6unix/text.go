@@ -0,0 +1,118 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Ported from _fs/usr/sys/ken/text.c and _fs/usr/sys/text.h.
+//
+// Copyright 2001-2002 Caldera International Inc. All rights reserved.
+// Use of this source code is governed by a 4-clause BSD-style
+// license that can be found in the LICENSE file.
+
+package v6unix
+
+import "rsc.io/unix/pdp11"
+
+/*
+ * Text structure.
+ * One allocated per pure
+ * procedure in use.
+ * A program's text is in the same
+ * 64K as its data, which every process
+ * has to itself, except for split I&D
+ * (0411) programs, whose instruction
+ * space is kept here and shared.
+ * Otherwise the structure only marks
+ * the prototype inode busy, so that it
+ * cannot be written while it runs.
+ */
+type text struct {
+	iptr  *inode          /* inode of prototype */
+	count int             /* reference count */
+	imem  *pdp11.ArrayMem /* instruction space, for split I&D */
+}
+
+// An ispace is the instruction space of a split I&D program,
+// which the program can read but not write.
+type ispace struct {
+	mem *pdp11.ArrayMem
+}
+
+func (s ispace) ReadB(addr uint16) (uint8, error)     { return s.mem.ReadB(addr) }
+func (s ispace) ReadW(addr uint16) (uint16, error)    { return s.mem.ReadW(addr) }
+func (s ispace) WriteB(addr uint16, val uint8) error  { return pdp11.ErrMem }
+func (s ispace) WriteW(addr uint16, val uint16) error { return pdp11.ErrMem }
+
+// imem returns the instruction space of p,
+// or nil if p's text is in p.Mem.
+func (p *Proc) imem() *pdp11.ArrayMem {
+	if s, ok := p.CPU.IMem.(ispace); ok {
+		return s.mem
+	}
+	return nil
+}
+
+// itextw returns the instruction space of a split I&D process p
+// for writing, first giving p a private copy if the text is shared,
+// so that a debugger's changes do not affect other processes.
+func (p *Proc) itextw() *pdp11.ArrayMem {
+	if p.text != nil && p.text.imem == p.imem() {
+		imem := *p.imem()
+		p.CPU.IMem = ispace{&imem}
+	}
+	return p.imem()
+}
+
+/*
+ * relinquish use of the shared text segment
+ * of a process.
+ * The text of a sticky program is saved
+ * with its inode (see program in sys1.go),
+ * so the structure is always freed.
+ */
+func (p *Proc) xfree() {
+	xp := p.text
+	if xp == nil {
+		return
+	}
+	p.text = nil
+	if xp.count--; xp.count == 0 {
+		ip := xp.iptr
+		xp.iptr = nil
+		xp.imem = nil
+		ip.itext = false
+		p.iput(ip)
+	}
+}
+
+/*
+ * Attach to a shared text segment.
+ * If there is no shared text, just return.
+ * If the text table is full,
+ * the program runs unshared.
+ */
+func (p *Proc) xalloc(ip *inode, ts int) {
+	if ts == 0 || ip == nil {
+		return
+	}
+	var rp *text
+	for i := range p.Sys.texts {
+		xp := &p.Sys.texts[i]
+		if xp.iptr == nil {
+			if rp == nil {
+				rp = xp
+			}
+		} else if xp.iptr == ip {
+			xp.count++
+			p.text = xp
+			return
+		}
+	}
+	if rp == nil {
+		return
+	}
+	rp.count = 1
+	rp.iptr = ip
+	p.text = rp
+	ip.itext = true
+	ip.count++
+}
@@ -14,21 +14,68 @@ package v6unix
 
 import (
 	"bytes"
+	"fmt"
+	"io"
+	"time"
 	"unsafe"
 )
 
 type TTY struct {
 	TDev
-	Print func(b []byte, echo bool) (int, Errno)
-	State uint16
-	Raw   bytes.Buffer // raw input characters
-	Canon bytes.Buffer // canonicalized input characters
-	EOF   bool
-	Sys   *System
-	Delct int
+	tchars
+	lflags uint16  // local mode word (not in v6), settable by TIOCLSET
+	ws     winsize // window size (not in v6), settable by TIOCSWINSZ
+	Pgrp   int16   // foreground process group (not in v6), settable by TIOCSPGRP; 0 if none
+	sess   int16   // process group whose leader acquired it as controlling terminal (not in v6); 0 if none
+	Print  func(b []byte, echo bool) (int, Errno)
+	State  uint16
+	Raw    bytes.Buffer // raw input characters
+	Canon  bytes.Buffer // canonicalized input characters
+	outq   bytes.Buffer // output held while stopped
+	EOF    bool
+	Sys    *System
+	Delct  int
+	hungup bool      // carrier lost (not in v6); reads see EOF and writes fail until reopened
+	input  chan byte // from the reader given to AddTTY
+	detach func()    // stops the reader given to AddTTY
+	busy   time.Time // when the line is free to send (RealtimeTTY)
 }
 
 func (t *TTY) WriteByte(c byte) {
+	t.record('i', []byte{c})
+	if t.flags&RAW != 0 {
+		// Raw mode: no translation, no special characters, no echo.
+		// Each byte is available to read as soon as it arrives.
+		t.Canon.WriteByte(c)
+		t.Sys.wakeup(&t.Delct)
+		return
+	}
+
+	// Stop and start suspend and resume output (not in v6).
+	if c == t.stopc {
+		t.state |= TTSTOP
+		return
+	}
+	if c == t.startc {
+		t.start()
+		return
+	}
+
+	// Interrupt and quit signal the processes using the tty
+	// and throw away any pending input and held output;
+	// the character itself is not queued.
+	if c == t.intrc || c == t.quitc {
+		sig := SIGINT
+		if c == t.quitc {
+			sig = SIGQIT
+		}
+		t.Sys.signal(t, sig)
+		t.flushInput()
+		t.outq.Reset()
+		t.start()
+		return
+	}
+
 	// Translate modern backspace and ^U to v6 equivalents.
 	if c == '\b' || c == 0x7F {
 		c = t.erase
@@ -40,29 +87,217 @@ func (t *TTY) WriteByte(c byte) {
 	if c == '\r' && t.flags&CRMOD != 0 {
 		c = '\n'
 	}
-	if t.flags&RAW == 0 && (c == CQUIT || c == CINTR) {
-		sig := SIGINT
-		if c == CQUIT {
-			sig = SIGQIT
-		}
-		t.Sys.signal(t, sig)
-		t.Raw.Truncate(0)
-		t.Canon.Truncate(0)
-	}
 	if t.flags&LCASE != 0 && 'A' <= c && c <= 'Z' {
 		c += 'a' - 'A'
 	}
-	t.Raw.WriteByte(c)
-	if t.flags&RAW != 0 || c == '\n' || c == 0o004 {
-		t.Raw.WriteByte(0o377)
-		t.Delct++
+	echo := []byte{c}
+	if t.lflags&LCTLECH != 0 && (c < ' ' && c != '\t' && c != '\n' || c == 0o177) {
+		echo = []byte{'^', c ^ 0o100}
+	}
+	if c == '\n' && t.flags&CRMOD != 0 {
+		// As on output, so that the cursor returns to the left margin.
+		echo = []byte("\r\n")
+	}
+	if t.flags&CBREAK != 0 {
+		// Half-cooked: no line editing, each byte readable at once.
+		t.Canon.WriteByte(c)
 		t.Sys.wakeup(&t.Delct)
+	} else {
+		if c == t.erase && t.lflags&LCRTERA != 0 {
+			echo = t.echoErase()
+		}
+		t.Raw.WriteByte(c)
+		if c == '\n' || c == t.eofc {
+			t.Raw.WriteByte(0o377)
+			t.Delct++
+			t.Sys.wakeup(&t.Delct)
+		}
+	}
+	if t.flags&ECHO != 0 && t.Print != nil && len(echo) > 0 {
+		if c == '\n' {
+			t.col = 0
+		}
+		t.print(echo, true)
+	}
+}
+
+// echoErase returns the echo for typing the erase character
+// on a display terminal: the erased character is rubbed out
+// with backspace-space-backspace, or for a tab,
+// enough backspaces to return to where the tab began.
+// It must be called before the erase is added to t.Raw.
+func (t *TTY) echoErase() []byte {
+	line := t.Raw.Bytes()
+	if i := bytes.LastIndexByte(line, 0o377); i >= 0 {
+		line = line[i+1:]
+	}
+	before := t.edit(line)
+	after := t.edit(append(line[:len(line):len(line)], t.erase))
+	if len(after) >= len(before) {
+		if len(after) == 0 {
+			return nil // nothing to erase
+		}
+		return []byte{t.erase} // escaped: an ordinary character
 	}
-	if t.flags&ECHO != 0 && t.Print != nil {
-		var buf [1]byte
-		buf[0] = c
-		t.Print(buf[:], true)
+	if before[len(before)-1] == '\t' {
+		n := t.column(before) - t.column(after)
+		return bytes.Repeat([]byte{'\b'}, n)
 	}
+	return []byte("\b \b")
+}
+
+// column returns the column where echoing line leaves the cursor.
+// Echo does not update t.col, so t.col is where the line began.
+func (t *TTY) column(line []byte) int {
+	col := int(t.col)
+	for _, c := range line {
+		switch partab[c] & 0o77 {
+		case 0:
+			if c&0o300 != 0o200 {
+				col++
+			}
+		case 2:
+			if col > 0 {
+				col--
+			}
+		case 3, 6:
+			col = 0
+		case 4:
+			col |= 07
+			col++
+		}
+	}
+	return col
+}
+
+// start resumes output stopped by the stop character,
+// printing any output held in the meantime.
+func (t *TTY) start() {
+	if t.state&TTSTOP == 0 {
+		return
+	}
+	t.state &^= TTSTOP
+	if t.outq.Len() > 0 && t.Print != nil {
+		t.print(t.outq.Bytes(), false)
+	}
+	t.outq.Reset()
+	t.Sys.wakeup(&t.outq)
+}
+
+// sel reports whether a read or write of t
+// (rw is _FREAD or _FWRITE) would not block, for select.
+func (t *TTY) sel(rw int) bool {
+	if t.EOF || t.hungup {
+		return true
+	}
+	if rw == _FREAD {
+		return t.Canon.Len() > 0 || t.Delct > 0
+	}
+	return t.state&TTSTOP == 0 || t.outq.Len() <= TTHIWAT
+}
+
+// flushInput discards all pending input.
+func (t *TTY) flushInput() {
+	t.Raw.Reset()
+	t.Canon.Reset()
+	t.Delct = 0
+}
+
+// flush discards pending input if which has _FREAD set,
+// and held output if it has _FWRITE set,
+// waking any writers waiting for the output to go.
+func (t *TTY) flush(which int) {
+	if which&_FREAD != 0 {
+		t.flushInput()
+	}
+	if which&_FWRITE != 0 {
+		t.outq.Reset()
+		t.Sys.wakeup(&t.outq)
+	}
+}
+
+// Special characters, settable by the TIOCSETC ioctl (not in v6).
+// The layout matches the v7 struct tchars.
+// A character set to 0377 is disabled.
+type tchars struct {
+	intrc  uint8 /* interrupt */
+	quitc  uint8 /* quit */
+	startc uint8 /* start output */
+	stopc  uint8 /* stop output */
+	eofc   uint8 /* end-of-file */
+	brkc   uint8 /* input delimiter (like nl) */
+}
+
+var defaultTchars = tchars{
+	intrc:  CINTR,
+	quitc:  CQUIT,
+	startc: 'Q' & 037,
+	stopc:  'S' & 037,
+	eofc:   CEOT,
+	brkc:   0377,
+}
+
+// Window size, settable by the TIOCSWINSZ ioctl (not in v6).
+// The layout matches the 4.3BSD struct winsize.
+// A size of 0 means unknown.
+type winsize struct {
+	rows   uint16
+	cols   uint16
+	xpixel uint16 /* unused */
+	ypixel uint16 /* unused */
+}
+
+// setWinSize sets the window size, signaling the processes
+// using the tty if it changed.
+func (t *TTY) setWinSize(ws winsize) {
+	if t.ws != ws {
+		t.ws = ws
+		t.Sys.signal(t, SIGWINCH)
+	}
+}
+
+// SetWinSize records that the terminal /dev/tty<tty> now has
+// the given number of rows and columns,
+// as when the window holding it is resized.
+// Processes using the tty receive SIGWINCH.
+// SetWinSize does nothing if there is no such tty.
+func (sys *System) SetWinSize(tty int, rows, cols uint16) {
+	if tty < 0 || tty >= len(sys.ttys) {
+		return
+	}
+	sys.ttys[tty].setWinSize(winsize{rows: rows, cols: cols})
+}
+
+// HangupTTY hangs up the terminal /dev/tty<minor>,
+// as when its modem loses carrier.
+// The tty stops being a controlling terminal, and the processes
+// that had it get SIGHUP: the foreground process group,
+// or all of them if there is none, and any that are stopped.
+// Until the tty is opened again, reads of it return
+// end of file and writes fail with EIO.
+// HangupTTY does nothing if there is no such tty.
+func (sys *System) HangupTTY(minor uint8) {
+	if t := sys.Terminal(minor); t != nil {
+		t.hangup()
+	}
+}
+
+// hangup drops the carrier of t: it clears the modes,
+// throws away pending input and held output,
+// releases t as a controlling terminal, and wakes
+// any readers and writers to see the hangup.
+// Clearing ISOPEN makes the next open a first open,
+// which clears hungup again.
+func (t *TTY) hangup() {
+	t.hungup = true
+	t.state = 0
+	t.flags = 0
+	t.lflags = 0
+	t.flushInput()
+	t.outq.Reset()
+	t.release(nil)
+	t.Sys.wakeup(&t.Delct)
+	t.Sys.wakeup(&t.outq)
 }
 
 type TDev struct {
@@ -105,8 +340,63 @@ const (
 	TBDELAY = 0o6000
 	CRDELAY = 0o30000
 	VTDELAY = 0o40000
+	CBREAK  = 0o100000 /* not in v6: like RAW but keeps signals and echo */
+)
+
+/* local modes, settable by TIOCLSET (from 4BSD; not in v6) */
+const (
+	LCRTERA = 0o4     /* erase with backspace-space-backspace */
+	LCTLECH = 0o10000 /* echo control characters as ^X */
+)
+
+/* line speeds, as set in the low (input) and high (output) bytes of speeds */
+const (
+	B0    = 0
+	B50   = 1
+	B75   = 2
+	B110  = 3
+	B134  = 4
+	B150  = 5
+	B200  = 6
+	B300  = 7
+	B600  = 8
+	B1200 = 9
+	B1800 = 10
+	B2400 = 11
+	B4800 = 12
+	B9600 = 13
+	EXTA  = 14
+	EXTB  = 15
 )
 
+// charMicros is the time to send one character at each line speed,
+// in microseconds: 10 bits per character (11 at 110 baud, with two stop bits).
+// B0, EXTA, and EXTB have no delay.
+var charMicros = [16]int{
+	B50:   200000,
+	B75:   133333,
+	B110:  100000,
+	B134:  74349,
+	B150:  66667,
+	B200:  50000,
+	B300:  33333,
+	B600:  16667,
+	B1200: 8333,
+	B1800: 5556,
+	B2400: 4167,
+	B4800: 2083,
+	B9600: 1042,
+}
+
+// charTime returns the time to send one character on the tty,
+// or 0 if output is not delayed.
+func (t *TTY) charTime() time.Duration {
+	if !t.Sys.RealtimeTTY {
+		return 0
+	}
+	return time.Duration(charMicros[t.speeds>>8&017]) * time.Microsecond
+}
+
 /* Hardware bits */
 const (
 	DONE    = 0o200
@@ -122,16 +412,132 @@ const (
 	CARR_ON = 020  /* Software copy of carrier-present */
 	BUSY    = 040  /* Output in progress */
 	ASLEEP  = 0100 /* Wakeup when output done */
+	TTSTOP  = 0200 /* Output stopped by stop character (not in v6) */
+)
+
+const (
+	TTOPRI  = 20 /* sleep priority waiting for output */
+	TTHIWAT = 50 /* held output that blocks a writer */
 )
 
 func sysstty(p *Proc) {
-	info := (*[3]uint16)(unsafe.Pointer(&p.mem(p.Args[0], 3*2)[0]))
-	p.sgtty(p.CPU.R[0], info, nil)
+	p.sgttyUser(p.CPU.R[0], p.Args[0], true, true)
 }
 
 func sysgtty(p *Proc) {
-	info := (*[3]uint16)(unsafe.Pointer(&p.mem(p.Args[0], 3*2)[0]))
-	p.sgtty(p.CPU.R[0], nil, info)
+	p.sgttyUser(p.CPU.R[0], p.Args[0], false, false)
+}
+
+/*
+ * Copy the three-word sgtty structure
+ * (speeds, erase and kill, mode flags)
+ * between user memory at addr and the
+ * terminal open as fd: into the terminal
+ * for stty, out of it for gtty.
+ * Setting with flush set first throws
+ * away any typed-ahead input, as v6 did,
+ * so that none typed under the old modes
+ * is read under the new.
+ * User memory is written only if
+ * the get succeeds.
+ */
+func (p *Proc) sgttyUser(fd, addr uint16, set, flush bool) {
+	b := p.mem(addr, 3*2)
+	if b == nil {
+		return
+	}
+	info := *(*[3]uint16)(unsafe.Pointer(&b[0]))
+	if set {
+		if flush {
+			p.flushTTY(fd)
+		}
+		p.sgtty(fd, &info, nil)
+		return
+	}
+	if p.sgtty(fd, nil, &info); p.Error == 0 {
+		*(*[3]uint16)(unsafe.Pointer(&b[0])) = info
+	}
+}
+
+/* ioctl commands, as in v7 (not in v6) */
+const (
+	TIOCGETP = 't'<<8 | 8  /* get sgtty (like gtty) */
+	TIOCSETP = 't'<<8 | 9  /* set sgtty (like stty) */
+	TIOCSETC = 't'<<8 | 17 /* set special characters */
+	TIOCGETC = 't'<<8 | 18 /* get special characters */
+
+	TIOCSETN  = 't'<<8 | 10 /* set sgtty without flushing input */
+	TIOCFLUSH = 't'<<8 | 16 /* flush input and output (flag word from 4BSD) */
+
+	TIOCLBIS = 't'<<8 | 127 /* set bits in local mode word (4BSD) */
+	TIOCLBIC = 't'<<8 | 126 /* clear bits in local mode word (4BSD) */
+	TIOCLSET = 't'<<8 | 125 /* set local mode word (4BSD) */
+	TIOCLGET = 't'<<8 | 124 /* get local mode word (4BSD) */
+
+	TIOCGWINSZ = 't'<<8 | 104 /* get window size (4.3BSD) */
+	TIOCSWINSZ = 't'<<8 | 103 /* set window size (4.3BSD) */
+
+	TIOCGPGRP = 't'<<8 | 119 /* get foreground process group (4BSD) */
+	TIOCSPGRP = 't'<<8 | 118 /* set foreground process group (4BSD) */
+)
+
+// An ioctler is a device with ioctl commands beyond gtty and stty.
+type ioctler interface {
+	ioctl(p *Proc, minor uint8, cmd, addr uint16)
+}
+
+// A ttyer is a device that is a terminal.
+// tty returns the terminal with the given minor number,
+// or sets the error and returns nil if there is none.
+type ttyer interface {
+	tty(p *Proc, minor uint8) *TTY
+}
+
+/*
+ * ioctl system call (from v7).
+ * fd in r0; request and argument address inline.
+ */
+func sysioctl(p *Proc) {
+	fd, cmd, addr := p.CPU.R[0], p.Args[0], p.Args[1]
+	switch cmd {
+	case TIOCGETP, TIOCSETP, TIOCSETN:
+		p.sgttyUser(fd, addr, cmd != TIOCGETP, cmd == TIOCSETP)
+		return
+	}
+
+	f := p.getf(fd)
+	if f == nil {
+		return
+	}
+	ip := f.inode
+	if ip.mode&_IFMT != _IFCHR {
+		p.Error = ENOTTY
+		return
+	}
+	d, ok := p.dev(ip.major, ip.minor).(ioctler)
+	if !ok {
+		p.Error = ENOTTY
+		return
+	}
+	d.ioctl(p, ip.minor, cmd, addr)
+}
+
+// flushTTY discards the input typed ahead on
+// the terminal open as fd, if it is one.
+func (p *Proc) flushTTY(fd uint16) {
+	f := p.getf(fd)
+	if f == nil {
+		return
+	}
+	ip := f.inode
+	if ip.mode&_IFMT != _IFCHR {
+		return
+	}
+	if d, ok := p.dev(ip.major, ip.minor).(ttyer); ok {
+		if tty := d.tty(p, ip.minor); tty != nil {
+			tty.flush(_FREAD)
+		}
+	}
 }
 
 func (p *Proc) sgtty(fd uint16, in, out *[3]uint16) {
@@ -144,50 +550,301 @@ func (p *Proc) sgtty(fd uint16, in, out *[3]uint16) {
 		p.Error = ENOTTY
 		return
 	}
-	p.dev(ip.major).sgtty(p, ip.minor, in, out)
+	p.dev(ip.major, ip.minor).sgtty(p, ip.minor, in, out)
+}
+
+// ttyMajor is the major device number of /dev/tty0../dev/tty8
+// and any ttys added by AddTTY.
+const ttyMajor = 4
+
+// maxTTY is the number of ttys, one for each bit of TTYRead.
+// Their TDevs fit in /dev/kmem between memTTY and memProcs.
+const maxTTY = 16
+
+// newTTY adds a tty to sys.ttys, with the next minor number:
+// one of sys.TTY, or a new one once those are all in use.
+func (sys *System) newTTY() *TTY {
+	t := new(TTY)
+	if n := len(sys.ttys); n < len(sys.TTY) {
+		t = &sys.TTY[n]
+	}
+	t.Sys = sys
+	t.tchars = defaultTchars
+	t.major = ttyMajor
+	t.minor = uint8(len(sys.ttys))
+	sys.ttys = append(sys.ttys, t)
+	return t
+}
+
+// AddTTY adds a new terminal /dev/ttyN and returns N, its minor number.
+// Output printed on the terminal is written to out.
+// Input read from in is typed on the terminal;
+// if in is nil, the caller can type on it using Terminal(N).WriteByte.
+// AddTTY fails once there are 16 ttys, /dev/tty0 to /dev/tty15.
+//
+// Input is read by a separate goroutine but only typed during Wait,
+// when the system is idle. InputReady returns a channel
+// that receives a value whenever there is input waiting to be typed.
+// DetachTTY stops the goroutine.
+func (sys *System) AddTTY(in io.Reader, out io.Writer) (minor uint8, err error) {
+	if len(sys.ttys) >= maxTTY {
+		return 0, fmt.Errorf("AddTTY: too many ttys")
+	}
+	t := sys.newTTY()
+	t.Print = func(b []byte, echo bool) (int, Errno) {
+		n, err := out.Write(b)
+		if err != nil {
+			return n, EIO
+		}
+		return n, 0
+	}
+	sys.mknod(fmt.Sprintf("/dev/tty%d", t.minor), _IFCHR|0o622, ttyMajor, t.minor)
+
+	if in != nil {
+		c := sys.ttyInput(t)
+		stop := make(chan struct{})
+		t.detach = func() {
+			close(stop)
+			if cl, ok := in.(io.Closer); ok {
+				cl.Close()
+			}
+		}
+		go func() {
+			defer close(c)
+			buf := make([]byte, 100)
+			for {
+				n, err := in.Read(buf)
+				for _, b := range buf[:n] {
+					select {
+					case c <- b:
+					case <-stop:
+						return
+					}
+				}
+				if err != nil {
+					return
+				}
+				select {
+				case <-stop:
+					return
+				default:
+				}
+			}
+		}()
+	}
+	return t.minor, nil
+}
+
+// DetachTTY stops reading the input given to AddTTY for /dev/tty<minor>,
+// closing it if it is an io.Closer, which the system then sees
+// as end of input. Otherwise the reading goroutine exits
+// when the read in progress returns.
+// DetachTTY does nothing if there is no such tty.
+func (sys *System) DetachTTY(minor uint8) {
+	if t := sys.Terminal(minor); t != nil && t.detach != nil {
+		t.detach()
+		t.detach = nil
+	}
+}
+
+// Terminal returns /dev/tty<minor>, such as one added by AddTTY,
+// or nil if there is no such tty.
+func (sys *System) Terminal(minor uint8) *TTY {
+	if int(minor) >= len(sys.ttys) {
+		return nil
+	}
+	return sys.ttys[minor]
+}
+
+// ttyInput returns a channel on which to send input for t,
+// which is typed during Wait. Closing the channel ends the input.
+func (sys *System) ttyInput(t *TTY) chan<- byte {
+	c := make(chan byte)
+	t.input = make(chan byte, 256)
+	go func() {
+		for b := range c {
+			t.input <- b
+			sys.inputReady()
+		}
+		close(t.input)
+		sys.inputReady()
+	}()
+	return c
+}
+
+// ConsoleIn returns a channel on which to type on the console, /dev/tty8.
+// As with the reader given to AddTTY, the bytes sent are typed during Wait,
+// through the line discipline, and closing the channel ends the input.
+// Sending blocks once a few hundred bytes are waiting to be typed.
+func (sys *System) ConsoleIn() chan<- byte {
+	if sys.consIn == nil {
+		sys.consIn = sys.ttyInput(&sys.TTY[8])
+	}
+	return sys.consIn
+}
+
+// ConsoleOut returns a channel that receives the output printed on
+// the console, /dev/tty8, including echoed input, in place of the
+// writer given to Start. Output held by the stop character
+// is not sent until the start character resumes it.
+// A process printing on the console waits, holding up the system,
+// while the channel is full, so the caller must keep receiving.
+func (sys *System) ConsoleOut() <-chan byte {
+	if sys.consOut == nil {
+		c := make(chan byte, 256)
+		sys.consOut = c
+		sys.TTY[8].Print = func(b []byte, echo bool) (int, Errno) {
+			for _, x := range b {
+				c <- x
+			}
+			return len(b), 0
+		}
+	}
+	return sys.consOut
+}
+
+// InputReady returns a channel that receives a value
+// when a tty added by AddTTY has input for Wait to type.
+func (sys *System) InputReady() <-chan struct{} {
+	return sys.ttyReady
+}
+
+func (sys *System) inputReady() {
+	select {
+	case sys.ttyReady <- struct{}{}:
+	default:
+	}
+}
+
+// typeInput types any input waiting from the readers given to AddTTY,
+// from ConsoleIn, and from ReplayConsole.
+// End of input counts as the host side of the terminal going away.
+func (sys *System) typeInput() {
+	sys.typeReplay()
+	for _, t := range sys.ttys {
+	Loop:
+		for t.input != nil {
+			select {
+			default:
+				break Loop
+			case c, ok := <-t.input:
+				if !ok {
+					t.input = nil
+					t.EOF = true
+					sys.wakeup(&t.Delct)
+					break Loop
+				}
+				t.WriteByte(c)
+			}
+		}
+	}
 }
 
 type ttydev struct{}
 
 func (ttydev) open(p *Proc, minor uint8, rw int) {
-	if minor > 8 {
+	if int(minor) >= len(p.Sys.ttys) {
 		p.Error = ENXIO
+		return
 	}
-	tty := &p.Sys.TTY[minor]
-	if tty.State&ISOPEN == 0 {
+	p.Sys.ttys[minor].open(p, memTTY+memTTYSize*int16(minor))
+}
+
+// open is the device open routine shared by all kinds of tty.
+// ttyp is the tty's address in /dev/kmem, or 0 if it has none.
+// See acquire for how the tty may become p's controlling terminal.
+func (tty *TTY) open(p *Proc, ttyp int16) {
+	if tty.state&ISOPEN == 0 {
 		tty.state |= ISOPEN | CARR_ON
+		tty.hungup = false // carrier is back after HangupTTY
 		tty.flags = XTABS | LCASE | ECHO | CRMOD
 		tty.erase = CERASE
 		tty.kill = CKILL
+		tty.tchars = defaultTchars
+		tty.lflags = 0
 	}
-	if p.TTY == nil {
-		p.TTY = tty
-		p.ttyp = memTTY + memTTYSize*int16(minor)
+	tty.acquire(p, ttyp)
+}
+
+func (ttydev) tty(p *Proc, minor uint8) *TTY {
+	if int(minor) >= len(p.Sys.ttys) {
+		p.Error = ENXIO
+		return nil
 	}
+	return p.Sys.ttys[minor]
 }
 
 func (ttydev) read(p *Proc, minor uint8, b []byte, off int) int {
-	if minor > 8 {
+	if int(minor) >= len(p.Sys.ttys) {
 		p.Error = ENXIO
+		return 0
 	}
+	return p.Sys.ttys[minor].read(p, b)
+}
+
+func (tty *TTY) read(p *Proc, b []byte) int {
 	if len(b) == 0 {
 		return 0
 	}
-	tty := &p.Sys.TTY[minor]
+	if !tty.foreground(p) {
+		return 0
+	}
 	for {
 		n, _ := tty.Canon.Read(b)
 		if n > 0 {
 			return n
 		}
 		if tty.Delct > 0 {
+			// A line ended by the EOF character alone reads as 0 bytes.
 			tty.canon()
 			n, _ = tty.Canon.Read(b)
 			return n
 		}
-		p.Sys.TTYRead |= 1 << minor
-		p.sleep(&tty.Delct, 'i', PSLEP)
-		p.Sys.TTYRead &^= 1 << minor
+		if tty.EOF || tty.hungup {
+			// The host side of the terminal has gone away.
+			return 0
+		}
+		if p.fflag&_FNDELAY != 0 {
+			p.Error = EAGAIN
+			return 0
+		}
+		tty.sleepRead(p)
+	}
+}
+
+// foreground waits until p may read from tty,
+// stopping p's process group with SIGTTIN while p is in
+// a background group on its controlling tty (not in v6).
+// If p ignores SIGTTIN, or catches it,
+// foreground instead fails the read with EIO or EINTR.
+func (tty *TTY) foreground(p *Proc) bool {
+	for p.TTY == tty && tty.Pgrp != 0 && p.Pgrp != 0 && p.Pgrp != tty.Pgrp {
+		if p.Signals[SIGTTIN]&1 != 0 {
+			p.Error = EIO
+			return false
+		}
+		p.Sys.gsignal(p.Pgrp, SIGTTIN)
+		if p.Signals[SIGTTIN] != 0 {
+			p.Error = EINTR
+			return false
+		}
+		if p.issig() {
+			p.psig()
+		}
+	}
+	return true
+}
+
+// sleepRead waits for input to arrive.
+// A signal interrupts the wait, unwinding the read with EINTR.
+func (tty *TTY) sleepRead(p *Proc) {
+	if tty.major == ttyMajor {
+		p.Sys.TTYRead |= 1 << tty.minor
+		defer func() {
+			p.Sys.TTYRead &^= 1 << tty.minor
+		}()
 	}
+	p.sleep(&tty.Delct, 'i', PSLEP)
 }
 
 var maptab = [256]byte{
@@ -210,8 +867,7 @@ var maptab = [256]byte{
 }
 
 func (t *TTY) canon() {
-Loop:
-	var canon []byte
+	var line []byte
 	for {
 		c, err := t.Raw.ReadByte()
 		if err != nil {
@@ -221,6 +877,15 @@ Loop:
 			t.Delct--
 			break
 		}
+		line = append(line, c)
+	}
+	t.Canon.Write(t.edit(line))
+}
+
+// edit applies erase, kill, and escape processing to a line of raw input.
+func (t *TTY) edit(line []byte) []byte {
+	var canon []byte
+	for _, c := range line {
 		if t.flags&RAW == 0 {
 			cn := len(canon)
 			if cn < 1 || canon[cn-1] != '\\' {
@@ -231,9 +896,10 @@ Loop:
 					continue
 				}
 				if c == t.kill {
-					goto Loop
+					canon = canon[:0]
+					continue
 				}
-				if c == CEOT {
+				if c == t.eofc {
 					continue
 				}
 			} else if maptab[c] != 0 && (maptab[c] == c || t.flags&LCASE != 0) {
@@ -247,16 +913,19 @@ Loop:
 		canon = append(canon, c)
 		// if len(canon) >= CANBSIZ { break }
 	}
-	t.Canon.Write(canon)
+	return canon
 }
 
 func (ttydev) write(p *Proc, minor uint8, b []byte, off int) int {
-	if minor > 8 {
+	if int(minor) >= len(p.Sys.ttys) {
 		p.Error = EIO
 		return 0
 	}
-	tty := &p.Sys.TTY[minor]
-	if tty.Print == nil {
+	return p.Sys.ttys[minor].write(p, b)
+}
+
+func (tty *TTY) write(p *Proc, b []byte) int {
+	if tty.Print == nil || tty.hungup {
 		p.Error = EIO
 		return 0
 	}
@@ -265,7 +934,34 @@ func (ttydev) write(p *Proc, minor uint8, b []byte, off int) int {
 		out = tty.output(out, c)
 	}
 
-	_, errno := tty.Print(out, false)
+	if tty.state&TTSTOP != 0 {
+		// Hold the output until started,
+		// and block the writer once too much is held.
+		tty.outq.Write(out)
+		for tty.state&TTSTOP != 0 && tty.outq.Len() > TTHIWAT {
+			p.sleep(&tty.outq, 'o', TTOPRI)
+		}
+		return len(b)
+	}
+
+	if d := tty.charTime(); d > 0 {
+		// Send one character at a time at the line speed.
+		for i := range out {
+			p.sleepUntil(tty.busy, TTOPRI)
+			if tty.hungup {
+				p.Error = EIO
+				break
+			}
+			if _, errno := tty.print(out[i:i+1], false); errno != 0 {
+				p.Error = errno
+				break
+			}
+			tty.busy = p.Sys.clockTime().Add(d)
+		}
+		return len(b)
+	}
+
+	_, errno := tty.print(out, false)
 	if errno != 0 {
 		p.Error = errno
 	}
@@ -319,7 +1015,11 @@ func (t *TTY) output(dst []byte, c byte) []byte {
 	switch ctype & 0o77 {
 	/* ordinary */
 	case 0:
-		t.col++
+		// Not in v6: a UTF-8 continuation byte
+		// does not start a new column.
+		if c&0o300 != 0o200 {
+			t.col++
+		}
 
 	/* non-printing */
 	case 1:
@@ -370,20 +1070,27 @@ var partab = [256]byte{
 }
 
 func (ttydev) close(p *Proc, minor uint8) {
-	if minor > 8 {
+	if int(minor) >= len(p.Sys.ttys) {
 		p.Error = EIO
 		return
 	}
-	tty := &p.Sys.TTY[minor]
-	tty.State = 0
+	p.Sys.ttys[minor].close(p)
+}
+
+func (tty *TTY) close(p *Proc) {
+	tty.state = 0
+	tty.release(p)
 }
 
 func (ttydev) sgtty(p *Proc, minor uint8, in, out *[3]uint16) {
-	if minor > 8 {
+	if int(minor) >= len(p.Sys.ttys) {
 		p.Error = EIO
 		return
 	}
-	tty := &p.Sys.TTY[minor]
+	p.Sys.ttys[minor].sgtty(p, in, out)
+}
+
+func (tty *TTY) sgtty(p *Proc, in, out *[3]uint16) {
 	if out != nil {
 		out[0] = tty.speeds
 		out[1] = uint16(tty.erase) | uint16(tty.kill)<<8
@@ -393,6 +1100,97 @@ func (ttydev) sgtty(p *Proc, minor uint8, in, out *[3]uint16) {
 		tty.speeds = in[0]
 		tty.erase = uint8(in[1])
 		tty.kill = uint8(in[1] >> 8)
+		if in[2]&(RAW|CBREAK) != 0 && tty.flags&(RAW|CBREAK) == 0 {
+			tty.rawInput()
+		}
 		tty.flags = in[2]
+		if tty.flags&RAW != 0 {
+			tty.start() // raw mode has no start character
+		}
+	}
+}
+
+func (ttydev) ioctl(p *Proc, minor uint8, cmd, addr uint16) {
+	if int(minor) >= len(p.Sys.ttys) {
+		p.Error = EIO
+		return
+	}
+	p.Sys.ttys[minor].ioctl(p, cmd, addr)
+}
+
+func (tty *TTY) ioctl(p *Proc, cmd, addr uint16) {
+	switch cmd {
+	default:
+		p.Error = EINVAL
+	case TIOCGETC:
+		b := p.mem(addr, uint16(unsafe.Sizeof(tchars{})))
+		if b != nil {
+			*(*tchars)(unsafe.Pointer(&b[0])) = tty.tchars
+		}
+	case TIOCSETC:
+		b := p.mem(addr, uint16(unsafe.Sizeof(tchars{})))
+		if b != nil {
+			tty.tchars = *(*tchars)(unsafe.Pointer(&b[0]))
+		}
+	case TIOCLGET:
+		b := p.mem(addr, 2)
+		if b != nil {
+			*(*uint16)(unsafe.Pointer(&b[0])) = tty.lflags
+		}
+	case TIOCGPGRP:
+		b := p.mem(addr, 2)
+		if b != nil {
+			*(*int16)(unsafe.Pointer(&b[0])) = tty.Pgrp
+		}
+	case TIOCSPGRP:
+		b := p.mem(addr, 2)
+		if b != nil {
+			tty.Pgrp = *(*int16)(unsafe.Pointer(&b[0]))
+		}
+	case TIOCGWINSZ:
+		b := p.mem(addr, uint16(unsafe.Sizeof(winsize{})))
+		if b != nil {
+			*(*winsize)(unsafe.Pointer(&b[0])) = tty.ws
+		}
+	case TIOCFLUSH:
+		b := p.mem(addr, 2)
+		if b == nil {
+			break
+		}
+		which := int(*(*uint16)(unsafe.Pointer(&b[0])))
+		if which&(_FREAD|_FWRITE) == 0 {
+			which = _FREAD | _FWRITE
+		}
+		tty.flush(which)
+	case TIOCSWINSZ:
+		b := p.mem(addr, uint16(unsafe.Sizeof(winsize{})))
+		if b != nil {
+			tty.setWinSize(*(*winsize)(unsafe.Pointer(&b[0])))
+		}
+	case TIOCLSET, TIOCLBIS, TIOCLBIC:
+		b := p.mem(addr, 2)
+		if b == nil {
+			break
+		}
+		m := *(*uint16)(unsafe.Pointer(&b[0]))
+		switch cmd {
+		case TIOCLSET:
+			tty.lflags = m
+		case TIOCLBIS:
+			tty.lflags |= m
+		case TIOCLBIC:
+			tty.lflags &^= m
+		}
+	}
+}
+
+// rawInput prepares the input queues for a switch to raw or cbreak mode.
+// Complete lines are canonicalized as they would have been in cooked mode,
+// and a partial line becomes raw input, available to read immediately.
+func (tty *TTY) rawInput() {
+	for tty.Delct > 0 {
+		tty.canon()
 	}
+	tty.Canon.Write(tty.Raw.Bytes())
+	tty.Raw.Reset()
 }
@@ -0,0 +1,1145 @@
+// Copyright 2023 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package v6unix
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+
+	"rsc.io/unix/pdp11"
+)
+
+func TestPTY(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pt, name, err := sys.OpenPTY()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "/dev/pty0" {
+		t.Errorf("slave name = %q, want /dev/pty0", name)
+	}
+
+	p := &Proc{Sys: sys}
+	p.Dir = p.iget(ROOTINO)
+	var st stat
+	p.stat(name, &st)
+	if p.Error != 0 || st.major != ptyMajor || st.minor != 0 {
+		t.Fatalf("stat %s: %d,%d %v", name, st.major, st.minor, p.Error)
+	}
+
+	slave := p.dev(ptyMajor, 0)
+	slave.open(p, 0, 2)
+	if p.TTY != &pt.TTY {
+		t.Errorf("open did not make pty the controlling tty")
+	}
+	slave.sgtty(p, 0, &[3]uint16{0, CERASE | CKILL<<8, ECHO | CRMOD}, nil)
+
+	io.WriteString(pt, "ls -l\n")
+	b := make([]byte, 100)
+	n := slave.read(p, 0, b, 0)
+	if string(b[:n]) != "ls -l\n" {
+		t.Errorf("slave read %q, want %q", b[:n], "ls -l\n")
+	}
+	slave.write(p, 0, []byte("total 0\n"), 0)
+
+	out, _ := io.ReadAll(pt)
+	if want := "ls -l\r\ntotal 0\r\n"; string(out) != want {
+		t.Errorf("master read %q, want %q", out, want)
+	}
+
+	// The guest side of the master sees the same stream.
+	master := p.dev(ptmMajor, 0)
+	slave.write(p, 0, []byte("$ "), 0)
+	n = master.read(p, 0, b, 0)
+	if string(b[:n]) != "$ " {
+		t.Errorf("ptm read %q, want %q", b[:n], "$ ")
+	}
+}
+
+func TestAddTTY(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	minor, err := sys.AddTTY(strings.NewReader("hi\n"), &out)
+	if minor != 9 || err != nil {
+		t.Fatalf("AddTTY = %d, %v, want 9", minor, err)
+	}
+	if minor, err := sys.AddTTY(nil, io.Discard); minor != 10 || err != nil {
+		t.Fatalf("second AddTTY = %d, %v, want 10", minor, err)
+	}
+
+	p := &Proc{Sys: sys}
+	p.Dir = p.iget(ROOTINO)
+	var st stat
+	p.stat("/dev/tty9", &st)
+	if p.Error != 0 || st.major != ttyMajor || st.minor != 9 {
+		t.Fatalf("stat /dev/tty9: %d,%d %v", st.major, st.minor, p.Error)
+	}
+
+	d := p.dev(ttyMajor, 9)
+	d.open(p, 9, 2)
+	if p.TTY != sys.ttys[9] || p.ttyp != memTTY+9*memTTYSize {
+		t.Errorf("open did not make tty9 the controlling tty")
+	}
+	d.sgtty(p, 9, &[3]uint16{0, CERASE | CKILL<<8, ECHO | CRMOD}, nil)
+	tty := sys.Terminal(9)
+	for !tty.EOF {
+		<-sys.InputReady()
+		sys.typeInput()
+	}
+	b := make([]byte, 10)
+	if n := d.read(p, 9, b, 0); string(b[:n]) != "hi\n" {
+		t.Errorf("read %q, want %q", b[:n], "hi\n")
+	}
+	if n := d.read(p, 9, b, 0); n != 0 {
+		t.Errorf("read at EOF = %q, want EOF", b[:n])
+	}
+	d.write(p, 9, []byte("ok\n"), 0)
+	if want := "hi\r\nok\r\n"; out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+
+	// The new ttys appear in /dev/kmem.
+	b = make([]byte, memTTYSize)
+	if n := (kmemdev{}).read(p, kmemMinor, b, memTTY+10*memTTYSize); n != memTTYSize || b[unsafe.Offsetof(TDev{}.minor)] != 10 {
+		t.Errorf("kmem read of tty10 = %d bytes, minor %d", n, b[unsafe.Offsetof(TDev{}.minor)])
+	}
+	if n := (kmemdev{}).read(p, kmemMinor, b, memTTY+11*memTTYSize); n != 0 {
+		t.Errorf("kmem read past last tty = %d bytes, want 0", n)
+	}
+
+	// Detaching a tty closes its input, ending the read.
+	pr, _ := io.Pipe()
+	minor, err = sys.AddTTY(pr, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sys.DetachTTY(minor)
+	sys.DetachTTY(maxTTY) // no such tty: does nothing
+	for tty := sys.Terminal(minor); !tty.EOF; {
+		<-sys.InputReady()
+		sys.typeInput()
+	}
+
+	for len(sys.ttys) < maxTTY {
+		if _, err := sys.AddTTY(nil, io.Discard); err != nil {
+			t.Fatalf("AddTTY of tty%d: %v", len(sys.ttys), err)
+		}
+	}
+	if _, err := sys.AddTTY(nil, io.Discard); err == nil {
+		t.Errorf("AddTTY past tty%d succeeded", maxTTY-1)
+	}
+	if sys.Terminal(maxTTY) != nil {
+		t.Errorf("Terminal(%d) != nil", maxTTY)
+	}
+}
+
+func TestConsoleChannels(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, out := sys.ConsoleIn(), sys.ConsoleOut()
+	if sys.ConsoleIn() != in || sys.ConsoleOut() != out {
+		t.Errorf("ConsoleIn, ConsoleOut return new channels on second call")
+	}
+	p := &Proc{Sys: sys}
+	p.Dir = p.iget(ROOTINO)
+	d := p.dev(ttyMajor, 8)
+	d.open(p, 8, 2)
+	tty := &sys.TTY[8]
+	tty.flags &^= LCASE
+	recv := func(n int) string {
+		t.Helper()
+		var b []byte
+		for len(b) < n {
+			select {
+			case c := <-out:
+				b = append(b, c)
+			case <-time.After(10 * time.Second):
+				t.Fatalf("timeout waiting for output, have %q", b)
+			}
+		}
+		select {
+		case c := <-out:
+			t.Fatalf("unexpected output %q after %q", c, b)
+		default:
+		}
+		return string(b)
+	}
+
+	// Input goes through the line discipline, echoing as it is typed.
+	for _, c := range []byte("hix\b\n") {
+		in <- c
+	}
+	for tty.Delct == 0 {
+		<-sys.InputReady()
+		sys.typeInput()
+	}
+	if s := recv(6); s != "hix#\r\n" {
+		t.Errorf("echo = %q, want %q", s, "hix#\r\n")
+	}
+	b := make([]byte, 10)
+	if n := d.read(p, 8, b, 0); string(b[:n]) != "hi\n" {
+		t.Errorf("read %q, want %q", b[:n], "hi\n")
+	}
+
+	// Output stopped by ^S arrives only after ^Q.
+	tty.WriteByte('S' - '@')
+	d.write(p, 8, []byte("ok\n"), 0)
+	if s := recv(0); s != "" {
+		t.Errorf("output while stopped = %q", s)
+	}
+	tty.WriteByte('Q' - '@')
+	if s := recv(4); s != "ok\r\n" {
+		t.Errorf("output after start = %q, want %q", s, "ok\r\n")
+	}
+
+	// Closing the input channel ends the input.
+	close(in)
+	for !tty.EOF {
+		<-sys.InputReady()
+		sys.typeInput()
+	}
+	if n := d.read(p, 8, b, 0); n != 0 {
+		t.Errorf("read at EOF = %q, want EOF", b[:n])
+	}
+}
+
+func TestControllingTTY(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	sys.TTY[1].Print = func(b []byte, echo bool) (int, Errno) {
+		out.Write(b)
+		return len(b), 0
+	}
+	p := &Proc{Sys: sys}
+	p.Pid = 2
+	p.Dir = p.iget(ROOTINO)
+	open := func(name string) Errno {
+		p.Error = 0
+		p.open(name, 2)
+		return p.Error
+	}
+	if err := open("/dev/tty"); err != ENXIO {
+		t.Errorf("open /dev/tty without a controlling tty: %v, want ENXIO", err)
+	}
+
+	// A process in another's group does not take the tty it opens.
+	p.Pgrp = 1
+	if err := open("/dev/tty2"); err != 0 || p.TTY != nil {
+		t.Errorf("open /dev/tty2 in group 1: %v, controlling tty %v, want none", err, p.TTY)
+	}
+	p.Pgrp = p.Pid
+	if err := open("/dev/tty1"); err != 0 || p.TTY != &sys.TTY[1] {
+		t.Fatalf("open /dev/tty1 as group leader: %v, want tty1 controlling", err)
+	}
+	if err := open("/dev/tty2"); err != 0 || p.TTY != &sys.TTY[1] {
+		t.Errorf("second tty opened became controlling")
+	}
+
+	// /dev/tty is tty1.
+	if err := open("/dev/tty"); err != 0 {
+		t.Fatalf("open /dev/tty: %v", err)
+	}
+	d := p.dev(ctyMajor, 0)
+	d.write(p, 0, []byte("hi\n"), 0)
+	if want := "HI\r\n"; out.String() != want { // upper case, as the tty starts in LCASE mode
+		t.Errorf("/dev/tty output = %q, want %q on tty1", out.String(), want)
+	}
+	var sg [3]uint16
+	if d.sgtty(p, 0, nil, &sg); sg[2] != sys.TTY[1].flags {
+		t.Errorf("gtty on /dev/tty = flags %#o, want tty1's %#o", sg[2], sys.TTY[1].flags)
+	}
+	const addr = 0o1000
+	sys.TTY[1].Pgrp = 7
+	if d.(ioctler).ioctl(p, 0, TIOCGPGRP, addr); p.Mem[addr] != 7 {
+		t.Errorf("TIOCGPGRP on /dev/tty = %d, want tty1's 7", p.Mem[addr])
+	}
+}
+
+func TestReopenKeepsModes(t *testing.T) {
+	p := rootProc(t)
+	p.Pid = 2
+	p.Pgrp = p.Pid
+	open := func(name string) Errno {
+		p.Error = 0
+		p.open(name, 2)
+		return p.Error
+	}
+	if err := open("/dev/tty1"); err != 0 {
+		t.Fatal(err)
+	}
+	tty := &p.Sys.TTY[1]
+	p.dev(ttyMajor, 1).sgtty(p, 1, &[3]uint16{0, 'x' | 'y'<<8, ECHO | CBREAK}, nil)
+
+	// Opening a tty that is already open, directly or
+	// through /dev/tty, leaves the modes set by stty.
+	for _, name := range []string{"/dev/tty", "/dev/tty1"} {
+		if err := open(name); err != 0 {
+			t.Fatalf("open %s: %v", name, err)
+		}
+		if tty.flags != ECHO|CBREAK || tty.erase != 'x' || tty.kill != 'y' {
+			t.Errorf("after open %s: flags %#o erase %q kill %q, want %#o 'x' 'y'", name, tty.flags, tty.erase, tty.kill, ECHO|CBREAK)
+		}
+	}
+
+	// The first open after the last close resets them.
+	tty.close(p)
+	if err := open("/dev/tty1"); err != 0 {
+		t.Fatal(err)
+	}
+	if want := uint16(XTABS | LCASE | ECHO | CRMOD); tty.flags != want || tty.erase != CERASE {
+		t.Errorf("after reopen: flags %#o erase %q, want %#o %q", tty.flags, tty.erase, want, CERASE)
+	}
+}
+
+func TestHangup(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newProc := func(pid, pgrp int16) *Proc {
+		p := &Proc{Sys: sys}
+		p.Pid, p.Pgrp = pid, pgrp
+		p.Dir = p.iget(ROOTINO)
+		sys.Procs = append(sys.Procs, p)
+		return p
+	}
+	open := func(p *Proc, name string) uint16 {
+		t.Helper()
+		p.Error = 0
+		if p.open(name, 2); p.Error != 0 {
+			t.Fatalf("open %s: %v", name, p.Error)
+		}
+		return p.CPU.R[0]
+	}
+
+	// A group leader acquires the tty, and a leader of
+	// another group opening it does not.
+	tty := &sys.TTY[1]
+	leader := newProc(2, 2)
+	open(leader, "/dev/tty1")
+	if leader.ControllingTTY() != tty || tty.Pgrp != 2 {
+		t.Fatalf("leader opened tty1: controlling tty %v, foreground %d, want tty1, 2", leader.ControllingTTY(), tty.Pgrp)
+	}
+	other := newProc(5, 5)
+	open(other, "/dev/tty1")
+	if other.ControllingTTY() != nil {
+		t.Errorf("tty1 acquired by a second group")
+	}
+
+	// Hanging up signals the foreground group and
+	// continues stopped members of other groups to die too.
+	child := newProc(3, 2)
+	child.TTY = tty
+	stopped := newProc(4, 4)
+	stopped.TTY = tty
+	stopped.status = _SSTOP
+	sys.HangupTTY(1)
+	for _, p := range []*Proc{leader, child, stopped} {
+		if p.sig != SIGHUP || p.ControllingTTY() != nil {
+			t.Errorf("pid %d after hangup: signal %d, controlling tty %v, want SIGHUP, none", p.Pid, p.sig, p.ControllingTTY())
+		}
+	}
+	if stopped.status != _SRUN {
+		t.Errorf("stopped process not continued by hangup")
+	}
+	open(other, "/dev/tty1")
+	if other.ControllingTTY() != tty {
+		t.Errorf("tty1 not acquired after hangup")
+	}
+
+	// The last close releases the tty, hanging up the rest of the group.
+	for _, p := range sys.Procs {
+		p.sig = 0
+	}
+	member := newProc(6, 5)
+	member.TTY = tty
+	for _, p := range []*Proc{leader, other} {
+		for fd, f := range p.Files {
+			if f != nil {
+				closefd(p, uint16(fd))
+			}
+		}
+	}
+	if other.sig != 0 || member.sig != SIGHUP || member.ControllingTTY() != nil {
+		t.Errorf("after last close: closer signal %d, member signal %d, controlling tty %v, want 0, SIGHUP, none", other.sig, member.sig, member.ControllingTTY())
+	}
+}
+
+// openTTY opens /dev/tty1 in a new system and sets its mode flags.
+// It returns the process that opened it, the tty,
+// and a buffer collecting everything the tty prints.
+func openTTY(t *testing.T, flags uint16) (*Proc, *TTY, *bytes.Buffer) {
+	t.Helper()
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := new(bytes.Buffer)
+	tty := &sys.TTY[1]
+	tty.Print = func(b []byte, echo bool) (int, Errno) {
+		out.Write(b)
+		return len(b), 0
+	}
+	p := &Proc{Sys: sys}
+	d := p.dev(ttyMajor, 1)
+	d.open(p, 1, 2)
+	d.sgtty(p, 1, &[3]uint16{0, CERASE | CKILL<<8, flags}, nil)
+	if p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	return p, tty, out
+}
+
+func typeString(tty *TTY, s string) {
+	for i := 0; i < len(s); i++ {
+		tty.WriteByte(s[i])
+	}
+}
+
+func ttyRead(p *Proc, n int) string {
+	b := make([]byte, n)
+	n = p.dev(ttyMajor, 1).read(p, 1, b, 0)
+	return string(b[:n])
+}
+
+func TestTTYOutput(t *testing.T) {
+	p, _, out := openTTY(t, ECHO|CRMOD)
+	d := p.dev(ttyMajor, 1)
+
+	if n := d.write(p, 1, []byte("a\nb\n"), 0); n != 4 {
+		t.Errorf("write returned %d, want 4", n)
+	}
+	if want := "a\r\nb\r\n"; out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+
+	d.sgtty(p, 1, &[3]uint16{0, CERASE | CKILL<<8, ECHO}, nil)
+	out.Reset()
+	if n := d.write(p, 1, []byte("a\nb\n"), 0); n != 4 {
+		t.Errorf("write returned %d, want 4", n)
+	}
+	if want := "a\nb\n"; out.String() != want {
+		t.Errorf("output without CRMOD = %q, want %q", out.String(), want)
+	}
+}
+
+func TestTTYTabs(t *testing.T) {
+	p, _, out := openTTY(t, XTABS|CRMOD)
+	d := p.dev(ttyMajor, 1)
+
+	tests := []struct {
+		in, out string
+	}{
+		{"\tx\n", "        x\r\n"},
+		{"ab\tc\n", "ab      c\r\n"},
+		{"abcdefgh\tc\n", "abcdefgh        c\r\n"},
+		{"héllo\tx\n", "héllo   x\r\n"},
+		{"日本\tx\n", "日本      x\r\n"},
+		{"abc\r\tx\n", "abc\r        x\r\n"},
+		{"abc", "abc"},
+		{"\tx\n", "     x\r\n"}, // continues the previous line
+	}
+	for _, tt := range tests {
+		out.Reset()
+		d.write(p, 1, []byte(tt.in), 0)
+		if out.String() != tt.out {
+			t.Errorf("write %q: output %q, want %q", tt.in, out.String(), tt.out)
+		}
+	}
+
+	// Without XTABS, tabs are sent as is.
+	d.sgtty(p, 1, &[3]uint16{0, CERASE | CKILL<<8, CRMOD}, nil)
+	out.Reset()
+	d.write(p, 1, []byte("a\tb\n"), 0)
+	if want := "a\tb\r\n"; out.String() != want {
+		t.Errorf("output without XTABS = %q, want %q", out.String(), want)
+	}
+}
+
+func TestTTYEOF(t *testing.T) {
+	p, tty, _ := openTTY(t, ECHO|CRMOD)
+
+	typeString(tty, "abc\004\004")
+	if s := ttyRead(p, 20); s != "abc" {
+		t.Errorf("first read = %q, want %q", s, "abc")
+	}
+	if s := ttyRead(p, 20); s != "" {
+		t.Errorf("second read = %q, want EOF", s)
+	}
+
+	// EOF is settable; the old one becomes ordinary.
+	const addr = 0o1000
+	tty.ioctl(p, TIOCGETC, addr)
+	p.Mem[addr+4] = 'Z' & 037
+	tty.ioctl(p, TIOCSETC, addr)
+	typeString(tty, "x\004y\032\032")
+	if s := ttyRead(p, 20); s != "x\004y" {
+		t.Errorf("read = %q, want %q", s, "x\004y")
+	}
+	if s := ttyRead(p, 20); s != "" {
+		t.Errorf("read = %q, want EOF", s)
+	}
+
+	// Host EOF.
+	tty.EOF = true
+	if s := ttyRead(p, 20); s != "" {
+		t.Errorf("read after host EOF = %q, want EOF", s)
+	}
+}
+
+func TestTTYStop(t *testing.T) {
+	p, tty, out := openTTY(t, CRMOD)
+	d := p.dev(ttyMajor, 1)
+
+	typeString(tty, "\023")
+	if n := d.write(p, 1, []byte("one\n"), 0); n != 4 {
+		t.Errorf("write while stopped returned %d, want 4", n)
+	}
+	d.write(p, 1, []byte("two\n"), 0)
+	if out.Len() != 0 {
+		t.Errorf("output while stopped: %q", out.String())
+	}
+	typeString(tty, "\021")
+	if want := "one\r\ntwo\r\n"; out.String() != want {
+		t.Errorf("output after start = %q, want %q", out.String(), want)
+	}
+	out.Reset()
+	d.write(p, 1, []byte("three\n"), 0)
+	if want := "three\r\n"; out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+	typeString(tty, "\n")
+	if s := ttyRead(p, 10); s != "\n" {
+		t.Errorf("read = %q: stop or start was queued as input", s)
+	}
+
+	// In raw mode, stop and start are data.
+	d.sgtty(p, 1, &[3]uint16{0, CERASE | CKILL<<8, RAW}, nil)
+	typeString(tty, "\023\021")
+	if s := ttyRead(p, 10); s != "\023\021" {
+		t.Errorf("raw read = %q, want %q", s, "\023\021")
+	}
+	out.Reset()
+	d.write(p, 1, []byte("four"), 0)
+	if out.String() != "four" {
+		t.Errorf("raw output = %q, want %q", out.String(), "four")
+	}
+}
+
+func TestTTYWinSize(t *testing.T) {
+	p, tty, _ := openTTY(t, ECHO|CRMOD)
+	p.Sys.Procs = append(p.Sys.Procs, p)
+
+	// Ignored by default.
+	p.Sys.SetWinSize(1, 24, 80)
+	if p.sig != 0 {
+		t.Errorf("SIGWINCH not ignored by default: sig = %d", p.sig)
+	}
+	const addr = 0o1000
+	tty.ioctl(p, TIOCGWINSZ, addr)
+	rows, _ := p.Mem.ReadW(addr)
+	cols, _ := p.Mem.ReadW(addr + 2)
+	if rows != 24 || cols != 80 {
+		t.Errorf("TIOCGWINSZ = %d×%d, want 24×80", rows, cols)
+	}
+
+	p.Signals[SIGWINCH] = 0o1000
+	p.Sys.SetWinSize(1, 24, 80)
+	if p.sig != 0 {
+		t.Errorf("SIGWINCH sent for unchanged size")
+	}
+	p.Sys.SetWinSize(1, 50, 132)
+	if p.sig != SIGWINCH {
+		t.Errorf("after resize: sig = %d, want SIGWINCH", p.sig)
+	}
+	p.Sys.SetWinSize(-1, 1, 1) // no such tty: does nothing
+	p.Sys.SetWinSize(maxTTY, 1, 1)
+
+	p.sig = 0
+	tty.ioctl(p, TIOCGWINSZ, addr)
+	p.Mem.WriteW(addr, 25)
+	tty.ioctl(p, TIOCSWINSZ, addr)
+	if p.sig != SIGWINCH || tty.ws.rows != 25 || tty.ws.cols != 132 {
+		t.Errorf("after TIOCSWINSZ: sig = %d, size %d×%d, want SIGWINCH, 25×132", p.sig, tty.ws.rows, tty.ws.cols)
+	}
+}
+
+func TestTTYSpeed(t *testing.T) {
+	p, tty, out := openTTY(t, 0)
+	d := p.dev(ttyMajor, 1)
+
+	tests := []struct {
+		speed int
+		want  time.Duration
+	}{
+		{B0, 0},
+		{B110, 100 * time.Millisecond},
+		{B300, 33333 * time.Microsecond},
+		{B1200, 8333 * time.Microsecond},
+		{B9600, 1042 * time.Microsecond},
+		{EXTA, 0},
+	}
+	for _, tt := range tests {
+		d.sgtty(p, 1, &[3]uint16{uint16(tt.speed<<8 | B110), CERASE | CKILL<<8, 0}, nil)
+		if ct := tty.charTime(); ct != 0 {
+			t.Errorf("speed %d: charTime = %v without RealtimeTTY", tt.speed, ct)
+		}
+		p.Sys.RealtimeTTY = true
+		if ct := tty.charTime(); ct != tt.want {
+			t.Errorf("speed %d: charTime = %v, want %v", tt.speed, ct, tt.want)
+		}
+		p.Sys.RealtimeTTY = false
+	}
+
+	// By default, output is sent all at once.
+	d.write(p, 1, []byte("hello"), 0)
+	if out.String() != "hello" {
+		t.Errorf("output = %q, want %q", out.String(), "hello")
+	}
+}
+
+func TestTTYStty(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Proc{Sys: sys}
+	p.Dir = p.iget(ROOTINO)
+	tty := &sys.TTY[1]
+	var out bytes.Buffer
+	tty.Print = func(b []byte, echo bool) (int, Errno) {
+		out.Write(b)
+		return len(b), 0
+	}
+	open := func(name string) uint16 {
+		t.Helper()
+		p.Error = 0
+		if p.open(name, 2); p.Error != 0 {
+			t.Fatalf("open %s: %v", name, p.Error)
+		}
+		return p.CPU.R[0]
+	}
+	fd := open("/dev/tty1")
+	call := func(f func(*Proc), fd uint16, args ...uint16) Errno {
+		p.Error = 0
+		p.CPU.R[0] = fd
+		copy(p.Args[:], args)
+		f(p)
+		return p.Error
+	}
+	words := func(addr uint16) [3]uint16 {
+		var w [3]uint16
+		for i := range w {
+			w[i], _ = p.Mem.ReadW(addr + 2*uint16(i))
+		}
+		return w
+	}
+
+	// Read the settings, flip echo, and write them back.
+	const addr = 0o1000
+	if err := call(sysgtty, fd, addr); err != 0 {
+		t.Fatalf("gtty: %v", err)
+	}
+	sg := words(addr)
+	if sg[1] != CERASE|CKILL<<8 || sg[2] != tty.flags || sg[2]&ECHO == 0 {
+		t.Fatalf("gtty = %#o, want erase, kill, and flags %#o with ECHO", sg, tty.flags)
+	}
+	p.Mem.WriteW(addr+4, sg[2]&^ECHO)
+	if err := call(sysstty, fd, addr); err != 0 {
+		t.Fatalf("stty: %v", err)
+	}
+	typeString(tty, "x")
+	if out.Len() != 0 {
+		t.Errorf("typing with echo off printed %q", out.String())
+	}
+
+	// ioctl does the same with TIOCGETP and TIOCSETP,
+	// setting the speeds and the erase and kill characters too.
+	p.Mem.WriteW(addr, B300<<8|B1200)
+	p.Mem.WriteW(addr+2, 'h'&037|'u'&037<<8)
+	p.Mem.WriteW(addr+4, sg[2])
+	if err := call(sysioctl, fd, TIOCSETP, addr); err != 0 {
+		t.Fatalf("TIOCSETP: %v", err)
+	}
+	if tty.speeds != B300<<8|B1200 || tty.erase != 'h'&037 || tty.kill != 'u'&037 || tty.flags != sg[2] {
+		t.Errorf("after TIOCSETP: speeds %#o erase %#o kill %#o flags %#o", tty.speeds, tty.erase, tty.kill, tty.flags)
+	}
+	if err := call(sysioctl, fd, TIOCGETP, addr+0o100); err != 0 || words(addr+0o100) != words(addr) {
+		t.Errorf("TIOCGETP = %#o, %v, want %#o", words(addr+0o100), err, words(addr))
+	}
+	typeString(tty, "y")
+	if out.String() != "y" {
+		t.Errorf("typing with echo back on printed %q, want %q", out.String(), "y")
+	}
+
+	// Only terminals have settings, and they must be in memory.
+	file := open("/bin/ls")
+	p.Mem.WriteW(addr, 0o123)
+	for _, f := range []func(*Proc){sysgtty, sysstty} {
+		if err := call(f, file, addr); err != ENOTTY {
+			t.Errorf("gtty/stty of a file: %v, want ENOTTY", err)
+		}
+	}
+	if err := call(sysioctl, file, TIOCGETP, addr); err != ENOTTY || p.Mem[addr] != 0o123 {
+		t.Errorf("TIOCGETP of a file: %v, memory %#o, want ENOTTY, unchanged", err, p.Mem[addr])
+	}
+	if err := call(sysgtty, fd, 0o177776); err != EFAULT {
+		t.Errorf("gtty to unmapped memory: %v, want EFAULT", err)
+	}
+}
+
+func TestTTYReadInterrupt(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Proc{Sys: sys, sched: make(chan bool)}
+	p.status = _SRUN
+	p.CPU.Mem = &p.Mem
+	p.Dir = p.iget(ROOTINO)
+	sys.Procs = []*Proc{p}
+	sys.TTY[1].Print = func(b []byte, echo bool) (int, Errno) { return len(b), 0 }
+	p.open("/dev/tty1", 0)
+	if p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+
+	// sys read; 1000; 10
+	const pc = 0o100
+	p.Mem.WriteW(pc, 0o104403)
+	p.Mem.WriteW(pc+2, 0o1000)
+	p.Mem.WriteW(pc+4, 10)
+	p.CPU.R[pdp11.PC] = pc
+	p.CPU.Inst = 0o104403
+
+	done := make(chan error)
+	go func() { done <- Trap(p) }()
+	<-sys.idle // blocked in read
+	if sys.TTYRead != 1<<1 {
+		t.Errorf("TTYRead = %#x while reading, want %#x", sys.TTYRead, 1<<1)
+	}
+	sys.psignal(p, SIGINT)
+	p.sched <- true
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if p.CPU.PS.C() == 0 || Errno(p.CPU.R[0]) != EINTR {
+		t.Errorf("interrupted read: C=%d r0=%d, want C=1 r0=EINTR", p.CPU.PS.C(), p.CPU.R[0])
+	}
+	if sys.TTYRead != 0 {
+		t.Errorf("TTYRead = %#x after interrupt, want 0", sys.TTYRead)
+	}
+	if p.fflag != 0 {
+		t.Errorf("fflag left set to %#o after interrupt", p.fflag)
+	}
+}
+
+func TestHangupTTY(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Proc{Sys: sys, sched: make(chan bool)}
+	p.status = _SRUN
+	p.CPU.Mem = &p.Mem
+	p.Dir = p.iget(ROOTINO)
+	p.Signals[SIGHUP] = 1 // ignored, to see the read end
+	sys.Procs = []*Proc{p}
+	tty := &sys.TTY[1]
+	tty.Print = func(b []byte, echo bool) (int, Errno) { return len(b), 0 }
+	p.open("/dev/tty1", 0)
+	if p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	typeString(tty, "partial")
+
+	// sys read; 1000; 10
+	const pc = 0o100
+	p.Mem.WriteW(pc, 0o104403)
+	p.Mem.WriteW(pc+2, 0o1000)
+	p.Mem.WriteW(pc+4, 10)
+	p.CPU.R[pdp11.PC] = pc
+	p.CPU.Inst = 0o104403
+
+	done := make(chan error)
+	go func() { done <- Trap(p) }()
+	<-sys.idle // blocked in read
+	sys.HangupTTY(1)
+	p.sched <- true
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if p.CPU.PS.C() != 0 || p.CPU.R[0] != 0 {
+		t.Errorf("read pending at hangup: C=%d r0=%d, want end of file", p.CPU.PS.C(), p.CPU.R[0])
+	}
+	if p.sig != SIGHUP || p.TTY != nil {
+		t.Errorf("after hangup: signal %d, controlling tty %v, want SIGHUP, none", p.sig, p.TTY)
+	}
+	p.sig = 0
+	if tty.flags != 0 || tty.Raw.Len() != 0 {
+		t.Errorf("after hangup: flags %#o, %d bytes of input, want none", tty.flags, tty.Raw.Len())
+	}
+	d := p.dev(ttyMajor, 1)
+	p.Error = 0
+	if d.write(p, 1, []byte("x"), 0); p.Error != EIO {
+		t.Errorf("write after hangup: %v, want EIO", p.Error)
+	}
+	if n := d.read(p, 1, make([]byte, 10), 0); n != 0 {
+		t.Errorf("read after hangup = %d bytes, want end of file", n)
+	}
+
+	// Opened again, the tty works as before.
+	p.Error = 0
+	if p.open("/dev/tty1", 2); p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	if d.write(p, 1, []byte("x"), 0); p.Error != 0 {
+		t.Errorf("write after reopen: %v", p.Error)
+	}
+	typeString(tty, "hi\n")
+	if got := ttyRead(p, 10); got != "hi\n" {
+		t.Errorf("read after reopen = %q, want %q", got, "hi\n")
+	}
+	sys.HangupTTY(maxTTY) // no such tty: does nothing
+}
+
+func TestTTYFlush(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Proc{Sys: sys, sched: make(chan bool)}
+	p.status = _SRUN
+	p.CPU.Mem = &p.Mem
+	p.Dir = p.iget(ROOTINO)
+	sys.Procs = []*Proc{p}
+	tty := &sys.TTY[1]
+	var out bytes.Buffer
+	tty.Print = func(b []byte, echo bool) (int, Errno) {
+		out.Write(b)
+		return len(b), 0
+	}
+	p.open("/dev/tty1", 2)
+	if p.Error != 0 {
+		t.Fatal(p.Error)
+	}
+	fd := p.CPU.R[0]
+	const addr = 0o2000
+	ioctl := func(cmd, arg uint16) {
+		t.Helper()
+		p.Error = 0
+		p.CPU.R[0] = fd
+		p.Mem.WriteW(addr, arg)
+		p.Args[0], p.Args[1] = cmd, addr
+		if sysioctl(p); p.Error != 0 {
+			t.Fatalf("ioctl %#x: %v", cmd, p.Error)
+		}
+	}
+
+	// Setting the modes with TIOCSETN keeps type-ahead;
+	// TIOCSETP throws it away.
+	p.Mem.WriteW(addr+2, CERASE|CKILL<<8)
+	p.Mem.WriteW(addr+4, tty.flags)
+	typeString(tty, "ahead\n")
+	ioctl(TIOCSETN, 0)
+	if tty.Raw.Len() == 0 {
+		t.Errorf("TIOCSETN discarded type-ahead")
+	}
+	ioctl(TIOCSETP, 0)
+	if tty.Raw.Len() != 0 || tty.Delct != 0 {
+		t.Errorf("TIOCSETP kept type-ahead %q", tty.Raw.String())
+	}
+
+	// TIOCFLUSH of output discards only what a stop is holding.
+	typeString(tty, "more\n")
+	tty.state |= TTSTOP
+	tty.write(p, []byte("held"))
+	ioctl(TIOCFLUSH, uint16(_FWRITE))
+	if tty.outq.Len() != 0 || tty.Raw.Len() == 0 {
+		t.Errorf("TIOCFLUSH FWRITE: %d bytes held, %d typed, want 0 held", tty.outq.Len(), tty.Raw.Len())
+	}
+	tty.start()
+
+	// TIOCFLUSH of input throws away the type-ahead,
+	// so that a read waits for new input.
+	ioctl(TIOCFLUSH, uint16(_FREAD))
+	p.Mem.WriteW(0o100, 0o104403) // sys read; 1000; 10
+	p.Mem.WriteW(0o102, 0o1000)
+	p.Mem.WriteW(0o104, 10)
+	p.CPU.R[0] = fd
+	p.CPU.R[pdp11.PC] = 0o100
+	p.CPU.Inst = 0o104403
+	done := make(chan error)
+	go func() { done <- Trap(p) }()
+	<-sys.idle // blocked in read
+	typeString(tty, "ok\n")
+	p.sched <- true
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if n := p.CPU.R[0]; string(p.Mem[0o1000:0o1000+n]) != "ok\n" {
+		t.Errorf("read after flush = %q, want %q", p.Mem[0o1000:0o1000+n], "ok\n")
+	}
+	if out.String() != "ahead\r\nmore\r\nok\r\n" {
+		t.Errorf("output = %q, want only the echo", out.String())
+	}
+}
+
+func TestTTYNDelay(t *testing.T) {
+	sys, err := NewSystem(FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &Proc{Sys: sys}
+	p.Dir = p.iget(ROOTINO)
+	sys.Procs = []*Proc{p}
+	tty := &sys.TTY[1]
+	tty.Print = func(b []byte, echo bool) (int, Errno) { return len(b), 0 }
+	open := func(mode int) *File {
+		t.Helper()
+		p.Error = 0
+		p.open("/dev/tty1", mode)
+		if p.Error != 0 {
+			t.Fatalf("open mode %#o: %v", mode, p.Error)
+		}
+		return p.Files[p.CPU.R[0]]
+	}
+
+	if f := open(ONDELAY); f.flag != _FREAD {
+		t.Errorf("ONDELAY without EnableNDelay: flags %#o, want %#o", f.flag, _FREAD)
+	}
+	sys.EnableNDelay = true
+	f := open(2 | ONDELAY)
+	if f.flag != _FREAD|_FWRITE|_FNDELAY {
+		t.Errorf("ONDELAY: flags %#o, want %#o", f.flag, _FREAD|_FWRITE|_FNDELAY)
+	}
+	fd := p.CPU.R[0]
+	read := func() (string, Errno) {
+		p.Error = 0
+		p.CPU.R[0] = fd
+		p.Args[0], p.Args[1] = 0o1000, 10
+		p.rdwr(_FREAD)
+		return string(p.Mem[0o1000 : 0o1000+p.CPU.R[0]]), p.Error
+	}
+	if s, err := read(); s != "" || err != EAGAIN {
+		t.Errorf("read with no input = %q, %v, want EAGAIN", s, err)
+	}
+	typeString(tty, "par")
+	if s, err := read(); s != "" || err != EAGAIN {
+		t.Errorf("read of partial line = %q, %v, want EAGAIN", s, err)
+	}
+	typeString(tty, "is\n")
+	if s, err := read(); s != "paris\n" || err != 0 {
+		t.Errorf("read of line = %q, %v, want %q", s, err, "paris\n")
+	}
+	if p.fflag != 0 {
+		t.Errorf("fflag left set to %#o after read", p.fflag)
+	}
+}
+
+func TestTTYRaw(t *testing.T) {
+	p, tty, out := openTTY(t, ECHO|CRMOD)
+
+	// Unfinished cooked line becomes readable on switch to raw.
+	typeString(tty, "ab")
+	p.dev(ttyMajor, 1).sgtty(p, 1, &[3]uint16{0, CERASE | CKILL<<8, ECHO | RAW}, nil)
+	if s := ttyRead(p, 10); s != "ab" {
+		t.Errorf("read after switch to raw = %q, want %q", s, "ab")
+	}
+
+	out.Reset()
+	typeString(tty, "x")
+	if s := ttyRead(p, 10); s != "x" {
+		t.Errorf("raw read = %q, want %q", s, "x")
+	}
+	typeString(tty, "#@\r")
+	if s := ttyRead(p, 10); s != "#@\r" {
+		t.Errorf("raw read = %q, want %q", s, "#@\r")
+	}
+	if out.Len() != 0 {
+		t.Errorf("raw mode echoed %q", out.String())
+	}
+
+	// Back to cooked: erase and kill work again.
+	p.dev(ttyMajor, 1).sgtty(p, 1, &[3]uint16{0, CERASE | CKILL<<8, ECHO | CRMOD}, nil)
+	typeString(tty, "junk@ab#c\n")
+	if s := ttyRead(p, 10); s != "ac\n" {
+		t.Errorf("cooked read = %q, want %q", s, "ac\n")
+	}
+	if out.String() != "junk@ab#c\r\n" {
+		t.Errorf("cooked echo = %q, want %q", out.String(), "junk@ab#c\r\n")
+	}
+}
+
+func TestTTYCbreak(t *testing.T) {
+	p, tty, out := openTTY(t, ECHO|CRMOD|CBREAK)
+
+	typeString(tty, "a")
+	if s := ttyRead(p, 10); s != "a" {
+		t.Errorf("cbreak read = %q, want %q", s, "a")
+	}
+	typeString(tty, "b@#\r")
+	if s := ttyRead(p, 10); s != "b@#\n" {
+		t.Errorf("cbreak read = %q, want %q", s, "b@#\n")
+	}
+	if out.String() != "ab@#\r\n" {
+		t.Errorf("cbreak echo = %q, want %q", out.String(), "ab@#\r\n")
+	}
+
+	// Switching between modes moves or keeps queued input appropriately.
+	d := p.dev(ttyMajor, 1)
+	d.sgtty(p, 1, &[3]uint16{0, CERASE | CKILL<<8, ECHO | CRMOD}, nil)
+	typeString(tty, "ab#")
+	d.sgtty(p, 1, &[3]uint16{0, CERASE | CKILL<<8, ECHO | RAW}, nil)
+	if s := ttyRead(p, 10); s != "ab#" {
+		t.Errorf("read after cooked->raw = %q, want %q", s, "ab#")
+	}
+	d.sgtty(p, 1, &[3]uint16{0, CERASE | CKILL<<8, ECHO | CBREAK}, nil)
+	typeString(tty, "#")
+	if s := ttyRead(p, 10); s != "#" {
+		t.Errorf("read after raw->cbreak = %q, want %q", s, "#")
+	}
+	d.sgtty(p, 1, &[3]uint16{0, CERASE | CKILL<<8, ECHO | CRMOD}, nil)
+	typeString(tty, "ab#c\n")
+	if s := ttyRead(p, 10); s != "ac\n" {
+		t.Errorf("read after cbreak->cooked = %q, want %q", s, "ac\n")
+	}
+}
+
+func TestTTYInterrupt(t *testing.T) {
+	p, tty, _ := openTTY(t, ECHO|CRMOD)
+	p.Sys.Procs = append(p.Sys.Procs, p)
+
+	typeString(tty, "partial\177")
+	if p.sig != SIGINT {
+		t.Errorf("after DEL: sig = %d, want SIGINT", p.sig)
+	}
+	typeString(tty, "\n")
+	if s := ttyRead(p, 20); s != "\n" {
+		t.Errorf("read after interrupt = %q, want %q", s, "\n")
+	}
+
+	// Change interrupt to ^C and quit to ^\ off.
+	p.sig = 0
+	const addr = 0o1000
+	tty.ioctl(p, TIOCGETC, addr)
+	if p.Mem[addr] != CINTR || p.Mem[addr+1] != CQUIT {
+		t.Errorf("TIOCGETC = %o %o, want %o %o", p.Mem[addr], p.Mem[addr+1], CINTR, CQUIT)
+	}
+	p.Mem[addr] = 'C' & 037
+	p.Mem[addr+1] = 0377
+	tty.ioctl(p, TIOCSETC, addr)
+	typeString(tty, "a\034b\003")
+	if p.sig != SIGINT {
+		t.Errorf("after ^C: sig = %d, want SIGINT", p.sig)
+	}
+	// DEL is an ordinary (erase) character now.
+	typeString(tty, "x\177y\n")
+	if s := ttyRead(p, 20); s != "y\n" {
+		t.Errorf("read = %q, want %q", s, "y\n")
+	}
+}
+
+func TestTTYEcho(t *testing.T) {
+	p, tty, out := openTTY(t, ECHO)
+	d := p.dev(ttyMajor, 1)
+
+	// With echo off, as for a password, typing prints nothing.
+	d.sgtty(p, 1, &[3]uint16{0, CERASE | CKILL<<8, 0}, nil)
+	typeString(tty, "secret\n")
+	if s := ttyRead(p, 20); s != "secret\n" {
+		t.Errorf("read = %q, want %q", s, "secret\n")
+	}
+	if out.Len() != 0 {
+		t.Errorf("echo off: printed %q", out.String())
+	}
+
+	// With LCTLECH, control characters echo as ^X.
+	d.sgtty(p, 1, &[3]uint16{0, CERASE | CKILL<<8, ECHO}, nil)
+	typeString(tty, "a\x01\x1b\tb\n")
+	if want := "a\x01\x1b\tb\n"; out.String() != want {
+		t.Errorf("echo = %q, want %q", out.String(), want)
+	}
+	ttyRead(p, 20)
+	out.Reset()
+	const addr = 0o1000
+	p.Mem.WriteW(addr, LCTLECH)
+	tty.ioctl(p, TIOCLBIS, addr)
+	typeString(tty, "a\x01\x1b\tb\n")
+	if want := "a^A^[\tb\n"; out.String() != want {
+		t.Errorf("LCTLECH echo = %q, want %q", out.String(), want)
+	}
+	if s := ttyRead(p, 20); s != "a\x01\x1b\tb\n" {
+		t.Errorf("LCTLECH read = %q, want the characters themselves", s)
+	}
+}
+
+func TestTTYErase(t *testing.T) {
+	p, tty, out := openTTY(t, ECHO)
+
+	// Without LCRTERA, the erase character is echoed as itself.
+	typeString(tty, "wort#d\n")
+	if s := ttyRead(p, 20); s != "word\n" {
+		t.Errorf("read = %q, want %q", s, "word\n")
+	}
+	if out.String() != "wort#d\n" {
+		t.Errorf("echo = %q, want %q", out.String(), "wort#d\n")
+	}
+
+	const addr = 0o1000
+	p.Mem[addr] = LCRTERA
+	tty.ioctl(p, TIOCLBIS, addr)
+	p.Mem[addr] = 0
+	tty.ioctl(p, TIOCLGET, addr)
+	if p.Mem[addr] != LCRTERA {
+		t.Fatalf("TIOCLGET = %o, want %o", p.Mem[addr], LCRTERA)
+	}
+
+	tests := []struct {
+		in, read, echo string
+	}{
+		{"hello##p\n", "help\n", "hello\b \b\b \bp\n"},
+		{"#x\n", "x\n", "x\n"},
+		{"a\tb##c\n", "ac\n", "a\tb\b \b\b\b\b\b\b\b\bc\n"},
+		{"a\\#\n", "a#\n", "a\\#\n"},
+		{"junk@#ok\n", "ok\n", "junk@ok\n"},
+	}
+	for _, tt := range tests {
+		out.Reset()
+		typeString(tty, tt.in)
+		if s := ttyRead(p, 20); s != tt.read {
+			t.Errorf("type %q: read %q, want %q", tt.in, s, tt.read)
+		}
+		if out.String() != tt.echo {
+			t.Errorf("type %q: echo %q, want %q", tt.in, out.String(), tt.echo)
+		}
+	}
+
+	// stty erase '^H' kill '^X'
+	p.dev(ttyMajor, 1).sgtty(p, 1, &[3]uint16{0, '\b' | 'X'&037<<8, ECHO}, nil)
+	out.Reset()
+	typeString(tty, "#@\b\bx\030ok\b\n")
+	if s := ttyRead(p, 20); s != "o\n" {
+		t.Errorf("read = %q, want %q", s, "o\n")
+	}
+	if want := "#@\b \b\b \bx\030ok\b \b\n"; out.String() != want {
+		t.Errorf("echo = %q, want %q", out.String(), want)
+	}
+}